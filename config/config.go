@@ -77,9 +77,52 @@ func SetDefaults(cfg Provider) {
 	cfg.SetDefault("crawler.max.queue.links", 100000)
 	cfg.SetDefault("crawler.max.links", 100)
 	cfg.SetDefault("crawler.max.domain.links", 10000)
+	cfg.SetDefault("crawler.max.domain.concurrent", 2)    // requests in flight to a domain across all its subdomains
+	cfg.SetDefault("crawler.max.sitemap.links", 50000)    // per host each time its robots.txt is refreshed
+	cfg.SetDefault("crawler.backoff.base", 1*time.Minute) // delay after a host's first 5xx, timeout, etc...doubles w/ each failure
+	cfg.SetDefault("crawler.backoff.max", 7*24*time.Hour)
+	cfg.SetDefault("crawler.backoff.failures", 20)          // failures in a row before we give up on a host (until its failures expire)
+	cfg.SetDefault("crawler.recrawl.min", 6*time.Hour)      // pages that change often are recrawled sooner than crawler.since...
+	cfg.SetDefault("crawler.recrawl.max", 180*24*time.Hour) // ...and pages that don't less often
 	cfg.SetDefault("crawler.truncate.title", 100)
 	cfg.SetDefault("crawler.truncate.keywords", 25)
 	cfg.SetDefault("crawler.truncate.description", 250)
+	cfg.SetDefault("crawler.truncate.body", 20000)
+
+	// link-based authority (see search/rank)
+	cfg.SetDefault("rank.level", "page") // "page" or "host"
+	cfg.SetDefault("rank.damping", 0.85)
+	cfg.SetDefault("rank.iterations", 100)
+	cfg.SetDefault("rank.factor", 1.0)       // how much the rank counts toward a document's score
+	cfg.SetDefault("rank.anchors.max", 100)  // anchor text of the links to a page that we index with it...
+	cfg.SetDefault("rank.anchors.domain", 5) // ...and how many of those any one domain gets to give
+
+	// votes for a query count toward a document's score
+	cfg.SetDefault("vote.weight", 1.0)
+	cfg.SetDefault("vote.domain.weight", 0.2)         // votes for other pages of the same domain
+	cfg.SetDefault("vote.halflife", 180*24*time.Hour) // older votes count for less
+	cfg.SetDefault("vote.stem", true)                 // "song" and "songs" share their votes
+	cfg.SetDefault("vote.secret", "")                 // keys the hash that identifies a voter...required, a long random string
+	cfg.SetDefault("vote.limit.hour", 20)             // votes per voter...0 for no limit
+	cfg.SetDefault("vote.limit.day", 100)
+
+	// autocomplete
+	cfg.SetDefault("suggest.fuzziness", 1)              // typos allowed...0 for none
+	cfg.SetDefault("suggest.prefix", 1)                 // characters at the start that must be right
+	cfg.SetDefault("suggest.anonymity", 5)              // different users that have to search a query before we suggest it
+	cfg.SetDefault("suggest.secret", "")                // keys the hash that tells users apart...required, a long random string
+	cfg.SetDefault("suggest.expire", 30*24*time.Hour)   // we forget the queries we withheld for longer
+	cfg.SetDefault("suggest.admin.port", 8001)          // of the blocklist & removal api (on localhost only)
+	cfg.SetDefault("suggest.halflife", 14*24*time.Hour) // a search counts for half as much after
+	cfg.SetDefault("suggest.rebuild", 24*time.Hour)     // how often we rebuild the weights of our suggestions
+
+	// the IPs & CIDR ranges of our load balancers, etc. We trust them to tell
+	// us the IP of a user (X-Forwarded-For or X-Real-IP) and no one else.
+	cfg.SetDefault("frontend.proxies", []string{}) // e.g. JIVESEARCH_FRONTEND_PROXIES="10.0.0.0/8 192.168.1.2"
+
+	// "Did you mean?"
+	cfg.SetDefault("spelling.thin", 5)      // we search the correction of a query with fewer results...0 to never
+	cfg.SetDefault("spelling.plenty", 1000) // no "Did you mean?" for a query with at least this many results
 
 	// useragent for fetching api's, images, etc.
 	cfg.SetDefault("useragent", "https://github.com/jivesearch/jivesearch")
@@ -60,9 +60,43 @@ func TestSetDefaults(t *testing.T) {
 		{"crawler.max.queue.links", 100000},
 		{"crawler.max.links", 100},
 		{"crawler.max.domain.links", 10000},
+		{"crawler.max.domain.concurrent", 2},
+		{"crawler.max.sitemap.links", 50000},
+		{"crawler.backoff.base", 1 * time.Minute},
+		{"crawler.backoff.max", 7 * 24 * time.Hour},
+		{"crawler.backoff.failures", 20},
+		{"crawler.recrawl.min", 6 * time.Hour},
+		{"crawler.recrawl.max", 180 * 24 * time.Hour},
 		{"crawler.truncate.title", 100},
 		{"crawler.truncate.keywords", 25},
 		{"crawler.truncate.description", 250},
+		{"crawler.truncate.body", 20000},
+
+		// link-based authority
+		{"rank.level", "page"},
+		{"rank.damping", 0.85},
+		{"rank.iterations", 100},
+		{"rank.factor", 1.0},
+		{"rank.anchors.max", 100},
+		{"rank.anchors.domain", 5},
+		{"vote.weight", 1.0},
+		{"vote.domain.weight", 0.2},
+		{"vote.halflife", 180 * 24 * time.Hour},
+		{"vote.stem", true},
+		{"vote.secret", ""},
+		{"vote.limit.hour", 20},
+		{"vote.limit.day", 100},
+		{"suggest.fuzziness", 1},
+		{"suggest.prefix", 1},
+		{"suggest.anonymity", 5},
+		{"suggest.secret", ""},
+		{"suggest.expire", 30 * 24 * time.Hour},
+		{"suggest.admin.port", 8001},
+		{"suggest.halflife", 14 * 24 * time.Hour},
+		{"suggest.rebuild", 24 * time.Hour},
+		{"frontend.proxies", []string{}},
+		{"spelling.thin", 5},
+		{"spelling.plenty", 1000},
 
 		{"useragent", "https://github.com/jivesearch/jivesearch"},
 
@@ -46,6 +46,12 @@ func setup(v *viper.Viper) *http.Server {
 	frontend.ParseTemplates()
 	f = &frontend.Frontend{}
 
+	proxies, err := frontend.Proxies(v.GetStringSlice("frontend.proxies"))
+	if err != nil {
+		panic(err)
+	}
+	f.Proxies = proxies
+
 	f.Bangs = bangs.New()
 
 	router := f.Router(v)
@@ -70,12 +76,28 @@ func main() {
 		panic(err)
 	}
 
-	f.Search = &search.ElasticSearch{
+	sr := &search.ElasticSearch{
 		ElasticSearch: &document.ElasticSearch{
 			Client: client,
 			Index:  v.GetString("elasticsearch.search.index"),
 			Type:   v.GetString("elasticsearch.search.type"),
 		},
+		Rankers: []search.Ranker{
+			&search.LinkRank{Factor: v.GetFloat64("rank.factor")},
+		},
+		Votes: &search.VoteRank{
+			Weight:       v.GetFloat64("vote.weight"),
+			DomainWeight: v.GetFloat64("vote.domain.weight"),
+		},
+	}
+
+	f.Search, f.Images = sr, sr
+
+	// "Did you mean?" corrections come from the words of our documents
+	f.Spelling = frontend.Spelling{
+		Speller: sr,
+		Thin:    int64(v.GetInt("spelling.thin")),
+		Plenty:  int64(v.GetInt("spelling.plenty")),
 	}
 
 	// Set the backend for our autocomplete & phrase suggestor
@@ -83,17 +105,19 @@ func main() {
 		Client: client,
 		Index:  v.GetString("elasticsearch.query.index"),
 		Type:   v.GetString("elasticsearch.query.type"),
+		Fuzzy: suggest.Fuzzy{
+			Fuzziness: v.GetInt("suggest.fuzziness"),
+			Prefix:    v.GetInt("suggest.prefix"),
+		},
+		Anonymity: v.GetInt("suggest.anonymity"),
 	}
 
-	exists, err := f.Suggest.IndexExists()
-	if err != nil {
+	if err := f.Suggest.Setup(); err != nil {
 		panic(err)
 	}
 
-	if !exists {
-		if err := f.Suggest.Setup(); err != nil {
-			panic(err)
-		}
+	if f.SuggestKey, err = secret(v, "suggest.secret"); err != nil {
+		panic(err)
 	}
 
 	// Setup the voting backend. Tables will be setup automatically.
@@ -114,9 +138,26 @@ func main() {
 	defer db.Close()
 	db.SetMaxIdleConns(0)
 
+	limits := []vote.Limit{}
+	for _, l := range []vote.Limit{
+		{Votes: v.GetInt("vote.limit.hour"), Per: time.Hour},
+		{Votes: v.GetInt("vote.limit.day"), Per: 24 * time.Hour},
+	} {
+		if l.Votes > 0 {
+			limits = append(limits, l)
+		}
+	}
+
 	f.Vote = &vote.PostgreSQL{
-		DB:    db,
-		Table: v.GetString("postgresql.votes.table"),
+		DB:       db,
+		Table:    v.GetString("postgresql.votes.table"),
+		HalfLife: v.GetDuration("vote.halflife"),
+		Limits:   limits,
+		Stem:     v.GetBool("vote.stem"),
+	}
+
+	if f.VoteKey, err = secret(v, "vote.secret"); err != nil {
+		panic(err)
 	}
 
 	if err := f.Vote.Setup(); err != nil {
@@ -153,6 +194,22 @@ func main() {
 	log.Info.Fatal(s.ListenAndServe())
 }
 
+// minSecret is the shortest secret we accept. A hash of an IP address
+// keyed by a short secret is easily reversed: there are only 2^32 IPv4 addresses.
+const minSecret = 16
+
+// secret returns a secret key from our config. We won't run without one. A key that is
+// random each time we start gives anyone who comes back after a restart a new identity,
+// e.g. a user who searches a query after each restart is counted as another user of it.
+func secret(cfg config.Provider, key string) ([]byte, error) {
+	s := cfg.GetString(key)
+	if len(s) < minSecret {
+		env := "JIVESEARCH_" + strings.ToUpper(strings.Replace(key, ".", "_", -1))
+		return nil, fmt.Errorf("%v must be at least %d characters...set %v to a long random string", key, minSecret, env)
+	}
+	return []byte(s), nil
+}
+
 func languages(cfg config.Provider) ([]language.Tag, []language.Tag) {
 	supported := []language.Tag{}
 
@@ -77,3 +77,33 @@ func TestLanguages(t *testing.T) {
 		})
 	}
 }
+
+func TestSecret(t *testing.T) {
+	for _, c := range []struct {
+		name   string
+		secret string
+		err    bool
+	}{
+		{"missing", "", true},
+		{"too short", "abc123", true},
+		{"ok", "a long and random string", false},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			v := viper.New()
+			v.Set("vote.secret", c.secret)
+
+			got, err := secret(v, "vote.secret")
+			if (err != nil) != c.err {
+				t.Fatalf("got err %v; want err %v", err, c.err)
+			}
+
+			if err != nil {
+				return
+			}
+
+			if string(got) != c.secret {
+				t.Fatalf("got %q; want %q", got, c.secret)
+			}
+		})
+	}
+}
@@ -18,6 +18,7 @@ import (
 )
 
 var funcMap = template.FuncMap{
+	"Byline":        byline,
 	"Commafy":       commafy,
 	"SafeHTML":      safeHTML,
 	"Truncate":      truncate,
@@ -55,6 +56,22 @@ func commafy(v int64) string {
 	return sign + strings.Join(parts[j:], ",")
 }
 
+// byline joins the site name, author and publish date of a search result
+func byline(siteName, author, date string) string {
+	parts := []string{}
+	for _, s := range []string{siteName, author} {
+		if s != "" {
+			parts = append(parts, s)
+		}
+	}
+
+	if t, err := time.Parse(time.RFC3339, date); err == nil {
+		parts = append(parts, t.Format("Jan 2, 2006"))
+	}
+
+	return strings.Join(parts, " · ")
+}
+
 func safeHTML(value string) template.HTML {
 	return template.HTML(value)
 }
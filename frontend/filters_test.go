@@ -76,6 +76,25 @@ func TestSafeHTML(t *testing.T) {
 	}
 }
 
+func TestByline(t *testing.T) {
+	for _, c := range []struct {
+		siteName, author, date string
+		want                   string
+	}{
+		{"", "", "", ""},
+		{"Example News", "", "", "Example News"},
+		{"", "Jane Doe", "2017-01-27T13:16:23Z", "Jane Doe · Jan 27, 2017"},
+		{"Example News", "Jane Doe", "2017-01-27T13:16:23Z", "Example News · Jane Doe · Jan 27, 2017"},
+		{"Example News", "", "not a date", "Example News"},
+	} {
+		t.Run(c.want, func(t *testing.T) {
+			if got := byline(c.siteName, c.author, c.date); got != c.want {
+				t.Fatalf("got %q; want %q", got, c.want)
+			}
+		})
+	}
+}
+
 func TestTruncate(t *testing.T) {
 	for _, tt := range []struct {
 		s    string
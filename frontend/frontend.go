@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"html/template"
+	"net"
 	"net/http"
 	"strings"
 	"time"
@@ -26,10 +27,15 @@ import (
 type Frontend struct {
 	Document
 	*bangs.Bangs
-	Suggest suggest.Suggester
-	Search  search.Fetcher
+	Suggest    suggest.Suggester
+	SuggestKey []byte // the secret key of the hash that tells the users of a query apart
+	Search     search.Fetcher
+	Images     search.ImageFetcher
+	Spelling
 	Wikipedia
-	Vote vote.Voter
+	Vote    vote.Voter
+	VoteKey []byte       // the secret key of the hash that identifies a voter
+	Proxies []*net.IPNet // we trust to tell us the IP address of a user (see remoteIP)
 }
 
 // Document has the languages we support
@@ -38,6 +44,13 @@ type Document struct {
 	language.Matcher
 }
 
+// Spelling holds our settings for "Did you mean?"
+type Spelling struct {
+	search.Speller       // nil for no corrections
+	Thin           int64 // we search the correction of a query with fewer results than this instead
+	Plenty         int64 // we don't look for a correction of a query with at least this many results
+}
+
 // Wikipedia holds our settings for wikipedia/wikidata
 // Note: language matcher here may be different than that for
 // document due to available languages Wikipedia supports
@@ -135,7 +148,8 @@ func errHandler(w http.ResponseWriter, rsp *response) {
 
 func (f *Frontend) autocompleteHandler(w http.ResponseWriter, r *http.Request) *response {
 	q := strings.TrimSpace(r.FormValue("q"))
-	res, err := f.Suggest.Completion(q, 10)
+	lang, _, _ := f.Document.Matcher.Match(f.detectLanguage(r)...)
+	res, err := f.Suggest.Completion(q, lang, f.detectRegion(lang, r), 10)
 	if err != nil {
 		return &response{
 			status: http.StatusInternalServerError,
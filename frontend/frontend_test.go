@@ -9,6 +9,7 @@ import (
 
 	"github.com/jivesearch/jivesearch/suggest"
 	"github.com/spf13/pflag"
+	"golang.org/x/text/language"
 )
 
 func TestMiddleware(t *testing.T) {
@@ -97,14 +98,14 @@ func TestAutocompleteHandler(t *testing.T) {
 				status:   http.StatusOK,
 				template: "json",
 				data: suggest.Results{
-					Suggestions: []string{
-						"radiohead",
-						"rage against the machine",
-						"red hot chili peppers",
-						"r.e.m.",
-						"rolling stones",
-						"rollins band",
-						"rusted root",
+					Suggestions: []suggest.Suggestion{
+						{Text: "radiohead", Match: 1},
+						{Text: "rage against the machine", Match: 1},
+						{Text: "red hot chili peppers", Match: 1},
+						{Text: "r.e.m.", Match: 1},
+						{Text: "rolling stones", Match: 1},
+						{Text: "rollins band", Match: 1},
+						{Text: "rusted root", Match: 1},
 					},
 				},
 			},
@@ -112,6 +113,9 @@ func TestAutocompleteHandler(t *testing.T) {
 	} {
 		t.Run(c.name, func(t *testing.T) {
 			f := &Frontend{
+				Document: Document{
+					Matcher: language.NewMatcher([]language.Tag{language.English}),
+				},
 				Suggest: &mockSuggester{},
 			}
 
@@ -161,35 +165,31 @@ type mockSuggester struct {
 	ex bool
 }
 
-func (ms *mockSuggester) Exists(q string) (bool, error) {
-	return ms.ex, nil
-}
-
-func (ms *mockSuggester) Insert(q string) error {
+func (ms *mockSuggester) Add(q string, lang language.Tag, region language.Region, user string) error {
 	return nil
 }
 
-func (ms *mockSuggester) Increment(q string) error {
-	return nil
-}
-
-func (ms *mockSuggester) Completion(q string, size int) (suggest.Results, error) {
+func (ms *mockSuggester) Completion(q string, lang language.Tag, region language.Region, size int) (suggest.Results, error) {
 	s := suggest.Results{}
 
 	if q == "r" {
-		s.Suggestions = []string{
-			"radiohead",
-			"rage against the machine",
-			"red hot chili peppers",
-			"r.e.m.",
-			"rolling stones",
-			"rollins band",
-			"rusted root",
+		s.Suggestions = []suggest.Suggestion{
+			{Text: "radiohead", Match: 1},
+			{Text: "rage against the machine", Match: 1},
+			{Text: "red hot chili peppers", Match: 1},
+			{Text: "r.e.m.", Match: 1},
+			{Text: "rolling stones", Match: 1},
+			{Text: "rollins band", Match: 1},
+			{Text: "rusted root", Match: 1},
 		}
 	}
 	return s, nil
 }
 
+func (ms *mockSuggester) Popularity(queries []string, lang language.Tag, region language.Region) (map[string]int, error) {
+	return map[string]int{"jimi hendrix": 20, "jimmy hendrix": 1}, nil
+}
+
 func (ms *mockSuggester) IndexExists() (bool, error) {
 	return ms.ex, nil
 }
@@ -0,0 +1,101 @@
+package frontend
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Proxies parses the IP addresses & CIDR ranges of the proxies (load balancers, etc)
+// we trust to tell us the IP address of a user, e.g. "10.0.0.1" or "10.0.0.0/8".
+func Proxies(s []string) ([]*net.IPNet, error) {
+	proxies := []*net.IPNet{}
+
+	for _, p := range s {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+
+		if strings.Contains(p, "/") {
+			_, n, err := net.ParseCIDR(p)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid proxy %q", p)
+			}
+			proxies = append(proxies, n)
+			continue
+		}
+
+		ip := net.ParseIP(p)
+		if ip == nil {
+			return nil, errors.Errorf("invalid proxy %q", p)
+		}
+
+		bits := 8 * net.IPv6len
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, bits = ip4, 8*net.IPv4len
+		}
+		proxies = append(proxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+	}
+
+	return proxies, nil
+}
+
+// remoteIP is the IP address of the user. We don't store it, only hashes of it (see vote.Identity & suggest.User).
+// Behind one of our proxies it is the last address in X-Forwarded-For (or else X-Real-IP) that isn't a proxy.
+// Anyone can send those headers so we ignore them unless the request came from a proxy.
+func (f *Frontend) remoteIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+
+	if !f.proxy(ip) {
+		return ip
+	}
+
+	fwd := []string{}
+	for _, h := range r.Header["X-Forwarded-For"] {
+		for _, a := range strings.Split(h, ",") {
+			fwd = append(fwd, strings.TrimSpace(a))
+		}
+	}
+
+	if len(fwd) == 0 {
+		if a := strings.TrimSpace(r.Header.Get("X-Real-IP")); a != "" {
+			fwd = append(fwd, a)
+		}
+	}
+
+	// each proxy appends the address it got the request from
+	for i := len(fwd) - 1; i >= 0; i-- {
+		if net.ParseIP(fwd[i]) == nil { // we can't trust anything before it
+			break
+		}
+
+		ip = fwd[i]
+		if !f.proxy(ip) {
+			break
+		}
+	}
+
+	return ip
+}
+
+// proxy returns true if the IP address is one of our proxies
+func (f *Frontend) proxy(ip string) bool {
+	i := net.ParseIP(ip)
+	if i == nil {
+		return false
+	}
+
+	for _, p := range f.Proxies {
+		if p.Contains(i) {
+			return true
+		}
+	}
+
+	return false
+}
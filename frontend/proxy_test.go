@@ -0,0 +1,78 @@
+package frontend
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestProxies(t *testing.T) {
+	for _, c := range []struct {
+		proxies []string
+		want    []string
+		err     bool
+	}{
+		{[]string{}, []string{}, false},
+		{[]string{"10.0.0.0/8", " 192.168.1.2 ", "::1"}, []string{"10.0.0.0/8", "192.168.1.2/32", "::1/128"}, false},
+		{[]string{"10.0.0.0/33"}, nil, true},
+		{[]string{"localhost"}, nil, true},
+	} {
+		t.Run("", func(t *testing.T) {
+			got, err := Proxies(c.proxies)
+			if (err != nil) != c.err {
+				t.Fatalf("got err %v; want err %v", err, c.err)
+			}
+
+			if err != nil {
+				return
+			}
+
+			if len(got) != len(c.want) {
+				t.Fatalf("got %v; want %v", got, c.want)
+			}
+
+			for i, p := range got {
+				if p.String() != c.want[i] {
+					t.Fatalf("got %v; want %v", p, c.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestRemoteIP(t *testing.T) {
+	proxies, err := Proxies([]string{"10.0.0.0/8", "192.168.1.2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		name    string
+		remote  string
+		headers map[string]string
+		want    string
+	}{
+		{"direct", "1.2.3.4:5678", nil, "1.2.3.4"},
+		{"spoofed", "1.2.3.4:5678", map[string]string{"X-Forwarded-For": "5.6.7.8", "X-Real-IP": "5.6.7.8"}, "1.2.3.4"},
+		{"proxied", "10.1.2.3:5678", map[string]string{"X-Forwarded-For": "5.6.7.8"}, "5.6.7.8"},
+		{"spoofed behind a proxy", "10.1.2.3:5678", map[string]string{"X-Forwarded-For": "9.9.9.9, 5.6.7.8"}, "5.6.7.8"},
+		{"proxy chain", "192.168.1.2:5678", map[string]string{"X-Forwarded-For": "5.6.7.8, 10.0.0.1"}, "5.6.7.8"},
+		{"real ip", "10.1.2.3:5678", map[string]string{"X-Real-IP": "5.6.7.8"}, "5.6.7.8"},
+		{"garbage", "10.1.2.3:5678", map[string]string{"X-Forwarded-For": "5.6.7.8, unknown"}, "10.1.2.3"},
+		{"no headers", "10.1.2.3:5678", nil, "10.1.2.3"},
+		{"no port", "1.2.3.4", nil, "1.2.3.4"},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			f := &Frontend{Proxies: proxies}
+
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = c.remote
+			for k, v := range c.headers {
+				r.Header.Set(k, v)
+			}
+
+			if got := f.remoteIP(r); got != c.want {
+				t.Fatalf("got %q; want %q", got, c.want)
+			}
+		})
+	}
+}
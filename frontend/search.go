@@ -2,6 +2,7 @@ package frontend
 
 import (
 	"database/sql"
+	"math"
 	"net/http"
 	"strconv"
 	"strings"
@@ -10,6 +11,7 @@ import (
 	"github.com/jivesearch/jivesearch/instant"
 	"github.com/jivesearch/jivesearch/log"
 	"github.com/jivesearch/jivesearch/search"
+	"github.com/jivesearch/jivesearch/suggest"
 	"github.com/jivesearch/jivesearch/wikipedia"
 	"github.com/pkg/errors"
 	"golang.org/x/text/language"
@@ -22,15 +24,23 @@ type Context struct {
 	L         string          `json:"-"`
 	R         string          `json:"-"`
 	N         string          `json:"-"`
+	T         string          `json:"-"` // the type of results, e.g. "images"
+	D         string          `json:"-"` // how recent the results are, e.g. "week"
+	From      string          `json:"-"` // of a custom date range (YYYY-MM-DD)
+	To        string          `json:"-"`
+	E         string          `json:"-"` // "1" searches the query as is rather than its correction
 	Preferred []language.Tag  `json:"-"`
 	Region    language.Region `json:"-"`
 	Number    int             `json:"-"`
 	Page      int             `json:"-"`
+	Filter    search.Filter   `json:"-"`
 }
 
-// Results is the results from search, instant, wikipedia, etc
+// Results is the results from search, instant, wikipedia, etc.
+// The search results are for the Alternative (a spelling correction) if Corrected.
 type Results struct {
 	Alternative string           `json:"alternative"`
+	Corrected   bool             `json:"corrected"`
 	Instant     instant.Solution `json:"instant"`
 	Search      *search.Results  `json:"search"`
 	Wikipedia   *wikipedia.Item  `json:"wikipedia"`
@@ -71,28 +81,38 @@ func (f *Frontend) detectRegion(lang language.Tag, r *http.Request) language.Reg
 	return reg.Canonicalize()
 }
 
-func (f *Frontend) addQuery(q string) error {
-	exists, err := f.Suggest.Exists(q)
-	if err != nil {
-		return err
+// Detect the time range of the results. A custom range
+// has a "from" and/or a "to" date and is ignored otherwise.
+func (f *Frontend) detectFilter(c Context) search.Filter {
+	filter := search.Filter{
+		Time: search.ParseTime(c.D),
 	}
 
-	if !exists {
-		if err := f.Suggest.Insert(q); err != nil {
-			return err
-		}
+	if filter.Time != search.CustomTime {
+		return filter
+	}
+
+	filter.From, _ = time.Parse("2006-01-02", c.From)
+	filter.To, _ = time.Parse("2006-01-02", c.To)
+	if filter.From.IsZero() && filter.To.IsZero() {
+		filter.Time = search.AnyTime
 	}
 
-	return f.Suggest.Increment(q)
+	return filter
 }
 
 func (f *Frontend) searchHandler(w http.ResponseWriter, r *http.Request) *response {
 	d := data{
 		Context{
-			Q: strings.TrimSpace(r.FormValue("q")),
-			L: strings.TrimSpace(r.FormValue("l")),
-			N: strings.TrimSpace(r.FormValue("n")),
-			R: strings.TrimSpace(r.FormValue("r")),
+			Q:    strings.TrimSpace(r.FormValue("q")),
+			L:    strings.TrimSpace(r.FormValue("l")),
+			N:    strings.TrimSpace(r.FormValue("n")),
+			R:    strings.TrimSpace(r.FormValue("r")),
+			T:    strings.TrimSpace(r.FormValue("t")),
+			D:    strings.TrimSpace(r.FormValue("d")),
+			From: strings.TrimSpace(r.FormValue("from")),
+			To:   strings.TrimSpace(r.FormValue("to")),
+			E:    strings.TrimSpace(r.FormValue("e")),
 		},
 		Results{
 			Search: &search.Results{},
@@ -110,6 +130,10 @@ func (f *Frontend) searchHandler(w http.ResponseWriter, r *http.Request) *respon
 		err:      nil,
 	}
 
+	if d.Context.T != "images" || f.Images == nil { // the only other vertical we have
+		d.Context.T = ""
+	}
+
 	if d.Context.Q == "" { // render start page if no query
 		return resp
 	}
@@ -141,24 +165,28 @@ func (f *Frontend) searchHandler(w http.ResponseWriter, r *http.Request) *respon
 		d.Context.Number = 25
 	}
 
+	d.Context.Filter = f.detectFilter(d.Context)
+
 	channels := 1
 	sc := make(chan *search.Results)
+	cc := make(chan correction, 1) // sent before the search results (if at all) so we needn't wait on it
 	var ac chan error
 	var ic chan instant.Solution
 	var wc chan *wikipedia.Item
 
 	strt := time.Now() // we already have total response time in nginx...we want the breakdown
 
-	if d.Context.Page == 1 {
+	if d.Context.Page == 1 && d.Context.T == "" {
 		channels += 3
 
 		ac = make(chan error)
 		ic = make(chan instant.Solution)
 		wc = make(chan *wikipedia.Item)
 
-		go func(q string, ch chan error) {
-			ch <- f.addQuery(q)
-		}(d.Context.Q, ac)
+		// count the search for our autocomplete in the language & region it was searched in
+		go func(q string, lang language.Tag, region language.Region, user string, ch chan error) {
+			ch <- f.Suggest.Add(q, lang, region, user)
+		}(d.Context.Q, lang, d.Context.Region, suggest.User(f.remoteIP(r), r.UserAgent(), d.Context.Q, f.SuggestKey), ac)
 
 		go func(r *http.Request) {
 			ic <- instant.Detect(r)
@@ -173,30 +201,43 @@ func (f *Frontend) searchHandler(w http.ResponseWriter, r *http.Request) *respon
 		}(d)
 	}
 
-	go func(d data, lang language.Tag, region language.Region) {
-		// get the votes
-		offset := d.Context.Page*d.Context.Number - d.Context.Number
-		votes, err := f.Vote.Get(d.Context.Q, d.Context.Number*10) // get votes for first 10 pages
-		if err != nil {
-			log.Info.Println(err)
-		}
+	if d.Context.T == "images" {
+		go func(d data, lang language.Tag, region language.Region) {
+			offset := d.Context.Page*d.Context.Number - d.Context.Number
+			res, err := f.Images.FetchImages(d.Context.Q, lang, region, d.Context.Number, offset)
+			if err != nil {
+				log.Info.Println(err)
+			}
 
-		res, err := f.Search.Fetch(d.Context.Q, lang, region, d.Context.Number, offset, votes)
-		if err != nil {
-			log.Info.Println(err)
-		}
+			sc <- res.AddPagination(d.Context.Number, d.Context.Page)
+		}(d, lang, d.Context.Region)
+	} else {
+		go func(d data, lang language.Tag, region language.Region) {
+			res := f.fetch(d.Context.Q, d.Context, lang, region)
+
+			// "Did you mean?" is only asked on the first page of a query without plenty of results.
+			// Too few results? Search the correction instead (on every page so the pages agree)
+			// unless they asked for the query as is.
+			c := correction{}
+			thin := res.Count < f.Spelling.Thin && d.Context.E != "1"
+			if thin || (d.Context.Page == 1 && res.Count < f.Spelling.Plenty) {
+				var err error
+				if c.alternative, err = f.alternative(d.Context.Q, lang, region); err != nil {
+					log.Info.Println(err)
+				}
+			}
 
-		for _, doc := range res.Documents {
-			for _, v := range votes {
-				if doc.ID == v.URL {
-					doc.Votes = v.Votes
+			if c.alternative != "" && thin {
+				if alt := f.fetch(c.alternative, d.Context, lang, region); alt.Count > res.Count {
+					res, c.corrected = alt, true
 				}
 			}
-		}
 
-		res = res.AddPagination(d.Context.Number, d.Context.Page) // move this to javascript??? (Wouldn't be available in API....)
-		sc <- res
-	}(d, lang, d.Context.Region)
+			cc <- c
+			res = res.AddPagination(d.Context.Number, d.Context.Page) // move this to javascript??? (Wouldn't be available in API....)
+			sc <- res
+		}(d, lang, d.Context.Region)
+	}
 
 	stats := struct {
 		autocomplete time.Duration
@@ -230,6 +271,12 @@ func (f *Frontend) searchHandler(w http.ResponseWriter, r *http.Request) *respon
 
 	log.Info.Printf("ac:%v, instant:%v, search:%v, wiki:%v\n", stats.autocomplete, stats.instant, stats.search, stats.wikipedia)
 
+	select {
+	case c := <-cc:
+		d.Alternative, d.Corrected = c.alternative, c.corrected
+	default: // an image search or the search timed out
+	}
+
 	if r.FormValue("o") == "json" {
 		resp.template = r.FormValue("o")
 	}
@@ -238,6 +285,75 @@ func (f *Frontend) searchHandler(w http.ResponseWriter, r *http.Request) *respon
 	return resp
 }
 
+// fetch gets the search results of a query along with their votes
+func (f *Frontend) fetch(q string, c Context, lang language.Tag, region language.Region) *search.Results {
+	offset := c.Page*c.Number - c.Number
+	votes, err := f.Vote.Get(q, lang, c.Number*10) // get votes for first 10 pages
+	if err != nil {
+		log.Info.Println(err)
+	}
+
+	res, err := f.Search.Fetch(q, c.Filter, lang, region, c.Number, offset, votes)
+	if err != nil {
+		log.Info.Println(err)
+	}
+
+	for _, doc := range res.Documents {
+		for _, v := range votes {
+			if doc.ID == v.URL {
+				doc.Votes = v.Votes
+			}
+		}
+	}
+
+	return res
+}
+
+// correction is the "Did you mean?" of a search and whether we searched it instead
+type correction struct {
+	alternative string
+	corrected   bool
+}
+
+// alternative is the likeliest spelling of a query if it isn't the query itself.
+// The speller knows how well the words of each correction fit our documents and our autocomplete
+// how often it was searched (so "jimi hendrix" beats "jimmy hendrix" even if both are on lots of pages).
+// The query competes with its corrections so a name we know little of isn't "corrected".
+func (f *Frontend) alternative(q string, lang language.Tag, region language.Region) (string, error) {
+	if f.Speller == nil {
+		return "", nil
+	}
+
+	corrections, err := f.Spell(q, lang, 5)
+	if err != nil || len(corrections) == 0 {
+		return "", err
+	}
+
+	queries := []string{}
+	for _, c := range corrections {
+		queries = append(queries, c.Text)
+	}
+
+	pop, err := f.Suggest.Popularity(queries, lang, region)
+	if err != nil {
+		log.Info.Println(err) // the speller alone will do
+	}
+
+	var alt string
+	var best float64
+	for _, c := range corrections {
+		if score := c.Score * (1 + math.Log1p(float64(pop[c.Text]))); score > best {
+			alt, best = c.Text, score
+		}
+	}
+
+	if strings.EqualFold(alt, strings.Join(strings.Fields(q), " ")) {
+		return "", nil
+	}
+
+	return alt, nil
+}
+
 func (f *Frontend) wikiHandler(query string, preferred []language.Tag) (*wikipedia.Item, error) {
 	var err error
 	item := &wikipedia.Item{}
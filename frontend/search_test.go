@@ -5,6 +5,7 @@ import (
 	"net/http/httptest"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/jivesearch/jivesearch/bangs"
 	"github.com/jivesearch/jivesearch/instant"
@@ -121,16 +122,64 @@ func TestDetectRegion(t *testing.T) {
 	}
 }
 
+func TestDetectFilter(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		Context
+		want search.Filter
+	}{
+		{
+			"empty", Context{}, search.Filter{},
+		},
+		{
+			"past week", Context{D: "week"}, search.Filter{Time: search.PastWeek},
+		},
+		{
+			"unknown", Context{D: "decade"}, search.Filter{},
+		},
+		{
+			"custom", Context{D: "custom", From: "2017-01-01", To: "2017-06-30"},
+			search.Filter{
+				Time: search.CustomTime,
+				From: time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC),
+				To:   time.Date(2017, 6, 30, 0, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			"custom since", Context{D: "custom", From: "2017-01-01", To: "garbage"},
+			search.Filter{
+				Time: search.CustomTime,
+				From: time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			"custom without dates", Context{D: "custom"}, search.Filter{},
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			f := &Frontend{}
+
+			got := f.detectFilter(c.Context)
+
+			if !reflect.DeepEqual(got, c.want) {
+				t.Fatalf("got %+v; want %+v", got, c.want)
+			}
+		})
+	}
+}
+
 func TestSearchHandler(t *testing.T) {
 	for _, c := range []struct {
 		name     string
 		language string
 		query    string
+		kind     string
 		output   string
+		exact    string
 		want     *response
 	}{
 		{
-			"empty", "en", "", "",
+			"empty", "en", "", "", "", "",
 			&response{
 				status:   http.StatusOK,
 				template: "search",
@@ -138,7 +187,7 @@ func TestSearchHandler(t *testing.T) {
 			},
 		},
 		{
-			"basic", "en", " some query ", "",
+			"basic", "en", " some query ", "", "", "",
 			&response{
 				status:   http.StatusOK,
 				template: "search",
@@ -167,7 +216,7 @@ func TestSearchHandler(t *testing.T) {
 			},
 		},
 		{
-			"json", "en", " some query", "json",
+			"json", "en", " some query", "", "json", "",
 			&response{
 				status:   http.StatusOK,
 				template: "json",
@@ -196,7 +245,102 @@ func TestSearchHandler(t *testing.T) {
 			},
 		},
 		{
-			"!bang", "", "!g something", "",
+			"images", "en", "some query", "images", "", "",
+			&response{
+				status:   http.StatusOK,
+				template: "search",
+				data: data{
+					Context: Context{
+						Q:         "some query",
+						L:         "en",
+						T:         "images",
+						Preferred: []language.Tag{language.MustParse("en")},
+						Region:    language.MustParseRegion("US"),
+						Number:    25,
+						Page:      1,
+					},
+					Results: Results{
+						Search: &search.Results{
+							Count:      int64(1),
+							Page:       "1",
+							Pagination: []string{"1"},
+							Images: []*document.Image{
+								{ID: "https://www.example.com/image.jpg", Page: "https://www.example.com"},
+							},
+						},
+						Wikipedia: &wikipedia.Item{
+							Wikidata: &wikipedia.Wikidata{
+								Claims: &wikipedia.Claims{},
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			"did you mean", "en", "jimi hendrixx", "", "", "1",
+			&response{
+				status:   http.StatusOK,
+				template: "search",
+				data: data{
+					Context: Context{
+						Q:         "jimi hendrixx",
+						L:         "en",
+						E:         "1",
+						Preferred: []language.Tag{language.MustParse("en")},
+						Region:    language.MustParseRegion("US"),
+						Number:    25,
+						Page:      1,
+					},
+					Results: Results{
+						Alternative: "jimi hendrix",
+						Search: &search.Results{
+							Count:      int64(2),
+							Page:       "1",
+							Previous:   "",
+							Next:       "2",
+							Last:       "72",
+							Pagination: []string{"1"},
+							Documents:  []*document.Document{},
+						},
+						Wikipedia: &wikipedia.Item{},
+					},
+				},
+			},
+		},
+		{
+			"corrected", "en", "jimi hendrixx", "", "", "",
+			&response{
+				status:   http.StatusOK,
+				template: "search",
+				data: data{
+					Context: Context{
+						Q:         "jimi hendrixx",
+						L:         "en",
+						Preferred: []language.Tag{language.MustParse("en")},
+						Region:    language.MustParseRegion("US"),
+						Number:    25,
+						Page:      1,
+					},
+					Results: Results{
+						Alternative: "jimi hendrix",
+						Corrected:   true,
+						Search: &search.Results{
+							Count:      int64(25),
+							Page:       "1",
+							Previous:   "",
+							Next:       "2",
+							Last:       "72",
+							Pagination: []string{"1"},
+							Documents:  []*document.Document{},
+						},
+						Wikipedia: &wikipedia.Item{},
+					},
+				},
+			},
+		},
+		{
+			"!bang", "", "!g something", "", "", "",
 			&response{
 				status:   http.StatusFound,
 				redirect: "https://encrypted.google.com/search?hl=en&q=something",
@@ -218,6 +362,12 @@ func TestSearchHandler(t *testing.T) {
 				Bangs:   bangs.New(),
 				Suggest: &mockSuggester{},
 				Search:  &mockSearch{},
+				Images:  &mockSearch{},
+				Spelling: Spelling{
+					Speller: &mockSpeller{},
+					Thin:    5,
+					Plenty:  20,
+				},
 				Wikipedia: Wikipedia{
 					Matcher: matcher,
 					Fetcher: &mockWikipedia{},
@@ -238,7 +388,9 @@ func TestSearchHandler(t *testing.T) {
 			q := req.URL.Query()
 			q.Add("q", c.query)
 			q.Add("l", c.language)
+			q.Add("t", c.kind)
 			q.Add("o", c.output)
+			q.Add("e", c.exact)
 			req.URL.RawQuery = q.Encode()
 
 			got := f.searchHandler(httptest.NewRecorder(), req)
@@ -250,9 +402,71 @@ func TestSearchHandler(t *testing.T) {
 	}
 }
 
+func TestSearchAlternative(t *testing.T) {
+	for _, c := range []struct {
+		name   string
+		page   string
+		plenty int64
+		want   string
+		calls  int
+	}{
+		{"first page", "1", 20, "jimi hendrix", 1},
+		{"second page", "2", 20, "", 0},
+		{"plenty of results", "1", 2, "", 0},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			s := &countingSpeller{}
+			f := &Frontend{
+				Document: Document{
+					Matcher: language.NewMatcher([]language.Tag{language.English}),
+				},
+				Bangs:   bangs.New(),
+				Suggest: &mockSuggester{},
+				Search:  &mockSearch{},
+				Spelling: Spelling{
+					Speller: s,
+					Thin:    5,
+					Plenty:  c.plenty,
+				},
+				Wikipedia: Wikipedia{
+					Matcher: language.NewMatcher([]language.Tag{language.English}),
+					Fetcher: &mockWikipedia{},
+				},
+				Vote: &mockVoter{},
+			}
+
+			instant.Detect = func(r *http.Request) instant.Solution {
+				return instant.Solution{}
+			}
+
+			req, err := http.NewRequest("GET", "/", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			q := req.URL.Query()
+			q.Add("q", "jimi hendrixx")
+			q.Add("l", "en")
+			q.Add("e", "1")
+			q.Add("p", c.page)
+			req.URL.RawQuery = q.Encode()
+
+			got := f.searchHandler(httptest.NewRecorder(), req)
+
+			if d := got.data.(data); d.Alternative != c.want {
+				t.Fatalf("got %q; want %q", d.Alternative, c.want)
+			}
+
+			if s.calls != c.calls {
+				t.Fatalf("got %d spell calls; want %d", s.calls, c.calls)
+			}
+		})
+	}
+}
+
 type mockSearch struct{}
 
-func (s *mockSearch) Fetch(q string, lang language.Tag, region language.Region, page int, number int, votes []vote.Result) (*search.Results, error) {
+func (s *mockSearch) Fetch(q string, filter search.Filter, lang language.Tag, region language.Region, page int, number int, votes []vote.Result) (*search.Results, error) {
 	r := &search.Results{
 		Count:      int64(25),
 		Page:       "1",
@@ -262,6 +476,46 @@ func (s *mockSearch) Fetch(q string, lang language.Tag, region language.Region,
 		Documents:  []*document.Document{},
 	}
 
+	if q == "jimi hendrixx" {
+		r.Count = 2
+	}
+
+	return r, nil
+}
+
+type mockSpeller struct{}
+
+func (s *mockSpeller) Spell(q string, lang language.Tag, size int) ([]search.Correction, error) {
+	if q != "jimi hendrixx" {
+		return []search.Correction{}, nil
+	}
+
+	// the suggest index knows "jimi hendrix" is searched a lot more
+	return []search.Correction{
+		{Text: "jimmy hendrix", Score: 0.05},
+		{Text: "jimi hendrix", Score: 0.04},
+		{Text: "jimi hendrixx", Score: 0.001},
+	}, nil
+}
+
+type countingSpeller struct {
+	mockSpeller
+	calls int
+}
+
+func (s *countingSpeller) Spell(q string, lang language.Tag, size int) ([]search.Correction, error) {
+	s.calls++
+	return s.mockSpeller.Spell(q, lang, size)
+}
+
+func (s *mockSearch) FetchImages(q string, lang language.Tag, region language.Region, number int, offset int) (*search.Results, error) {
+	r := &search.Results{
+		Count: int64(1),
+		Images: []*document.Image{
+			{ID: "https://www.example.com/image.jpg", Page: "https://www.example.com"},
+		},
+	}
+
 	return r, nil
 }
 
@@ -1,9 +1,8 @@
 $(document).ready(function() {
-  // highlight query in the results
+  // highlight query in the results that don't have a snippet
+  // (snippets are already highlighted by the search backend).
   // Highlighting here ensures we don't introduce unsafe characters.
-  // This is not ideal and should be replaced with a template 
-  // function in Go as this will break if javascript is disabled.
-  $(".description").each(function(index, value){
+  $(".description:not(.snippet)").each(function(index, value){
     var q = $("#query").data("query").split(" ");
     var content = $(value).html();
     var c = content.split(" ");
@@ -51,6 +50,16 @@ $(document).ready(function() {
     });
   });
 
+  // time filter...a custom range waits for its dates
+  $(document).on('change', '#time', function(){
+    if ($(this).val() == 'custom'){
+      $('#custom_range').show();
+      return;
+    }
+    $('#custom_range').hide().find('input').prop('disabled', true);
+    $('#time_filter').submit();
+  });
+
   // Traditional Pagination
   $(document).on('click', '.pagination', function(){
     window.location.href = window.location.pathname + replaceQueryParam(queryString(), 'p', $(this).data('page'));
@@ -69,8 +78,17 @@ $(document).ready(function() {
         $("ul.ui-menu").innerWidth($(this).innerWidth()); // width of input including button
       },
       source: function(request, callback){
-        $.getJSON('/autocomplete', {q: request.term}, function(data){ // '{q: request.term}' changes it from ?term=b to ?q=b so nginx doesn't log query.
-            callback(data.suggestions);
+        var d = {q: request.term}; // '{q: request.term}' changes it from ?term=b to ?q=b so nginx doesn't log query.
+        $.each(['l', 'r'], function(i, name){ // suggest what others searched in the same language & region
+          var v = $("#form input[name='" + name + "']").val();
+          if (v){
+            d[name] = v;
+          }
+        });
+        $.getJSON('/autocomplete', d, function(data){
+            callback($.map(data.suggestions || [], function(s){
+              return {label: s.text, value: s.text, match: s.match};
+            }));
         });
       },
       select: function(event, ui){
@@ -83,10 +101,11 @@ $(document).ready(function() {
         return false;
       },
       }).data('ui-autocomplete')._renderItem = function(ul, item){
-        var re = new RegExp(this.term, 'i');
-        var re = new RegExp("^" + this.term);
-        var r = item.label.replace(re, "<span style='font-weight:normal;'>" + "$&" + "</span>");
-        return $("<li></li>" ).data("item.autocomplete", item).append("<a>" + r + "</a>").appendTo(ul);
+        // what they typed (or near enough for a typo) is normal and the rest is bold
+        var a = $("<a></a>")
+          .append($("<span style='font-weight:normal;'></span>").text(item.label.substr(0, item.match)))
+          .append(document.createTextNode(item.label.substr(item.match)));
+        return $("<li></li>" ).data("item.autocomplete", item).append(a).appendTo(ul);
       };
   });
 
@@ -108,6 +127,12 @@ $(document).ready(function() {
     window.location.href = window.location.pathname + replaceQueryParam(queryString(), "q", $(this).attr("data-alternative"));
   });
 
+  // search the query as is rather than its correction
+  $("#original").on("click", function(){
+    var qs = replaceQueryParam(queryString(), "q", encodeURIComponent($(this).attr("data-original")));
+    window.location.href = window.location.pathname + replaceQueryParam(qs, "e", "1");
+  });
+
   function queryString(){
     return window.location.search;
   }
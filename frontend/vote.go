@@ -25,11 +25,16 @@ func (f *Frontend) voteHandler(w http.ResponseWriter, r *http.Request) *response
 		return fail
 	}
 
+	// the language of the query (probably) is the language the user searched in
+	lang, _, _ := f.Document.Matcher.Match(f.detectLanguage(r)...)
+
 	v := &vote.Vote{}
 	v, fail.err = vote.New(
 		vote.Query(q),
+		vote.Language(lang),
 		vote.URL(u),
 		vote.Value(val),
+		vote.Identity(f.remoteIP(r), r.UserAgent(), f.VoteKey),
 	)
 
 	if fail.err != nil {
@@ -41,6 +46,9 @@ func (f *Frontend) voteHandler(w http.ResponseWriter, r *http.Request) *response
 	}
 
 	if fail.err = f.Vote.Insert(v); fail.err != nil {
+		if fail.err == vote.ErrRateLimited {
+			fail.status = http.StatusTooManyRequests
+		}
 		return fail
 	}
 
@@ -1,12 +1,15 @@
 package frontend
 
 import (
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/jivesearch/jivesearch/search/vote"
+	"golang.org/x/text/language"
 )
 
 func TestVoteHandler(t *testing.T) {
@@ -65,6 +68,14 @@ func TestVoteHandler(t *testing.T) {
 				err:      vote.ErrInvalidQuery,
 			},
 		},
+		{
+			"rate limited", "too many votes", "https://www.example.com", "1",
+			&response{
+				status:   http.StatusTooManyRequests,
+				template: "json",
+				err:      vote.ErrRateLimited,
+			},
+		},
 		{
 			"blank url", "some query", "", "1",
 			&response{
@@ -76,6 +87,9 @@ func TestVoteHandler(t *testing.T) {
 	} {
 		t.Run(c.name, func(t *testing.T) {
 			f := &Frontend{
+				Document: Document{
+					Matcher: language.NewMatcher([]language.Tag{language.English}),
+				},
 				Vote: &mockVoter{},
 			}
 
@@ -102,13 +116,28 @@ func TestVoteHandler(t *testing.T) {
 
 type mockVoter struct{}
 
-func (m *mockVoter) Get(q string, l int) ([]vote.Result, error) {
+func (m *mockVoter) Get(q string, lang language.Tag, l int) ([]vote.Result, error) {
 	res := []vote.Result{}
 	return res, nil
 }
+func (m *mockVoter) DomainVotes(domain string) (int, error) {
+	return 0, nil
+}
 func (m *mockVoter) Setup() error {
 	return nil
 }
 func (m *mockVoter) Insert(v *vote.Vote) error {
+	if v.Identity == "" {
+		return fmt.Errorf("no identity")
+	}
+	if v.Query == "too many votes" {
+		return vote.ErrRateLimited
+	}
 	return nil
 }
+func (m *mockVoter) Bursts(since time.Time, min int) ([]vote.Burst, error) {
+	return []vote.Burst{}, nil
+}
+func (m *mockVoter) Purge(b vote.Burst) (int64, error) {
+	return 0, nil
+}
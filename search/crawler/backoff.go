@@ -0,0 +1,71 @@
+package crawler
+
+import (
+	"math/rand"
+	"net"
+	"net/url"
+	"time"
+)
+
+// backoff is an exponential backoff policy for hosts that keep
+// returning server errors, timing out or failing to resolve.
+type backoff struct {
+	base     time.Duration // the delay after the first failure
+	max      time.Duration // the delay will never grow past this
+	failures int           // we give up on a host after this many failures in a row...0 to never give up
+}
+
+// jitter returns a random duration in [0, d].
+// Makes testing our backoff deterministic.
+var jitter = func(d time.Duration) time.Duration {
+	return time.Duration(rand.Int63n(int64(d) + 1))
+}
+
+// duration returns the delay after n consecutive failures: base * 2^(n-1), capped at max.
+// Up to a quarter of the delay is shaved off at random so that hosts
+// that failed together don't all get retried at the same moment.
+func (b backoff) duration(n int) time.Duration {
+	if n < 1 || b.base <= 0 {
+		return 0
+	}
+
+	d := b.max
+	if n <= 32 {
+		if e := b.base << uint(n-1); e > 0 && e < b.max {
+			d = e
+		}
+	}
+
+	return d - jitter(d/4)
+}
+
+// gaveUp tells us if a host has failed so many times in a row that it is most likely dead.
+// Its links are dropped until its failures expire (see ttl) and then it gets another chance.
+func (b backoff) gaveUp(n int) bool {
+	return b.failures > 0 && n >= b.failures
+}
+
+// ttl is how long we remember a host's failures. It must outlive
+// the longest delay or a dead host would start over at base.
+func (b backoff) ttl() time.Duration {
+	return 2 * b.max
+}
+
+// isHostError tells us if an error fetching a url is most likely the host's fault
+// (e.g. a timeout, dns failure or refused connection) rather than our own.
+func isHostError(err error) bool {
+	if e, ok := err.(*url.Error); ok {
+		err = e.Err
+	}
+
+	switch e := err.(type) {
+	case *net.DNSError:
+		return true
+	case *net.OpError:
+		return true
+	case net.Error:
+		return e.Timeout()
+	}
+
+	return false
+}
@@ -0,0 +1,204 @@
+package crawler
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/jarcoal/httpmock"
+	"github.com/jivesearch/jivesearch/search/crawler/queue"
+)
+
+func TestBackoffDuration(t *testing.T) {
+	j := jitter
+	defer func() { jitter = j }()
+
+	jitter = func(d time.Duration) time.Duration { return 0 }
+
+	b := backoff{base: 1 * time.Minute, max: 1 * time.Hour}
+
+	for _, c := range []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{1, 1 * time.Minute},
+		{2, 2 * time.Minute},
+		{3, 4 * time.Minute},
+		{6, 32 * time.Minute},
+		{7, 1 * time.Hour},
+		{100, 1 * time.Hour},
+	} {
+		t.Run(fmt.Sprintf("%d failures", c.failures), func(t *testing.T) {
+			got := b.duration(c.failures)
+			if got != c.want {
+				t.Fatalf("got %v; want %v", got, c.want)
+			}
+		})
+	}
+
+	// the jitter never pushes the delay over the cap
+	jitter = func(d time.Duration) time.Duration { return d }
+	if got, want := b.duration(100), 45*time.Minute; got != want {
+		t.Fatalf("got %v; want %v", got, want)
+	}
+}
+
+func TestGaveUp(t *testing.T) {
+	for _, c := range []struct {
+		max      int
+		failures int
+		want     bool
+	}{
+		{0, 100, false},
+		{20, 0, false},
+		{20, 19, false},
+		{20, 20, true},
+		{20, 21, true},
+	} {
+		t.Run(fmt.Sprintf("%d of %d failures", c.failures, c.max), func(t *testing.T) {
+			b := backoff{base: 1 * time.Minute, max: 1 * time.Hour, failures: c.max}
+			if got := b.gaveUp(c.failures); got != c.want {
+				t.Fatalf("got %v; want %v", got, c.want)
+			}
+		})
+	}
+}
+
+// failingQueue can't keep count of a host's failures
+type failingQueue struct {
+	mockQueue
+	delayed map[string]time.Duration
+}
+
+func (q *failingQueue) AddHostFailure(host string, ttl time.Duration) (int, error) {
+	return 0, errors.New("oops")
+}
+
+func (q *failingQueue) ResetHostFailures(host string) error {
+	return errors.New("oops")
+}
+
+func (q *failingQueue) DelayHost(host string, ttl time.Duration) error {
+	q.delayed[host] = ttl
+	return nil
+}
+
+func TestDelayHost(t *testing.T) {
+	j := jitter
+	defer func() { jitter = j }()
+	jitter = func(d time.Duration) time.Duration { return 0 }
+
+	for _, c := range []struct {
+		name     string
+		failures int
+		status   int
+		failed   bool
+		errs     int
+		want     time.Duration
+	}{
+		{"failed", 0, 0, true, 1, 1 * time.Minute},
+		{"responded", 2, 200, false, 1, 10 * time.Second},
+		{"never failed", 0, 200, false, 0, 10 * time.Second}, // nothing to reset
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			q := &failingQueue{delayed: map[string]time.Duration{}}
+			cr := &Crawler{
+				Queue:   q,
+				backoff: backoff{base: 1 * time.Minute, max: 1 * time.Hour},
+				err:     make(chan error, 1),
+			}
+
+			cr.delayHost("http://www.example.com", c.failures, c.status, "", 10*time.Second, c.failed)
+
+			if len(cr.err) != c.errs {
+				t.Fatalf("got %d errors; want %d", len(cr.err), c.errs)
+			}
+
+			got, ok := q.delayed["http://www.example.com"]
+			if !ok {
+				t.Fatal("expected the host to be delayed")
+			}
+
+			if got != c.want {
+				t.Fatalf("got %v; want %v", got, c.want)
+			}
+		})
+	}
+}
+
+// deadQueue is a host that has failed too many times in a row
+type deadQueue struct {
+	mockQueue
+	delayed map[string]time.Duration
+}
+
+func (q *deadQueue) ReserveHost(host string, ttl time.Duration) (int, error) {
+	return 20, nil
+}
+
+func (q *deadQueue) DelayHost(host string, ttl time.Duration) error {
+	q.delayed[host] = ttl
+	return nil
+}
+
+func TestWorkGaveUp(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterNoResponder(func(req *http.Request) (*http.Response, error) {
+		t.Fatalf("requested %v from a host we gave up on", req.URL)
+		return nil, nil
+	})
+
+	q := &deadQueue{delayed: map[string]time.Duration{}}
+	b := &mockBackend{}
+	cr := &Crawler{
+		HTTPClient: http.DefaultClient,
+		backoff:    backoff{base: 1 * time.Minute, max: 1 * time.Hour, failures: 20},
+		Queue:      q,
+		Backend:    b,
+		channels:   channels{err: make(chan error, 1)},
+	}
+
+	cr.work(queue.Link{URL: "http://www.example.com/page"})
+
+	if len(b.upserted) != 0 {
+		t.Fatalf("got %d upserted docs; want 0", len(b.upserted))
+	}
+
+	// the host is only held for a moment so its other links are dropped quickly too
+	if got := q.delayed["http://www.example.com"]; got != 1*time.Second {
+		t.Fatalf("got delay %v; want %v", got, 1*time.Second)
+	}
+}
+
+type timeoutError struct{ timeout bool }
+
+func (e timeoutError) Error() string   { return "timeout" }
+func (e timeoutError) Timeout() bool   { return e.timeout }
+func (e timeoutError) Temporary() bool { return false }
+
+func TestIsHostError(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"dns", &url.Error{Op: "Get", URL: "http://example.com", Err: &net.DNSError{Err: "no such host"}}, true},
+		{"refused", &url.Error{Op: "Get", URL: "http://example.com", Err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}}, true},
+		{"timeout", &url.Error{Op: "Get", URL: "http://example.com", Err: timeoutError{true}}, true},
+		{"not a timeout", &url.Error{Op: "Get", URL: "http://example.com", Err: timeoutError{false}}, false},
+		{"other", errors.New("stopped after 10 redirects"), false},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			if got := isHostError(c.err); got != c.want {
+				t.Fatalf("got %v; want %v", got, c.want)
+			}
+		})
+	}
+}
@@ -3,6 +3,7 @@ package main
 
 import (
 	"context"
+	"database/sql"
 	"errors"
 	"fmt"
 	"net/http"
@@ -18,6 +19,8 @@ import (
 	"github.com/jivesearch/jivesearch/search/crawler/queue"
 	"github.com/jivesearch/jivesearch/search/crawler/robots"
 	"github.com/jivesearch/jivesearch/search/document"
+	"github.com/jivesearch/jivesearch/search/vote"
+	_ "github.com/lib/pq"
 	"github.com/olivere/elastic"
 	"github.com/spf13/viper"
 )
@@ -156,8 +159,36 @@ func main() {
 	}
 
 	defer rds.RedisPool.Close()
+
+	// links queued before our frontier was a sorted set
+	if err := rds.Setup(); err != nil {
+		panic(err)
+	}
+
 	c.Queue = rds
 
+	// Votes help prioritize our crawl frontier.
+	// The votes table is setup by the frontend.
+	db, err := sql.Open("postgres",
+		fmt.Sprintf(
+			"user=%s password=%s host=%s database=%s sslmode=require",
+			v.GetString("postgresql.user"),
+			v.GetString("postgresql.password"),
+			v.GetString("postgresql.host"),
+			v.GetString("postgresql.database"),
+		),
+	)
+	if err != nil {
+		panic(err)
+	}
+
+	defer db.Close()
+
+	c.Votes = &vote.PostgreSQL{
+		DB:    db,
+		Table: v.GetString("postgresql.votes.table"),
+	}
+
 	defer c.Close()
 
 	if err := c.Start(duration); err != nil {
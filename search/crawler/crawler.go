@@ -14,6 +14,7 @@ import (
 	"github.com/jivesearch/jivesearch/log"
 	"github.com/jivesearch/jivesearch/search/crawler/queue"
 	"github.com/jivesearch/jivesearch/search/crawler/robots"
+	"github.com/jivesearch/jivesearch/search/vote"
 	"github.com/pkg/errors"
 	"github.com/temoto/robotstxt"
 
@@ -25,16 +26,22 @@ import (
 type Crawler struct {
 	HTTPClient *http.Client
 	UserAgent
-	workers        int
-	seeds          []string
-	since          time.Duration // how often we crawl a page
-	maxBytes       int64         // max number of bytes of doc to download...-1 for no limit
-	maxQueueLinks  int64         // max links for our queue
-	maxLinks       int           // max links to extract from a document
-	maxDomainLinks int           // max links to store for a domain by default (votes will increase this)
+	workers             int
+	seeds               []string
+	since               time.Duration // how often we crawl a page
+	maxBytes            int64         // max number of bytes of doc to download...-1 for no limit
+	maxQueueLinks       int64         // max links for our queue
+	maxLinks            int           // max links to extract from a document
+	maxDomainLinks      int           // max links to store for a domain by default (votes will increase this)
+	maxSitemapLinks     int           // max links to queue from a host's sitemaps...0 to ignore sitemaps
+	maxDomainConcurrent int           // max requests in flight to a domain (across all its hosts)...0 for no limit
+	backoff
+	schedule schedule
 	truncate
-	Robots robots.Cacher
-	Queue  queue.Queuer
+	Robots    robots.Cacher
+	Queue     queue.Queuer
+	Votes     vote.Voter // optional...links on upvoted domains are crawled sooner
+	voteCache voteCache
 	channels
 	wg    sync.WaitGroup
 	stats *Stats
@@ -42,10 +49,11 @@ type Crawler struct {
 }
 
 type channels struct {
-	links  chan string
-	ch     chan string
-	cancel chan bool
-	err    chan error
+	links    chan queue.Link
+	ch       chan queue.Link
+	sitemaps chan sitemapJob
+	cancel   chan bool
+	err      chan error
 }
 
 // UserAgent holds the full and short version of the crawler's useragent
@@ -58,15 +66,41 @@ type truncate struct {
 	title       int // chars
 	keywords    int // words
 	description int // chars
+	body        int // chars
 }
 
-// Backend outlines methods to save documents and count the docs a domain has
+// Backend outlines methods to save documents & images and count the docs a domain has
 type Backend interface {
 	Setup() error
-	CrawledAndCount(u, domain string) (time.Time, int, error) // gotta be a better name for this
+	CrawledAndCount(u, domain string) (Crawled, int, error) // gotta be a better name for this
+	LastCrawled(urls []string) (map[string]time.Time, error)
 	Upsert(*document.Document) error
+	UpsertImages([]*document.Image) error
+	DeleteImages(page string, images []*document.Image) error
+	UpsertLinks(*document.Outlinks) error
+	Touch(Crawled) error
 }
 
+// Crawled is what our Backend knows about the last crawl of a url.
+// ETag & LastModified are the validators from the response so we can make a conditional request.
+type Crawled struct {
+	ID           string
+	Index        string
+	Date         time.Time
+	Due          time.Time // when the page should be crawled again
+	Interval     time.Duration
+	Crawls       int
+	Changes      int // how many crawls found the page had changed
+	Fingerprint  string
+	ETag         string
+	LastModified string
+	SimHash      string
+	Cluster      string
+}
+
+// minDeferDelay is the least we wait to try a link again when its host or domain is busy
+const minDeferDelay = 10 * time.Second
+
 var now = func() time.Time { return time.Now().UTC() }
 
 // RobotsPath is robots.txt path
@@ -80,23 +114,37 @@ func New(cfg config.Provider) *Crawler {
 			Full:  cfg.GetString("crawler.useragent.full"),
 			Short: cfg.GetString("crawler.useragent.short"),
 		},
-		workers:        cfg.GetInt("crawler.workers"),
-		seeds:          cfg.GetStringSlice("crawler.seeds"),
-		since:          cfg.Get("crawler.since").(time.Duration),
-		maxBytes:       int64(cfg.GetInt("crawler.max.bytes")),
-		maxQueueLinks:  int64(cfg.GetInt("crawler.max.queue.links")),
-		maxLinks:       cfg.GetInt("crawler.max.links"),
-		maxDomainLinks: cfg.GetInt("crawler.max.domain.links"),
+		workers:             cfg.GetInt("crawler.workers"),
+		seeds:               cfg.GetStringSlice("crawler.seeds"),
+		since:               cfg.Get("crawler.since").(time.Duration),
+		maxBytes:            int64(cfg.GetInt("crawler.max.bytes")),
+		maxQueueLinks:       int64(cfg.GetInt("crawler.max.queue.links")),
+		maxLinks:            cfg.GetInt("crawler.max.links"),
+		maxDomainLinks:      cfg.GetInt("crawler.max.domain.links"),
+		maxSitemapLinks:     cfg.GetInt("crawler.max.sitemap.links"),
+		maxDomainConcurrent: cfg.GetInt("crawler.max.domain.concurrent"),
+		backoff: backoff{
+			base:     cfg.Get("crawler.backoff.base").(time.Duration),
+			max:      cfg.Get("crawler.backoff.max").(time.Duration),
+			failures: cfg.GetInt("crawler.backoff.failures"),
+		},
+		schedule: schedule{
+			initial: cfg.Get("crawler.since").(time.Duration),
+			min:     cfg.Get("crawler.recrawl.min").(time.Duration),
+			max:     cfg.Get("crawler.recrawl.max").(time.Duration),
+		},
 		truncate: truncate{
 			title:       cfg.GetInt("crawler.truncate.title"),
 			keywords:    cfg.GetInt("crawler.truncate.keywords"),
 			description: cfg.GetInt("crawler.truncate.description"),
+			body:        cfg.GetInt("crawler.truncate.body"),
 		},
 		channels: channels{
-			links:  make(chan string),
-			ch:     make(chan string),
-			cancel: make(chan bool),
-			err:    make(chan error),
+			links:    make(chan queue.Link),
+			ch:       make(chan queue.Link),
+			sitemaps: make(chan sitemapJob, maxSitemapJobs),
+			cancel:   make(chan bool),
+			err:      make(chan error),
 		},
 		wg:    sync.WaitGroup{},
 		stats: &Stats{Start: now(), StatusCodes: make(map[int]int64)},
@@ -109,12 +157,15 @@ func (c *Crawler) Start(t time.Duration) error {
 	defer cancel()
 
 	go c.linkHandler()
+	go c.sitemapHandler()
 	go c.startQueue()
 
 	go func() {
+		seeds := []queue.Link{}
 		for _, lnk := range c.seeds {
-			c.links <- lnk
+			seeds = append(seeds, queue.Link{URL: lnk})
 		}
+		c.queueLinks(seeds)
 
 		for worker := 0; worker < c.workers; worker++ {
 			c.wg.Add(1)
@@ -140,14 +191,17 @@ func (c *Crawler) Start(t time.Duration) error {
 	time.Sleep(1 * time.Second) // w/out we get a race condition in our tests (code smell)
 	c.wg.Wait()
 	close(c.links)
+	close(c.sitemaps)
 
 	return err
 }
 
+// linkHandler adds the links we find to our frontier. Their priority
+// is set before they get here (see queueLinks) so this never waits on our Backend.
 func (c *Crawler) linkHandler() {
 	for lnk := range c.links {
-		if err := c.Queue.AddLink(lnk); err != nil {
-			c.err <- errors.Wrapf(err, "%q", lnk)
+		if err := c.Queue.AddLink(lnk, lnk.Priority); err != nil {
+			c.err <- errors.Wrapf(err, "%q", lnk.URL)
 			return
 		}
 	}
@@ -164,65 +218,103 @@ func (c *Crawler) startQueue() {
 			// Alternative is to keep track of items queued and delete them in bulk's afterFunction
 			lnk, err := c.Queue.QueueLink(600 * time.Second)
 			if err != nil {
-				c.err <- errors.Wrapf(err, "%q", lnk)
+				c.err <- errors.Wrapf(err, "%q", lnk.URL)
 				return
 			}
 
-			if lnk != "" {
+			if lnk.URL != "" {
 				c.ch <- lnk
 			}
 		}
 	}
 }
 
-func (c *Crawler) work(lnk string) {
-	doc, err := document.New(lnk)
+func (c *Crawler) work(lnk queue.Link) {
+	doc, err := document.New(lnk.URL)
 	if err != nil {
-		log.Debug.Println(errors.Wrapf(err, "link: %q", lnk))
+		log.Debug.Println(errors.Wrapf(err, "link: %q", lnk.URL))
 		return
 	}
 
 	sh := doc.SchemeHost()
 
-	if err := c.Queue.ReserveHost(sh, 600*time.Second); err != nil {
-		msg := errors.Wrapf(err, "host: %q", sh)
+	failures, err := c.Queue.ReserveHost(sh, 600*time.Second)
+	if err != nil {
 		switch err {
-		case queue.ErrAlreadyReserved:
-			//log.Debug.Println(msg)
+		case queue.ErrAlreadyReserved: // another worker has it or it is waiting out its delay
+			c.deferLink(lnk, c.hostDelay(sh, doc.Domain))
 		default:
-			c.err <- msg
+			c.err <- errors.Wrapf(err, "host: %q", sh)
 		}
 
 		return
 	}
 
 	var delay time.Duration
-	var ra string // Retry-After header
+	var ra string                 // Retry-After header
+	var failed bool               // server error, timeout or dns failure
+	var links *document.Outlinks  // for our link graph
+	var dropped []*document.Image // the images of a page we don't index (any more)
 
 	defer func() {
-		delay = calculateHostDelay(doc.StatusCode, ra, delay)
-		if err := c.Queue.DelayHost(sh, delay); err != nil {
-			c.err <- errors.Wrapf(err, "host: %q, delay: %q", sh, delay)
-		}
+		c.delayHost(sh, failures, doc.StatusCode, ra, delay, failed)
 	}()
 
+	// the host has been down for so long that we gave up on it
+	if c.backoff.gaveUp(failures) {
+		return
+	}
+
 	crawled, cnt, err := c.Backend.CrawledAndCount(doc.ID, doc.Domain)
 	if err != nil {
-		c.err <- errors.Wrapf(err, doc.ID)
+		c.err <- errors.Wrap(err, doc.ID)
 		return
 	}
 
-	// crawled recently...always skip
-	if !crawled.Before(now().Add(-c.since)) {
+	// not due yet...always skip
+	if now().Before(c.schedule.due(crawled)) {
 		return
 	}
 
+	// the host's sitemap says the page hasn't changed since we last crawled it
+	if crawled.Date != (time.Time{}) {
+		sl, err := c.Queue.GetSitemapLink(doc.ID)
+		if err != nil {
+			c.err <- errors.Wrapf(err, "unable to get sitemap info: %v", doc.ID)
+			return
+		}
+
+		if sl.LastMod != (time.Time{}) && sl.LastMod.Before(crawled.Date) {
+			return
+		}
+	}
+
 	// new doc? only crawl if we have room for that domain
 	// TODO: make count dependent on votes
-	if crawled == (time.Time{}) && cnt > c.maxDomainLinks {
+	if crawled.Date == (time.Time{}) && cnt > c.maxDomainLinks {
 		return
 	}
 
+	// a domain can spread across many hosts (subdomains) so we also
+	// cap the number of requests in flight to the domain as a whole.
+	if c.maxDomainConcurrent > 0 {
+		if err := c.Queue.ReserveDomain(doc.Domain, c.maxDomainConcurrent, 600*time.Second); err != nil {
+			switch err {
+			case queue.ErrDomainBusy:
+				c.deferLink(lnk, minDeferDelay)
+			default:
+				c.err <- errors.Wrapf(err, "domain: %q", doc.Domain)
+			}
+			return
+		}
+
+		defer func() {
+			if err := c.Queue.ReleaseDomain(doc.Domain); err != nil {
+				c.err <- errors.Wrapf(err, "domain: %q", doc.Domain)
+			}
+		}()
+	}
+
 	doc.SetStatusCode(-1).SetCrawled(now())
 
 	rbt := c.fetchRobots(doc)
@@ -237,10 +329,22 @@ func (c *Crawler) work(lnk string) {
 		return
 	}
 
-	delay = group.CrawlDelay
+	delay = c.crawlDelay(rbtsText, rbt.Body)
 
-	resp, err := c.doRequest(doc.ID)
+	// a recrawl? Ask for the page only if it changed
+	h := http.Header{}
+	if crawled.Date != (time.Time{}) {
+		if crawled.ETag != "" {
+			h.Set("If-None-Match", crawled.ETag)
+		}
+		if crawled.LastModified != "" {
+			h.Set("If-Modified-Since", crawled.LastModified)
+		}
+	}
+
+	resp, err := c.doRequest(doc.ID, h)
 	if err != nil {
+		failed = isHostError(err)
 		log.Info.Println(err)
 		return
 	}
@@ -250,6 +354,16 @@ func (c *Crawler) work(lnk string) {
 	c.stats.Update(resp.StatusCode)
 	doc.SetStatusCode(resp.StatusCode)
 	ra = resp.Header.Get("Retry-After")
+	failed = doc.StatusCode >= 500 && doc.StatusCode < 600
+
+	// unchanged since our last crawl so there's nothing to parse or reindex
+	if doc.StatusCode == http.StatusNotModified {
+		crawled = c.schedule.update(crawled, crawled.Fingerprint)
+		if err := c.Backend.Touch(crawled); err != nil {
+			c.err <- errors.Wrapf(err, "unable to touch doc: %v", doc.ID)
+		}
+		return
+	}
 
 	if doc.StatusCode == http.StatusOK {
 		var b io.Reader = resp.Body
@@ -266,9 +380,9 @@ func (c *Crawler) work(lnk string) {
 			return
 		}
 
-		// TODO: image (& video?) search and extract some of the text of pdf files.
-		// Note: some html is mismarked as text/xml
-		if doc.MIME != "text/plain" && doc.MIME != "text/html" && doc.MIME != "text/xml" {
+		// TODO: video search?
+		// html or a document we have an Extractor for (pdf, plain text, markdown...see document.Extractors)
+		if !doc.Supported() {
 			return
 		}
 
@@ -283,29 +397,99 @@ func (c *Crawler) work(lnk string) {
 			maxLinks = 0
 		}
 
-		if err := doc.SetContent(c.UserAgent.Short, maxLinks, c.links,
-			c.truncate.title, c.truncate.keywords, c.truncate.description); err != nil {
+		// the links we find are one link further away from a seed than this doc
+		found := make(chan string)
+		done := make(chan struct{})
+		go func() {
+			lnks := []queue.Link{}
+			for l := range found {
+				lnks = append(lnks, queue.Link{URL: l, Depth: lnk.Depth + 1})
+			}
+			c.queueLinks(lnks)
+			close(done)
+		}()
+
+		if err := doc.SetContent(c.UserAgent.Short, maxLinks, found,
+			c.truncate.title, c.truncate.keywords, c.truncate.description, c.truncate.body); err != nil {
 			log.Debug.Printf("document parsing error: %v\n%v", doc.ID, err)
 		}
 
-		// don't index content if not wanted or if not canonical
-		if doc.SetCanonical(c.links); !doc.Canonical || !doc.Index {
+		doc.SetCanonical(found)
+		close(found)
+		<-done
+
+		if doc.NoImageIndex {
+			dropped, doc.Image, doc.Images = doc.Images, "", nil
+		}
+
+		// a page that isn't canonical links to the same pages as the canonical one
+		links = doc.Outlinks()
+		if !doc.Canonical {
+			links.Links = nil
+		}
+
+		// don't index content if not wanted, if not canonical or if it is no longer available
+		if !doc.Canonical || !doc.Index || !doc.Available(now()) {
+			dropped = append(dropped, doc.Images...)
 			doc = &document.Document{
 				ID:      doc.ID,
 				Crawled: doc.Crawled,
 				Content: document.Content{
-					StatusCode: doc.StatusCode,
-					Language:   doc.Language,
+					StatusCode:  doc.StatusCode,
+					Language:    doc.Language,
+					Fingerprint: doc.Fingerprint,
 				},
 			}
 		}
 	}
 
+	switch doc.StatusCode {
+	case http.StatusOK:
+		crawled = c.schedule.update(crawled, doc.Fingerprint)
+	default:
+		crawled = c.schedule.failed(crawled)
+	}
+
+	doc.Crawls, doc.Changes = crawled.Crawls, crawled.Changes
+
+	// the text didn't change so neither did its near-duplicates...saves our Backend a search
+	if doc.SimHash != "" && doc.SimHash == crawled.SimHash {
+		doc.Cluster = crawled.Cluster
+	}
+	doc.SetNextCrawl(crawled.Due, crawled.Interval)
+
 	if err := c.Backend.Upsert(doc); err != nil {
 		c.err <- errors.Wrapf(err, "unable to insert doc: %v", doc.ID)
 		return
 	}
 
+	// a page that is gone no longer links anywhere
+	if links == nil && doc.StatusCode >= 400 && doc.StatusCode < 500 {
+		links = doc.Outlinks()
+	}
+
+	if links != nil {
+		if err := c.Backend.UpsertLinks(links); err != nil {
+			c.err <- errors.Wrapf(err, "unable to insert links of doc: %v", doc.ID)
+			return
+		}
+	}
+
+	// a doc we don't index has no images
+	if len(doc.Images) > 0 {
+		if err := c.Backend.UpsertImages(doc.Images); err != nil {
+			c.err <- errors.Wrapf(err, "unable to insert images of doc: %v", doc.ID)
+			return
+		}
+	}
+
+	if len(dropped) > 0 {
+		if err := c.Backend.DeleteImages(doc.ID, dropped); err != nil {
+			c.err <- errors.Wrapf(err, "unable to delete images of doc: %v", doc.ID)
+			return
+		}
+	}
+
 	return
 }
 
@@ -330,7 +514,7 @@ func (c *Crawler) fetchRobots(doc *document.Document) *robots.Robots {
 
 	if !rbt.Cached || expired {
 		u := doc.URL.ResolveReference(RobotsPath)
-		resp, err := c.doRequest(u.String())
+		resp, err := c.doRequest(u.String(), nil)
 		if err != nil {
 			log.Info.Println(err)
 			return rbt
@@ -353,12 +537,84 @@ func (c *Crawler) fetchRobots(doc *document.Document) *robots.Robots {
 		}
 
 		c.Robots.Put(rbt)
+
+		// Only look for sitemaps when the robots.txt file is (re)fetched
+		// so we aren't downloading them for every page we crawl.
+		if rd, err := robotstxt.FromStatusAndString(rbt.StatusCode, rbt.Body); err == nil {
+			c.queueSitemaps(sitemapJob{
+				schemeHost: sh,
+				host:       doc.URL.Host,
+				domain:     doc.Domain,
+				delay:      c.crawlDelay(rd, rbt.Body),
+				sitemaps:   rd.Sitemaps,
+			})
+		}
 	}
 
 	return rbt
 }
 
-func calculateHostDelay(status int, retry string, delay time.Duration) time.Duration {
+// deferLink puts a link back in our frontier when we can't crawl it yet.
+// Otherwise we'd lose it until another page happens to link to it.
+func (c *Crawler) deferLink(lnk queue.Link, delay time.Duration) {
+	if err := c.Queue.DeferLink(lnk, delay); err != nil {
+		c.err <- errors.Wrapf(err, "%q", lnk.URL)
+	}
+}
+
+// hostDelay is how long until a host can be crawled again
+func (c *Crawler) hostDelay(sh, domain string) time.Duration {
+	l, err := c.Queue.Limits(sh, domain)
+	if err != nil {
+		log.Debug.Println(errors.Wrapf(err, "host: %q", sh))
+	}
+
+	if l.HostDelay < minDeferDelay {
+		return minDeferDelay
+	}
+
+	return l.HostDelay
+}
+
+// crawlDelay is the more conservative of the Crawl-delay & Request-rate a robots.txt file has for us
+func (c *Crawler) crawlDelay(rd *robotstxt.RobotsData, body string) time.Duration {
+	delay := rd.FindGroup(c.UserAgent.Full).CrawlDelay
+	if rr := requestRate(body, c.UserAgent.Full); rr > delay {
+		delay = rr
+	}
+
+	return delay
+}
+
+// delayHost keeps us from crawling a host again too soon. The host is delayed even if
+// we can't keep count of its failures...a delay without the backoff beats no delay at all.
+// failures are the host's consecutive failures before this request.
+func (c *Crawler) delayHost(sh string, failures, status int, ra string, delay time.Duration, failed bool) {
+	var bo time.Duration
+
+	switch {
+	case failed:
+		n, err := c.Queue.AddHostFailure(sh, c.backoff.ttl())
+		if err != nil {
+			c.err <- errors.Wrapf(err, "host: %q", sh)
+			n = 1
+		}
+		bo = c.backoff.duration(n)
+	case status > 0 && failures > 0: // the host responded so we start over
+		if err := c.Queue.ResetHostFailures(sh); err != nil {
+			c.err <- errors.Wrapf(err, "host: %q", sh)
+		}
+	}
+
+	delay = calculateHostDelay(status, ra, delay, bo)
+	if err := c.Queue.DelayHost(sh, delay); err != nil {
+		c.err <- errors.Wrapf(err, "host: %q, delay: %q", sh, delay)
+	}
+}
+
+// calculateHostDelay determines how long a host should wait before it is crawled again.
+// backoff is the delay from our backoff policy if the host is failing.
+func calculateHostDelay(status int, retry string, delay, backoff time.Duration) time.Duration {
 	max := func(x, y time.Duration) time.Duration {
 		if x > y {
 			return x
@@ -366,8 +622,8 @@ func calculateHostDelay(status int, retry string, delay time.Duration) time.Dura
 		return y
 	}
 
-	// we take the greater of robots.txt crawl-delay, replay-after header, or a hard 10 minutes
-	// in case of a 5xx error.
+	// we take the greater of robots.txt crawl-delay, replay-after header, or our
+	// exponential backoff in case of a 5xx error, timeout or dns failure.
 	if retry != "" && (status < 300 || status > 399) {
 		// see https://developer.mozilla.org/en-US/docs/Web/HTTP/Headers/Retry-After
 		// The "Retry-After" header applies to 503 error (server temporarily unavailable), a back-off
@@ -394,11 +650,8 @@ func calculateHostDelay(status int, retry string, delay time.Duration) time.Dura
 	}
 
 	switch {
-	case status >= 500 && status < 600: // server error
-		// TODO: implement an exponential backoff policy here
-		// for now we will be conservative & implement a hard 10-min delay
-		// for each server error.
-		delay = max(600*time.Second, delay)
+	case backoff > 0: // server error, timeout or dns failure
+		delay = max(backoff, delay)
 	case status == -1: // not crawled, remove the delay (but might be error fetching robots.txt)
 		delay = max(0*time.Second, delay)
 	case delay < 1*time.Second: // min of 1 second if crawled
@@ -408,7 +661,8 @@ func calculateHostDelay(status int, retry string, delay time.Duration) time.Dura
 	return delay
 }
 
-func (c *Crawler) doRequest(u string) (*http.Response, error) {
+// doRequest fetches a url. Any headers (e.g. for a conditional request) are added to the request.
+func (c *Crawler) doRequest(u string, h http.Header) (*http.Response, error) {
 	// Note: Transport automatically adds "Accept-Encoding: gzip"
 	// and transparently decodes response UNLESS you manually
 	// set the "Accept-Encoding" header.
@@ -418,6 +672,10 @@ func (c *Crawler) doRequest(u string) (*http.Response, error) {
 		return nil, err
 	}
 
+	for k, v := range h {
+		req.Header[k] = v
+	}
+
 	req.Header.Set("User-Agent", c.UserAgent.Full)
 	return c.HTTPClient.Do(req)
 }
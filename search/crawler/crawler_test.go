@@ -13,6 +13,7 @@ import (
 	"github.com/jivesearch/jivesearch/search/document"
 
 	"github.com/jarcoal/httpmock"
+	"github.com/jivesearch/jivesearch/search/crawler/queue"
 	"github.com/jivesearch/jivesearch/search/crawler/robots"
 	"github.com/spf13/pflag"
 )
@@ -35,9 +36,17 @@ func TestNew(t *testing.T) {
 	p.SetDefault("crawler.max.queue.links", 100000)
 	p.SetDefault("crawler.max.links", 10)
 	p.SetDefault("crawler.max.domain.links", 100)
+	p.SetDefault("crawler.max.sitemap.links", 1000)
+	p.SetDefault("crawler.max.domain.concurrent", 3)
+	p.SetDefault("crawler.backoff.base", 30*time.Second)
+	p.SetDefault("crawler.backoff.max", 24*time.Hour)
+	p.SetDefault("crawler.backoff.failures", 15)
+	p.SetDefault("crawler.recrawl.min", 1*time.Hour)
+	p.SetDefault("crawler.recrawl.max", 90*24*time.Hour)
 	p.SetDefault("crawler.truncate.title", 100)
 	p.SetDefault("crawler.truncate.keywords", 25)
 	p.SetDefault("crawler.truncate.description", 250)
+	p.SetDefault("crawler.truncate.body", 5000)
 	p.SetDefault("crawler.max.bytes", 10240000) // 10MB
 
 	want := &Crawler{
@@ -46,17 +55,30 @@ func TestNew(t *testing.T) {
 			Full:  "test-bot-full",
 			Short: "test-bot-short",
 		},
-		workers:        10,
-		seeds:          []string{"http://example.com", "https://another.com"},
-		since:          45 * 24 * time.Hour,
-		maxQueueLinks:  100000,
-		maxLinks:       10,
-		maxDomainLinks: 100,
-		maxBytes:       10240000,
+		workers:             10,
+		seeds:               []string{"http://example.com", "https://another.com"},
+		since:               45 * 24 * time.Hour,
+		maxQueueLinks:       100000,
+		maxLinks:            10,
+		maxDomainLinks:      100,
+		maxSitemapLinks:     1000,
+		maxDomainConcurrent: 3,
+		backoff: backoff{
+			base:     30 * time.Second,
+			max:      24 * time.Hour,
+			failures: 15,
+		},
+		schedule: schedule{
+			initial: 45 * 24 * time.Hour,
+			min:     1 * time.Hour,
+			max:     90 * 24 * time.Hour,
+		},
+		maxBytes: 10240000,
 		truncate: truncate{
 			title:       100,
 			keywords:    25,
 			description: 250,
+			body:        5000,
 		},
 		wg: sync.WaitGroup{},
 		stats: &Stats{
@@ -117,10 +139,11 @@ func TestStart(t *testing.T) {
 			description: 250,
 		},
 		channels: channels{
-			links:  make(chan string),
-			ch:     make(chan string),
-			cancel: make(chan bool),
-			err:    make(chan error),
+			links:    make(chan queue.Link),
+			ch:       make(chan queue.Link),
+			sitemaps: make(chan sitemapJob, 1),
+			cancel:   make(chan bool),
+			err:      make(chan error),
 		},
 		wg:    sync.WaitGroup{},
 		stats: &Stats{Start: now(), StatusCodes: make(map[int]int64)},
@@ -181,19 +204,20 @@ func TestWork(t *testing.T) {
 					Full:  "test-bot-full",
 					Short: "test-bot-short",
 				},
-				workers:        10,
-				seeds:          seeds,
-				since:          45 * 24 * time.Hour,
-				maxLinks:       10,
-				maxDomainLinks: 100,
+				workers:             10,
+				seeds:               seeds,
+				since:               45 * 24 * time.Hour,
+				maxLinks:            10,
+				maxDomainLinks:      100,
+				maxDomainConcurrent: 2,
 				truncate: truncate{
 					title:       100,
 					keywords:    25,
 					description: 250,
 				},
 				channels: channels{
-					links:  make(chan string),
-					ch:     make(chan string),
+					links:  make(chan queue.Link),
+					ch:     make(chan queue.Link),
 					cancel: make(chan bool),
 					err:    make(chan error),
 				},
@@ -235,7 +259,7 @@ func TestWork(t *testing.T) {
 				httpmock.NewStringResponder(200, c.body),
 			)
 
-			cr.work(c.lnk)
+			cr.work(queue.Link{URL: c.lnk})
 		})
 
 		httpmock.Reset()
@@ -244,6 +268,173 @@ func TestWork(t *testing.T) {
 	httpmock.Reset()
 }
 
+func TestWorkConditional(t *testing.T) {
+	now = func() time.Time {
+		t, _ := time.Parse(time.RFC3339, "2017-09-01T15:04:05Z")
+		return t
+	}
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	for _, c := range []struct {
+		name    string
+		status  int
+		touched bool
+	}{
+		{"not modified", http.StatusNotModified, true},
+		{"modified", http.StatusOK, false},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			lnk := "https://www.example.com/page"
+
+			cr := &Crawler{
+				HTTPClient:          http.DefaultClient,
+				UserAgent:           UserAgent{Full: "test-bot-full", Short: "test-bot-short"},
+				since:               45 * 24 * time.Hour,
+				maxLinks:            10,
+				maxDomainLinks:      100,
+				maxDomainConcurrent: 2,
+				maxBytes:            -1,
+				channels: channels{
+					links: make(chan queue.Link, 10),
+					err:   make(chan error, 10),
+				},
+				stats: &Stats{Start: now(), StatusCodes: make(map[int]int64)},
+			}
+
+			b := &mockBackend{}
+			cr.Queue = &mockQueue{}
+			cr.Backend = b
+			cr.Robots = &MockRobotsCache{m: make(map[string]*robots.Robots)}
+
+			httpmock.RegisterResponder("GET", "https://www.example.com/robots.txt",
+				httpmock.NewStringResponder(200, "User-agent: *\nAllow: /"))
+
+			var header http.Header
+			httpmock.RegisterResponder("GET", lnk,
+				func(req *http.Request) (*http.Response, error) {
+					header = req.Header
+					resp := httpmock.NewStringResponse(c.status, "<html><head><title>hello</title></head><body>world</body></html>")
+					resp.Header.Set("Content-Type", "text/html")
+					return resp, nil
+				},
+			)
+
+			cr.work(queue.Link{URL: lnk})
+
+			if got := header.Get("If-None-Match"); got != `"abc123"` {
+				t.Fatalf("got If-None-Match %q; want %q", got, `"abc123"`)
+			}
+			if got := header.Get("If-Modified-Since"); got != "Sun, 14 Aug 2016 15:03:05 GMT" {
+				t.Fatalf("got If-Modified-Since %q; want %q", got, "Sun, 14 Aug 2016 15:03:05 GMT")
+			}
+
+			if got := len(b.touched) == 1; got != c.touched {
+				t.Fatalf("got touched %v; want %v", got, c.touched)
+			}
+			if got := len(b.upserted) == 1; got == c.touched {
+				t.Fatalf("got upserted %v; want %v", got, !c.touched)
+			}
+			if c.touched && !b.touched[0].Date.Equal(now()) {
+				t.Fatalf("got crawled %v; want %v", b.touched[0].Date, now())
+			}
+		})
+
+		httpmock.Reset()
+	}
+}
+
+func TestWorkPolicy(t *testing.T) {
+	now = func() time.Time {
+		t, _ := time.Parse(time.RFC3339, "2017-09-01T15:04:05Z")
+		return t
+	}
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	for _, c := range []struct {
+		name    string
+		tag     string
+		index   bool
+		image   string
+		images  int
+		deleted int // the images we indexed from an earlier crawl are dropped
+		links   int // a page we don't index can still link to others
+	}{
+		{"default", "", true, "https://www.example.com/image.jpg", 2, 0, 1},
+		{"noindex", "test-bot-short: noindex", false, "", 0, 2, 1},
+		{"other bot", "otherbot: noindex", true, "https://www.example.com/image.jpg", 2, 0, 1},
+		{"unavailable", "unavailable_after: 2017-08-01", false, "", 0, 2, 1},
+		{"noimageindex", "noimageindex", true, "", 0, 2, 1},
+		{"nofollow", "nofollow", true, "https://www.example.com/image.jpg", 2, 0, 0},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			lnk := "https://www.example.com/page"
+
+			cr := &Crawler{
+				HTTPClient:          http.DefaultClient,
+				UserAgent:           UserAgent{Full: "test-bot-full", Short: "test-bot-short"},
+				since:               45 * 24 * time.Hour,
+				maxLinks:            10,
+				maxDomainLinks:      100,
+				maxDomainConcurrent: 2,
+				maxBytes:            -1,
+				channels: channels{
+					links: make(chan queue.Link, 10),
+					err:   make(chan error, 10),
+				},
+				stats: &Stats{Start: now(), StatusCodes: make(map[int]int64)},
+			}
+
+			b := &mockBackend{}
+			cr.Queue = &mockQueue{}
+			cr.Backend = b
+			cr.Robots = &MockRobotsCache{m: make(map[string]*robots.Robots)}
+
+			httpmock.RegisterResponder("GET", "https://www.example.com/robots.txt",
+				httpmock.NewStringResponder(200, "User-agent: *\nAllow: /"))
+
+			httpmock.RegisterResponder("GET", lnk,
+				func(req *http.Request) (*http.Response, error) {
+					resp := httpmock.NewStringResponse(200, `<html><head><title>hello</title><meta property="og:image" content="/image.jpg"></head><body>world <img src="/photo.jpg" alt="photo"> <a href="/other">other</a></body></html>`)
+					resp.Header.Set("Content-Type", "text/html")
+					if c.tag != "" {
+						resp.Header.Set("X-Robots-Tag", c.tag)
+					}
+					return resp, nil
+				},
+			)
+
+			cr.work(queue.Link{URL: lnk})
+
+			if len(b.upserted) != 1 {
+				t.Fatalf("got %d upserted docs; want 1", len(b.upserted))
+			}
+
+			doc := b.upserted[0]
+			if doc.Index != c.index {
+				t.Fatalf("got index %v; want %v", doc.Index, c.index)
+			}
+			if doc.Image != c.image {
+				t.Fatalf("got image %q; want %q", doc.Image, c.image)
+			}
+			if len(b.images) != c.images {
+				t.Fatalf("got %d images; want %d", len(b.images), c.images)
+			}
+			if len(b.deleted) != c.deleted {
+				t.Fatalf("got %d deleted images; want %d", len(b.deleted), c.deleted)
+			}
+			if len(b.links) != 1 || b.links[0].ID != lnk || len(b.links[0].Links) != c.links {
+				t.Fatalf("got links %+v; want %d links from %v", b.links, c.links, lnk)
+			}
+		})
+
+		httpmock.Reset()
+	}
+}
+
 func TestCalculateHostDelay(t *testing.T) {
 	type retryAfter struct {
 		value  string
@@ -251,10 +442,11 @@ func TestCalculateHostDelay(t *testing.T) {
 	}
 
 	for _, c := range []struct {
-		name   string
-		status int
-		delay  time.Duration
-		now    time.Time
+		name    string
+		status  int
+		delay   time.Duration
+		backoff time.Duration
+		now     time.Time
 		retryAfter
 		raFormat string
 		want     time.Duration
@@ -288,11 +480,27 @@ func TestCalculateHostDelay(t *testing.T) {
 			err:    nil,
 		},
 		{
-			name:   "5xx error",
-			status: 500,
-			delay:  2 * time.Second,
-			want:   10 * time.Minute,
-			err:    nil,
+			name:    "5xx error",
+			status:  500,
+			delay:   2 * time.Second,
+			backoff: 4 * time.Minute,
+			want:    4 * time.Minute,
+			err:     nil,
+		},
+		{
+			name:    "5xx error with longer crawl delay",
+			status:  503,
+			delay:   10 * time.Minute,
+			backoff: 1 * time.Minute,
+			want:    10 * time.Minute,
+			err:     nil,
+		},
+		{
+			name:    "timeout",
+			status:  -1,
+			backoff: 2 * time.Hour,
+			want:    2 * time.Hour,
+			err:     nil,
 		},
 		{
 			name:       "retry after (integer)",
@@ -320,7 +528,7 @@ func TestCalculateHostDelay(t *testing.T) {
 				}
 			}
 
-			got := calculateHostDelay(c.status, c.retryAfter.value, c.delay)
+			got := calculateHostDelay(c.status, c.retryAfter.value, c.delay, c.backoff)
 
 			if !reflect.DeepEqual(got, c.want) {
 				t.Fatalf("got %q; want %q", got, c.want)
@@ -331,41 +539,121 @@ func TestCalculateHostDelay(t *testing.T) {
 
 type mockQueue struct{}
 
-func (q *mockQueue) AddLink(lnk string) error {
+func (q *mockQueue) AddLink(lnk queue.Link, priority float64) error {
+	return nil
+}
+
+func (q *mockQueue) AddSitemapLink(lnk queue.SitemapLink, priority float64, ttl time.Duration) error {
 	return nil
 }
 
+func (q *mockQueue) GetSitemapLink(lnk string) (queue.SitemapLink, error) {
+	return queue.SitemapLink{URL: lnk}, nil
+}
+
 func (q *mockQueue) CountLinks() (int64, error) {
 	return 100, nil
 }
 
-func (q *mockQueue) QueueLink(time.Duration) (string, error) {
-	return "", nil
+func (q *mockQueue) QueueLink(time.Duration) (queue.Link, error) {
+	return queue.Link{}, nil
 }
 
-func (q *mockQueue) ReserveHost(host string, ttl time.Duration) error {
+func (q *mockQueue) DeferLink(lnk queue.Link, delay time.Duration) error {
 	return nil
 }
 
+func (q *mockQueue) ReserveHost(host string, ttl time.Duration) (int, error) {
+	return 0, nil
+}
+
 func (q *mockQueue) DelayHost(host string, ttl time.Duration) error {
 	return nil
 }
 
+func (q *mockQueue) AddHostFailure(host string, ttl time.Duration) (int, error) {
+	return 1, nil
+}
+
+func (q *mockQueue) ResetHostFailures(host string) error {
+	return nil
+}
+
+func (q *mockQueue) ReserveDomain(domain string, max int, ttl time.Duration) error {
+	return nil
+}
+
+func (q *mockQueue) ReleaseDomain(domain string) error {
+	return nil
+}
+
+func (q *mockQueue) Limits(host, domain string) (queue.Limits, error) {
+	return queue.Limits{}, nil
+}
+
 func (q *mockQueue) Delete(lnks []string) error {
 	return nil
 }
 
-type mockBackend struct{}
+type mockBackend struct {
+	sync.Mutex
+	touched  []Crawled
+	upserted []*document.Document
+	images   []*document.Image
+	deleted  []*document.Image
+	links    []*document.Outlinks
+}
 
 func (m *mockBackend) Setup() error {
 	return nil
 }
 
-func (m *mockBackend) CrawledAndCount(u, domain string) (time.Time, int, error) {
-	return time.Date(2016, time.August, 14, 15, 3, 5, 0, time.UTC), 10, nil
+func (m *mockBackend) CrawledAndCount(u, domain string) (Crawled, int, error) {
+	return Crawled{
+		ID:           u,
+		Index:        "search-english",
+		Date:         time.Date(2016, time.August, 14, 15, 3, 5, 0, time.UTC),
+		ETag:         `"abc123"`,
+		LastModified: "Sun, 14 Aug 2016 15:03:05 GMT",
+	}, 10, nil
+}
+
+func (m *mockBackend) LastCrawled(urls []string) (map[string]time.Time, error) {
+	return map[string]time.Time{}, nil
+}
+
+func (m *mockBackend) Upsert(doc *document.Document) error {
+	m.Lock()
+	m.upserted = append(m.upserted, doc)
+	m.Unlock()
+	return nil
+}
+
+func (m *mockBackend) UpsertImages(images []*document.Image) error {
+	m.Lock()
+	m.images = append(m.images, images...)
+	m.Unlock()
+	return nil
+}
+
+func (m *mockBackend) DeleteImages(page string, images []*document.Image) error {
+	m.Lock()
+	m.deleted = append(m.deleted, images...)
+	m.Unlock()
+	return nil
+}
+
+func (m *mockBackend) UpsertLinks(links *document.Outlinks) error {
+	m.Lock()
+	m.links = append(m.links, links)
+	m.Unlock()
+	return nil
 }
 
-func (m *mockBackend) Upsert(*document.Document) error {
+func (m *mockBackend) Touch(c Crawled) error {
+	m.Lock()
+	m.touched = append(m.touched, c)
+	m.Unlock()
 	return nil
 }
 
@@ -1,9 +1,12 @@
 package crawler
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
+	"reflect"
+	"strings"
 	"sync"
 	"time"
 
@@ -29,20 +32,264 @@ func (e *ElasticSearch) Upsert(doc *document.Document) error {
 
 	idx := e.IndexName(a)
 
+	if err := e.cluster(doc, idx); err != nil {
+		return err
+	}
+
+	d, err := replaced(doc)
+	if err != nil {
+		return err
+	}
+
 	item := elastic.NewBulkUpdateRequest().
 		Index(idx).
 		Type(e.Type).
 		Id(doc.ID).
 		DocAsUpsert(true).
+		Doc(d)
+
+	e.Bulk.Add(item)
+	return nil
+}
+
+// UpsertImages updates the images of a page or inserts them if they don't exist.
+// An image is in the image index of the language of the page we last found it on.
+func (e *ElasticSearch) UpsertImages(images []*document.Image) error {
+	for _, img := range images {
+		a, err := e.Analyzer(img.Language)
+		if err != nil {
+			return err
+		}
+
+		item := elastic.NewBulkUpdateRequest().
+			Index(e.ImageIndexName(a)).
+			Type(document.ImageType).
+			Id(img.ID).
+			DocAsUpsert(true).
+			Doc(img)
+
+		e.Bulk.Add(item)
+	}
+
+	return nil
+}
+
+// DeleteImages deletes images we found on a page from our image index.
+// An image we last found on another page (that allows it) is kept.
+func (e *ElasticSearch) DeleteImages(page string, images []*document.Image) error {
+	script := elastic.NewScript("ctx.op = ctx._source.page == params.page ? 'delete' : 'none'").
+		Param("page", page)
+
+	for _, img := range images {
+		a, err := e.Analyzer(img.Language)
+		if err != nil {
+			return err
+		}
+
+		// the empty upsert lets the script skip an image we never indexed
+		item := elastic.NewBulkUpdateRequest().
+			Index(e.ImageIndexName(a)).
+			Type(document.ImageType).
+			Id(img.ID).
+			Script(script).
+			ScriptedUpsert(true).
+			Upsert(map[string]interface{}{})
+
+		e.Bulk.Add(item)
+	}
+
+	return nil
+}
+
+// UpsertLinks replaces the links from a page in our link graph
+func (e *ElasticSearch) UpsertLinks(links *document.Outlinks) error {
+	item := elastic.NewBulkIndexRequest().
+		Index(e.LinkIndexName()).
+		Type(document.LinkType).
+		Id(links.ID).
+		Doc(links)
+
+	e.Bulk.Add(item)
+	return nil
+}
+
+// contentFields are the fields of a doc that each crawl replaces
+var contentFields = jsonFields(reflect.TypeOf(document.Content{}))
+
+// jsonFields returns the json names of a struct's fields (and those of its embedded structs)
+func jsonFields(t reflect.Type) []string {
+	fields := []string{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Anonymous && f.Type.Kind() == reflect.Struct {
+			fields = append(fields, jsonFields(f.Type)...)
+			continue
+		}
+
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if f.PkgPath != "" || name == "" || name == "-" {
+			continue
+		}
+		fields = append(fields, name)
+	}
+	return fields
+}
+
+// replaced is the partial update of a doc. A field the doc doesn't have is nulled out
+// else a page that is now noindex (or no longer has a title, etc) would keep the old value.
+func replaced(doc *document.Document) (map[string]interface{}, error) {
+	d := map[string]interface{}{}
+
+	b, err := json.Marshal(doc)
+	if err != nil {
+		return d, err
+	}
+
+	// numbers stay as they are (e.g. a big interval isn't turned into a float)
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	if err := dec.Decode(&d); err != nil {
+		return d, err
+	}
+
+	for _, f := range contentFields {
+		if _, ok := d[f]; !ok {
+			d[f] = nil
+		}
+	}
+
+	// the rank job sets the anchors of a page we index
+	if !doc.Index {
+		d["anchors"] = nil
+	}
+
+	return d, nil
+}
+
+// cluster puts a doc in the same cluster as a near-duplicate (printer version,
+// session-id variant, mirror, etc) with a different url so our search results can be collapsed.
+// A doc without a near-duplicate is its own cluster. We only look in the doc's own index
+// (its near-duplicates are in the same language) and skip the search if the crawler
+// already knows the cluster (e.g. the text didn't change since the last crawl).
+func (e *ElasticSearch) cluster(doc *document.Document, idx string) error {
+	if doc.Cluster != "" {
+		return nil
+	}
+
+	doc.Cluster = doc.ID
+
+	if len(doc.SimHashBands) == 0 {
+		return nil
+	}
+
+	bands := []interface{}{}
+	for _, b := range doc.SimHashBands {
+		bands = append(bands, b)
+	}
+
+	q := elastic.NewBoolQuery().
+		Filter(elastic.NewTermsQuery("simhash_bands", bands...)).
+		MustNot(elastic.NewTermQuery("_id", doc.ID))
+
+	// one index at a time so we don't need the lock (see note in CrawledAndCount)
+	res, err := e.Client.Search().
+		Index(idx).
+		Type(e.Type).
+		Query(q).
+		FetchSourceContext(elastic.NewFetchSourceContext(true).Include("simhash", "cluster")).
+		Size(20).
+		Do(context.TODO())
+
+	if err != nil {
+		return err
+	}
+
+	for _, h := range res.Hits.Hits {
+		d := &document.Document{}
+		if err := json.Unmarshal(*h.Source, d); err != nil {
+			return err
+		}
+
+		if !document.NearDuplicate(doc.SimHash, d.SimHash) {
+			continue
+		}
+
+		doc.Cluster = h.Id
+		if d.Cluster != "" {
+			doc.Cluster = d.Cluster
+		}
+
+		return nil
+	}
+
+	return nil
+}
+
+// Touch bumps the crawled date and schedule of a document that hasn't changed since
+// we last crawled it (e.g. a 304 response) without reindexing its content.
+func (e *ElasticSearch) Touch(c Crawled) error {
+	doc := &document.Document{ID: c.ID, Crawls: c.Crawls}
+	doc.SetCrawled(c.Date).SetNextCrawl(c.Due, c.Interval)
+
+	item := elastic.NewBulkUpdateRequest().
+		Index(c.Index).
+		Type(e.Type).
+		Id(c.ID).
 		Doc(doc)
 
 	e.Bulk.Add(item)
 	return nil
 }
 
-// CrawledAndCount returns the crawled date of the url (if any) and
+// LastCrawled returns when we last crawled each of the urls we have crawled.
+// It is one request for many urls (e.g. all the links on a page).
+func (e *ElasticSearch) LastCrawled(urls []string) (map[string]time.Time, error) {
+	crawled := map[string]time.Time{}
+
+	if len(urls) == 0 {
+		return crawled, nil
+	}
+
+	// see note in CrawledAndCount
+	e.Lock()
+
+	res, err := e.Client.Search().
+		Index(e.Index + "-*").
+		Type(e.Type).
+		Query(elastic.NewIdsQuery(e.Type).Ids(urls...)).
+		FetchSourceContext(elastic.NewFetchSourceContext(true).Include("crawled")).
+		Size(len(urls)).
+		Do(context.TODO())
+
+	e.Unlock()
+
+	if err != nil {
+		if elastic.IsNotFound(err) { // nothing crawled yet
+			return crawled, nil
+		}
+		return crawled, err
+	}
+
+	for _, h := range res.Hits.Hits {
+		doc := &document.Document{}
+		if err := json.Unmarshal(*h.Source, doc); err != nil {
+			return crawled, err
+		}
+
+		t, err := time.Parse("20060102", doc.Crawled)
+		if err != nil {
+			return crawled, err
+		}
+
+		crawled[h.Id] = t
+	}
+
+	return crawled, nil
+}
+
+// CrawledAndCount returns what we know about the last crawl of the url (if any) and
 // the total number of links a domain has
-func (e *ElasticSearch) CrawledAndCount(u, domain string) (time.Time, int, error) {
+func (e *ElasticSearch) CrawledAndCount(u, domain string) (Crawled, int, error) {
 	body := fmt.Sprintf(`{
 		"bool": {
 			"filter": [
@@ -60,7 +307,7 @@ func (e *ElasticSearch) CrawledAndCount(u, domain string) (time.Time, int, error
 		}
 	}`, domain)
 
-	var crawled, cnt = time.Time{}, 0
+	var crawled, cnt = Crawled{ID: u}, 0
 
 	// even though this technically could be a count request
 	// it s/b faster using multisearch.
@@ -75,7 +322,10 @@ func (e *ElasticSearch) CrawledAndCount(u, domain string) (time.Time, int, error
 		Type(e.Type).
 		Source(elastic.NewSearchSource().
 			Query(elastic.NewTermQuery("_id", u)).
-			FetchSourceContext(elastic.NewFetchSourceContext(true).Include("crawled")),
+			FetchSourceContext(elastic.NewFetchSourceContext(true).Include(
+				"crawled", "next_crawl", "interval", "crawls", "changes", "fingerprint", "etag", "last_modified",
+				"simhash", "cluster",
+			)),
 		)
 
 	// Concurrently calling this results in Error 429 [reduce_search_phase_exception] error.
@@ -98,15 +348,32 @@ func (e *ElasticSearch) CrawledAndCount(u, domain string) (time.Time, int, error
 	cnt = int(r1.TotalHits())
 
 	if err != nil && !elastic.IsNotFound(r2.Error) {
-		return crawled, cnt, fmt.Errorf(r2.Error.Reason)
+		return crawled, cnt, fmt.Errorf("%v", r2.Error.Reason)
 	}
 
 	for _, h := range r2.Hits.Hits {
-		c := make(map[string]string)
-		if err := json.Unmarshal(*h.Source, &c); err != nil {
+		doc := &document.Document{}
+		if err := json.Unmarshal(*h.Source, doc); err != nil {
 			return crawled, cnt, err
 		}
-		crawled, err = time.Parse("20060102", c["crawled"])
+
+		crawled.Index = h.Index
+		crawled.Interval = time.Duration(doc.Interval) * time.Second
+		crawled.Crawls = doc.Crawls
+		crawled.Changes = doc.Changes
+		crawled.Fingerprint = doc.Fingerprint
+		crawled.ETag = doc.ETag
+		crawled.LastModified = doc.LastModified
+		crawled.SimHash = doc.SimHash
+		crawled.Cluster = doc.Cluster
+
+		if doc.NextCrawl != "" {
+			if crawled.Due, err = time.Parse(document.NextCrawlFormat, doc.NextCrawl); err != nil {
+				return crawled, cnt, err
+			}
+		}
+
+		crawled.Date, err = time.Parse("20060102", doc.Crawled)
 	}
 
 	return crawled, cnt, err
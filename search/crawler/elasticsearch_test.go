@@ -2,14 +2,20 @@ package crawler
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/jivesearch/jivesearch/search/document"
 
 	"github.com/olivere/elastic"
+	"golang.org/x/text/language"
 )
 
 func TestUpsert(t *testing.T) {
@@ -82,10 +88,334 @@ func TestUpsert(t *testing.T) {
 	}
 }
 
+// A page we indexed that is now noindex drops out of our results (and its content is gone)
+func TestUpsertNoindex(t *testing.T) {
+	// applies partial updates like Elasticsearch does
+	indexed := map[string]map[string]interface{}{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+		for i := 0; i+1 < len(lines); i += 2 {
+			var action map[string]struct {
+				ID string `json:"_id"`
+			}
+			var update struct {
+				Doc map[string]interface{} `json:"doc"`
+			}
+			if err := json.Unmarshal([]byte(lines[i]), &action); err != nil {
+				t.Fatal(err)
+			}
+			if err := json.Unmarshal([]byte(lines[i+1]), &update); err != nil {
+				t.Fatal(err)
+			}
+
+			id := action["update"].ID
+			if indexed[id] == nil {
+				indexed[id] = map[string]interface{}{}
+			}
+			for k, v := range update.Doc {
+				indexed[id][k] = v
+			}
+		}
+		w.Write([]byte(`{"took": 1, "errors": false, "items": [{"update": {"status": 200}}]}`))
+	}))
+	defer ts.Close()
+
+	e, err := MockService(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// what our search filters on
+	found := func(id string) bool {
+		return indexed[id]["index"] == true
+	}
+
+	id := "http://www.example.com/path/to/somewhere"
+	for _, c := range []struct {
+		name  string
+		doc   *document.Document
+		found bool
+	}{
+		{
+			"indexable",
+			&document.Document{
+				ID:      id,
+				Content: document.Content{Title: "hello", Body: "world", Policy: document.Policy{Index: true, NoArchive: true}},
+				Anchors: []string{"hello page"},
+			},
+			true,
+		},
+		{
+			"noindex",
+			&document.Document{ID: id, Content: document.Content{StatusCode: 200, Fingerprint: "abc"}},
+			false,
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			if err := e.Upsert(c.doc); err != nil {
+				t.Fatal(err)
+			}
+			if err := e.Bulk.Flush(); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := found(id); got != c.found {
+				t.Fatalf("got found %v; want %v", got, c.found)
+			}
+
+			if c.found {
+				return
+			}
+
+			for _, f := range []string{"title", "body", "anchors", "noarchive"} {
+				if v := indexed[id][f]; v != nil {
+					t.Fatalf("got %v %v; want it removed", f, v)
+				}
+			}
+		})
+	}
+}
+
+func TestUpsertImages(t *testing.T) {
+	var body []byte
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = ioutil.ReadAll(r.Body)
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"took": 1, "errors": false, "items": [{"update": {"_index": "search-images-english", "_type": "image", "_id": "https://www.example.com/cat.jpg", "status": 201}}]}`))
+	}))
+	defer ts.Close()
+
+	e, err := MockService(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	images := []*document.Image{
+		{ID: "https://www.example.com/cat.jpg", Alt: "A cat", Language: language.English},
+	}
+
+	if err := e.UpsertImages(images); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := e.Bulk.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `{"update":{"_index":"search-images-english","_type":"image","_id":"https://www.example.com/cat.jpg"}}`
+	if !strings.Contains(string(body), want) {
+		t.Fatalf("got %s; want %s", body, want)
+	}
+
+	if stats := e.Bulk.Stats(); stats.Succeeded != 1 {
+		t.Fatalf("upsert failed: got %d", stats.Succeeded)
+	}
+}
+
+func TestUpsertLinks(t *testing.T) {
+	var body []byte
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = ioutil.ReadAll(r.Body)
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"took": 1, "errors": false, "items": [{"index": {"_index": "search-links", "_type": "link", "_id": "https://www.example.com/", "status": 201}}]}`))
+	}))
+	defer ts.Close()
+
+	e, err := MockService(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	links := &document.Outlinks{
+		ID: "https://www.example.com/", Host: "www.example.com", Domain: "example.com", Crawled: "20170901",
+		Links: []document.Link{{Target: "https://www.example.org/", Anchor: "Example"}},
+	}
+
+	if err := e.UpsertLinks(links); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := e.Bulk.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `{"index":{"_index":"search-links","_id":"https://www.example.com/","_type":"link"}}
+{"host":"www.example.com","domain":"example.com","crawled":"20170901","links":[{"target":"https://www.example.org/","anchor":"Example"}]}`
+	if !strings.Contains(string(body), want) {
+		t.Fatalf("got %s; want %s", body, want)
+	}
+
+	if stats := e.Bulk.Stats(); stats.Succeeded != 1 {
+		t.Fatalf("upsert failed: got %d", stats.Succeeded)
+	}
+}
+
+func TestCluster(t *testing.T) {
+	hits := `{
+		"took": 2,
+		"timed_out": false,
+		"hits": {
+			"total": 1,
+			"max_score": 0,
+			"hits": [
+				{
+					"_index": "search-english",
+					"_type": "document",
+					"_id": "http://www.example.com/print/article",
+					"_score": 0,
+					"_source": %v
+				}
+			]
+		}
+	}`
+
+	for _, c := range []struct {
+		name    string
+		simhash string
+		cluster string // from our last crawl
+		source  string
+		want    string
+	}{
+		{
+			name: "too little text",
+			want: "http://www.example.com/article",
+		},
+		{
+			name:    "known cluster",
+			simhash: "00000000000000ff",
+			cluster: "http://mirror.example.com/article",
+			want:    "http://mirror.example.com/article",
+		},
+		{
+			name:    "near-duplicate",
+			simhash: "00000000000000ff",
+			source:  `{"simhash": "00000000000000fe", "cluster": "http://mirror.example.com/article"}`,
+			want:    "http://mirror.example.com/article",
+		},
+		{
+			name:    "near-duplicate without a cluster",
+			simhash: "00000000000000ff",
+			source:  `{"simhash": "00000000000000fe"}`,
+			want:    "http://www.example.com/print/article",
+		},
+		{
+			name:    "same band but too far apart",
+			simhash: "00000000000000ff",
+			source:  `{"simhash": "0000ffff000000ff", "cluster": "http://mirror.example.com/article"}`,
+			want:    "http://www.example.com/article",
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if c.cluster != "" {
+					t.Fatal("searched for a cluster we already know")
+				}
+				if !strings.HasPrefix(r.URL.Path, "/search-english/") {
+					t.Fatalf("searched %v; want only the doc's own index", r.URL.Path)
+				}
+				w.WriteHeader(http.StatusOK)
+				w.Write([]byte(fmt.Sprintf(hits, c.source)))
+			}))
+			defer ts.Close()
+
+			e, err := MockService(ts.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			doc := &document.Document{ID: "http://www.example.com/article"}
+			doc.SimHash = c.simhash
+			doc.SimHashBands = document.SimHashBands(c.simhash)
+			doc.Cluster = c.cluster
+
+			if err := e.cluster(doc, "search-english"); err != nil {
+				t.Fatal(err)
+			}
+
+			if doc.Cluster != c.want {
+				t.Fatalf("got %q; want %q", doc.Cluster, c.want)
+			}
+		})
+	}
+}
+
+func TestLastCrawled(t *testing.T) {
+	for _, c := range []struct {
+		name   string
+		status int
+		resp   string
+		want   map[string]time.Time
+	}{
+		{
+			name:   "basic",
+			status: http.StatusOK,
+			resp: `{
+				"took": 2,
+				"timed_out": false,
+				"hits": {
+					"total": 1,
+					"max_score": 1,
+					"hits": [
+						{
+							"_index": "search-english",
+							"_type": "document",
+							"_id": "http://www.example.com/crawled",
+							"_score": 1,
+							"_source": {"crawled": "20170901"}
+						}
+					]
+				}
+			}`,
+			want: map[string]time.Time{
+				"http://www.example.com/crawled": time.Date(2017, time.September, 1, 0, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			name:   "no search index yet",
+			status: http.StatusNotFound,
+			resp:   `{"error": {"type": "index_not_found_exception", "reason": "no such index"}, "status": 404}`,
+			want:   map[string]time.Time{},
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			var requests int
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				b, _ := ioutil.ReadAll(r.Body)
+				if !strings.Contains(string(b), `"values":["http://www.example.com/crawled","http://www.example.com/new"]`) {
+					t.Fatalf("got query %s", b)
+				}
+				w.WriteHeader(c.status)
+				w.Write([]byte(c.resp))
+			}))
+			defer ts.Close()
+
+			e, err := MockService(ts.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := e.LastCrawled([]string{"http://www.example.com/crawled", "http://www.example.com/new"})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if requests != 1 {
+				t.Fatalf("got %d requests; want 1", requests)
+			}
+
+			if !reflect.DeepEqual(got, c.want) {
+				t.Fatalf("got %+v; want %+v", got, c.want)
+			}
+		})
+	}
+}
+
 func TestCrawledAndCount(t *testing.T) {
 	type want struct {
 		count   int
-		crawled time.Time
+		crawled Crawled
 		err     error
 	}
 
@@ -176,7 +506,15 @@ func TestCrawledAndCount(t *testing.T) {
 								"_id": "http://www.example.com/path/to/somewhere",
 								"_score": 9.395768,
 								"_source": {
-								"crawled": "20170706"
+								"crawled": "20170706",
+								"next_crawl": "20170707T120000Z",
+								"interval": 129600,
+								"crawls": 3,
+								"changes": 1,
+								"fingerprint": "2b5e0d2c4f1a9e87",
+								"etag": "\"abc123\"",
+								"simhash": "00000000000000ff",
+								"cluster": "http://mirror.example.com/path/to/somewhere"
 								}
 							}
 							]
@@ -184,7 +522,23 @@ func TestCrawledAndCount(t *testing.T) {
 					}
 				]
 			}`,
-			want: want{593, time.Date(2017, time.July, 06, 0, 0, 0, 0, time.UTC), nil},
+			want: want{
+				593,
+				Crawled{
+					ID:          "http://www.example.com/path/to/somewhere",
+					Index:       "search-english",
+					Date:        time.Date(2017, time.July, 06, 0, 0, 0, 0, time.UTC),
+					Due:         time.Date(2017, time.July, 07, 12, 0, 0, 0, time.UTC),
+					Interval:    36 * time.Hour,
+					Crawls:      3,
+					Changes:     1,
+					Fingerprint: "2b5e0d2c4f1a9e87",
+					ETag:        `"abc123"`,
+					SimHash:     "00000000000000ff",
+					Cluster:     "http://mirror.example.com/path/to/somewhere",
+				},
+				nil,
+			},
 		},
 		{
 			name:   "does not exist",
@@ -264,7 +618,7 @@ func TestCrawledAndCount(t *testing.T) {
 				]
 			}`,
 			status: http.StatusOK,
-			want:   want{412, time.Time{}, nil},
+			want:   want{412, Crawled{ID: "http://www.example.com/path/to/nowhere"}, nil},
 		},
 	} {
 		t.Run(c.name, func(t *testing.T) {
@@ -290,12 +644,95 @@ func TestCrawledAndCount(t *testing.T) {
 			}
 
 			if crawled != c.want.crawled {
-				t.Fatalf("got %q; want %q", crawled, c.want.crawled)
+				t.Fatalf("got %+v; want %+v", crawled, c.want.crawled)
 			}
 		})
 	}
 }
 
+func TestTouch(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{
+			"took": 3,
+			"errors": false,
+			"items": [
+				{
+					"update": {
+						"_index": "search-english",
+						"_type": "document",
+						"_id": "http://www.example.com/path/to/somewhere",
+						"_version": 2,
+						"status": 200
+					}
+				}
+			]
+		}`))
+	}))
+	defer ts.Close()
+
+	e, err := MockService(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := Crawled{
+		ID:    "http://www.example.com/path/to/somewhere",
+		Index: "search-english",
+		Date:  time.Date(2017, time.September, 1, 0, 0, 0, 0, time.UTC),
+	}
+
+	if err := e.Touch(c); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := e.Bulk.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	stats := e.Bulk.Stats()
+	if stats.Succeeded != 1 {
+		t.Fatalf("touch failed: got %d", stats.Succeeded)
+	}
+}
+
+func TestDeleteImages(t *testing.T) {
+	var body []byte
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = ioutil.ReadAll(r.Body)
+		w.Write([]byte(`{"took": 1, "errors": false, "items": [{"update": {"_index": "search-images-english", "_type": "image", "_id": "https://www.example.com/cat.jpg", "status": 200}}]}`))
+	}))
+	defer ts.Close()
+
+	e, err := MockService(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	images := []*document.Image{
+		{ID: "https://www.example.com/cat.jpg", Language: language.English},
+	}
+
+	if err := e.DeleteImages("https://www.example.com/cats", images); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := e.Bulk.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	// only deleted if we last found it on that page
+	for _, want := range []string{
+		`{"update":{"_index":"search-images-english","_type":"image","_id":"https://www.example.com/cat.jpg"}}`,
+		`"params":{"page":"https://www.example.com/cats"}`,
+		`"scripted_upsert":true`,
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Fatalf("got %s; want %s", body, want)
+		}
+	}
+}
+
 func MockService(url string) (*ElasticSearch, error) {
 	client, err := elastic.NewSimpleClient(elastic.SetURL(url))
 	if err != nil {
@@ -0,0 +1,116 @@
+package crawler
+
+import (
+	"bufio"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// requestRate parses the non-standard "Request-rate" directive of a robots.txt file
+// and returns the delay between requests it implies for our useragent.
+// e.g. "Request-rate: 1/5" is 1 page every 5 seconds and "Request-rate: 30/1m" is 30 pages a minute.
+// An optional time of day ("Request-rate: 1/10s 0800-1200") is ignored.
+// The group is chosen the same way robotstxt.FindGroup chooses it: the most specific
+// user-agent that matches, falling back to "*" only if no other group is for us.
+// A group for us without a Request-rate means no rate even if "*" has one.
+// http://www.conman.org/people/spc/robots2.html#format.directives.request-rate
+func requestRate(body, agent string) time.Duration {
+	rates := map[string]time.Duration{} // every user-agent that has a group
+	agents := []string{}
+	inGroup := false // the user-agent lines are followed by directives
+
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i > -1 {
+			line = line[:i]
+		}
+
+		kv := strings.SplitN(line, ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+
+		key := strings.ToLower(strings.TrimSpace(kv[0]))
+		val := strings.TrimSpace(kv[1])
+
+		switch key {
+		case "user-agent", "useragent":
+			if inGroup { // a new group
+				agents = []string{}
+				inGroup = false
+			}
+			a := strings.ToLower(val)
+			agents = append(agents, a)
+			if _, ok := rates[a]; !ok {
+				rates[a] = 0
+			}
+		case "request-rate":
+			inGroup = true
+			d, ok := parseRequestRate(val)
+			if !ok {
+				continue
+			}
+			for _, a := range agents {
+				rates[a] = d
+			}
+		default:
+			inGroup = true
+		}
+	}
+
+	var prefixLen int
+	agent = strings.ToLower(agent)
+
+	rate, found := time.Duration(0), false
+
+	for a, d := range rates {
+		if a != "*" && a != "" && strings.HasPrefix(agent, a) && len(a) > prefixLen {
+			prefixLen = len(a)
+			rate, found = d, true
+		}
+	}
+
+	if !found {
+		rate = rates["*"]
+	}
+
+	return rate
+}
+
+// parseRequestRate converts "pages/time[unit]" to the delay between requests
+func parseRequestRate(s string) (time.Duration, bool) {
+	f := strings.Fields(s)
+	if len(f) == 0 {
+		return 0, false
+	}
+
+	parts := strings.SplitN(f[0], "/", 2)
+	if len(parts) != 2 {
+		return 0, false
+	}
+
+	pages, err := strconv.ParseFloat(parts[0], 64)
+	if err != nil || pages <= 0 {
+		return 0, false
+	}
+
+	unit := time.Second
+	t := strings.ToLower(parts[1])
+	switch {
+	case strings.HasSuffix(t, "s"):
+		t = strings.TrimSuffix(t, "s")
+	case strings.HasSuffix(t, "m"):
+		unit, t = time.Minute, strings.TrimSuffix(t, "m")
+	case strings.HasSuffix(t, "h"):
+		unit, t = time.Hour, strings.TrimSuffix(t, "h")
+	}
+
+	n, err := strconv.ParseFloat(t, 64)
+	if err != nil || n <= 0 {
+		return 0, false
+	}
+
+	return time.Duration(n / pages * float64(unit)), true
+}
@@ -0,0 +1,142 @@
+package crawler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jivesearch/jivesearch/search/crawler/queue"
+)
+
+func TestRequestRate(t *testing.T) {
+	for _, c := range []struct {
+		name  string
+		body  string
+		agent string
+		want  time.Duration
+	}{
+		{"none", "User-agent: *\nDisallow: /private", "jivesearchbot", 0},
+		{"seconds", "User-agent: *\nRequest-rate: 1/5", "jivesearchbot", 5 * time.Second},
+		{"explicit seconds", "User-agent: *\nRequest-rate: 2/10s", "jivesearchbot", 5 * time.Second},
+		{"minutes", "User-agent: *\nRequest-rate: 30/1m # comment", "jivesearchbot", 2 * time.Second},
+		{"hours w/ time of day", "User-agent: *\nRequest-rate: 60/1h 0800-1200", "jivesearchbot", 1 * time.Minute},
+		{"invalid", "User-agent: *\nRequest-rate: fast", "jivesearchbot", 0},
+		{
+			name:  "specific agent wins",
+			body:  "User-agent: *\nRequest-rate: 1/1\n\nUser-agent: otherbot\nUser-agent: JiveSearchBot\nRequest-rate: 1/10",
+			agent: "jivesearchbot/1.0",
+			want:  10 * time.Second,
+		},
+		{
+			name:  "other agent",
+			body:  "User-agent: otherbot\nRequest-rate: 1/60\n\nUser-agent: *\nDisallow: /private\nRequest-rate: 1/2",
+			agent: "jivesearchbot",
+			want:  2 * time.Second,
+		},
+		{
+			name:  "our group has no rate",
+			body:  "User-agent: *\nRequest-rate: 1/30\n\nUser-agent: jivesearchbot\nDisallow: /private",
+			agent: "jivesearchbot",
+			want:  0,
+		},
+		{
+			name:  "a directive ends the group",
+			body:  "User-agent: otherbot\nDisallow: /\nUser-agent: *\nRequest-rate: 1/3",
+			agent: "jivesearchbot",
+			want:  3 * time.Second,
+		},
+		{
+			name:  "sitemap ends the group",
+			body:  "User-agent: jivesearchbot\nSitemap: http://www.example.com/sitemap.xml\nUser-agent: *\nRequest-rate: 1/3",
+			agent: "jivesearchbot",
+			want:  0,
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			got := requestRate(c.body, c.agent)
+			if got != c.want {
+				t.Fatalf("got %v; want %v", got, c.want)
+			}
+		})
+	}
+}
+
+// busyDomainQueue has a host or domain we can't crawl right now
+type busyDomainQueue struct {
+	mockQueue
+	hostErr   error
+	domainErr error
+	hostDelay time.Duration
+	deferred  map[string]time.Duration
+}
+
+func (q *busyDomainQueue) ReserveHost(host string, ttl time.Duration) (int, error) {
+	return 0, q.hostErr
+}
+
+func (q *busyDomainQueue) ReserveDomain(domain string, max int, ttl time.Duration) error {
+	return q.domainErr
+}
+
+func (q *busyDomainQueue) Limits(host, domain string) (queue.Limits, error) {
+	return queue.Limits{HostDelay: q.hostDelay}, nil
+}
+
+func (q *busyDomainQueue) DeferLink(lnk queue.Link, delay time.Duration) error {
+	q.deferred[lnk.URL] = delay
+	return nil
+}
+
+func TestWorkBusy(t *testing.T) {
+	now = func() time.Time {
+		t, _ := time.Parse(time.RFC3339, "2017-09-01T15:04:05Z")
+		return t
+	}
+
+	for _, c := range []struct {
+		name      string
+		hostErr   error
+		domainErr error
+		hostDelay time.Duration
+		want      time.Duration
+	}{
+		{"host delayed", queue.ErrAlreadyReserved, nil, 45 * time.Second, 45 * time.Second},
+		{"host almost free", queue.ErrAlreadyReserved, nil, 2 * time.Second, minDeferDelay},
+		{"domain busy", nil, queue.ErrDomainBusy, 0, minDeferDelay},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			lnk := "https://www.example.com/page"
+
+			q := &busyDomainQueue{
+				hostErr:   c.hostErr,
+				domainErr: c.domainErr,
+				hostDelay: c.hostDelay,
+				deferred:  map[string]time.Duration{},
+			}
+
+			cr := &Crawler{
+				since:               45 * 24 * time.Hour,
+				maxDomainLinks:      100,
+				maxDomainConcurrent: 2,
+				Queue:               q,
+				Backend:             &mockBackend{},
+				channels:            channels{err: make(chan error, 10)},
+			}
+
+			cr.work(queue.Link{URL: lnk, Depth: 2, Priority: 1.5})
+
+			// the link is back in the queue instead of lost
+			got, ok := q.deferred[lnk]
+			if !ok {
+				t.Fatal("expected the link to be deferred")
+			}
+
+			if got != c.want {
+				t.Fatalf("got delay %v; want %v", got, c.want)
+			}
+
+			if len(cr.err) != 0 {
+				t.Fatal(<-cr.err)
+			}
+		})
+	}
+}
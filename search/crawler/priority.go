@@ -0,0 +1,135 @@
+package crawler
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/jivesearch/jivesearch/log"
+	"github.com/jivesearch/jivesearch/search/crawler/queue"
+	"github.com/jivesearch/jivesearch/search/document"
+)
+
+const (
+	votesTTL         = 1 * time.Hour // how long we cache the vote total of a domain
+	maxCachedDomains = 100000
+	maxLookups       = 500 // urls we look up in our Backend at a time
+)
+
+type voteCache struct {
+	sync.Mutex
+	m map[string]cachedVotes
+}
+
+type cachedVotes struct {
+	votes   int
+	expires time.Time
+}
+
+// priority scores a link for our crawl frontier. The higher the score, the sooner it is crawled.
+// Links closer to a seed, on domains our users have upvoted and that haven't been
+// crawled in a while (or ever) go first. A link found on many pages is added
+// to the frontier many times and accumulates priority each time (see queue.Redis.AddLink).
+func (c *Crawler) priority(lnk queue.Link, domain string, crawled time.Time) float64 {
+	p := 1 / float64(1+lnk.Depth)
+	p *= voteFactor(c.domainVotes(domain))
+	p *= staleness(crawled, c.since)
+	return p
+}
+
+// queueLinks prioritizes links and hands them to our linkHandler. When we last crawled
+// them is looked up all at once...the links on a page cost us one trip to our Backend.
+func (c *Crawler) queueLinks(lnks []queue.Link) {
+	docs := make([]*document.Document, 0, len(lnks))
+	valid := make([]queue.Link, 0, len(lnks))
+	for _, lnk := range lnks {
+		doc, err := document.New(lnk.URL)
+		if err != nil {
+			continue
+		}
+		docs = append(docs, doc)
+		valid = append(valid, lnk)
+	}
+
+	crawled := c.lastCrawled(docs)
+	for i, lnk := range valid {
+		lnk.Priority = c.priority(lnk, docs[i].Domain, crawled[docs[i].ID])
+		c.links <- lnk
+	}
+}
+
+// lastCrawled returns when we last crawled each doc, maxLookups at a time. A page we never
+// crawled (or can't tell) is left out...better to crawl a page again too soon than never.
+func (c *Crawler) lastCrawled(docs []*document.Document) map[string]time.Time {
+	crawled := map[string]time.Time{}
+
+	for i := 0; i < len(docs); i += maxLookups {
+		end := i + maxLookups
+		if end > len(docs) {
+			end = len(docs)
+		}
+
+		ids := []string{}
+		for _, doc := range docs[i:end] {
+			ids = append(ids, doc.ID)
+		}
+
+		m, err := c.Backend.LastCrawled(ids)
+		if err != nil {
+			log.Debug.Println(err)
+			continue
+		}
+
+		for id, t := range m {
+			crawled[id] = t
+		}
+	}
+
+	return crawled
+}
+
+// voteFactor is on a log scale so a handful of votes
+// matters but a flood of them doesn't take over.
+func voteFactor(votes int) float64 {
+	f := 1 + math.Log1p(math.Abs(float64(votes)))/4
+	if votes < 0 {
+		return 1 / f
+	}
+	return f
+}
+
+// staleness favors links that were never crawled or were crawled long ago.
+// Ranges from .5 (just crawled) to 2 (never crawled or twice our recrawl interval).
+func staleness(crawled time.Time, since time.Duration) float64 {
+	if crawled == (time.Time{}) || since <= 0 {
+		return 2
+	}
+
+	return math.Min(2, .5+float64(now().Sub(crawled))/float64(since))
+}
+
+// domainVotes returns the (cached) vote total of a domain
+func (c *Crawler) domainVotes(domain string) int {
+	if c.Votes == nil || domain == "" {
+		return 0
+	}
+
+	c.voteCache.Lock()
+	defer c.voteCache.Unlock()
+
+	if c.voteCache.m == nil || len(c.voteCache.m) > maxCachedDomains {
+		c.voteCache.m = make(map[string]cachedVotes)
+	}
+
+	if cv, ok := c.voteCache.m[domain]; ok && now().Before(cv.expires) {
+		return cv.votes
+	}
+
+	votes, err := c.Votes.DomainVotes(domain)
+	if err != nil {
+		log.Info.Println(err)
+	}
+
+	c.voteCache.m[domain] = cachedVotes{votes: votes, expires: now().Add(votesTTL)}
+	return votes
+}
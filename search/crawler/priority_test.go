@@ -0,0 +1,194 @@
+package crawler
+
+import (
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/jivesearch/jivesearch/search/crawler/queue"
+	"github.com/jivesearch/jivesearch/search/document"
+	"github.com/jivesearch/jivesearch/search/vote"
+	"golang.org/x/text/language"
+)
+
+func TestPriority(t *testing.T) {
+	now = func() time.Time {
+		return time.Date(2017, time.September, 1, 15, 4, 5, 0, time.UTC)
+	}
+
+	for _, c := range []struct {
+		name    string
+		lnk     queue.Link
+		domain  string
+		crawled time.Time
+		want    float64
+	}{
+		{"seed", queue.Link{URL: "http://www.example.com"}, "example.com", time.Time{}, 2},
+		{"deep", queue.Link{URL: "http://www.example.com/a/b", Depth: 3}, "example.com", time.Time{}, .5},
+		{
+			"crawled recently", queue.Link{URL: "http://www.example.com"}, "example.com",
+			now().Add(-10 * 24 * time.Hour), 1,
+		},
+		{
+			"crawled long ago", queue.Link{URL: "http://www.example.com"}, "example.com",
+			now().Add(-100 * 24 * time.Hour), 2,
+		},
+		{"upvoted", queue.Link{URL: "http://www.upvoted.com", Depth: 1}, "upvoted.com", time.Time{}, 1 + math.Log1p(20)/4},
+		{"downvoted", queue.Link{URL: "http://www.downvoted.com", Depth: 1}, "downvoted.com", time.Time{}, 1 / (1 + math.Log1p(20)/4)},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			cr := &Crawler{
+				since: 20 * 24 * time.Hour,
+				Votes: &mockVoter{
+					"upvoted.com":   20,
+					"downvoted.com": -20,
+				},
+			}
+
+			got := cr.priority(c.lnk, c.domain, c.crawled)
+			if math.Abs(got-c.want) > 1e-9 {
+				t.Fatalf("got %v; want %v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestQueueLinks(t *testing.T) {
+	now = func() time.Time {
+		return time.Date(2017, time.September, 1, 15, 4, 5, 0, time.UTC)
+	}
+
+	b := &crawledBackend{
+		crawled: map[string]time.Time{
+			"http://www.example.com/fresh": now().Add(-1 * 24 * time.Hour),
+			"http://www.example.com/stale": now().Add(-60 * 24 * time.Hour),
+		},
+	}
+
+	q := &priorityQueue{priorities: map[string]float64{}}
+	cr := &Crawler{
+		since:   20 * 24 * time.Hour,
+		Backend: b,
+		Queue:   q,
+		links:   make(chan queue.Link, 3),
+		err:     make(chan error, 1),
+	}
+
+	cr.queueLinks([]queue.Link{
+		{URL: "http://www.example.com/fresh", Depth: 1},
+		{URL: "http://www.example.com/stale", Depth: 1},
+		{URL: "not a url", Depth: 1},
+	})
+	close(cr.links)
+	cr.linkHandler()
+
+	// one trip to the backend for all the links
+	if b.lookups != 1 {
+		t.Fatalf("got %d lookups; want 1", b.lookups)
+	}
+
+	if len(q.priorities) != 2 {
+		t.Fatalf("got %d links; want 2", len(q.priorities))
+	}
+
+	fresh, stale := q.priorities["http://www.example.com/fresh"], q.priorities["http://www.example.com/stale"]
+	if stale <= fresh {
+		t.Fatalf("got stale %v, fresh %v; want the stale page first", stale, fresh)
+	}
+}
+
+func TestLastCrawledBatches(t *testing.T) {
+	b := &crawledBackend{crawled: map[string]time.Time{}}
+	cr := &Crawler{Backend: b}
+
+	docs := []*document.Document{}
+	for i := 0; i < maxLookups+1; i++ {
+		doc, err := document.New(fmt.Sprintf("http://www.example.com/%d", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		docs = append(docs, doc)
+	}
+
+	cr.lastCrawled(docs)
+
+	if b.lookups != 2 {
+		t.Fatalf("got %d lookups; want 2", b.lookups)
+	}
+}
+
+// crawledBackend knows when we last crawled each url
+type crawledBackend struct {
+	mockBackend
+	crawled map[string]time.Time
+	lookups int
+}
+
+func (b *crawledBackend) LastCrawled(urls []string) (map[string]time.Time, error) {
+	b.lookups++
+
+	m := map[string]time.Time{}
+	for _, u := range urls {
+		if t, ok := b.crawled[u]; ok {
+			m[u] = t
+		}
+	}
+	return m, nil
+}
+
+// priorityQueue remembers the priority of the links we add
+type priorityQueue struct {
+	mockQueue
+	priorities map[string]float64
+}
+
+func (q *priorityQueue) AddLink(lnk queue.Link, priority float64) error {
+	q.priorities[lnk.URL] = priority
+	return nil
+}
+
+func TestDomainVotesCache(t *testing.T) {
+	now = func() time.Time {
+		return time.Date(2017, time.September, 1, 15, 4, 5, 0, time.UTC)
+	}
+
+	v := &mockVoter{"example.com": 5}
+	cr := &Crawler{Votes: v}
+
+	if got := cr.domainVotes("example.com"); got != 5 {
+		t.Fatalf("got %d; want 5", got)
+	}
+
+	(*v)["example.com"] = 10
+	if got := cr.domainVotes("example.com"); got != 5 {
+		t.Fatalf("expected cached votes; got %d", got)
+	}
+
+	now = func() time.Time {
+		return time.Date(2017, time.September, 1, 17, 4, 5, 0, time.UTC)
+	}
+
+	if got := cr.domainVotes("example.com"); got != 10 {
+		t.Fatalf("expected expired cache; got %d", got)
+	}
+}
+
+type mockVoter map[string]int
+
+func (m *mockVoter) Setup() error { return nil }
+func (m *mockVoter) Get(query string, lang language.Tag, limit int) ([]vote.Result, error) {
+	return []vote.Result{}, nil
+}
+func (m *mockVoter) DomainVotes(domain string) (int, error) {
+	votes, ok := (*m)[domain]
+	if !ok {
+		return 0, fmt.Errorf("unknown domain %q", domain)
+	}
+	return votes, nil
+}
+func (m *mockVoter) Insert(v *vote.Vote) error { return nil }
+func (m *mockVoter) Bursts(since time.Time, min int) ([]vote.Burst, error) {
+	return []vote.Burst{}, nil
+}
+func (m *mockVoter) Purge(b vote.Burst) (int64, error) { return 0, nil }
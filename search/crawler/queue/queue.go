@@ -9,10 +9,39 @@ import (
 // Queuer is handles links and our crawling queue
 type Queuer interface {
 	CountLinks() (int64, error)
-	AddLink(lnk string) error
-	QueueLink(ttl time.Duration) (string, error)
-	ReserveHost(host string, ttl time.Duration) error
+	AddLink(lnk Link, priority float64) error
+	AddSitemapLink(lnk SitemapLink, priority float64, ttl time.Duration) error
+	GetSitemapLink(lnk string) (SitemapLink, error)
+	QueueLink(ttl time.Duration) (Link, error)
+	DeferLink(lnk Link, delay time.Duration) error
+	ReserveHost(host string, ttl time.Duration) (int, error)
 	DelayHost(host string, ttl time.Duration) error
+	AddHostFailure(host string, ttl time.Duration) (int, error)
+	ResetHostFailures(host string) error
+	ReserveDomain(domain string, max int, ttl time.Duration) error
+	ReleaseDomain(domain string) error
+	Limits(host, domain string) (Limits, error)
+}
+
+// Link is a link in our crawl frontier
+type Link struct {
+	URL      string
+	Depth    int     // the number of links away from a seed url
+	Priority float64 // the link's priority in our frontier
+}
+
+// SitemapLink is a link discovered in a sitemap along with its optional metadata
+// https://www.sitemaps.org/protocol.html#xmlTagDefinitions
+type SitemapLink struct {
+	URL      string
+	LastMod  time.Time // zero if the sitemap didn't specify it
+	Priority float64   // 0.0 - 1.0 (0.5 is the default per the protocol)
+}
+
+// Limits are the politeness limits currently in effect for a host and its domain
+type Limits struct {
+	HostDelay      time.Duration // time until the host can be crawled again (0 if it is free)
+	DomainRequests int           // number of requests in flight to the domain
 }
 
 // ErrNotQueued indicates a link was not queued
@@ -20,4 +49,7 @@ var ErrNotQueued = errors.New("link already queued")
 
 // ErrAlreadyReserved indicates another worker reserved the host
 var ErrAlreadyReserved = errors.New("host already reserved")
+
+// ErrDomainBusy indicates the domain already has the max number of requests in flight
+var ErrDomainBusy = errors.New("domain has too many requests in flight")
 var errNotDelayed = errors.New("host not delayed")
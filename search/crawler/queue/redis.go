@@ -1,18 +1,32 @@
 package queue
 
 import (
+	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/garyburd/redigo/redis"
 )
 
 const (
-	prefix      = "jivesearch:"
-	hostPrefix  = "h:"
-	queuePrefix = "q:"
-	links       = prefix + "links"
+	prefix        = "jivesearch:"
+	hostPrefix    = "h:"
+	queuePrefix   = "q:"
+	sitemapPrefix = "s:"
+	failurePrefix = "f:"
+	domainPrefix  = "d:"
+	frontier      = prefix + "frontier"   // sorted set of links by priority
+	depths        = prefix + "depths"     // hash of link -> depth
+	deferred      = prefix + "deferred"   // sorted set of links we couldn't crawl yet by when they go back in the frontier
+	priorities    = prefix + "priorities" // hash of deferred link -> priority
+	maxPromoted   = 100                   // deferred links moved back to the frontier at a time
+	links         = prefix + "links"      // the set our links were in before we had a frontier
+	linkPriority  = 1                     // of the links we move from that set
 )
 
+var now = func() time.Time { return time.Now().UTC() }
+
 // Redis implements the Queuer interface
 type Redis struct {
 	RedisPool *redis.Pool
@@ -30,53 +44,214 @@ func (r *Redis) do(commandName string, args ...interface{}) (reply interface{},
 	return c.Do(commandName, args...)
 }
 
-// CountLinks counts the number of links in our queue
+// CountLinks counts the number of links in our frontier
 func (r *Redis) CountLinks() (int64, error) {
-	cnt, err := redis.Int64(r.do("SCARD", links))
+	cnt, err := redis.Int64(r.do("ZCARD", frontier))
 	return cnt, err
 }
 
-// AddLink adds a link to our redis set
-func (r *Redis) AddLink(lnk string) error {
-	_, err := r.do("SADD", links, lnk)
+// AddLink adds a link to our frontier. A link that is added more than once
+// (e.g. it has many inbound links) accumulates priority each time it is seen.
+// We keep the depth of the first sighting of a link.
+func (r *Redis) AddLink(lnk Link, priority float64) error {
+	if _, err := r.do("ZINCRBY", frontier, priority, lnk.URL); err != nil {
+		return err
+	}
+
+	_, err := r.do("HSETNX", depths, lnk.URL, lnk.Depth)
 	return err
 }
 
-// QueueLink pops a link from our set
-func (r *Redis) QueueLink(ttl time.Duration) (string, error) {
-	lnk, err := redis.String(r.do("SPOP", links))
+// AddSitemapLink adds a link to our frontier and stores the
+// lastmod and priority from the sitemap alongside it.
+// Sitemap links are treated as one link away from a seed.
+func (r *Redis) AddSitemapLink(lnk SitemapLink, priority float64, ttl time.Duration) error {
+	if err := r.AddLink(Link{URL: lnk.URL, Depth: 1}, priority); err != nil {
+		return err
+	}
+
+	var lastmod int64
+	if !lnk.LastMod.IsZero() {
+		lastmod = lnk.LastMod.Unix()
+	}
+
+	k := r.prefixKey(sitemapPrefix + lnk.URL)
+	v := fmt.Sprintf("%d %v", lastmod, strconv.FormatFloat(lnk.Priority, 'f', -1, 64))
+
+	_, err := r.do("SET", k, v, "EX", seconds(ttl))
+	return err
+}
+
+// GetSitemapLink retrieves the sitemap metadata of a link (if any)
+func (r *Redis) GetSitemapLink(lnk string) (SitemapLink, error) {
+	sl := SitemapLink{URL: lnk}
+
+	v, err := redis.String(r.do("GET", r.prefixKey(sitemapPrefix+lnk)))
 	if err != nil {
 		if err == redis.ErrNil {
 			err = nil
 		}
-		return "", err
+		return sl, err
+	}
+
+	parts := strings.Fields(v)
+	if len(parts) != 2 {
+		return sl, fmt.Errorf("invalid sitemap value for %v: %q", lnk, v)
+	}
+
+	lastmod, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return sl, err
 	}
 
-	k := r.prefixKey(queuePrefix + lnk)
+	if lastmod != 0 {
+		sl.LastMod = time.Unix(lastmod, 0).UTC()
+	}
+
+	sl.Priority, err = strconv.ParseFloat(parts[1], 64)
+	return sl, err
+}
+
+// Setup moves the links queued before we had a frontier into it
+func (r *Redis) Setup() error {
+	cursor := 0
+
+	for {
+		reply, err := redis.Values(r.do("SSCAN", links, cursor, "COUNT", 1000))
+		if err != nil {
+			return err
+		}
+
+		if len(reply) != 2 {
+			return fmt.Errorf("unexpected SSCAN reply: %v", reply)
+		}
+
+		if cursor, err = redis.Int(reply[0], nil); err != nil {
+			return err
+		}
+
+		lnks, err := redis.Strings(reply[1], nil)
+		if err != nil {
+			return err
+		}
+
+		if len(lnks) > 0 {
+			args := redis.Args{}.Add(frontier, "NX")
+			for _, l := range lnks {
+				args = args.Add(linkPriority, l)
+			}
+
+			if _, err := r.do("ZADD", args...); err != nil {
+				return err
+			}
+		}
+
+		if cursor == 0 {
+			break
+		}
+	}
+
+	_, err := r.do("DEL", links)
+	return err
+}
+
+// pop moves the deferred links that are due back to our frontier and then
+// takes the link with the highest priority (and its depth) out of it. It is one
+// script so two workers can never get the same link.
+var pop = redis.NewScript(4, `
+local due = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, ARGV[2])
+for _, u in ipairs(due) do
+	redis.call('ZINCRBY', KEYS[3], redis.call('HGET', KEYS[2], u) or 0, u)
+	redis.call('ZREM', KEYS[1], u)
+	redis.call('HDEL', KEYS[2], u)
+end
+
+local top = redis.call('ZREVRANGE', KEYS[3], 0, 0, 'WITHSCORES')
+if #top == 0 then
+	return {}
+end
+
+redis.call('ZREM', KEYS[3], top[1])
+local depth = redis.call('HGET', KEYS[4], top[1]) or '0'
+redis.call('HDEL', KEYS[4], top[1])
+return {top[1], top[2], depth}
+`)
+
+// QueueLink pops the link with the highest priority from our frontier
+func (r *Redis) QueueLink(ttl time.Duration) (Link, error) {
+	lnk := Link{}
+
+	c := r.RedisPool.Get()
+	top, err := redis.Strings(pop.Do(c, deferred, priorities, frontier, depths, now().Unix(), maxPromoted))
+	c.Close()
+	if err != nil || len(top) != 3 {
+		return lnk, err
+	}
+
+	lnk.URL = top[0]
+
+	if lnk.Priority, err = strconv.ParseFloat(top[1], 64); err != nil {
+		return lnk, err
+	}
+
+	if lnk.Depth, err = strconv.Atoi(top[2]); err != nil {
+		return lnk, err
+	}
+
+	k := r.prefixKey(queuePrefix + lnk.URL)
 	set, err := r.do("SET", k, "", "EX", seconds(ttl), "NX")
 	if set != "OK" && err == nil { // means it is already queued
-		lnk = ""
+		lnk = Link{}
 	}
 
 	return lnk, err
 }
 
-// ReserveHost reserves a host for crawling
-func (r *Redis) ReserveHost(host string, ttl time.Duration) error {
-	k := r.prefixKey(hostPrefix + host)
-	s := seconds(ttl)
+// reserveHost sets the reservation of a host (if it doesn't have one) and returns
+// its consecutive failures so we only reset them when there are any. -1 means already reserved.
+var reserveHost = redis.NewScript(2, `
+if not redis.call('SET', KEYS[1], '', 'EX', ARGV[1], 'NX') then
+	return -1
+end
+return tonumber(redis.call('GET', KEYS[2]) or '0')
+`)
 
-	set, err := r.do("SET", k, "", "EX", s, "NX")
-	if err != nil {
+// DeferLink puts a link we took from the frontier back in it after a delay (e.g. its host is busy).
+// It keeps its priority and depth.
+func (r *Redis) DeferLink(lnk Link, delay time.Duration) error {
+	if _, err := r.do("ZADD", deferred, now().Add(delay).Unix(), lnk.URL); err != nil {
+		return err
+	}
+
+	if _, err := r.do("HSET", priorities, lnk.URL, lnk.Priority); err != nil {
 		return err
 	}
 
-	if set != "OK" {
-		err = ErrAlreadyReserved
+	if _, err := r.do("HSETNX", depths, lnk.URL, lnk.Depth); err != nil {
+		return err
 	}
+
+	// so we can queue it again
+	_, err := r.do("DEL", r.prefixKey(queuePrefix+lnk.URL))
 	return err
 }
 
+// ReserveHost reserves a host for crawling and returns its consecutive failures
+func (r *Redis) ReserveHost(host string, ttl time.Duration) (int, error) {
+	c := r.RedisPool.Get()
+	defer c.Close()
+
+	n, err := redis.Int(reserveHost.Do(c, r.prefixKey(hostPrefix+host), r.prefixKey(failurePrefix+host), seconds(ttl)))
+	if err != nil {
+		return 0, err
+	}
+
+	if n < 0 {
+		return 0, ErrAlreadyReserved
+	}
+	return n, nil
+}
+
 // DelayHost is like ReserveHost but makes sure the key is already set.
 // If key doesn't exist then something is wrong (eg reservation < crawler's timeout)
 func (r *Redis) DelayHost(host string, ttl time.Duration) error {
@@ -107,6 +282,89 @@ func (r *Redis) DelayHost(host string, ttl time.Duration) error {
 	return err
 }
 
+// AddHostFailure increments the number of consecutive failures (5xx, timeouts, etc)
+// for a host and returns the new total. The count expires after ttl.
+func (r *Redis) AddHostFailure(host string, ttl time.Duration) (int, error) {
+	k := r.prefixKey(failurePrefix + host)
+
+	n, err := redis.Int(r.do("INCR", k))
+	if err != nil {
+		return n, err
+	}
+
+	_, err = r.do("EXPIRE", k, seconds(ttl))
+	return n, err
+}
+
+// ResetHostFailures clears the consecutive failures of a host
+func (r *Redis) ResetHostFailures(host string) error {
+	_, err := r.do("DEL", r.prefixKey(failurePrefix+host))
+	return err
+}
+
+// ReserveDomain reserves one of the max concurrent requests to a domain.
+// The counter expires after ttl w/out any new reservations in case a worker never releases it.
+func (r *Redis) ReserveDomain(domain string, max int, ttl time.Duration) error {
+	k := r.prefixKey(domainPrefix + domain)
+
+	n, err := redis.Int(r.do("INCR", k))
+	if err != nil {
+		return err
+	}
+
+	if _, err := r.do("EXPIRE", k, seconds(ttl)); err != nil {
+		return err
+	}
+
+	if n > max {
+		if _, err := r.do("DECR", k); err != nil {
+			return err
+		}
+		return ErrDomainBusy
+	}
+
+	return nil
+}
+
+// ReleaseDomain releases a reservation made with ReserveDomain
+func (r *Redis) ReleaseDomain(domain string) error {
+	k := r.prefixKey(domainPrefix + domain)
+
+	n, err := redis.Int(r.do("DECR", k))
+	if err != nil {
+		return err
+	}
+
+	// the counter expired while the request was in flight
+	if n < 0 {
+		_, err = r.do("DEL", k)
+	}
+
+	return err
+}
+
+// Limits returns the politeness limits in effect for a host & its domain
+func (r *Redis) Limits(host, domain string) (Limits, error) {
+	l := Limits{}
+
+	// -2 means the key doesn't exist & -1 means no expiration
+	ms, err := redis.Int64(r.do("PTTL", r.prefixKey(hostPrefix+host)))
+	if err != nil {
+		return l, err
+	}
+
+	if ms > 0 {
+		l.HostDelay = time.Duration(ms) * time.Millisecond
+	}
+
+	l.DomainRequests, err = redis.Int(r.do("GET", r.prefixKey(domainPrefix+domain)))
+	if err == redis.ErrNil {
+		err = nil
+	}
+
+	return l, err
+}
+
 func seconds(ttl time.Duration) int {
 	return int(ttl / time.Second)
 }
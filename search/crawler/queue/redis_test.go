@@ -9,21 +9,108 @@ import (
 )
 
 func TestAddLink(t *testing.T) {
+	for _, c := range []struct {
+		name     string
+		link     Link
+		priority float64
+	}{
+		{
+			"first", Link{URL: "http://www.example.com"}, 1,
+		},
+		{
+			"second", Link{URL: "https://www.somelink.com/and/a/path/?for=fun", Depth: 3}, 0.25,
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			r := &Redis{}
+			conn := redigomock.NewConn()
+			conn.Command("ZINCRBY", frontier, c.priority, c.link.URL).Expect("1")
+			conn.Command("HSETNX", depths, c.link.URL, c.link.Depth).Expect(int64(1))
+
+			r.RedisPool = &redis.Pool{
+				Dial: func() (redis.Conn, error) {
+					return conn, nil
+				},
+			}
+			defer r.RedisPool.Close()
+
+			if err := r.AddLink(c.link, c.priority); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestAddSitemapLink(t *testing.T) {
 	for _, c := range []struct {
 		name string
-		link string
+		lnk  SitemapLink
+		want string
+	}{
+		{
+			"basic",
+			SitemapLink{
+				URL:      "http://www.example.com",
+				LastMod:  time.Date(2017, time.September, 1, 15, 4, 5, 0, time.UTC),
+				Priority: 0.8,
+			},
+			"1504278245 0.8",
+		},
+		{
+			"no lastmod", SitemapLink{URL: "https://www.somelink.com/and/a/path/?for=fun", Priority: 0.5}, "0 0.5",
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			ttl := 24 * time.Hour
+
+			r := &Redis{}
+			conn := redigomock.NewConn()
+			conn.Command("ZINCRBY", frontier, 0.5, c.lnk.URL).Expect("0.5")
+			conn.Command("HSETNX", depths, c.lnk.URL, 1).Expect(int64(1))
+			conn.Command("SET", r.prefixKey(sitemapPrefix+c.lnk.URL), c.want, "EX", int(ttl/time.Second)).Expect("OK")
+
+			r.RedisPool = &redis.Pool{
+				Dial: func() (redis.Conn, error) {
+					return conn, nil
+				},
+			}
+			defer r.RedisPool.Close()
+
+			if err := r.AddSitemapLink(c.lnk, 0.5, ttl); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestGetSitemapLink(t *testing.T) {
+	for _, c := range []struct {
+		name  string
+		link  string
+		value interface{}
+		want  SitemapLink
 	}{
 		{
-			"first", "http://www.example.com",
+			"basic", "http://www.example.com", "1504278245 0.8",
+			SitemapLink{
+				URL:      "http://www.example.com",
+				LastMod:  time.Date(2017, time.September, 1, 15, 4, 5, 0, time.UTC),
+				Priority: 0.8,
+			},
+		},
+		{
+			"no lastmod", "http://www.example.com/path", "0 0.5",
+			SitemapLink{URL: "http://www.example.com/path", Priority: 0.5},
 		},
 		{
-			"second", "https://www.somelink.com/and/a/path/?for=fun",
+			"missing", "http://www.example.com/missing", nil,
+			SitemapLink{URL: "http://www.example.com/missing"},
 		},
 	} {
 		t.Run(c.name, func(t *testing.T) {
 			r := &Redis{}
 			conn := redigomock.NewConn()
-			conn.Command("SADD", links, c.link).Expect("OK")
+			conn.Command("GET", r.prefixKey(sitemapPrefix+c.link)).Expect(c.value)
 
 			r.RedisPool = &redis.Pool{
 				Dial: func() (redis.Conn, error) {
@@ -32,32 +119,50 @@ func TestAddLink(t *testing.T) {
 			}
 			defer r.RedisPool.Close()
 
-			if err := r.AddLink(c.link); err != nil {
+			got, err := r.GetSitemapLink(c.link)
+			if err != nil {
 				t.Fatal(err)
 			}
+
+			if got != c.want {
+				t.Fatalf("got %+v; want: %+v", got, c.want)
+			}
 		})
 	}
 }
 
 func TestQueueLink(t *testing.T) {
 	for _, c := range []struct {
-		name string
-		link string
+		name   string
+		reply  []interface{}
+		queued interface{} // reply to SET NX of the queued marker
+		want   Link
 	}{
 		{
-			"first", "http://www.example.com",
+			"first", []interface{}{[]byte("http://www.example.com"), []byte("1.5"), []byte("0")}, "OK",
+			Link{URL: "http://www.example.com", Priority: 1.5},
+		},
+		{
+			"second", []interface{}{[]byte("https://www.somelink.com/and/a/path/?for=fun"), []byte("0.25"), []byte("2")}, "OK",
+			Link{URL: "https://www.somelink.com/and/a/path/?for=fun", Depth: 2, Priority: 0.25},
+		},
+		{
+			"empty frontier", []interface{}{}, nil, Link{},
 		},
 		{
-			"second", "https://www.somelink.com/and/a/path/?for=fun",
+			"already queued", []interface{}{[]byte("http://www.example.com/queued"), []byte("1"), []byte("1")}, nil, Link{},
 		},
 	} {
 		t.Run(c.name, func(t *testing.T) {
 			ttl := 10 * time.Minute
+			now = func() time.Time { return time.Date(2017, time.September, 1, 15, 4, 5, 0, time.UTC) }
 
 			r := &Redis{}
 			conn := redigomock.NewConn()
-			conn.Command("SPOP", links).Expect(c.link)
-			conn.Command("SET", r.prefixKey(queuePrefix+c.link), "", "EX", int(ttl/time.Second), "NX").Expect("OK")
+			conn.Command("EVALSHA", pop.Hash(), 4, deferred, priorities, frontier, depths, now().Unix(), maxPromoted).Expect(c.reply)
+			if len(c.reply) > 0 {
+				conn.Command("SET", r.prefixKey(queuePrefix+string(c.reply[0].([]byte))), "", "EX", int(ttl/time.Second), "NX").Expect(c.queued)
+			}
 
 			r.RedisPool = &redis.Pool{
 				Dial: func() (redis.Conn, error) {
@@ -71,33 +176,103 @@ func TestQueueLink(t *testing.T) {
 				t.Fatal(err)
 			}
 
-			if got != c.link {
-				t.Fatalf("got %v; want: %v", got, c.link)
+			if got != c.want {
+				t.Fatalf("got %+v; want %+v", got, c.want)
 			}
 		})
 	}
 }
 
+func TestSetup(t *testing.T) {
+	r := &Redis{}
+	conn := redigomock.NewConn()
+	conn.Command("SSCAN", links, 0, "COUNT", 1000).Expect([]interface{}{
+		[]byte("7"), []interface{}{[]byte("http://www.example.com"), []byte("http://www.another.com")},
+	})
+	conn.Command("SSCAN", links, 7, "COUNT", 1000).Expect([]interface{}{
+		[]byte("0"), []interface{}{[]byte("http://www.example.com/page")},
+	})
+	first := conn.Command("ZADD", frontier, "NX", linkPriority, "http://www.example.com", linkPriority, "http://www.another.com").Expect(int64(2))
+	second := conn.Command("ZADD", frontier, "NX", linkPriority, "http://www.example.com/page").Expect(int64(1))
+	del := conn.Command("DEL", links).Expect(int64(1))
+
+	r.RedisPool = &redis.Pool{
+		Dial: func() (redis.Conn, error) {
+			return conn, nil
+		},
+	}
+	defer r.RedisPool.Close()
+
+	if err := r.Setup(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, cmd := range []*redigomock.Cmd{first, second, del} {
+		if conn.Stats(cmd) != 1 {
+			t.Fatalf("expected %v %v to be called", cmd.Name, cmd.Args)
+		}
+	}
+}
+
+func TestDeferLink(t *testing.T) {
+	now = func() time.Time { return time.Date(2017, time.September, 1, 15, 4, 5, 0, time.UTC) }
+
+	lnk := Link{URL: "http://www.example.com/page", Depth: 2, Priority: 0.75}
+
+	r := &Redis{}
+	conn := redigomock.NewConn()
+	conn.Command("ZADD", deferred, now().Add(30*time.Second).Unix(), lnk.URL).Expect(int64(1))
+	conn.Command("HSET", priorities, lnk.URL, lnk.Priority).Expect(int64(1))
+	conn.Command("HSETNX", depths, lnk.URL, lnk.Depth).Expect(int64(1))
+	del := conn.Command("DEL", r.prefixKey(queuePrefix+lnk.URL)).Expect(int64(1))
+
+	r.RedisPool = &redis.Pool{
+		Dial: func() (redis.Conn, error) {
+			return conn, nil
+		},
+	}
+	defer r.RedisPool.Close()
+
+	if err := r.DeferLink(lnk, 30*time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	// or it would look like it is still queued when it comes back
+	if conn.Stats(del) != 1 {
+		t.Fatal("expected the link to be unmarked as queued")
+	}
+}
+
 func TestReserveHost(t *testing.T) {
 	// this does NOT check if the key actually expires
 	for _, c := range []struct {
-		name  string
-		host  string
-		delay time.Duration
+		name     string
+		host     string
+		delay    time.Duration
+		reply    int64
+		failures int
+		err      error
 	}{
 		{
-			"10m reservation", "http://www.example.com", 10 * time.Minute,
+			"10m reservation", "http://www.example.com", 10 * time.Minute, 0, 0, nil,
 		},
 		{
-			"30s reservation", "https://api.somewebsite.org", 30 * time.Second,
+			"30s reservation", "https://api.somewebsite.org", 30 * time.Second, 0, 0, nil,
+		},
+		{
+			"failing host", "https://api.somewebsite.org", 30 * time.Second, 3, 3, nil,
+		},
+		{
+			"already reserved", "http://www.example.com", 10 * time.Minute, -1, 0, ErrAlreadyReserved,
 		},
 	} {
 		t.Run(c.name, func(t *testing.T) {
 			r := &Redis{}
 			conn := redigomock.NewConn()
 			k := r.prefixKey(hostPrefix + c.host)
+			fk := r.prefixKey(failurePrefix + c.host)
 
-			conn.Command("SET", k, "", "EX", int(c.delay)/1e9, "NX").Expect("OK")
+			conn.Command("EVALSHA", reserveHost.Hash(), 2, k, fk, int(c.delay)/1e9).Expect(c.reply)
 
 			r.RedisPool = &redis.Pool{
 				Dial: func() (redis.Conn, error) {
@@ -106,8 +281,13 @@ func TestReserveHost(t *testing.T) {
 			}
 			defer r.RedisPool.Close()
 
-			if err := r.ReserveHost(c.host, c.delay); err != nil {
-				t.Fatal(err)
+			failures, err := r.ReserveHost(c.host, c.delay)
+			if err != c.err {
+				t.Fatalf("got %v; want %v", err, c.err)
+			}
+
+			if failures != c.failures {
+				t.Fatalf("got %d failures; want %d", failures, c.failures)
 			}
 		})
 	}
@@ -143,3 +323,171 @@ func TestDelayHost(t *testing.T) {
 		})
 	}
 }
+
+func TestAddHostFailure(t *testing.T) {
+	for _, c := range []struct {
+		name     string
+		host     string
+		failures int
+	}{
+		{"first failure", "http://www.example.com", 1},
+		{"third failure", "https://api.somewebsite.org", 3},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			ttl := 48 * time.Hour
+
+			r := &Redis{}
+			conn := redigomock.NewConn()
+			k := r.prefixKey(failurePrefix + c.host)
+			conn.Command("INCR", k).Expect(int64(c.failures))
+			conn.Command("EXPIRE", k, int(ttl/time.Second)).Expect(int64(1))
+
+			r.RedisPool = &redis.Pool{
+				Dial: func() (redis.Conn, error) {
+					return conn, nil
+				},
+			}
+			defer r.RedisPool.Close()
+
+			got, err := r.AddHostFailure(c.host, ttl)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got != c.failures {
+				t.Fatalf("got %d; want %d", got, c.failures)
+			}
+		})
+	}
+}
+
+func TestResetHostFailures(t *testing.T) {
+	r := &Redis{}
+	conn := redigomock.NewConn()
+	conn.Command("DEL", r.prefixKey(failurePrefix+"http://www.example.com")).Expect(int64(1))
+
+	r.RedisPool = &redis.Pool{
+		Dial: func() (redis.Conn, error) {
+			return conn, nil
+		},
+	}
+	defer r.RedisPool.Close()
+
+	if err := r.ResetHostFailures("http://www.example.com"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReserveDomain(t *testing.T) {
+	for _, c := range []struct {
+		name     string
+		domain   string
+		max      int
+		inflight int
+		err      error
+	}{
+		{"free", "example.com", 2, 1, nil},
+		{"full", "example.com", 2, 2, nil},
+		{"busy", "somewebsite.org", 2, 3, ErrDomainBusy},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			ttl := 10 * time.Minute
+
+			r := &Redis{}
+			conn := redigomock.NewConn()
+			k := r.prefixKey(domainPrefix + c.domain)
+			conn.Command("INCR", k).Expect(int64(c.inflight))
+			conn.Command("EXPIRE", k, int(ttl/time.Second)).Expect(int64(1))
+			decr := conn.Command("DECR", k).Expect(int64(c.inflight - 1))
+
+			r.RedisPool = &redis.Pool{
+				Dial: func() (redis.Conn, error) {
+					return conn, nil
+				},
+			}
+			defer r.RedisPool.Close()
+
+			if err := r.ReserveDomain(c.domain, c.max, ttl); err != c.err {
+				t.Fatalf("got %v; want %v", err, c.err)
+			}
+
+			if called := conn.Stats(decr) == 1; called != (c.err == ErrDomainBusy) {
+				t.Fatalf("DECR called: %v", called)
+			}
+		})
+	}
+}
+
+func TestReleaseDomain(t *testing.T) {
+	for _, c := range []struct {
+		name     string
+		domain   string
+		inflight int
+	}{
+		{"basic", "example.com", 1},
+		{"expired", "somewebsite.org", -1},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			r := &Redis{}
+			conn := redigomock.NewConn()
+			k := r.prefixKey(domainPrefix + c.domain)
+			conn.Command("DECR", k).Expect(int64(c.inflight))
+			del := conn.Command("DEL", k).Expect(int64(1))
+
+			r.RedisPool = &redis.Pool{
+				Dial: func() (redis.Conn, error) {
+					return conn, nil
+				},
+			}
+			defer r.RedisPool.Close()
+
+			if err := r.ReleaseDomain(c.domain); err != nil {
+				t.Fatal(err)
+			}
+
+			if called := conn.Stats(del) == 1; called != (c.inflight < 0) {
+				t.Fatalf("DEL called: %v", called)
+			}
+		})
+	}
+}
+
+func TestLimits(t *testing.T) {
+	for _, c := range []struct {
+		name     string
+		host     string
+		domain   string
+		pttl     int64
+		inflight interface{}
+		want     Limits
+	}{
+		{"free", "http://www.example.com", "example.com", -2, nil, Limits{}},
+		{
+			"delayed", "https://api.somewebsite.org", "somewebsite.org", 1500, int64(2),
+			Limits{HostDelay: 1500 * time.Millisecond, DomainRequests: 2},
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			r := &Redis{}
+			conn := redigomock.NewConn()
+			conn.Command("PTTL", r.prefixKey(hostPrefix+c.host)).Expect(c.pttl)
+			conn.Command("GET", r.prefixKey(domainPrefix+c.domain)).Expect(c.inflight)
+
+			r.RedisPool = &redis.Pool{
+				Dial: func() (redis.Conn, error) {
+					return conn, nil
+				},
+			}
+			defer r.RedisPool.Close()
+
+			got, err := r.Limits(c.host, c.domain)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got != c.want {
+				t.Fatalf("got %+v; want %+v", got, c.want)
+			}
+		})
+	}
+}
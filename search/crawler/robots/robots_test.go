@@ -143,7 +143,7 @@ func TestSetStatusCode(t *testing.T) {
 			got := New(c.host).SetStatusCode(c.statusCode)
 
 			if got.StatusCode != c.statusCode {
-				t.Fatalf("got %q; want %q", got.StatusCode, c.statusCode)
+				t.Fatalf("got %d; want %d", got.StatusCode, c.statusCode)
 			}
 		})
 	}
@@ -0,0 +1,80 @@
+package crawler
+
+import (
+	"time"
+)
+
+// schedule adapts how often we recrawl a page to how often it changes.
+// A page that changed since our last crawl is revisited twice as soon,
+// one that didn't a little later each time, within [min, max].
+// So a news homepage ends up being crawled every few hours while
+// a page that never changes is crawled only every few months.
+type schedule struct {
+	initial time.Duration // the interval after a page's first crawl
+	min     time.Duration
+	max     time.Duration
+}
+
+// update records a crawl that found content with the given fingerprint and
+// sets when the page is next due. An unchanged page (e.g. a 304) passes its old fingerprint.
+// No fingerprint (e.g. nothing we could extract) counts as unchanged so we keep the one we had.
+func (s schedule) update(c Crawled, fingerprint string) Crawled {
+	if fingerprint == "" {
+		fingerprint = c.Fingerprint
+	}
+
+	changed := c.Fingerprint != "" && c.Fingerprint != fingerprint
+
+	interval := s.initial
+	if c.Crawls > 0 && c.Interval > 0 { // docs crawled before we had a schedule start over
+		interval = c.Interval
+		if changed {
+			interval /= 2
+		} else {
+			interval += interval / 2
+		}
+	}
+
+	if changed {
+		c.Changes++
+	}
+
+	c.Crawls++
+	c.Fingerprint = fingerprint
+	return s.next(c, interval)
+}
+
+// failed records a crawl that didn't get the page (e.g. a 5xx or 404). We can't tell if
+// it changed so its interval stays the same...the host's backoff already slows us down.
+func (s schedule) failed(c Crawled) Crawled {
+	interval := s.initial
+	if c.Crawls > 0 && c.Interval > 0 {
+		interval = c.Interval
+	}
+
+	return s.next(c, interval)
+}
+
+// next sets when a page is due again, within [min, max]
+func (s schedule) next(c Crawled, interval time.Duration) Crawled {
+	if s.min > 0 && interval < s.min {
+		interval = s.min
+	}
+	if s.max > 0 && interval > s.max {
+		interval = s.max
+	}
+
+	c.Interval = interval
+	c.Date = now()
+	c.Due = c.Date.Add(interval)
+	return c
+}
+
+// due returns when a page is due to be crawled again. Pages that were crawled
+// before we had a schedule are due our initial interval after their last crawl.
+func (s schedule) due(c Crawled) time.Time {
+	if c.Date == (time.Time{}) || c.Due != (time.Time{}) {
+		return c.Due
+	}
+	return c.Date.Add(s.initial)
+}
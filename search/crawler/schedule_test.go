@@ -0,0 +1,220 @@
+package crawler
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/jarcoal/httpmock"
+	"github.com/jivesearch/jivesearch/search/crawler/queue"
+	"github.com/jivesearch/jivesearch/search/crawler/robots"
+)
+
+func TestScheduleUpdate(t *testing.T) {
+	now = func() time.Time {
+		return time.Date(2017, time.September, 1, 15, 4, 5, 0, time.UTC)
+	}
+
+	s := schedule{
+		initial: 30 * 24 * time.Hour,
+		min:     6 * time.Hour,
+		max:     180 * 24 * time.Hour,
+	}
+
+	for _, c := range []struct {
+		name        string
+		crawled     Crawled
+		fingerprint string
+		want        Crawled
+	}{
+		{
+			name:        "first crawl",
+			crawled:     Crawled{ID: "http://www.example.com"},
+			fingerprint: "abc",
+			want: Crawled{
+				ID: "http://www.example.com", Crawls: 1, Fingerprint: "abc", Interval: 30 * 24 * time.Hour,
+				Date: now(), Due: now().Add(30 * 24 * time.Hour),
+			},
+		},
+		{
+			name: "changed",
+			crawled: Crawled{
+				ID: "http://www.example.com", Crawls: 4, Changes: 2, Fingerprint: "abc", Interval: 24 * time.Hour,
+			},
+			fingerprint: "def",
+			want: Crawled{
+				ID: "http://www.example.com", Crawls: 5, Changes: 3, Fingerprint: "def", Interval: 12 * time.Hour,
+				Date: now(), Due: now().Add(12 * time.Hour),
+			},
+		},
+		{
+			name: "unchanged",
+			crawled: Crawled{
+				ID: "http://www.example.com", Crawls: 4, Changes: 2, Fingerprint: "abc", Interval: 24 * time.Hour,
+			},
+			fingerprint: "abc",
+			want: Crawled{
+				ID: "http://www.example.com", Crawls: 5, Changes: 2, Fingerprint: "abc", Interval: 36 * time.Hour,
+				Date: now(), Due: now().Add(36 * time.Hour),
+			},
+		},
+		{
+			name: "no fingerprint",
+			crawled: Crawled{
+				ID: "http://www.example.com", Crawls: 4, Changes: 2, Fingerprint: "abc", Interval: 24 * time.Hour,
+			},
+			fingerprint: "",
+			want: Crawled{
+				ID: "http://www.example.com", Crawls: 5, Changes: 2, Fingerprint: "abc", Interval: 36 * time.Hour,
+				Date: now(), Due: now().Add(36 * time.Hour),
+			},
+		},
+		{
+			name: "min",
+			crawled: Crawled{
+				ID: "http://www.example.com", Crawls: 40, Changes: 39, Fingerprint: "abc", Interval: 7 * time.Hour,
+			},
+			fingerprint: "def",
+			want: Crawled{
+				ID: "http://www.example.com", Crawls: 41, Changes: 40, Fingerprint: "def", Interval: 6 * time.Hour,
+				Date: now(), Due: now().Add(6 * time.Hour),
+			},
+		},
+		{
+			name: "max",
+			crawled: Crawled{
+				ID: "http://www.example.com", Crawls: 10, Fingerprint: "abc", Interval: 150 * 24 * time.Hour,
+			},
+			fingerprint: "abc",
+			want: Crawled{
+				ID: "http://www.example.com", Crawls: 11, Fingerprint: "abc", Interval: 180 * 24 * time.Hour,
+				Date: now(), Due: now().Add(180 * 24 * time.Hour),
+			},
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			got := s.update(c.crawled, c.fingerprint)
+			if got != c.want {
+				t.Fatalf("got %+v; want %+v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestScheduleFailed(t *testing.T) {
+	now = func() time.Time {
+		return time.Date(2017, time.September, 1, 15, 4, 5, 0, time.UTC)
+	}
+
+	s := schedule{
+		initial: 30 * 24 * time.Hour,
+		min:     6 * time.Hour,
+		max:     180 * 24 * time.Hour,
+	}
+
+	for _, c := range []struct {
+		name    string
+		crawled Crawled
+		want    Crawled
+	}{
+		{
+			name:    "never crawled",
+			crawled: Crawled{ID: "http://www.example.com"},
+			want: Crawled{
+				ID: "http://www.example.com", Interval: 30 * 24 * time.Hour,
+				Date: now(), Due: now().Add(30 * 24 * time.Hour),
+			},
+		},
+		{
+			name: "interval unchanged",
+			crawled: Crawled{
+				ID: "http://www.example.com", Crawls: 4, Changes: 2, Fingerprint: "abc", Interval: 24 * time.Hour,
+			},
+			want: Crawled{
+				ID: "http://www.example.com", Crawls: 4, Changes: 2, Fingerprint: "abc", Interval: 24 * time.Hour,
+				Date: now(), Due: now().Add(24 * time.Hour),
+			},
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			got := s.failed(c.crawled)
+			if got != c.want {
+				t.Fatalf("got %+v; want %+v", got, c.want)
+			}
+		})
+	}
+}
+
+// scheduledBackend has a page we crawl every day
+type scheduledBackend struct {
+	mockBackend
+}
+
+func (b *scheduledBackend) CrawledAndCount(u, domain string) (Crawled, int, error) {
+	return Crawled{
+		ID: u, Index: "search-english", Crawls: 4, Fingerprint: "abc", Interval: 24 * time.Hour,
+		Date: now().Add(-48 * time.Hour), Due: now().Add(-24 * time.Hour),
+	}, 10, nil
+}
+
+func TestWorkServerError(t *testing.T) {
+	now = func() time.Time {
+		return time.Date(2017, time.September, 1, 15, 4, 5, 0, time.UTC)
+	}
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	lnk := "https://www.example.com/page"
+	httpmock.RegisterResponder("GET", "https://www.example.com/robots.txt",
+		httpmock.NewStringResponder(200, "User-agent: *\nAllow: /"))
+	httpmock.RegisterResponder("GET", lnk, httpmock.NewStringResponder(http.StatusServiceUnavailable, "down"))
+
+	b := &scheduledBackend{}
+	cr := &Crawler{
+		HTTPClient:     http.DefaultClient,
+		UserAgent:      UserAgent{Full: "test-bot-full", Short: "test-bot-short"},
+		maxDomainLinks: 100,
+		schedule:       schedule{initial: 30 * 24 * time.Hour, min: 6 * time.Hour, max: 180 * 24 * time.Hour},
+		Queue:          &mockQueue{},
+		Backend:        b,
+		Robots:         &MockRobotsCache{m: make(map[string]*robots.Robots)},
+		channels: channels{
+			links: make(chan queue.Link, 10),
+			err:   make(chan error, 10),
+		},
+		stats: &Stats{Start: now(), StatusCodes: make(map[int]int64)},
+	}
+
+	cr.work(queue.Link{URL: lnk})
+
+	if len(b.upserted) != 1 {
+		t.Fatalf("got %d upserted docs; want 1", len(b.upserted))
+	}
+
+	// an outage doesn't push the page out any further
+	if got, want := b.upserted[0].Interval, int64(24*time.Hour/time.Second); got != want {
+		t.Fatalf("got interval %ds; want %ds", got, want)
+	}
+}
+
+func TestScheduleDue(t *testing.T) {
+	s := schedule{initial: 30 * 24 * time.Hour}
+	crawled := time.Date(2017, time.September, 1, 0, 0, 0, 0, time.UTC)
+
+	for _, c := range []struct {
+		name    string
+		crawled Crawled
+		want    time.Time
+	}{
+		{"never crawled", Crawled{}, time.Time{}},
+		{"no schedule", Crawled{Date: crawled}, crawled.Add(30 * 24 * time.Hour)},
+		{"scheduled", Crawled{Date: crawled, Due: crawled.Add(6 * time.Hour)}, crawled.Add(6 * time.Hour)},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			if got := s.due(c.crawled); !got.Equal(c.want) {
+				t.Fatalf("got %v; want %v", got, c.want)
+			}
+		})
+	}
+}
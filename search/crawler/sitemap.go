@@ -0,0 +1,292 @@
+package crawler
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jivesearch/jivesearch/log"
+	"github.com/jivesearch/jivesearch/search/crawler/queue"
+	"github.com/jivesearch/jivesearch/search/document"
+	"github.com/pkg/errors"
+	"golang.org/x/net/html/charset"
+)
+
+const (
+	maxSitemapBytes = 50 * 1024 * 1024 // the max (uncompressed) size allowed by the protocol
+	maxSitemapDepth = 2                // an index shouldn't list other indices but some do
+	sitemapTTL      = 7 * 24 * time.Hour
+	maxSitemapJobs  = 1000            // hosts whose sitemaps are waiting to be fetched
+	maxSitemapWait  = 5 * time.Minute // how long we wait for a busy host before skipping its sitemaps
+)
+
+// sleep makes testing the wait for a busy host quick
+var sleep = time.Sleep
+
+var errSitemapHost = errors.New("sitemap link is not on the same host")
+
+// sitemap is either a sitemap index or a urlset. Since we don't
+// know which one we have until we parse it we decode into both.
+// https://www.sitemaps.org/protocol.html
+type sitemap struct {
+	Sitemaps []sitemapEntry `xml:"sitemap"` // <sitemapindex>
+	URLs     []sitemapEntry `xml:"url"`     // <urlset>
+}
+
+type sitemapEntry struct {
+	Loc      string `xml:"loc"`
+	LastMod  string `xml:"lastmod"`
+	Priority string `xml:"priority"`
+}
+
+// parseSitemap parses a sitemap index or urlset. Gzipped sitemaps are detected from
+// their magic number since the Transport only decompresses a "Content-Encoding: gzip"
+// response, not a .xml.gz file.
+func parseSitemap(r io.Reader) (*sitemap, error) {
+	sm := &sitemap{}
+
+	br := bufio.NewReader(r)
+	var rdr io.Reader = br
+
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return sm, err
+		}
+		defer gz.Close()
+		rdr = gz
+	}
+
+	dec := xml.NewDecoder(io.LimitReader(rdr, maxSitemapBytes))
+	dec.CharsetReader = charset.NewReaderLabel
+
+	err := dec.Decode(sm)
+	return sm, err
+}
+
+// onHost validates the <loc> of an entry. The protocol only allows
+// a sitemap to list urls (and other sitemaps) on its own host.
+func (e sitemapEntry) onHost(host string) (string, error) {
+	u, err := document.ValidateURL(strings.TrimSpace(e.Loc))
+	if err != nil {
+		return "", err
+	}
+
+	if u.Host != host {
+		return "", errSitemapHost
+	}
+
+	return u.String(), nil
+}
+
+// link validates a <url> entry and converts it to a queue.SitemapLink
+func (e sitemapEntry) link(host string) (queue.SitemapLink, error) {
+	sl := queue.SitemapLink{Priority: 0.5}
+
+	u, err := e.onHost(host)
+	if err != nil {
+		return sl, err
+	}
+
+	sl.URL = u
+
+	if p, err := strconv.ParseFloat(strings.TrimSpace(e.Priority), 64); err == nil && p >= 0 && p <= 1 {
+		sl.Priority = p
+	}
+
+	sl.LastMod = parseLastMod(e.LastMod)
+	return sl, nil
+}
+
+// parseLastMod parses the W3C Datetime format used by sitemaps.
+// A zero time is returned if the date is missing or invalid.
+// https://www.w3.org/TR/NOTE-datetime
+func parseLastMod(s string) time.Time {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return time.Time{}
+	}
+
+	for _, f := range []string{
+		time.RFC3339Nano, time.RFC3339, "2006-01-02T15:04Z07:00", "2006-01-02", "2006-01", "2006",
+	} {
+		if t, err := time.Parse(f, s); err == nil {
+			return t.UTC()
+		}
+	}
+
+	return time.Time{}
+}
+
+// sitemapJob holds the sitemaps listed in a host's robots.txt file
+type sitemapJob struct {
+	schemeHost string
+	host       string
+	domain     string
+	delay      time.Duration // the host's crawl delay
+	sitemaps   []string
+}
+
+// queueSitemaps hands a host's sitemaps to our sitemap goroutine so a big
+// sitemap doesn't tie up a worker (and the host reservation it holds).
+// If too many are waiting we skip them until the robots.txt file is refreshed.
+func (c *Crawler) queueSitemaps(job sitemapJob) {
+	if c.maxSitemapLinks < 1 || len(job.sitemaps) == 0 {
+		return
+	}
+
+	select {
+	case c.sitemaps <- job:
+	default:
+		log.Debug.Printf("too many sitemaps waiting, skipping %v", job.schemeHost)
+	}
+}
+
+// sitemapHandler fetches the sitemaps of one host at a time
+func (c *Crawler) sitemapHandler() {
+	for job := range c.sitemaps {
+		c.fetchSitemaps(job)
+	}
+}
+
+// fetchSitemaps adds the links found in a host's sitemaps to our queue
+func (c *Crawler) fetchSitemaps(job sitemapJob) {
+	cnt, err := c.Queue.CountLinks()
+	if err != nil {
+		log.Debug.Printf("unable to count links in queue: %v\n%v", job.host, err)
+		return
+	}
+
+	if cnt > c.maxQueueLinks {
+		return
+	}
+
+	remaining := c.maxSitemapLinks
+	for _, lnk := range job.sitemaps {
+		c.fetchSitemap(job, strings.TrimSpace(lnk), 0, &remaining)
+	}
+}
+
+func (c *Crawler) fetchSitemap(job sitemapJob, lnk string, depth int, remaining *int) {
+	if depth > maxSitemapDepth || *remaining < 1 {
+		return
+	}
+
+	sm, err := c.getSitemap(job, lnk)
+	if err != nil {
+		log.Debug.Println(errors.Wrapf(err, "unable to get sitemap %v", lnk))
+		return
+	}
+
+	for _, s := range sm.Sitemaps {
+		u, err := s.onHost(job.host)
+		if err != nil {
+			log.Debug.Println(errors.Wrapf(err, "sitemap %q in %v", s.Loc, lnk))
+			continue
+		}
+		c.fetchSitemap(job, u, depth+1, remaining)
+	}
+
+	sls := []queue.SitemapLink{}
+	docs := []*document.Document{}
+	for _, u := range sm.URLs {
+		if len(sls) >= *remaining {
+			break
+		}
+
+		sl, err := u.link(job.host)
+		if err != nil {
+			continue
+		}
+
+		doc, err := document.New(sl.URL)
+		if err != nil {
+			continue
+		}
+
+		sls = append(sls, sl)
+		docs = append(docs, doc)
+	}
+
+	crawled := c.lastCrawled(docs)
+	for i, sl := range sls {
+		// the site's own priority (.5 by default) nudges ours up or down
+		p := c.priority(queue.Link{URL: sl.URL, Depth: 1}, job.domain, crawled[docs[i].ID]) * (.5 + sl.Priority)
+		if err := c.Queue.AddSitemapLink(sl, p, sitemapTTL); err != nil {
+			c.err <- errors.Wrapf(err, "%q", sl.URL)
+			return
+		}
+
+		*remaining--
+	}
+}
+
+// getSitemap downloads and parses a sitemap. Like any other request to the
+// host we wait for our turn and then hold off for its crawl delay.
+func (c *Crawler) getSitemap(job sitemapJob, lnk string) (*sitemap, error) {
+	failures, err := c.reserveHost(job.schemeHost, job.domain)
+	if err != nil {
+		return nil, err
+	}
+
+	status, ra, failed := -1, "", false
+	defer func() {
+		c.delayHost(job.schemeHost, failures, status, ra, job.delay, failed)
+	}()
+
+	if c.backoff.gaveUp(failures) {
+		return nil, errors.Errorf("gave up on %v after %d failures", job.schemeHost, failures)
+	}
+
+	resp, err := c.doRequest(lnk, nil)
+	if err != nil {
+		failed = isHostError(err)
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	status, ra = resp.StatusCode, resp.Header.Get("Retry-After")
+	failed = status >= 500 && status < 600
+
+	if status != http.StatusOK {
+		return nil, errors.Errorf("status code %d", status)
+	}
+
+	return parseSitemap(resp.Body)
+}
+
+// reserveHost waits until a host is free, reserves it and returns its consecutive failures.
+// We give up if the workers crawling its pages keep it busy for too long.
+func (c *Crawler) reserveHost(sh, domain string) (int, error) {
+	var waited time.Duration
+
+	for {
+		failures, err := c.Queue.ReserveHost(sh, 600*time.Second)
+		if err != queue.ErrAlreadyReserved || waited >= maxSitemapWait {
+			return failures, err
+		}
+
+		l, err := c.Queue.Limits(sh, domain)
+		if err != nil {
+			return 0, err
+		}
+
+		// check back at least every 10s as a worker usually frees its host well before the reservation expires
+		wait := l.HostDelay
+		switch {
+		case wait < 1*time.Second:
+			wait = 1 * time.Second
+		case wait > 10*time.Second:
+			wait = 10 * time.Second
+		}
+
+		sleep(wait)
+		waited += wait
+	}
+}
@@ -0,0 +1,318 @@
+package crawler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jarcoal/httpmock"
+	"github.com/jivesearch/jivesearch/search/crawler/queue"
+)
+
+const urlset = `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+	<url>
+		<loc>http://www.example.com/</loc>
+		<lastmod>2017-09-01</lastmod>
+		<priority>0.8</priority>
+	</url>
+	<url>
+		<loc>http://www.example.com/catalog?item=12&amp;desc=vacation_hawaii</loc>
+		<lastmod>2017-09-01T15:04:05+00:00</lastmod>
+	</url>
+	<url>
+		<loc>http://www.another.com/not/allowed</loc>
+	</url>
+</urlset>`
+
+const sitemapIndex = `<?xml version="1.0" encoding="UTF-8"?>
+<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+	<sitemap>
+		<loc>http://www.example.com/sitemap1.xml.gz</loc>
+		<lastmod>2017-09-01T15:04:05+00:00</lastmod>
+	</sitemap>
+</sitemapindex>`
+
+func gzipped(t *testing.T, s string) []byte {
+	var b bytes.Buffer
+	gz := gzip.NewWriter(&b)
+	if _, err := gz.Write([]byte(s)); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func TestParseSitemap(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		body []byte
+		want *sitemap
+	}{
+		{
+			name: "urlset",
+			body: []byte(urlset),
+			want: &sitemap{
+				URLs: []sitemapEntry{
+					{Loc: "http://www.example.com/", LastMod: "2017-09-01", Priority: "0.8"},
+					{Loc: "http://www.example.com/catalog?item=12&desc=vacation_hawaii", LastMod: "2017-09-01T15:04:05+00:00"},
+					{Loc: "http://www.another.com/not/allowed"},
+				},
+			},
+		},
+		{
+			name: "index",
+			body: []byte(sitemapIndex),
+			want: &sitemap{
+				Sitemaps: []sitemapEntry{
+					{Loc: "http://www.example.com/sitemap1.xml.gz", LastMod: "2017-09-01T15:04:05+00:00"},
+				},
+			},
+		},
+		{
+			name: "gzipped index",
+			body: gzipped(t, sitemapIndex),
+			want: &sitemap{
+				Sitemaps: []sitemapEntry{
+					{Loc: "http://www.example.com/sitemap1.xml.gz", LastMod: "2017-09-01T15:04:05+00:00"},
+				},
+			},
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := parseSitemap(bytes.NewReader(c.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(got, c.want) {
+				t.Fatalf("got %+v; want %+v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestParseLastMod(t *testing.T) {
+	for _, c := range []struct {
+		value string
+		want  time.Time
+	}{
+		{"", time.Time{}},
+		{"not a date", time.Time{}},
+		{"2017", time.Date(2017, time.January, 1, 0, 0, 0, 0, time.UTC)},
+		{"2017-09-01", time.Date(2017, time.September, 1, 0, 0, 0, 0, time.UTC)},
+		{"2017-09-01T15:04+02:00", time.Date(2017, time.September, 1, 13, 4, 0, 0, time.UTC)},
+		{"2017-09-01T15:04:05Z", time.Date(2017, time.September, 1, 15, 4, 5, 0, time.UTC)},
+	} {
+		t.Run(c.value, func(t *testing.T) {
+			got := parseLastMod(c.value)
+			if !got.Equal(c.want) {
+				t.Fatalf("got %v; want %v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestFetchSitemaps(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	offHost := strings.Replace(sitemapIndex, "</sitemapindex>",
+		"<sitemap><loc>http://www.another.com/sitemap.xml</loc></sitemap></sitemapindex>", 1)
+
+	httpmock.RegisterResponder("GET", "http://www.example.com/sitemap_index.xml",
+		httpmock.NewStringResponder(http.StatusOK, offHost))
+	httpmock.RegisterResponder("GET", "http://www.example.com/sitemap1.xml.gz",
+		httpmock.NewBytesResponder(http.StatusOK, gzipped(t, urlset)))
+
+	// a sitemap can't send us to another host
+	httpmock.RegisterResponder("GET", "http://www.another.com/sitemap.xml",
+		func(req *http.Request) (*http.Response, error) {
+			t.Fatalf("fetched off-host sitemap %v", req.URL)
+			return nil, nil
+		})
+
+	for _, c := range []struct {
+		name     string
+		maxLinks int
+		want     []queue.SitemapLink
+	}{
+		{
+			name:     "disabled",
+			maxLinks: 0,
+		},
+		{
+			name:     "limited",
+			maxLinks: 1,
+			want: []queue.SitemapLink{
+				{
+					URL:      "http://www.example.com/",
+					LastMod:  time.Date(2017, time.September, 1, 0, 0, 0, 0, time.UTC),
+					Priority: 0.8,
+				},
+			},
+		},
+		{
+			name:     "all",
+			maxLinks: 100,
+			want: []queue.SitemapLink{
+				{
+					URL:      "http://www.example.com/",
+					LastMod:  time.Date(2017, time.September, 1, 0, 0, 0, 0, time.UTC),
+					Priority: 0.8,
+				},
+				{
+					URL:      "http://www.example.com/catalog?item=12&desc=vacation_hawaii",
+					LastMod:  time.Date(2017, time.September, 1, 15, 4, 5, 0, time.UTC),
+					Priority: 0.5,
+				},
+			},
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			q := &mockSitemapQueue{}
+
+			cr := &Crawler{
+				HTTPClient:      http.DefaultClient,
+				maxQueueLinks:   1000,
+				maxSitemapLinks: c.maxLinks,
+				Queue:           q,
+				Backend:         &mockBackend{},
+			}
+
+			cr.fetchSitemaps(sitemapJob{
+				schemeHost: "http://www.example.com",
+				host:       "www.example.com",
+				domain:     "example.com",
+				delay:      5 * time.Second,
+				sitemaps:   []string{"http://www.example.com/sitemap_index.xml"},
+			})
+
+			if !reflect.DeepEqual(q.links, c.want) {
+				t.Fatalf("got %+v; want %+v", q.links, c.want)
+			}
+
+			// we wait out the crawl delay after every sitemap we fetch
+			if len(q.delays) != q.reserved {
+				t.Fatalf("reserved the host %d times but delayed it %d times", q.reserved, len(q.delays))
+			}
+			for _, d := range q.delays {
+				if d != 5*time.Second {
+					t.Fatalf("got delay %v; want %v", d, 5*time.Second)
+				}
+			}
+		})
+	}
+}
+
+func TestQueueSitemaps(t *testing.T) {
+	cr := &Crawler{
+		maxSitemapLinks: 100,
+		channels:        channels{sitemaps: make(chan sitemapJob, 1)},
+	}
+
+	job := sitemapJob{schemeHost: "http://www.example.com", sitemaps: []string{"http://www.example.com/sitemap.xml"}}
+	cr.queueSitemaps(job)
+	cr.queueSitemaps(sitemapJob{schemeHost: "http://www.example.com"}) // no sitemaps
+
+	// too many waiting...the worker doesn't block
+	cr.queueSitemaps(sitemapJob{schemeHost: "http://www.another.com", sitemaps: []string{"http://www.another.com/sitemap.xml"}})
+
+	if got := len(cr.sitemaps); got != 1 {
+		t.Fatalf("got %d jobs; want 1", got)
+	}
+
+	if got := <-cr.sitemaps; !reflect.DeepEqual(got, job) {
+		t.Fatalf("got %+v; want %+v", got, job)
+	}
+}
+
+func TestReserveHost(t *testing.T) {
+	s := sleep
+	defer func() { sleep = s }()
+
+	for _, c := range []struct {
+		name  string
+		busy  int
+		delay time.Duration
+		want  error
+		slept time.Duration
+	}{
+		{"free", 0, 0, nil, 0},
+		{"delayed", 2, 3 * time.Second, nil, 6 * time.Second},
+		{"reserved by a worker", 1, 600 * time.Second, nil, 10 * time.Second},
+		{"never free", 1000, 600 * time.Second, queue.ErrAlreadyReserved, maxSitemapWait},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			var slept time.Duration
+			sleep = func(d time.Duration) { slept += d }
+
+			cr := &Crawler{
+				Queue: &busyQueue{busy: c.busy, delay: c.delay},
+			}
+
+			if _, err := cr.reserveHost("http://www.example.com", "example.com"); err != c.want {
+				t.Fatalf("got %v; want %v", err, c.want)
+			}
+
+			if slept != c.slept {
+				t.Fatalf("slept %v; want %v", slept, c.slept)
+			}
+		})
+	}
+}
+
+// busyQueue is a host that is reserved for a while
+type busyQueue struct {
+	mockQueue
+	busy  int
+	delay time.Duration
+}
+
+func (q *busyQueue) ReserveHost(host string, ttl time.Duration) (int, error) {
+	if q.busy > 0 {
+		q.busy--
+		return 0, queue.ErrAlreadyReserved
+	}
+	return 0, nil
+}
+
+func (q *busyQueue) Limits(host, domain string) (queue.Limits, error) {
+	return queue.Limits{HostDelay: q.delay}, nil
+}
+
+type mockSitemapQueue struct {
+	mockQueue
+	sync.Mutex
+	links    []queue.SitemapLink
+	reserved int
+	delays   []time.Duration
+}
+
+func (q *mockSitemapQueue) ReserveHost(host string, ttl time.Duration) (int, error) {
+	q.Lock()
+	q.reserved++
+	q.Unlock()
+	return 0, nil
+}
+
+func (q *mockSitemapQueue) DelayHost(host string, ttl time.Duration) error {
+	q.Lock()
+	q.delays = append(q.delays, ttl)
+	q.Unlock()
+	return nil
+}
+
+func (q *mockSitemapQueue) AddSitemapLink(lnk queue.SitemapLink, priority float64, ttl time.Duration) error {
+	q.Lock()
+	q.links = append(q.links, lnk)
+	q.Unlock()
+	return nil
+}
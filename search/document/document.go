@@ -4,6 +4,7 @@ package document
 import (
 	"bufio"
 	"fmt"
+	"hash/fnv"
 	"io"
 	"net/http"
 	"net/url"
@@ -22,43 +23,77 @@ var (
 	errInvalidScheme = fmt.Errorf("invalid scheme")
 )
 
+// NextCrawlFormat is how we store the date & time a page is next due to be crawled
+const NextCrawlFormat = "20060102T150405Z0700"
+
 // Document is the URL & parsed content of the page
 // Note, since we want just a couple of fields from *url.URL
 // (Scheme, Host) we explicitly set those. Much easier than
 // a custom MarshalJSON method.
 type Document struct {
-	ID        string   `json:"id"` // store ID also as a field as sorting on document ID is not advised in Elasticsearch
-	URL       *url.URL `json:"-"`
-	Scheme    string   `json:"scheme,omitempty"`
-	Host      string   `json:"host,omitempty"`       // not HostName()...we want the port for the robots.txt file
-	Domain    string   `json:"domain,omitempty"`     // tld+1 -> example.com
-	TLD       string   `json:"tld,omitempty"`        // com, org, uk, etc (we don't want co.uk just uk)
-	PathParts string   `json:"path_parts,omitempty"` // https://api.example.com/path/to/something -> "path to something"
-	Crawled   string   `json:"crawled,omitempty"`
-	header    http.Header
-	MIME      string `json:"mime,omitempty"`
-	tokenizer *html.Tokenizer
+	ID           string   `json:"id"` // store ID also as a field as sorting on document ID is not advised in Elasticsearch
+	URL          *url.URL `json:"-"`
+	Scheme       string   `json:"scheme,omitempty"`
+	Host         string   `json:"host,omitempty"`       // not HostName()...we want the port for the robots.txt file
+	Domain       string   `json:"domain,omitempty"`     // tld+1 -> example.com
+	TLD          string   `json:"tld,omitempty"`        // com, org, uk, etc (we don't want co.uk just uk)
+	PathParts    string   `json:"path_parts,omitempty"` // https://api.example.com/path/to/something -> "path to something"
+	Crawled      string   `json:"crawled,omitempty"`
+	NextCrawl    string   `json:"next_crawl,omitempty"` // when the page is due to be crawled again
+	Interval     int64    `json:"interval,omitempty"`   // the seconds between our last crawl and the next one
+	Crawls       int      `json:"crawls,omitempty"`     // how many times we've crawled it
+	Changes      int      `json:"changes,omitempty"`    // how many of those crawls found the page had changed
+	Cluster      string   `json:"cluster,omitempty"`    // the ID of the first doc we found that is a near-duplicate of this one (or its own)
+	header       http.Header
+	ETag         string `json:"etag,omitempty"`          // validators for a conditional recrawl
+	LastModified string `json:"last_modified,omitempty"` // (the raw header values)
+	MIME         string `json:"mime,omitempty"`
+	tokenizer    *html.Tokenizer
+	reader       io.Reader // for an Extractor
+	robots       robots
 	Content
-	Votes int `json:"-"`
+	Images  []*Image `json:"-"`                 // indexed apart from the document (see image.go)
+	Links   []Link   `json:"-"`                 // our link graph (see link.go)
+	Anchors []string `json:"anchors,omitempty"` // what the pages that link to this one call it (see search/rank)
+	Votes   int      `json:"-"`
+	Snippet string   `json:"snippet,omitempty"` // html-escaped excerpt with the query terms in <em> tags...set by the search backend
 }
 
 // Content is set from the response
 type Content struct {
-	StatusCode  int `json:"status,omitempty"`
-	canonical   string
-	Canonical   bool         `json:"canonical,omitempty"`
-	Language    language.Tag `json:"-"`
-	Date        string       `json:"date,omitempty"`
-	Title       string       `json:"title,omitempty"`
-	Keywords    string       `json:"keywords,omitempty"`
-	Description string       `json:"description,omitempty"`
+	StatusCode   int `json:"status,omitempty"`
+	canonical    string
+	Canonical    bool         `json:"canonical,omitempty"`
+	Language     language.Tag `json:"-"`
+	Date         string       `json:"date,omitempty"` // published & modified dates are from structured data (see structured.go)
+	Modified     string       `json:"modified,omitempty"`
+	Title        string       `json:"title,omitempty"`
+	Keywords     string       `json:"keywords,omitempty"`
+	Description  string       `json:"description,omitempty"`
+	H1           []string     `json:"h1,omitempty"`
+	H2           []string     `json:"h2,omitempty"`
+	H3           []string     `json:"h3,omitempty"`
+	Body         string       `json:"body,omitempty"` // the main content without the nav, footer, etc.
+	Author       string       `json:"author,omitempty"`
+	Image        string       `json:"image,omitempty"`
+	Type         string       `json:"type,omitempty"` // e.g. NewsArticle, Recipe, article, website
+	SiteName     string       `json:"site_name,omitempty"`
+	Fingerprint  string       `json:"fingerprint,omitempty"` // a hash of the page's text so we can tell if it changed
+	SimHash      string       `json:"simhash,omitempty"`     // a hash of the page's text so we can find near-duplicates
+	SimHashBands []string     `json:"simhash_bands,omitempty"`
 	Policy
 }
 
 // Policy tells us if we can index the content & store the links
+// and how the page may be shown in our results (see policy.go)
 type Policy struct {
-	Index  bool `json:"index,omitempty"` // are we allowed to index the page?
-	follow bool // are we allowed to follow links?
+	Index            bool   `json:"index,omitempty"` // are we allowed to index the page?
+	follow           bool   // are we allowed to follow links?
+	NoArchive        bool   `json:"noarchive,omitempty"`         // don't show a cached copy
+	NoSnippet        bool   `json:"nosnippet,omitempty"`         // don't show a snippet or description
+	MaxSnippet       int    `json:"max_snippet,omitempty"`       // the max characters of a snippet (0 is no limit)
+	UnavailableAfter string `json:"unavailable_after,omitempty"` // don't show the page after this date
+	NoImageIndex     bool   `json:"noimageindex,omitempty"`      // don't index the page's images
 }
 
 // New creates a new Document from a link and validates the url
@@ -87,7 +122,7 @@ func New(lnk string) (*Document, error) {
 }
 
 // ValidateURL validates a link and returns a *url.URL
-// Note: There seems to be a lot of overlap between this and handleLink()
+// Note: There seems to be a lot of overlap between this and resolve()
 func ValidateURL(lnk string) (*url.URL, error) {
 	// we have to strip the fragment BEFORE we use ParseRequestURI
 	u, err := url.Parse(lnk)
@@ -101,7 +136,7 @@ func ValidateURL(lnk string) (*url.URL, error) {
 		return nil, err
 	}
 
-	// wrong scheme will also be filtered by handleLink ;)
+	// wrong scheme will also be filtered by resolve ;)
 	if u.Scheme != "http" && u.Scheme != "https" {
 		return nil, errInvalidScheme
 	}
@@ -142,26 +177,38 @@ func (d *Document) SetCrawled(t time.Time) *Document {
 	return d
 }
 
+// SetNextCrawl sets when the doc is due to be crawled again and the interval that got us there
+func (d *Document) SetNextCrawl(t time.Time, interval time.Duration) *Document {
+	d.NextCrawl = t.UTC().Format(NextCrawlFormat)
+	d.Interval = int64(interval / time.Second)
+	return d
+}
+
 // SetHeader sets the Document's header to the response header.
+// The ETag and Last-Modified validators are saved so we can make a conditional request on a recrawl.
 func (d *Document) SetHeader(h http.Header) *Document {
 	d.header = h
+	d.ETag = h.Get("ETag")
+	d.LastModified = h.Get("Last-Modified")
 	return d
 }
 
 // SetPolicyFromHeader sets the indexing & follow policy of a document from the response header.
-// A specific bot directive overrides a general robots directive (still TODO).
+// A specific bot directive overrides a general robots directive.
 // We process the X-Robots-Tag header first so may not even get to the meta tag found in the html.
 // https://developers.google.com/search/reference/robots_meta_tag
 // https://stackoverflow.com/a/18330818/776942 (see end of answer)
-// TODO: Process the bot directive.
 func (d *Document) SetPolicyFromHeader(bot string) *Document {
-	d.Policy = Policy{true, true} // assume we can index & follow unless proven otherwise
+	d.robots = robots{}
+	d.Policy = d.robots.policy() // assume we can index & follow unless proven otherwise
 
 	// Get only returns the first value for a key...This version gets all values for a key.
 	for key, values := range d.header {
 		if c := http.CanonicalHeaderKey(key); c == "X-Robots-Tag" {
 			for _, val := range values {
-				d.setPolicy(bot, val)
+				if dir, val := d.robots.scope(bot, val); dir != nil {
+					d.setPolicy(dir, val)
+				}
 			}
 		}
 	}
@@ -169,31 +216,15 @@ func (d *Document) SetPolicyFromHeader(bot string) *Document {
 	return d
 }
 
-// TODO: Process the bot directive for our bot
-var botPolicy = regexp.MustCompile(`(?P<bot>.*):(?P<policy>.*)`)
-
+// setPolicy adds the directives to a scope & recomputes the Policy.
 // In case of competing directives we follow the most restrictive.
-// Since our default is to Index and Follow we don't want to
-// switch a "false" to "true" since we follow the most restrictive.
-func (d *Document) setPolicy(bot, pol string) {
-	for _, p := range strings.Split(pol, ",") {
-		p = strings.ToLower(strings.TrimSpace(p))
-		switch p {
-		case "none":
-			d.Policy.Index = false
-			d.Policy.follow = false
-		case "all": // see note above
-		case "index": // see note above
-		case "noindex":
-			d.Policy.Index = false
-		case "follow": // see note above
-		case "nofollow":
-			d.Policy.follow = false
-		}
-	}
+func (d *Document) setPolicy(dir *directives, pol string) {
+	dir.set(pol)
+	d.Policy = d.robots.policy()
 }
 
 // SetTokenizer sets the html tokenizer and MIME Type from the response's body (utf-8 encoded).
+// A document with an Extractor (pdf, plain text, etc) is read by it instead.
 // It is the caller's responsibility to close the response body.
 func (d *Document) SetTokenizer(b io.Reader) error {
 	bdy := bufio.NewReader(b)
@@ -202,7 +233,12 @@ func (d *Document) SetTokenizer(b io.Reader) error {
 		return err
 	}
 
-	d.MIME = strings.Split(http.DetectContentType(peek), ";")[0]
+	d.setMIME(peek)
+
+	if _, ok := Extractors[d.MIME]; ok && !strings.HasPrefix(d.MIME, "text/") {
+		d.reader = bdy // binary formats have their own encoding
+		return nil
+	}
 
 	// html tokenizer requires utf-8
 	utf, err := charset.NewReader(bdy, d.MIME)
@@ -210,19 +246,41 @@ func (d *Document) SetTokenizer(b io.Reader) error {
 		return err
 	}
 
+	if _, ok := Extractors[d.MIME]; ok {
+		d.reader = utf
+		return nil
+	}
+
 	d.tokenizer = html.NewTokenizer(utf)
 	return nil
 }
 
-// SetContent parses the html and sets the language, title, description, extracts links, etc.
+// SetContent parses the html and sets the language, title, description, body text, extracts links, etc.
+// Other documents have their content set by their Extractor.
 func (d *Document) SetContent(bot string, maxLinks int, ch chan string,
-	truncateTitle, truncateKeywords, truncateDescription int) error {
+	truncateTitle, truncateKeywords, truncateDescription, truncateBody int) error {
+
+	if e, ok := Extractors[d.MIME]; ok && d.reader != nil {
+		return d.extract(e, maxLinks, ch, truncateTitle, truncateBody)
+	}
 
 	var collected int
 
 	var tt html.TokenType
 	var title bool
 
+	im := &images{}
+	defer d.setImages(im, truncateTitle) // after the structured data has set the page's image
+
+	lk := &links{}
+	defer d.setLinks(lk)
+
+	ex := newExtractor()
+	defer d.setText(ex, truncateTitle, truncateBody)
+
+	sd := &structured{}
+	defer sd.set(d, truncateTitle)
+
 	for {
 		tt = d.tokenizer.Next()
 
@@ -230,19 +288,28 @@ func (d *Document) SetContent(bot string, maxLinks int, ch chan string,
 		case html.ErrorToken:
 			return nil
 		case html.TextToken:
+			txt := string(d.tokenizer.Text())
+			im.text(txt, ex.current().boilerplate)
+			lk.text(txt)
+			ex.text(txt)
+			sd.text(txt)
 			if title {
-				d.Title = d.extractText(string(d.tokenizer.Text()), truncateTitle)
+				d.Title = d.extractText(txt, truncateTitle)
 			}
 		case html.StartTagToken, html.SelfClosingTagToken:
 			t := d.tokenizer.Token()
+			im.start(t, ex.current().boilerplate)
+			lk.img(t)
+			ex.start(t, tt == html.SelfClosingTagToken)
+			sd.start(t, tt == html.SelfClosingTagToken)
 
 			// Note: comparing DataAtom is faster (& uses less memory) than n.Data=="title", etc.
 			switch t.DataAtom {
 			case atom.Html:
 				// A document may have multiple languages for different
-				// sections of the <body>, <span>, etc. Since we only
-				// extract text from the <head> section we
-				// only need the language from the <html> tag.
+				// sections of the <body>, <span>, etc. Since we index
+				// the page as a whole we only need the language
+				// from the <html> tag.
 				d.setLanguage(t)
 				/*
 					TODO: How to deal with rtl text (Arabic, Hebrew, etc)...simply reverse it?
@@ -281,43 +348,33 @@ func (d *Document) SetContent(bot string, maxLinks int, ch chan string,
 					}
 				}
 				name, _ := getAttribute(t, "name")
-				// TODO: Like SetPolicyFromHeader(), we need to process bot directive
-				if strings.EqualFold(name, "robots") || strings.EqualFold(name, bot) {
-					content, _ := getAttribute(t, "content")
-					d.setPolicy(bot, content)
+				content, _ := getAttribute(t, "content")
+				switch {
+				case bot != "" && strings.EqualFold(name, bot):
+					d.setPolicy(&d.robots.bot, content)
+				case strings.EqualFold(name, "robots"):
+					d.setPolicy(&d.robots.all, content)
 				}
 			case atom.A:
-				if d.Policy.follow && (maxLinks == -1 || collected < maxLinks) {
+				// our link graph has all the followed links even when we don't crawl them all
+				if d.Policy.follow {
 					rel, _ := getAttribute(t, "rel")
-					if !contains(strings.Fields(rel), "nofollow") {
-						href, _ := getAttribute(t, "href")
-						d.handleLink(href, ch)
-						collected++
+					href, _ := getAttribute(t, "href")
+					if u := d.resolve(href); u != "" && !contains(strings.Fields(rel), "nofollow") {
+						lk.start(u)
+						if maxLinks == -1 || collected < maxLinks {
+							ch <- u
+							collected++
+						}
 					}
 				}
-			case atom.Time:
-				// There are a few ways to get the creation date (or modified) date of the document:
-
-				// the "created" or "last-modified" meta tags
-				// <meta name="created" content="2009-05-09" />
-				// <meta http-equiv="last-modified" content="Sat, 07 Apr 2001 00:58:08 GMT" />
-				// The last-modified meta tag is NOT officially supported by html standards.
-
-				// <time class="date" data-time="1485526583" datetime="2017-01-27T14:16:23+00:00">Jan 27, 2017 2:16 pm UTC</time>
-				// https: //www.w3.org/TR/html51/infrastructure.html#dates-and-times
-
-				// RDFa: https://alistapart.com/article/introduction-to-rdfa
-				// <em property="dc:created" content="2009-05-09">
-				// <em property="created" content="2009-05-09">
-
-				// See: https://developers.google.com/search/docs/guides/intro-structured-data?visit_id=1-636402414686311906-557470069&rd=1
-
-				// stackoverflow.com doesn't use any of these methods? How do other search engines get their creation date?
-				// https://search.google.com/structured-data/testing-tool#url=https%3A%2F%2Fstackoverflow.com%2Fquestions%2F252703%2Fappend-vs-extend
-
 			}
 		case html.EndTagToken:
 			t := d.tokenizer.Token()
+			im.end(t)
+			lk.end(t)
+			ex.end(t)
+			sd.end(t)
 
 			switch t.DataAtom {
 			case atom.Title:
@@ -327,6 +384,41 @@ func (d *Document) SetContent(bot string, maxLinks int, ch chan string,
 	}
 }
 
+// setText sets the body text & headings from the extractor along with
+// the fingerprint and SimHash of the text. Both hashes are of the extracted
+// text only...markup, scripts and boilerplate change too often
+// (e.g. a session id or a "latest news" box) to tell us if the content did.
+func (d *Document) setText(ex *extractor, truncateHeading, truncateBody int) {
+	body := ex.body()
+
+	var headings []string
+	for _, h := range []atom.Atom{atom.H1, atom.H2, atom.H3} {
+		for i, s := range ex.headings[h] {
+			ex.headings[h][i] = d.extractText(s, truncateHeading)
+		}
+		headings = append(headings, ex.headings[h]...)
+	}
+
+	d.H1, d.H2, d.H3 = ex.headings[atom.H1], ex.headings[atom.H2], ex.headings[atom.H3]
+	d.Body = d.extractText(strings.Join(body, " "), truncateBody)
+
+	fingerprint := fnv.New64a()
+	sh := &simHasher{}
+
+	for _, w := range strings.Fields(d.Title + " " + strings.Join(headings, " ")) {
+		fingerprint.Write([]byte(w + " "))
+	}
+
+	for _, w := range body {
+		fingerprint.Write([]byte(w + " "))
+		sh.add(w)
+	}
+
+	d.Fingerprint = fmt.Sprintf("%016x", fingerprint.Sum64())
+	d.SimHash = sh.sum()
+	d.SimHashBands = SimHashBands(d.SimHash)
+}
+
 var canonicalHeader = regexp.MustCompile(`<(.*?)>; rel="canonical"`)
 
 // SetCanonical sets Canonical to true if the Document's ID is the canonical URL
@@ -373,9 +465,10 @@ func contains(s []string, val string) bool {
 	return false
 }
 
-func (d *Document) handleLink(href string, ch chan string) {
+// resolve returns the absolute url of a link or "" if it isn't one we can follow
+func (d *Document) resolve(href string) string {
 	if len(href) < 3 || len(href) > 2083 {
-		return
+		return ""
 	}
 
 	// escape any invalid characters
@@ -383,19 +476,21 @@ func (d *Document) handleLink(href string, ch chan string) {
 	var err error
 	href, err = url.QueryUnescape(url.QueryEscape(href))
 	if err != nil {
-		return
+		return ""
 	}
 
 	// maybe use url.ParseRequestURI instead????
 	u, err := url.Parse(href)
 	if err != nil {
-		return
+		return ""
 	}
 
 	u = d.URL.ResolveReference(u)
-	if u.String() != d.ID && (u.Scheme == "http" || u.Scheme == "https") {
-		ch <- u.String()
+	if u.String() == d.ID || (u.Scheme != "http" && u.Scheme != "https") {
+		return ""
 	}
+
+	return u.String()
 }
 
 func getAttribute(t html.Token, key string) (string, bool) {
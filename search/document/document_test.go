@@ -160,10 +160,35 @@ func TestSetCrawled(t *testing.T) {
 	}
 }
 
+func TestSetNextCrawl(t *testing.T) {
+	for _, c := range []struct {
+		tme      time.Time
+		interval time.Duration
+		want     string
+		seconds  int64
+	}{
+		{time.Date(2017, 7, 24, 23, 0, 0, 0, time.UTC), 6 * time.Hour, "20170724T230000Z", 21600},
+		{time.Date(1996, 12, 10, 4, 54, 32, 72, time.FixedZone("EST", -5*3600)), 90 * time.Minute, "19961210T095432Z", 5400},
+	} {
+		t.Run(fmt.Sprintf("date: %v", c.tme), func(t *testing.T) {
+			d := &Document{}
+			d.SetNextCrawl(c.tme, c.interval)
+			if d.NextCrawl != c.want {
+				t.Fatalf("got %+v; want: %+v", d.NextCrawl, c.want)
+			}
+			if d.Interval != c.seconds {
+				t.Fatalf("got %d; want: %d", d.Interval, c.seconds)
+			}
+		})
+	}
+}
+
 func TestSetHeader(t *testing.T) {
 	for _, c := range []struct {
-		name string
-		h    http.Header
+		name         string
+		h            http.Header
+		etag         string
+		lastModified string
 	}{
 		{
 			"basic",
@@ -172,6 +197,15 @@ func TestSetHeader(t *testing.T) {
 				"Cache-Control":   []string{"no-cache"},
 				"Link":            []string{`<http://www.example.com/canonical>; rel="canonical"`},
 			},
+			"", "",
+		},
+		{
+			"validators",
+			http.Header{
+				"Etag":          []string{`W/"abc123"`},
+				"Last-Modified": []string{"Fri, 01 Sep 2017 15:04:05 GMT"},
+			},
+			`W/"abc123"`, "Fri, 01 Sep 2017 15:04:05 GMT",
 		},
 	} {
 		t.Run(c.name, func(t *testing.T) {
@@ -181,6 +215,14 @@ func TestSetHeader(t *testing.T) {
 			if !reflect.DeepEqual(d.header, c.h) {
 				t.Fatalf("got %+v; want: %+v", d.header, c.h)
 			}
+
+			if d.ETag != c.etag {
+				t.Fatalf("got %q; want: %q", d.ETag, c.etag)
+			}
+
+			if d.LastModified != c.lastModified {
+				t.Fatalf("got %q; want: %q", d.LastModified, c.lastModified)
+			}
 		})
 	}
 }
@@ -225,12 +267,31 @@ func TestSetPolicyFromHeader(t *testing.T) {
 		policy []string
 		want   Policy
 	}{
-		{"default", "", []string{""}, Policy{true, true}},
-		{"none", "", []string{"none"}, Policy{false, false}},
-		{"conflicting policies", "", []string{"all", "noindex, nofollow"}, Policy{false, false}},
-		{"conflicting policies2", "", []string{"all", "nofollow"}, Policy{true, false}},
-		{"conflicting policies3", "", []string{"all", "noindex"}, Policy{false, true}},
-		{"conflicting policies4", "", []string{"noindex, nofollow", "all"}, Policy{false, false}},
+		{"default", "", []string{""}, Policy{Index: true, follow: true}},
+		{"none", "", []string{"none"}, Policy{Index: false, follow: false}},
+		{"conflicting policies", "", []string{"all", "noindex, nofollow"}, Policy{Index: false, follow: false}},
+		{"conflicting policies2", "", []string{"all", "nofollow"}, Policy{Index: true, follow: false}},
+		{"conflicting policies3", "", []string{"all", "noindex"}, Policy{Index: false, follow: true}},
+		{"conflicting policies4", "", []string{"noindex, nofollow", "all"}, Policy{Index: false, follow: false}},
+		{"other bot", "jivesearchbot", []string{"otherbot: noindex, nofollow"}, Policy{Index: true, follow: true}},
+		{"our bot", "jivesearchbot", []string{"JiveSearchBot: noindex"}, Policy{Index: false, follow: true}},
+		{"our bot overrides", "jivesearchbot", []string{"noindex, nofollow", "jivesearchbot: index"}, Policy{Index: true, follow: false}},
+		{"noarchive", "", []string{"noarchive"}, Policy{Index: true, follow: true, NoArchive: true}},
+		{"nosnippet", "", []string{"nosnippet"}, Policy{Index: true, follow: true, NoSnippet: true}},
+		{"noimageindex", "", []string{"noimageindex"}, Policy{Index: true, follow: true, NoImageIndex: true}},
+		{"max-snippet", "", []string{"max-snippet: 50", "max-snippet:20"}, Policy{Index: true, follow: true, MaxSnippet: 20}},
+		{"max-snippet no limit", "", []string{"max-snippet:-1"}, Policy{Index: true, follow: true}},
+		{"max-snippet 0", "", []string{"max-snippet:0"}, Policy{Index: true, follow: true, NoSnippet: true}},
+		{"max-snippet our bot", "jivesearchbot", []string{"max-snippet:20", "jivesearchbot: max-snippet:-1"}, Policy{Index: true, follow: true}},
+		{
+			"unavailable_after", "", []string{"unavailable_after: 2020-09-21"},
+			Policy{Index: true, follow: true, UnavailableAfter: "2020-09-21T00:00:00Z"},
+		},
+		{
+			"unavailable_after rfc 850", "", []string{"noarchive, unavailable_after: Sunday, 25-Jun-17 15:00:00 UTC, nosnippet"},
+			Policy{Index: true, follow: true, NoArchive: true, NoSnippet: true, UnavailableAfter: "2017-06-25T15:00:00Z"},
+		},
+		{"unavailable_after invalid", "", []string{"unavailable_after: someday"}, Policy{Index: true, follow: true}},
 	} {
 		t.Run(c.name, func(t *testing.T) {
 			d := Document{
@@ -295,6 +356,7 @@ func TestSetContent(t *testing.T) {
 		truncateTitle       int
 		truncateKeywords    int
 		truncateDescription int
+		truncateBody        int
 		want                Content
 	}{
 		{
@@ -322,12 +384,14 @@ func TestSetContent(t *testing.T) {
 			truncateTitle:       100,
 			truncateKeywords:    5,
 			truncateDescription: 14,
+			truncateBody:        1000,
 			want: Content{
 				StatusCode:  http.StatusOK,
 				Language:    language.English,
 				Title:       "The title of a page",
 				Keywords:    "some keywords for a search",
 				Description: "A description",
+				Fingerprint: "eef7704b900dec7d",
 				Policy:      Policy{Index: true, follow: true},
 			},
 		},
@@ -350,12 +414,14 @@ func TestSetContent(t *testing.T) {
 			truncateTitle:       100,
 			truncateKeywords:    5,
 			truncateDescription: 14,
+			truncateBody:        1000,
 			want: Content{
 				StatusCode:  http.StatusOK,
 				Language:    language.Spanish,
 				Title:       "",
 				Keywords:    "",
 				Description: "",
+				Fingerprint: "cbf29ce484222325",
 				Policy:      Policy{Index: false, follow: false},
 			},
 		},
@@ -383,6 +449,7 @@ func TestSetContent(t *testing.T) {
 			truncateTitle:       100,
 			truncateKeywords:    5,
 			truncateDescription: 14,
+			truncateBody:        1000,
 			want: Content{
 				StatusCode:  http.StatusOK,
 				canonical:   "https://example.com/canonical.php",
@@ -390,6 +457,52 @@ func TestSetContent(t *testing.T) {
 				Title:       "The title of a page",
 				Keywords:    "some keywords for a search",
 				Description: "A description",
+				Fingerprint: "eef7704b900dec7d",
+				Policy:      Policy{Index: true, follow: true},
+			},
+		},
+		{
+			name:   "body text",
+			url:    "http://www.example.com",
+			status: http.StatusOK,
+			body: `<html>
+					<head>
+						<title>A title</title>
+						<script>var session = "abc";</script>
+					</head>
+					<body>
+						<header><a href="/">Example</a> The best site</header>
+						<nav><ul><li><a href="/about">About us</a></li></ul></nav>
+						<div class="social-share">Share this page on social media</div>
+						<article>
+							<h1>The main heading</h1>
+							<p>The first paragraph of the article.</p>
+							<h2>A subheading</h2>
+							<p>The second paragraph with <a href="/link">a link</a> in it.</p>
+							<ul class="tags"><li><a href="/tag1">tag one</a></li><li><a href="/tag2">tag two</a></li></ul>
+							<h3>A minor heading</h3>
+							<p>The third paragraph.<br>After a break that goes on and on.</p>
+						</article>
+						<div>Text outside of the article is dropped</div>
+						<footer>Copyright 2017</footer>
+					</body>
+				</html>`,
+			links:               []string{},
+			maxLinks:            0,
+			ch:                  make(chan string),
+			truncateTitle:       100,
+			truncateKeywords:    5,
+			truncateDescription: 14,
+			truncateBody:        1000,
+			want: Content{
+				StatusCode:  http.StatusOK,
+				Language:    language.English,
+				Title:       "A title",
+				H1:          []string{"The main heading"},
+				H2:          []string{"A subheading"},
+				H3:          []string{"A minor heading"},
+				Body:        "The first paragraph of the article. The second paragraph with a link in it. The third paragraph. After a break that goes on and on.",
+				Fingerprint: "e4a61c152a1c3202", // too little text for a SimHash
 				Policy:      Policy{Index: true, follow: true},
 			},
 		},
@@ -432,7 +545,7 @@ func TestSetContent(t *testing.T) {
 			}
 
 			err = d.SetContent("", c.maxLinks, c.ch,
-				c.truncateTitle, c.truncateKeywords, c.truncateDescription)
+				c.truncateTitle, c.truncateKeywords, c.truncateDescription, c.truncateBody)
 
 			if err != nil {
 				t.Fatalf("expected nil error; got %q", err)
@@ -451,7 +564,7 @@ func TestSetContent(t *testing.T) {
 
 			// make sure SetContent changed no other part of the doc
 			// (this also checks that New() doesn't change Content)
-			d.tokenizer, d.MIME, d.Content = nil, "", Content{}
+			d.tokenizer, d.reader, d.MIME, d.Content, d.robots, d.Images, d.Links = nil, nil, "", Content{}, robots{}, nil, nil
 			if !reflect.DeepEqual(d, cpy) {
 				t.Fatalf("Parse() changed parts outside of the `Content`: got %+v; want: %+v", d, cpy)
 			}
@@ -477,3 +590,44 @@ func TestLanguages(t *testing.T) {
 		})
 	}
 }
+
+func TestFingerprint(t *testing.T) {
+	page := `<html><head><title>A title</title><script>var session = "%v";</script></head>
+		<body><p class="%v">Some   text</p>%v</body></html>`
+
+	fingerprint := func(body string) string {
+		d, err := New("http://www.example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := d.SetTokenizer(strings.NewReader(body)); err != nil {
+			t.Fatal(err)
+		}
+
+		ch := make(chan string, 10)
+		if err := d.SetContent("", 10, ch, 100, 25, 250, 1000); err != nil {
+			t.Fatal(err)
+		}
+
+		return d.Fingerprint
+	}
+
+	original := fingerprint(fmt.Sprintf(page, "abc", "left", ""))
+
+	for _, c := range []struct {
+		name    string
+		body    string
+		changed bool
+	}{
+		{"script and markup", fmt.Sprintf(page, "def", "right", ""), false},
+		{"whitespace", strings.Replace(fmt.Sprintf(page, "abc", "left", ""), "Some   text", "Some text", 1), false},
+		{"text", fmt.Sprintf(page, "abc", "left", "<p>More text</p>"), true},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			if got := fingerprint(c.body) != original; got != c.changed {
+				t.Fatalf("got changed %v; want %v", got, c.changed)
+			}
+		})
+	}
+}
@@ -2,6 +2,7 @@ package document
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 
 	"github.com/jivesearch/jivesearch/log"
@@ -18,12 +19,27 @@ type ElasticSearch struct {
 
 var langAnalyzer = make(map[language.Tag]string)
 
+// ImageType is the type of our image indices
+const ImageType = "image"
+
 // IndexName returns the language-specific index
 // e.g. "search-english", "search-french"
 func (e *ElasticSearch) IndexName(a string) string {
 	return e.Index + "-" + a
 }
 
+// LinkIndexName returns our link graph index, e.g. "search-links".
+// Links are the same in any language so there is just the one.
+func (e *ElasticSearch) LinkIndexName() string {
+	return e.Index + "-links"
+}
+
+// ImageIndexName returns the language-specific image index
+// e.g. "search-images-english", "search-images-french"
+func (e *ElasticSearch) ImageIndexName(a string) string {
+	return e.Index + "-images-" + a
+}
+
 // Analyzer returns the appropriate analyzer for a given language.
 func (e *ElasticSearch) Analyzer(lang language.Tag) (string, error) {
 	var analyzer string
@@ -40,8 +56,8 @@ func (e *ElasticSearch) Analyzer(lang language.Tag) (string, error) {
 	return "", fmt.Errorf("analyzer should not be blank. Lang: %s", lang)
 }
 
-// Setup will create our main search index
-// and language-specific indices for the content
+// Setup will create our main search index, our link graph
+// and language-specific indices for the content and for images
 func (e *ElasticSearch) Setup() error {
 	// We create one index per analyzer: search-english, search-spanish, etc...
 	// This is a list of all elasticsearch analyzers
@@ -53,8 +69,20 @@ func (e *ElasticSearch) Setup() error {
 		"russian", "sorani", "spanish", "swedish", "turkish", "thai",
 	}
 
+	indices := map[string]string{
+		e.LinkIndexName(): e.linkMapping(),
+	}
+
+	// the fields we added to an index that already exists
+	properties := map[string]string{}
+
 	for _, a := range analyzers {
-		idx := e.IndexName(a)
+		indices[e.IndexName(a)] = e.mapping(a)
+		indices[e.ImageIndexName(a)] = e.imageMapping(a)
+		properties[e.IndexName(a)] = e.properties(a)
+	}
+
+	for idx, mapping := range indices {
 		exists, err := e.Client.IndexExists(idx).Do(context.TODO())
 		if err != nil {
 			return err
@@ -62,7 +90,34 @@ func (e *ElasticSearch) Setup() error {
 
 		if !exists {
 			log.Info.Println("Creating index:", idx)
-			if _, err = e.Client.CreateIndex(idx).Body(e.mapping(a)).Do(context.TODO()); err != nil {
+			if _, err = e.Client.CreateIndex(idx).Body(mapping).Do(context.TODO()); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if p, ok := properties[idx]; ok {
+			clustered, err := e.hasField(idx, "cluster")
+			if err != nil {
+				return err
+			}
+
+			if _, err := e.Client.PutMapping().Index(idx).Type(e.Type).BodyString(p).Do(context.TODO()); err != nil {
+				return err
+			}
+
+			if clustered {
+				continue
+			}
+
+			// a doc from before we clustered near-duplicates is its own cluster...
+			// else collapsing our results on it would lump all those docs together
+			_, err = e.Client.UpdateByQuery(idx).Type(e.Type).
+				Query(elastic.NewBoolQuery().MustNot(elastic.NewExistsQuery("cluster"))).
+				Script(elastic.NewScript("ctx._source.cluster = ctx._id")).
+				ProceedOnVersionConflict().
+				Do(context.TODO())
+			if err != nil {
 				return err
 			}
 		}
@@ -71,6 +126,155 @@ func (e *ElasticSearch) Setup() error {
 	return nil
 }
 
+// hasField tells us if the mapping of an index has a field
+func (e *ElasticSearch) hasField(idx, field string) (bool, error) {
+	res, err := e.Client.GetMapping().Index(idx).Type(e.Type).Do(context.TODO())
+	if err != nil {
+		return false, err
+	}
+
+	b, err := json.Marshal(res[idx])
+	if err != nil {
+		return false, err
+	}
+
+	m := struct {
+		Mappings map[string]struct {
+			Properties map[string]interface{} `json:"properties"`
+		} `json:"mappings"`
+	}{}
+
+	if err := json.Unmarshal(b, &m); err != nil {
+		return false, err
+	}
+
+	_, ok := m.Mappings[e.Type].Properties[field]
+	return ok, nil
+}
+
+// properties are the fields we added to our mapping after our search indices were first
+// created. Their mapping is strict so an existing index would reject our documents without them.
+func (e *ElasticSearch) properties(a string) string {
+	return fmt.Sprintf(`{
+		"properties": {
+			"h1": {
+				"type": "text",
+				"fields": {
+					"lang": {
+						"type":     "text",
+						"analyzer": "%v"
+					}
+				}
+			},
+			"h2": {
+				"type": "text",
+				"fields": {
+					"lang": {
+						"type":     "text",
+						"analyzer": "%v"
+					}
+				}
+			},
+			"h3": {
+				"type": "text",
+				"fields": {
+					"lang": {
+						"type":     "text",
+						"analyzer": "%v"
+					}
+				}
+			},
+			"body": {
+				"type": "text",
+				"fields": {
+					"lang": {
+						"type":     "text",
+						"analyzer": "%v"
+					}
+				}
+			},
+			"etag": {
+				"type": "keyword",
+				"index": false
+			},
+			"last_modified": {
+				"type": "keyword",
+				"index": false
+			},
+			"next_crawl": {
+				"type": "date",
+				"format": "basic_date_time_no_millis"
+			},
+			"interval": {
+				"type": "long",
+				"index": false
+			},
+			"crawls": {
+				"type": "integer"
+			},
+			"changes": {
+				"type": "integer"
+			},
+			"fingerprint": {
+				"type": "keyword",
+				"index": false
+			},
+			"simhash": {
+				"type": "keyword",
+				"index": false
+			},
+			"simhash_bands": {
+				"type": "keyword"
+			},
+			"cluster": {
+				"type": "keyword"
+			},
+			"modified": {
+				"type": "date",
+				"format": "strict_date_optional_time"
+			},
+			"author": {
+				"type": "text"
+			},
+			"image": {
+				"type": "keyword",
+				"index": false
+			},
+			"type": {
+				"type": "keyword"
+			},
+			"site_name": {
+				"type": "text"
+			},
+			"noarchive": {
+				"type": "boolean"
+			},
+			"nosnippet": {
+				"type": "boolean"
+			},
+			"max_snippet": {
+				"type": "integer",
+				"index": false
+			},
+			"unavailable_after": {
+				"type": "date"
+			},
+			"noimageindex": {
+				"type": "boolean"
+			},
+			"anchors": {
+				"type": "text",
+				"fields": {
+					"lang": {
+						"type":     "text",
+						"analyzer": "%v"
+					}
+				}
+			}
+		}
+	}`, a, a, a, a, a)
+}
+
 // mapping is the mapping of our main search Index.
 // https://www.elastic.co/guide/en/elasticsearch/guide/current/one-lang-docs.html
 func (e *ElasticSearch) mapping(a string) string {
@@ -150,6 +354,42 @@ func (e *ElasticSearch) mapping(a string) string {
 							}
 						}
 					},
+					"h1": {
+						"type": "text",
+						"fields": {
+							"lang": {
+								"type":     "text",
+								"analyzer": "%v" 
+							}
+						}
+					},
+					"h2": {
+						"type": "text",
+						"fields": {
+							"lang": {
+								"type":     "text",
+								"analyzer": "%v" 
+							}
+						}
+					},
+					"h3": {
+						"type": "text",
+						"fields": {
+							"lang": {
+								"type":     "text",
+								"analyzer": "%v" 
+							}
+						}
+					},
+					"body": {
+						"type": "text",
+						"fields": {
+							"lang": {
+								"type":     "text",
+								"analyzer": "%v" 
+							}
+						}
+					},
 					"id": {
 						"type": "keyword"
 					},
@@ -176,14 +416,83 @@ func (e *ElasticSearch) mapping(a string) string {
 					"index": {
 						"type": "boolean"
 					},
+					"noarchive": {
+						"type": "boolean"
+					},
+					"nosnippet": {
+						"type": "boolean"
+					},
+					"max_snippet": {
+						"type": "integer",
+						"index": false
+					},
+					"unavailable_after": {
+						"type": "date"
+					},
+					"noimageindex": {
+						"type": "boolean"
+					},
 					"crawled": {
 						"type": "date",
 						"format": "basic_date"
 					},
+					"next_crawl": {
+						"type": "date",
+						"format": "basic_date_time_no_millis"
+					},
+					"interval": {
+						"type": "long",
+						"index": false
+					},
+					"crawls": {
+						"type": "integer"
+					},
+					"changes": {
+						"type": "integer"
+					},
+					"simhash": {
+						"type": "keyword",
+						"index": false
+					},
+					"simhash_bands": {
+						"type": "keyword"
+					},
+					"cluster": {
+						"type": "keyword"
+					},
+					"fingerprint": {
+						"type": "keyword",
+						"index": false
+					},
+					"etag": {
+						"type": "keyword",
+						"index": false
+					},
+					"last_modified": {
+						"type": "keyword",
+						"index": false
+					},
 					"date": {
 						"type": "date",
 						"format": "strict_date_optional_time"
 					},
+					"modified": {
+						"type": "date",
+						"format": "strict_date_optional_time"
+					},
+					"author": {
+						"type": "text"
+					},
+					"image": {
+						"type": "keyword",
+						"index": false
+					},
+					"type": {
+						"type": "keyword"
+					},
+					"site_name": {
+						"type": "text"
+					},
 					"status": {
 						"type": "short"
 					},
@@ -193,15 +502,138 @@ func (e *ElasticSearch) mapping(a string) string {
 					},
 					"mime": {
 						"type": "keyword"
+					},
+					"rank": {
+						"type": "float"
+					},
+					"anchors": {
+						"type": "text",
+						"fields": {
+							"lang": {
+								"type":     "text",
+								"analyzer": "%v"
+							}
+						}
 					}
 				}
 			}
 		}
-	}`, a, a)
+	}`, a, a, a, a, a, a, a)
 
 	return m
 }
 
+// imageMapping is the mapping of our image indices. An image is found by
+// what its alt & title attributes, the text around it and its page say it is.
+func (e *ElasticSearch) imageMapping(a string) string {
+	return fmt.Sprintf(`{
+		"mappings": {
+			"image": {
+				"_all": {
+					"enabled": false
+				},
+				"dynamic": "strict",
+				"properties": {
+					"id": {
+						"type": "keyword"
+					},
+					"domain": {
+						"type": "keyword"
+					},
+					"alt": {
+						"type": "text",
+						"fields": {
+							"lang": {
+								"type":     "text",
+								"analyzer": "%v" 
+							}
+						}
+					},
+					"title": {
+						"type": "text",
+						"fields": {
+							"lang": {
+								"type":     "text",
+								"analyzer": "%v" 
+							}
+						}
+					},
+					"text": {
+						"type": "text",
+						"fields": {
+							"lang": {
+								"type":     "text",
+								"analyzer": "%v" 
+							}
+						}
+					},
+					"page_title": {
+						"type": "text",
+						"fields": {
+							"lang": {
+								"type":     "text",
+								"analyzer": "%v" 
+							}
+						}
+					},
+					"page": {
+						"type": "keyword",
+						"index": false
+					},
+					"width": {
+						"type": "integer"
+					},
+					"height": {
+						"type": "integer"
+					},
+					"crawled": {
+						"type": "date",
+						"format": "basic_date"
+					}
+				}
+			}
+		}
+	}`, a, a, a, a)
+}
+
+// linkMapping is the mapping of our link graph. A page's links are
+// found by the page's url (the id) and the pages linking to a url by links.target.
+func (e *ElasticSearch) linkMapping() string {
+	return `{
+		"mappings": {
+			"link": {
+				"_all": {
+					"enabled": false
+				},
+				"dynamic": "strict",
+				"properties": {
+					"host": {
+						"type": "keyword"
+					},
+					"domain": {
+						"type": "keyword"
+					},
+					"crawled": {
+						"type": "date",
+						"format": "basic_date"
+					},
+					"links": {
+						"properties": {
+							"target": {
+								"type": "keyword"
+							},
+							"anchor": {
+								"type": "text",
+								"index": false
+							}
+						}
+					}
+				}
+			}
+		}
+	}`
+}
+
 func init() {
 	// These are the most commonly used languages mapped to an elasticsearch analyzer
 	// TODO: fill in the rest of this map. Also, we haven't mapped the Basque, Galician,
@@ -1,8 +1,12 @@
 package document
 
 import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/olivere/elastic"
@@ -96,6 +100,104 @@ func TestSetup(t *testing.T) {
 	}
 }
 
+func TestSetupExisting(t *testing.T) {
+	for _, c := range []struct {
+		name      string
+		mapping   string // the existing mapping of our search indices
+		backfills bool
+	}{
+		{"from before we clustered", `{"properties": {"title": {"type": "text"}}}`, true},
+		{"already clustered", `{"properties": {"title": {"type": "text"}, "cluster": {"type": "keyword"}}}`, false},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			mappings := map[string]string{}
+			backfills := map[string]string{}
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				b, _ := ioutil.ReadAll(r.Body)
+				switch {
+				case r.Method == "PUT":
+					mappings[r.URL.Path] = string(b)
+				case r.Method == "GET" && strings.Contains(r.URL.Path, "/_mapping"):
+					idx := strings.Split(r.URL.Path, "/")[1]
+					fmt.Fprintf(w, `{%q: {"mappings": {"document": %v}}}`, idx, c.mapping)
+					return
+				case strings.HasSuffix(r.URL.Path, "/_update_by_query"):
+					backfills[r.URL.Path] = string(b)
+				}
+				w.Write([]byte(`{"acknowledged": true}`))
+			}))
+			defer ts.Close()
+
+			e, err := MockService(ts.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := e.Setup(); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, ok := mappings["/search-links/_mapping/document"]; ok {
+				t.Fatal("our link graph has no fields to add")
+			}
+
+			m, ok := mappings["/search-english/_mapping/document"]
+			if !ok {
+				t.Fatalf("expected the mapping of search-english to be updated; got %v", mappings)
+			}
+
+			var got struct {
+				Properties map[string]struct {
+					Type   string                       `json:"type"`
+					Fields map[string]map[string]string `json:"fields"`
+				} `json:"properties"`
+			}
+
+			if err := json.Unmarshal([]byte(m), &got); err != nil {
+				t.Fatal(err)
+			}
+
+			for _, f := range []string{
+				"etag", "last_modified",
+				"next_crawl", "interval", "crawls", "changes", "fingerprint",
+				"simhash", "simhash_bands", "cluster",
+				"h1", "h2", "h3", "body",
+				"modified", "author", "image", "type", "site_name",
+				"noarchive", "nosnippet", "max_snippet", "unavailable_after", "noimageindex",
+				"anchors",
+			} {
+				if _, ok := got.Properties[f]; !ok {
+					t.Fatalf("expected %q to be added to search-english", f)
+				}
+			}
+
+			for _, f := range []string{"body", "anchors"} {
+				if a := got.Properties[f].Fields["lang"]["analyzer"]; a != "english" {
+					t.Fatalf("got analyzer %q for %v; want english", a, f)
+				}
+			}
+
+			// the backfill only runs once
+			b, ok := backfills["/search-english/document/_update_by_query"]
+			if ok != c.backfills {
+				t.Fatalf("got backfill %v; want %v", ok, c.backfills)
+			}
+
+			if !c.backfills {
+				return
+			}
+
+			for _, want := range []string{
+				`"must_not":{"exists":{"field":"cluster"}}`, `ctx._source.cluster = ctx._id`,
+			} {
+				if !strings.Contains(b, want) {
+					t.Fatalf("got backfill %q; want %s", b, want)
+				}
+			}
+		})
+	}
+}
+
 func MockService(url string) (*ElasticSearch, error) {
 	client, err := elastic.NewSimpleClient(elastic.SetURL(url))
 	if err != nil {
@@ -0,0 +1,142 @@
+package document
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/html/atom"
+	"golang.org/x/text/language"
+)
+
+// Extractor extracts the content of a type of document other than html
+type Extractor interface {
+	Extract(r io.Reader) (Extracted, error)
+}
+
+// Extracted is the content an Extractor found in a document
+type Extracted struct {
+	Title    string
+	H1       []string
+	H2       []string
+	H3       []string
+	Body     string
+	Author   string
+	Date     string // RFC 3339
+	Language string // a BCP 47 tag
+	Links    []string
+}
+
+// Extractors are the Extractors for each MIME type we can index other than html.
+// Add to it to index another type of document.
+var Extractors = map[string]Extractor{
+	"application/pdf": &PDF{},
+	"text/plain":      &Text{},
+	"text/markdown":   &Markdown{},
+}
+
+// markdownExtensions are how we know a text/plain document is Markdown
+// when it isn't served as text/markdown (which it rarely is)
+var markdownExtensions = []string{".md", ".markdown", ".mdown", ".mkd"}
+
+// Supported tells us if we can extract the content of the document
+func (d *Document) Supported() bool {
+	if d.MIME == "text/html" || d.MIME == "text/xml" { // some html is mismarked as text/xml
+		return true
+	}
+
+	_, ok := Extractors[d.MIME]
+	return ok
+}
+
+// setMIME sets the MIME type from the Content-Type header if it is html or a text type
+// we have an Extractor for (e.g. text/markdown) or else sniffs it from the body.
+// The sniffer only knows html by its first tag so a page starting with
+// <meta>, <link>, <header>, etc would be text/plain if we didn't trust the header.
+func (d *Document) setMIME(peek []byte) {
+	d.MIME = strings.Split(http.DetectContentType(peek), ";")[0]
+
+	ct := strings.ToLower(strings.TrimSpace(strings.Split(d.header.Get("Content-Type"), ";")[0]))
+	if ct == "text/x-markdown" {
+		ct = "text/markdown"
+	}
+
+	_, ok := Extractors[ct]
+	switch {
+	case ct == "text/html":
+		d.MIME = ct
+	case ok && strings.HasPrefix(ct, "text/") && d.MIME == "text/plain":
+		d.MIME = ct
+	}
+
+	if d.MIME == "text/plain" && d.URL != nil {
+		for _, ext := range markdownExtensions {
+			if strings.HasSuffix(strings.ToLower(d.URL.Path), ext) {
+				d.MIME = "text/markdown"
+			}
+		}
+	}
+}
+
+// extract sets the content from the document's Extractor
+func (d *Document) extract(e Extractor, maxLinks int, ch chan string, truncateTitle, truncateBody int) error {
+	x, err := e.Extract(d.reader)
+	if err != nil {
+		return err
+	}
+
+	d.Language, _, _ = Matcher.Match(language.Make(strings.ToLower(x.Language))) // we ignore the error
+	d.Title = d.extractText(x.Title, truncateTitle)
+	d.Author = d.extractText(x.Author, truncateTitle)
+	d.Date = parseDate(x.Date)
+
+	lk := &links{}
+	if d.Policy.follow {
+		for _, lnk := range x.Links {
+			u := d.resolve(lnk)
+			if u == "" {
+				continue
+			}
+
+			lk.add(u)
+			if maxLinks == -1 || len(lk.links) <= maxLinks {
+				ch <- u
+			}
+		}
+	}
+	d.setLinks(lk)
+
+	ex := newExtractor()
+	ex.headings[atom.H1], ex.headings[atom.H2], ex.headings[atom.H3] = x.H1, x.H2, x.H3
+	ex.blocks = []textBlock{{words: strings.Fields(x.Body)}}
+
+	d.setText(ex, truncateTitle, truncateBody)
+	return nil
+}
+
+// Text extracts plain text. The first line is the title.
+type Text struct{}
+
+// Extract extracts the title and text of a plain text document
+func (t *Text) Extract(r io.Reader) (Extracted, error) {
+	x := Extracted{}
+
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return x, err
+	}
+
+	x.Body = string(b)
+
+	sc := bufio.NewScanner(strings.NewReader(x.Body))
+	for sc.Scan() {
+		if line := strings.TrimSpace(sc.Text()); line != "" {
+			x.Title = line
+			break
+		}
+	}
+
+	return x, nil
+}
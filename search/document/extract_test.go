@@ -0,0 +1,157 @@
+package document
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestText(t *testing.T) {
+	got, err := (&Text{}).Extract(strings.NewReader("\n  Release Notes \nv1.0 fixes <everything>\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := Extracted{
+		Title: "Release Notes",
+		Body:  "\n  Release Notes \nv1.0 fixes <everything>\n",
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v; want %+v", got, want)
+	}
+}
+
+func TestExtract(t *testing.T) {
+	for _, c := range []struct {
+		name        string
+		url         string
+		contentType string
+		body        string
+		mime        string
+		title       string
+		h1          []string
+		text        string
+		links       []string
+	}{
+		{
+			name:  "html",
+			url:   "https://www.example.com/",
+			body:  `<html><head><title>Hi</title></head><body><p>there</p></body></html>`,
+			mime:  "text/html",
+			title: "Hi",
+			text:  "there",
+			links: []string{},
+		},
+		{
+			name:        "html header",
+			url:         "https://www.example.com/",
+			contentType: "text/html; charset=utf-8",
+			body:        `<meta charset="utf-8"><html><head><title>Hi</title></head><body><p>there</p></body></html>`,
+			mime:        "text/html",
+			title:       "Hi",
+			text:        "there",
+			links:       []string{},
+		},
+		{
+			name:  "text",
+			url:   "https://www.example.com/notes.txt",
+			body:  "Notes\nsee https://www.example.com/other",
+			mime:  "text/plain",
+			title: "Notes",
+			text:  "Notes see https://www.example.com/other",
+			links: []string{},
+		},
+		{
+			name:  "markdown extension",
+			url:   "https://www.example.com/README.md",
+			body:  "# Readme\nSee [the docs](/docs).",
+			mime:  "text/markdown",
+			title: "Readme",
+			h1:    []string{"Readme"},
+			text:  "See the docs.",
+			links: []string{"https://www.example.com/docs"},
+		},
+		{
+			name:        "markdown header",
+			url:         "https://www.example.com/readme",
+			contentType: "text/x-markdown; charset=utf-8",
+			body:        "Readme\n======\nHello",
+			mime:        "text/markdown",
+			title:       "Readme",
+			h1:          []string{"Readme"},
+			text:        "Hello",
+			links:       []string{},
+		},
+		{
+			name: "pdf",
+			url:  "https://www.example.com/doc.pdf",
+			body: string(testPDF("/Root 1 0 R",
+				"<< /Type /Catalog /Pages 2 0 R >>",
+				"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+				"<< /Type /Page /Parent 2 0 R /Contents 4 0 R >>",
+				testStream("", []byte("BT (A PDF document) Tj ET"), false),
+			)),
+			mime:  "application/pdf",
+			title: "A PDF document",
+			text:  "A PDF document",
+			links: []string{},
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			d, err := New(c.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			h := http.Header{}
+			if c.contentType != "" {
+				h.Set("Content-Type", c.contentType)
+			}
+
+			if err := d.SetHeader(h).SetPolicyFromHeader("").SetTokenizer(strings.NewReader(c.body)); err != nil {
+				t.Fatal(err)
+			}
+
+			if d.MIME != c.mime {
+				t.Fatalf("got MIME %q; want %q", d.MIME, c.mime)
+			}
+
+			if !d.Supported() {
+				t.Fatalf("expected %q to be supported", d.MIME)
+			}
+
+			ch := make(chan string)
+			links := make(chan []string)
+			go func() {
+				l := []string{}
+				for lnk := range ch {
+					l = append(l, lnk)
+				}
+				links <- l
+			}()
+
+			if err := d.SetContent("", -1, ch, 100, 25, 250, 1000); err != nil {
+				t.Fatal(err)
+			}
+			close(ch)
+
+			if d.Title != c.title {
+				t.Fatalf("got title %q; want %q", d.Title, c.title)
+			}
+			if !reflect.DeepEqual(d.H1, c.h1) {
+				t.Fatalf("got h1 %q; want %q", d.H1, c.h1)
+			}
+			if d.Body != c.text {
+				t.Fatalf("got body %q; want %q", d.Body, c.text)
+			}
+			if got := <-links; !reflect.DeepEqual(got, c.links) {
+				t.Fatalf("got links %q; want %q", got, c.links)
+			}
+			if d.Fingerprint == "" {
+				t.Fatal("expected a fingerprint")
+			}
+		})
+	}
+}
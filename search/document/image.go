@@ -0,0 +1,175 @@
+package document
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"golang.org/x/net/publicsuffix"
+	"golang.org/x/text/language"
+)
+
+const (
+	maxImages         = 50 // per page
+	maxImageTextWords = 50 // of the text around an image
+	minImageSize      = 50 // px...smaller is an icon, a spacer or a tracking pixel
+	maxImageURL       = 2083
+)
+
+// Image is an image we found on a page. We index images apart from our
+// documents as an image can be on many pages and a page can have many images.
+// We know what an image is of from its alt & title attributes, the text around
+// it (e.g. its <figcaption>) and the page it is on.
+type Image struct {
+	ID        string       `json:"id"` // the image's url
+	Domain    string       `json:"domain,omitempty"`
+	Alt       string       `json:"alt,omitempty"`
+	Title     string       `json:"title,omitempty"`
+	Text      string       `json:"text,omitempty"`
+	Width     int          `json:"width,omitempty"` // from the <img> tag (if any)
+	Height    int          `json:"height,omitempty"`
+	Page      string       `json:"page,omitempty"` // the url of the page we last found it on
+	PageTitle string       `json:"page_title,omitempty"`
+	Crawled   string       `json:"crawled,omitempty"`
+	Language  language.Tag `json:"-"`
+}
+
+// images collects the <img> tags of a page along with the text around them
+type images struct {
+	images  []*Image
+	around  [][]string // the words around each image
+	pending []int      // the images in the current block (or <figure>) that still take its text
+	block   []string   // the words of the current block
+	figure  int        // how deep we are in <figure> tags
+}
+
+func (im *images) start(t html.Token, boilerplate bool) {
+	switch {
+	case t.DataAtom == atom.Figure:
+		im.figure++
+	case t.DataAtom == atom.Img:
+		im.add(t, boilerplate)
+	case blocks[t.DataAtom]:
+		im.flush()
+	}
+}
+
+func (im *images) end(t html.Token) {
+	switch {
+	case t.DataAtom == atom.Figure:
+		if im.figure > 0 {
+			im.figure--
+		}
+		im.pending, im.block = nil, nil
+	case blocks[t.DataAtom]:
+		im.flush()
+	}
+}
+
+func (im *images) text(s string, boilerplate bool) {
+	if boilerplate {
+		return
+	}
+
+	words := strings.Fields(s)
+	im.block = append(im.block, words...)
+
+	for _, i := range im.pending {
+		if n := maxImageTextWords - len(im.around[i]); n > 0 {
+			if len(words) < n {
+				n = len(words)
+			}
+			im.around[i] = append(im.around[i], words[:n]...)
+		}
+	}
+}
+
+// flush ends the current block. Inside a <figure> the images take
+// all its text (e.g. its <figcaption>) so they are kept pending.
+func (im *images) flush() {
+	if im.figure > 0 {
+		return
+	}
+	im.pending, im.block = nil, nil
+}
+
+func (im *images) add(t html.Token, boilerplate bool) {
+	if boilerplate || len(im.images) >= maxImages {
+		return
+	}
+
+	src, _ := getAttribute(t, "src")
+	if src == "" || strings.HasPrefix(src, "data:") { // lazy-loaded images
+		for _, a := range []string{"data-src", "data-original", "data-lazy-src"} {
+			if s, ok := getAttribute(t, a); ok {
+				src = s
+				break
+			}
+		}
+	}
+
+	img := &Image{ID: src}
+	img.Alt, _ = getAttribute(t, "alt")
+	img.Title, _ = getAttribute(t, "title")
+
+	w, _ := getAttribute(t, "width")
+	h, _ := getAttribute(t, "height")
+	img.Width, _ = strconv.Atoi(strings.TrimSuffix(strings.TrimSpace(w), "px"))
+	img.Height, _ = strconv.Atoi(strings.TrimSuffix(strings.TrimSpace(h), "px"))
+
+	if (img.Width > 0 && img.Width < minImageSize) || (img.Height > 0 && img.Height < minImageSize) {
+		return
+	}
+
+	before := im.block
+	if len(before) > maxImageTextWords/2 {
+		before = before[len(before)-maxImageTextWords/2:]
+	}
+
+	im.images = append(im.images, img)
+	im.around = append(im.around, append([]string{}, before...))
+	im.pending = append(im.pending, len(im.images)-1)
+}
+
+// setImages sets the images of the page. The image from the page's
+// structured data (e.g. og:image) is described by the page itself.
+func (d *Document) setImages(im *images, truncate int) {
+	d.Images = nil
+	seen := map[string]bool{}
+
+	add := func(img *Image, text string) {
+		u, err := url.Parse(strings.TrimSpace(img.ID))
+		if err != nil || img.ID == "" || d.URL == nil || len(d.Images) >= maxImages {
+			return
+		}
+
+		u = d.URL.ResolveReference(u)
+		u.Fragment = ""
+		if (u.Scheme != "http" && u.Scheme != "https") || len(u.String()) > maxImageURL || seen[u.String()] {
+			return
+		}
+
+		seen[u.String()] = true
+
+		img.ID = u.String()
+		img.Domain, _ = publicsuffix.EffectiveTLDPlusOne(u.Hostname())
+		img.Alt = d.extractText(img.Alt, truncate)
+		img.Title = d.extractText(img.Title, truncate)
+		img.Text = d.extractText(text, -1)
+		img.Page = d.ID
+		img.PageTitle = d.Title
+		img.Crawled = d.Crawled
+		img.Language = d.Language
+		d.Images = append(d.Images, img)
+	}
+
+	if d.Image != "" {
+		add(&Image{ID: d.Image, Alt: d.Title}, d.Description)
+	}
+
+	for i, img := range im.images {
+		add(img, strings.Join(im.around[i], " "))
+	}
+}
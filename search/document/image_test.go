@@ -0,0 +1,67 @@
+package document
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/text/language"
+)
+
+func TestImages(t *testing.T) {
+	page := `<html lang="en"><head><title>Cats</title>
+		<meta property="og:image" content="/og.jpg"><meta name="description" content="All about cats">
+		</head><body>
+		<nav><img src="/logo.png" alt="logo"></nav>
+		<p>Some text before <img src="/cat.jpg" alt="A cat" title="Kitty" width="300" height="200"> and after.</p>
+		<p>Unrelated text.</p>
+		<figure><img src="https://cdn.example.org/dog.jpg" alt="A dog"><figcaption>A very good dog</figcaption></figure>
+		<img src="/pixel.gif" width="1" height="1">
+		<img src="data:image/gif;base64,R0lGOD" data-src="/lazy.jpg">
+		<img src="/cat.jpg" alt="duplicate">
+		<img alt="no src">
+		</body></html>`
+
+	d, err := New("https://www.example.com/cats")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d.SetPolicyFromHeader("").SetCrawled(time.Date(2017, 9, 1, 0, 0, 0, 0, time.UTC))
+	if err := d.SetTokenizer(strings.NewReader(page)); err != nil {
+		t.Fatal(err)
+	}
+
+	ch := make(chan string)
+	go func() {
+		for range ch {
+		}
+	}()
+
+	if err := d.SetContent("", -1, ch, 100, 25, 250, 1000); err != nil {
+		t.Fatal(err)
+	}
+	close(ch)
+
+	img := func(id, domain, alt, title, text string, w, h int) *Image {
+		return &Image{
+			ID: id, Domain: domain, Alt: alt, Title: title, Text: text, Width: w, Height: h,
+			Page: "https://www.example.com/cats", PageTitle: "Cats", Crawled: "20170901", Language: language.English,
+		}
+	}
+
+	want := []*Image{
+		img("https://www.example.com/og.jpg", "example.com", "Cats", "", "All about cats", 0, 0),
+		img("https://www.example.com/cat.jpg", "example.com", "A cat", "Kitty", "Some text before and after.", 300, 200),
+		img("https://cdn.example.org/dog.jpg", "example.org", "A dog", "", "A very good dog", 0, 0),
+		img("https://www.example.com/lazy.jpg", "example.com", "", "", "", 0, 0),
+	}
+
+	if !reflect.DeepEqual(d.Images, want) {
+		for _, i := range d.Images {
+			t.Logf("%+v", i)
+		}
+		t.Fatalf("got %+v; want %+v", d.Images, want)
+	}
+}
@@ -0,0 +1,114 @@
+package document
+
+import (
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+const (
+	maxGraphLinks = 500 // per page
+	maxAnchor     = 100 // characters of anchor text
+)
+
+// LinkType is the type of our link graph index
+const LinkType = "link"
+
+// Link is a followed link from a page along with its anchor text
+type Link struct {
+	Target string `json:"target"`
+	Anchor string `json:"anchor,omitempty"`
+}
+
+// Outlinks are the links from a page. Together they make up our link graph.
+// Each page's links replace those from its last crawl.
+type Outlinks struct {
+	ID      string `json:"-"` // the url of the page the links are on
+	Host    string `json:"host"`
+	Domain  string `json:"domain"`
+	Crawled string `json:"crawled"`
+	Links   []Link `json:"links"`
+}
+
+// Outlinks returns the links from the document
+func (d *Document) Outlinks() *Outlinks {
+	return &Outlinks{
+		ID:      d.ID,
+		Host:    d.Host,
+		Domain:  d.Domain,
+		Crawled: d.Crawled,
+		Links:   d.Links,
+	}
+}
+
+// links collects the followed links of a page along with their anchor text
+type links struct {
+	links  []Link
+	anchor []string // the anchor text of the open <a> tag
+	open   bool
+}
+
+// start opens a link to target. An <a> tag that is never closed has no anchor text.
+func (lk *links) start(target string) {
+	lk.open, lk.anchor = true, []string{}
+	lk.links = append(lk.links, Link{Target: target})
+}
+
+// img is part of the anchor text of a linked image
+func (lk *links) img(t html.Token) {
+	if !lk.open || t.DataAtom != atom.Img {
+		return
+	}
+
+	if alt, ok := getAttribute(t, "alt"); ok {
+		lk.anchor = append(lk.anchor, alt)
+	}
+}
+
+func (lk *links) text(s string) {
+	if lk.open {
+		lk.anchor = append(lk.anchor, s)
+	}
+}
+
+func (lk *links) end(t html.Token) {
+	if !lk.open || t.DataAtom != atom.A {
+		return
+	}
+
+	lk.open = false
+	lk.links[len(lk.links)-1].Anchor = strings.Join(lk.anchor, " ")
+}
+
+// add adds a link from a document other than html (e.g. a pdf) which has no anchor text
+func (lk *links) add(target string) {
+	lk.links = append(lk.links, Link{Target: target})
+}
+
+// setLinks sets the links of the page. A link with the same target and anchor text is counted once.
+// The targets are the urls the pages will have once we crawl them (e.g. without a #fragment).
+func (d *Document) setLinks(lk *links) {
+	d.Links = nil
+	seen := map[Link]struct{}{}
+
+	for _, l := range lk.links {
+		if len(d.Links) >= maxGraphLinks {
+			return
+		}
+
+		u, err := ValidateURL(l.Target)
+		if err != nil || u.String() == d.ID {
+			continue
+		}
+
+		l.Target = u.String()
+		l.Anchor = d.extractText(l.Anchor, maxAnchor)
+		if _, ok := seen[l]; ok {
+			continue
+		}
+
+		seen[l] = struct{}{}
+		d.Links = append(d.Links, l)
+	}
+}
@@ -0,0 +1,104 @@
+package document
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLinks(t *testing.T) {
+	for _, c := range []struct {
+		name     string
+		header   string
+		page     string
+		maxLinks int
+		want     []Link
+		crawl    []string
+	}{
+		{
+			name: "basic",
+			page: `<html><body>
+				<a href="/about">About <b>us</b></a>
+				<a href="https://other.example.org/page" rel="nofollow">Sponsored</a>
+				<a href="/cats"><img src="/cat.jpg" alt="Cute cats"></a>
+				<a href="/about">About us</a>
+				<a href="/about">More about us</a>
+				<a href="mailto:me@example.com">Email</a>
+				<a href="https://www.example.com/page">Itself</a>
+				<a href="https://other.example.org/">Unclosed
+				</body></html>`,
+			maxLinks: 2,
+			want: []Link{
+				{Target: "https://www.example.com/about", Anchor: "About us"},
+				{Target: "https://www.example.com/cats", Anchor: "Cute cats"},
+				{Target: "https://www.example.com/about", Anchor: "More about us"},
+				{Target: "https://other.example.org/"},
+			},
+			crawl: []string{"https://www.example.com/about", "https://www.example.com/cats"},
+		},
+		{
+			name: "normalized",
+			page: `<html><body>
+				<a href="/about#team">Team</a>
+				<a href="HTTPS://WWW.EXAMPLE.COM/about">Team</a>
+				<a href="#top">Top</a>
+				</body></html>`,
+			want: []Link{
+				{Target: "https://www.example.com/about", Anchor: "Team"},
+			},
+		},
+		{
+			name:     "nofollow",
+			header:   "nofollow",
+			page:     `<html><body><a href="/about">About us</a></body></html>`,
+			maxLinks: -1,
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			d, err := New("https://www.example.com/page")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			d.SetHeader(http.Header{"X-Robots-Tag": {c.header}}).SetPolicyFromHeader("").SetCrawled(time.Date(2017, 9, 1, 0, 0, 0, 0, time.UTC))
+			if err := d.SetTokenizer(strings.NewReader(c.page)); err != nil {
+				t.Fatal(err)
+			}
+
+			ch := make(chan string)
+			done := make(chan []string)
+			go func() {
+				crawl := []string{}
+				for l := range ch {
+					crawl = append(crawl, l)
+				}
+				done <- crawl
+			}()
+
+			if err := d.SetContent("", c.maxLinks, ch, 100, 25, 250, 1000); err != nil {
+				t.Fatal(err)
+			}
+			close(ch)
+
+			// the graph has all the followed links but we crawl no more than maxLinks
+			if crawl := <-done; len(crawl) != len(c.crawl) || (len(crawl) > 0 && !reflect.DeepEqual(crawl, c.crawl)) {
+				t.Fatalf("got crawl %+v; want %+v", crawl, c.crawl)
+			}
+
+			if !reflect.DeepEqual(d.Links, c.want) {
+				t.Fatalf("got %+v; want %+v", d.Links, c.want)
+			}
+
+			want := &Outlinks{
+				ID: "https://www.example.com/page", Host: "www.example.com", Domain: "example.com",
+				Crawled: "20170901", Links: c.want,
+			}
+
+			if got := d.Outlinks(); !reflect.DeepEqual(got, want) {
+				t.Fatalf("got %+v; want %+v", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package document
+
+import (
+	"bufio"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// Markdown extracts the text of a Markdown document (README.md, docs, etc).
+// The title is from the front matter or else the first heading.
+// https://spec.commonmark.org/
+type Markdown struct{}
+
+var (
+	mdATXHeading = regexp.MustCompile(`^ {0,3}(#{1,6})\s+(.*?)(\s+#+)?\s*$`)
+	mdSetext     = regexp.MustCompile(`^ {0,3}(=+|-+)\s*$`)
+	mdFence      = regexp.MustCompile("^ {0,3}(```|~~~)")
+	mdReference  = regexp.MustCompile(`^ {0,3}\[[^\]]+\]:\s*<?(\S+?)>?(\s+.*)?$`)
+	mdImage      = regexp.MustCompile(`!\[([^\]]*)\]\(([^)\s]*)[^)]*\)`)
+	mdLink       = regexp.MustCompile(`\[([^\]]*)\]\(([^)\s]*)[^)]*\)`)
+	mdAutoLink   = regexp.MustCompile(`<(https?://[^>\s]+)>`)
+	mdRefLink    = regexp.MustCompile(`\[([^\]]+)\]\[[^\]]*\]`)
+	mdMarker     = regexp.MustCompile(`^ {0,3}(>\s*)*([*+-]|\d+[.)])?\s+`)
+	mdEmphasis   = regexp.MustCompile("(\\*{1,3}|_{2,3}|`+|~~)")
+	mdHTML       = regexp.MustCompile(`</?[a-zA-Z][^>]*>`)
+	mdTitle      = regexp.MustCompile(`^title:\s*["']?(.*?)["']?\s*$`)
+)
+
+// Extract extracts the title, headings, text and links of a Markdown document
+func (md *Markdown) Extract(r io.Reader) (Extracted, error) {
+	x := Extracted{}
+
+	var body []string
+	var prev string // a paragraph line that may turn out to be a setext heading
+	var fence, frontMatter bool
+
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	for n := 0; sc.Scan(); n++ {
+		line := sc.Text()
+
+		// YAML front matter (Jekyll, Hugo, etc)
+		if n == 0 && strings.TrimSpace(line) == "---" {
+			frontMatter = true
+			continue
+		}
+		if frontMatter {
+			if t := strings.TrimSpace(line); t == "---" || t == "..." {
+				frontMatter = false
+			} else if m := mdTitle.FindStringSubmatch(t); m != nil && x.Title == "" {
+				x.Title = m[1]
+			}
+			continue
+		}
+
+		if mdFence.MatchString(line) {
+			fence = !fence
+			prev = ""
+			continue
+		}
+
+		if fence { // code is text too
+			body = append(body, line)
+			continue
+		}
+
+		if m := mdSetext.FindStringSubmatch(line); m != nil {
+			if strings.TrimSpace(prev) != "" {
+				h := body[len(body)-1]
+				body = body[:len(body)-1]
+				if strings.HasPrefix(m[1], "=") {
+					x.H1 = append(x.H1, h)
+				} else {
+					x.H2 = append(x.H2, h)
+				}
+			}
+			prev = "" // otherwise a thematic break
+			continue
+		}
+
+		if m := mdATXHeading.FindStringSubmatch(line); m != nil {
+			switch h := x.inline(m[2]); len(m[1]) {
+			case 1:
+				x.H1 = append(x.H1, h)
+			case 2:
+				x.H2 = append(x.H2, h)
+			case 3:
+				x.H3 = append(x.H3, h)
+			default: // h4-h6 are part of the body
+				body = append(body, h)
+			}
+			prev = ""
+			continue
+		}
+
+		if m := mdReference.FindStringSubmatch(line); m != nil {
+			x.Links = append(x.Links, m[1])
+			prev = ""
+			continue
+		}
+
+		prev = line
+		body = append(body, x.inline(mdMarker.ReplaceAllString(line, "")))
+	}
+
+	if x.Title == "" {
+		for _, h := range [][]string{x.H1, x.H2, x.H3} {
+			if len(h) > 0 {
+				x.Title = h[0]
+				break
+			}
+		}
+	}
+
+	x.Body = strings.Join(body, "\n")
+	return x, sc.Err()
+}
+
+// inline strips the inline markup of a line and collects its links (but not its images)
+func (x *Extracted) inline(s string) string {
+	s = mdImage.ReplaceAllString(s, "$1")
+
+	for _, m := range mdLink.FindAllStringSubmatch(s, -1) {
+		x.Links = append(x.Links, m[2])
+	}
+	s = mdLink.ReplaceAllString(s, "$1")
+
+	for _, m := range mdAutoLink.FindAllStringSubmatch(s, -1) {
+		x.Links = append(x.Links, m[1])
+	}
+	s = mdAutoLink.ReplaceAllString(s, "$1")
+
+	s = mdRefLink.ReplaceAllString(s, "$1")
+	s = mdHTML.ReplaceAllString(s, " ")
+	s = mdEmphasis.ReplaceAllString(s, "")
+	return strings.TrimSpace(s)
+}
@@ -0,0 +1,51 @@
+package document
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMarkdown(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		md   string
+		want Extracted
+	}{
+		{
+			name: "headings",
+			md: "# Jive Search #\n\nA *privacy* focused [search engine](https://jivesearch.com).\n\n" +
+				"Install\n-------\n\n- `go get` it\n- run ![logo](/logo.png) it\n\n### Notes\n#### Minor\n\n---\n> quoted <b>text</b>\n",
+			want: Extracted{
+				Title: "Jive Search",
+				H1:    []string{"Jive Search"},
+				H2:    []string{"Install"},
+				H3:    []string{"Notes"},
+				Body:  "\nA privacy focused search engine.\n\n\ngo get it\nrun logo it\n\nMinor\n\nquoted  text",
+				Links: []string{"https://jivesearch.com"},
+			},
+		},
+		{
+			name: "front matter",
+			md: "---\ntitle: \"The Title\"\ndate: 2017-01-01\n---\n\n## Section\n\n```go\nfunc main() {}\n```\n" +
+				"See [the docs][docs] and <https://example.com/a>.\n\n[docs]: https://example.com/docs \"Docs\"\n",
+			want: Extracted{
+				Title: "The Title",
+				H2:    []string{"Section"},
+				Body:  "\n\nfunc main() {}\nSee the docs and https://example.com/a.\n",
+				Links: []string{"https://example.com/a", "https://example.com/docs"},
+			},
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := (&Markdown{}).Extract(strings.NewReader(c.md))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(got, c.want) {
+				t.Fatalf("got %#v; want %#v", got, c.want)
+			}
+		})
+	}
+}
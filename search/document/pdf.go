@@ -0,0 +1,101 @@
+package document
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/ledongthuc/pdf"
+)
+
+const (
+	maxPDFPages = 1000 // the page count of a pdf is whatever it says it is
+	maxPDFText  = 1024 * 1024
+)
+
+// PDF extracts the text of a PDF document: the text of its pages, the title,
+// author, etc from the document info and the URIs of its link annotations.
+type PDF struct{}
+
+// Extract extracts the title, text, etc. of a PDF
+func (p *PDF) Extract(r io.Reader) (x Extracted, err error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return x, err
+	}
+
+	// the pdf package panics on some malformed pdfs...that shouldn't take down the crawler
+	defer func() {
+		if e := recover(); e != nil {
+			x, err = Extracted{}, fmt.Errorf("unable to parse pdf: %v", e)
+		}
+	}()
+
+	rd, err := pdf.NewReader(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		return x, err
+	}
+
+	info := rd.Trailer().Key("Info")
+	x.Title = info.Key("Title").Text()
+	x.Author = info.Key("Author").Text()
+	x.Date = pdfDate(info.Key("CreationDate").Text())
+	x.Language = rd.Trailer().Key("Root").Key("Lang").Text()
+	x.Links = []string{}
+
+	var w strings.Builder
+	for i := 1; i <= rd.NumPage() && i <= maxPDFPages && w.Len() < maxPDFText; i++ {
+		pg := rd.Page(i)
+		if pg.V.IsNull() {
+			break
+		}
+
+		// a page we can't read doesn't spoil the others
+		if s, err := pg.GetPlainText(nil); err == nil {
+			w.WriteString(s)
+			w.WriteString("\n")
+		}
+
+		annots := pg.V.Key("Annots")
+		for j := 0; j < annots.Len(); j++ {
+			if u := annots.Index(j).Key("A").Key("URI").RawString(); u != "" {
+				x.Links = append(x.Links, u)
+			}
+		}
+	}
+
+	x.Body = w.String()
+
+	if strings.TrimSpace(x.Title) == "" { // most pdfs don't have one
+		for _, line := range strings.Split(x.Body, "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				x.Title = line
+				break
+			}
+		}
+	}
+
+	return x, nil
+}
+
+// pdfDate parses a pdf date (D:YYYYMMDDHHmmSSOHH'mm') to RFC 3339
+func pdfDate(s string) string {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "D:")
+	s = strings.Replace(s, "'", "", -1)
+	if i := strings.IndexByte(s, 'Z'); i > -1 {
+		s = s[:i+1]
+	}
+
+	for _, f := range []string{
+		"20060102150405Z0700", "20060102150405", "200601021504", "2006010215", "20060102", "200601", "2006",
+	} {
+		if t, err := time.Parse(f, s); err == nil {
+			return t.UTC().Format(time.RFC3339)
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,238 @@
+package document
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+// testPDF builds a pdf from its objects (numbered from 1) with an xref table & trailer
+func testPDF(trailer string, objects ...string) []byte {
+	var b bytes.Buffer
+	b.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+
+	offsets := []int{}
+	for i, o := range objects {
+		offsets = append(offsets, b.Len())
+		fmt.Fprintf(&b, "%d 0 obj\n%v\nendobj\n", i+1, o)
+	}
+
+	xref := b.Len()
+	fmt.Fprintf(&b, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, o := range offsets {
+		fmt.Fprintf(&b, "%010d 00000 n \n", o)
+	}
+
+	fmt.Fprintf(&b, "trailer\n<< %v /Size %d >>\nstartxref\n%d\n%%%%EOF\n", trailer, len(objects)+1, xref)
+	return b.Bytes()
+}
+
+func testStream(dict string, data []byte, compress bool) string {
+	if compress {
+		var z bytes.Buffer
+		w := zlib.NewWriter(&z)
+		w.Write(data)
+		w.Close()
+		data = z.Bytes()
+		dict += " /Filter /FlateDecode"
+	}
+	return fmt.Sprintf("<< %v /Length %d >>\nstream\n%s\nendstream", dict, len(data), data)
+}
+
+func TestPDF(t *testing.T) {
+	cmap := []byte(`/CIDInit /ProcSet findresource begin
+12 dict begin
+begincmap
+1 begincodespacerange
+<0000> <FFFF>
+endcodespacerange
+2 beginbfchar
+<0001> <0048>
+<0002> <00E9>
+endbfchar
+1 beginbfrange
+<0003> <0005> <006C>
+endbfrange
+endcmap
+CMapName currentdict /CMap defineresource pop
+end
+end`)
+
+	for _, c := range []struct {
+		name string
+		pdf  []byte
+		want Extracted
+		err  bool
+	}{
+		{
+			name: "simple",
+			pdf: testPDF("/Root 1 0 R /Info 6 0 R",
+				"<< /Type /Catalog /Pages 2 0 R /Lang (en-US) >>",
+				"<< /Type /Pages /Kids [3 0 R] /Count 1 /Resources << /Font << /F1 5 0 R >> >> >>",
+				"<< /Type /Page /Parent 2 0 R /Contents 4 0 R /Annots [<< /Subtype /Link /A << /S /URI /URI (https://www.example.com/docs) >> >>] >>",
+				testStream("", []byte(`BT /F1 12 Tf 72 712 Td (Hello World) Tj T* [(Jive)( Search)] TJ ET`), false),
+				"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica >>",
+				"<< /Title (A Simple PDF) /Author (Jane Doe) /CreationDate (D:20170612153000+02'00') >>",
+			),
+			want: Extracted{
+				Title:    "A Simple PDF",
+				Author:   "Jane Doe",
+				Date:     "2017-06-12T13:30:00Z",
+				Language: "en-US",
+				Body:     "\nHello World\nJive Search\n",
+				Links:    []string{"https://www.example.com/docs"},
+			},
+		},
+		{
+			name: "compressed with a cmap",
+			pdf: testPDF("/Root 1 0 R /Info 7 0 R",
+				"<< /Type /Catalog /Pages 2 0 R >>",
+				"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+				"<< /Type /Page /Parent 2 0 R /Contents 4 0 R /Resources << /Font << /C0 5 0 R >> >> >>",
+				testStream("", []byte(`BT /C0 10 Tf <00010002000300040005> Tj ET`), true),
+				"<< /Type /Font /Subtype /Type0 /BaseFont /Foo /Encoding /Identity-H /ToUnicode 6 0 R >>",
+				testStream("", cmap, true),
+				"<< /Title <FEFF0043004D00610070> >>",
+			),
+			want: Extracted{
+				Title: "CMap",
+				Body:  "\nHélmn\n", // the range increments its last character
+				Links: []string{},
+			},
+		},
+		{
+			name: "no title",
+			pdf: testPDF("/Root 1 0 R",
+				"<< /Type /Catalog /Pages 2 0 R >>",
+				"<< /Type /Pages /Kids [3 0 R 5 0 R] /Count 2 >>",
+				"<< /Type /Page /Parent 2 0 R /Contents 4 0 R >>",
+				testStream("", []byte("BT (First line) Tj T* (Second line) Tj ET"), true),
+				"<< /Type /Page /Parent 2 0 R /Contents 6 0 R >>",
+				testStream("", []byte("BT (Next page) Tj ET"), true),
+			),
+			want: Extracted{
+				Title: "First line",
+				Body:  "\nFirst line\nSecond line\n\nNext page\n",
+				Links: []string{},
+			},
+		},
+		{
+			name: "more pages than it has",
+			pdf: testPDF("/Root 1 0 R",
+				"<< /Type /Catalog /Pages 2 0 R >>",
+				"<< /Type /Pages /Kids [3 0 R] /Count 1000000000 >>",
+				"<< /Type /Page /Parent 2 0 R /Contents 4 0 R >>",
+				testStream("", []byte("BT (Only page) Tj ET"), false),
+			),
+			want: Extracted{
+				Title: "Only page",
+				Body:  "\nOnly page\n",
+				Links: []string{},
+			},
+		},
+		{
+			name: "encrypted",
+			pdf: testPDF("/Root 1 0 R /Encrypt 2 0 R /ID [<00> <00>]",
+				"<< /Type /Catalog >>",
+				"<< /Filter /Standard /V 2 /R 3 /Length 128 /O <00> /U <00> /P -4 >>",
+			),
+			err: true,
+		},
+		{
+			name: "not a pdf",
+			pdf:  []byte("hello world"),
+			err:  true,
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := (&PDF{}).Extract(bytes.NewReader(c.pdf))
+			if (err != nil) != c.err {
+				t.Fatalf("got err %v; want err %v", err, c.err)
+			}
+
+			if err != nil {
+				return
+			}
+
+			if !reflect.DeepEqual(got, c.want) {
+				t.Fatalf("got %+v; want %+v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestPDFMalformed(t *testing.T) {
+	valid := testPDF("/Root 1 0 R /Info 6 0 R",
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /Contents [4 0 R 4 0 R] /Resources << /Font << /C0 7 0 R >> >> >>",
+		testStream("", []byte("BT /C0 10 Tf (Hello) Tj [(Wor)-300(ld)] TJ ET"), true),
+		testStream("/Type /ObjStm /N 1 /First 4", []byte("6 0 << /Title (Compressed Info) >>"), true),
+		"<< /Type /Font /Subtype /Type0 /ToUnicode 8 0 R >>",
+		testStream("", []byte("1 begincodespacerange <00> <FF> endcodespacerange 1 beginbfrange <00> <FF> <0041> endbfrange"), true),
+	)
+
+	pdfs := map[string][]byte{
+		"huge length":        []byte("%PDF-1.4\n1 0 obj\n<< /Length 1e20 >>\nstream\nabc\nendstream\nendobj\n"),
+		"infinite length":    []byte("%PDF-1.4\n1 0 obj\n<< /Length +Inf >>\nstream\nabc\nendstream\nendobj\n"),
+		"negative length":    []byte("%PDF-1.4\n1 0 obj\n<< /Length -3 >>\nstream\nabc\nendstream\nendobj\n"),
+		"huge object stream": testPDF("/Root 1 0 R", testStream("/Type /ObjStm /N 1e20 /First 1e20", []byte("6 1e20 << >>"), false)),
+		"huge ref":           testPDF("/Root 1e20 0 R /Info -1 1e20 R", "<< /Type /Catalog /Pages 1e300 0 R >>"),
+		"filter chain": testPDF("/Root 1 0 R",
+			"<< /Type /Catalog /Pages 2 0 R >>",
+			"<< /Type /Page /Contents 3 0 R >>",
+			testStream("/Filter [/FlateDecode /FlateDecode /FlateDecode /FlateDecode /FlateDecode /FlateDecode]", []byte("x"), false),
+		),
+		"garbage":   []byte("%PDF-1.4\n1 0 obj << /Type /Page /Contents [2 0 R ( >> stream\n\x00\xff\nendobj 2 0 obj <</Filter/AHx/Length 2>>stream\nzz"),
+		"empty":     {},
+		"no header": []byte("1 0 obj"),
+	}
+
+	// every truncation of a valid pdf
+	for i := 0; i < len(valid); i += 7 {
+		pdfs[fmt.Sprintf("truncated at %d", i)] = valid[:i]
+	}
+
+	// and some random corruption
+	rnd := rand.New(rand.NewSource(42))
+	for i := 0; i < 200; i++ {
+		b := append([]byte{}, valid...)
+		for j := 0; j < 1+rnd.Intn(8); j++ {
+			b[rnd.Intn(len(b))] = byte(rnd.Intn(256))
+		}
+		pdfs[fmt.Sprintf("corrupted %d", i)] = b
+	}
+
+	for name, pdf := range pdfs {
+		t.Run(name, func(t *testing.T) {
+			defer func() {
+				if r := recover(); r != nil {
+					t.Fatalf("%q panicked: %v", pdf, r)
+				}
+			}()
+
+			(&PDF{}).Extract(bytes.NewReader(pdf))
+		})
+	}
+}
+
+func TestPDFDate(t *testing.T) {
+	for _, c := range []struct {
+		date string
+		want string
+	}{
+		{"D:20170612153000+02'00'", "2017-06-12T13:30:00Z"},
+		{"D:20170612153000Z00'00'", "2017-06-12T15:30:00Z"},
+		{"D:20170612", "2017-06-12T00:00:00Z"},
+		{"garbage", ""},
+	} {
+		t.Run(c.date, func(t *testing.T) {
+			if got := pdfDate(c.date); got != c.want {
+				t.Fatalf("got %q; want %q", got, c.want)
+			}
+		})
+	}
+}
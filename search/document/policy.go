@@ -0,0 +1,215 @@
+package document
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// robots holds the robots directives of a page from its X-Robots-Tag headers and robots meta tags.
+// Directives for all bots and those for our bot are kept apart as a directive
+// for our bot overrides the general one, e.g. "noindex" for everyone and "jivesearchbot: index" means we can index.
+// Within a scope we follow the most restrictive of competing directives.
+// https://developers.google.com/search/reference/robots_meta_tag
+type robots struct {
+	all directives
+	bot directives
+}
+
+// directives of a scope. A zero value was not set.
+type directives struct {
+	index            restriction
+	follow           restriction
+	archive          restriction
+	snippet          restriction
+	imageIndex       restriction
+	maxSnippet       *int
+	unavailableAfter time.Time
+}
+
+type restriction int
+
+const (
+	unset restriction = iota
+	allowed
+	denied
+)
+
+// restrict sets a restriction unless a more restrictive one was already set
+func (r *restriction) restrict(to restriction) {
+	if *r != denied {
+		*r = to
+	}
+}
+
+// override returns the bot's restriction if it set one
+func (r restriction) override(bot restriction) restriction {
+	if bot != unset {
+		return bot
+	}
+	return r
+}
+
+// names of the directives we know and if they take a value ("unavailable_after: ...")
+// so we can tell them apart from a user-agent ("googlebot: noindex").
+var names = map[string]bool{
+	"none": false, "all": false, "index": false, "noindex": false, "follow": false, "nofollow": false,
+	"noarchive": false, "nocache": false, "nosnippet": false, "noimageindex": false, "notranslate": false,
+	"unavailable_after": true, "max-snippet": true, "max-image-preview": true, "max-video-preview": true,
+}
+
+// scope returns the directives a header value applies to. A value for
+// another bot returns nil and its directives are to be ignored.
+func (r *robots) scope(bot, val string) (*directives, string) {
+	if i := strings.Index(val, ":"); i > -1 {
+		ua := strings.ToLower(strings.TrimSpace(val[:i]))
+		if !names[ua] && !strings.ContainsAny(ua, ", ") {
+			if bot == "" || ua != strings.ToLower(bot) {
+				return nil, ""
+			}
+			return &r.bot, val[i+1:]
+		}
+	}
+
+	return &r.all, val
+}
+
+// set parses a comma-separated list of directives
+func (d *directives) set(val string) {
+	var after []string // unavailable_after dates may have a comma in them (e.g. RFC 850)
+
+	for _, p := range strings.Split(val, ",") {
+		name, value := p, ""
+		if i := strings.Index(p, ":"); i > -1 {
+			name, value = p[:i], strings.TrimSpace(p[i+1:])
+		}
+		name = strings.ToLower(strings.TrimSpace(name))
+
+		if _, ok := names[name]; !ok && after != nil {
+			after = append(after, strings.TrimSpace(p))
+			continue
+		}
+
+		if after != nil {
+			d.setUnavailableAfter(strings.Join(after, ", "))
+			after = nil
+		}
+
+		switch name {
+		case "none":
+			d.index.restrict(denied)
+			d.follow.restrict(denied)
+		case "all":
+			d.index.restrict(allowed)
+			d.follow.restrict(allowed)
+		case "index":
+			d.index.restrict(allowed)
+		case "noindex":
+			d.index.restrict(denied)
+		case "follow":
+			d.follow.restrict(allowed)
+		case "nofollow":
+			d.follow.restrict(denied)
+		case "noarchive", "nocache":
+			d.archive.restrict(denied)
+		case "nosnippet":
+			d.snippet.restrict(denied)
+		case "noimageindex":
+			d.imageIndex.restrict(denied)
+		case "max-snippet":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < -1 {
+				break
+			}
+			// -1 is no limit so any other value is more restrictive
+			if d.maxSnippet == nil || *d.maxSnippet == -1 || (n > -1 && n < *d.maxSnippet) {
+				d.maxSnippet = &n
+			}
+		case "unavailable_after":
+			after = []string{value}
+		}
+	}
+
+	if after != nil {
+		d.setUnavailableAfter(strings.Join(after, ", "))
+	}
+}
+
+func (d *directives) setUnavailableAfter(s string) {
+	t := parseUnavailableAfter(s)
+	if t.IsZero() {
+		return
+	}
+
+	if d.unavailableAfter.IsZero() || t.Before(d.unavailableAfter) {
+		d.unavailableAfter = t
+	}
+}
+
+// parseUnavailableAfter parses the date of an unavailable_after directive.
+// It is meant to be in RFC 822, RFC 850 or ISO 8601 format but we find others too.
+func parseUnavailableAfter(s string) time.Time {
+	s = strings.TrimSpace(s)
+
+	for _, f := range []string{
+		time.RFC3339, "2006-01-02T15:04:05", "2006-01-02", time.RFC850, time.RFC822, time.RFC822Z,
+		time.RFC1123, time.RFC1123Z, "2 Jan 2006 15:04:05 MST", "02-Jan-2006 15:04:05 MST",
+		"Monday, 02-Jan-2006 15:04:05 MST", "Monday, 2 January 2006 15:04:05 MST",
+	} {
+		if t, err := time.Parse(f, s); err == nil {
+			return t.UTC()
+		}
+	}
+
+	return time.Time{}
+}
+
+// policy combines the scopes into a Policy. A directive for our bot overrides the general one.
+func (r robots) policy() Policy {
+	p := Policy{
+		Index:        r.all.index.override(r.bot.index) != denied,
+		follow:       r.all.follow.override(r.bot.follow) != denied,
+		NoArchive:    r.all.archive.override(r.bot.archive) == denied,
+		NoSnippet:    r.all.snippet.override(r.bot.snippet) == denied,
+		NoImageIndex: r.all.imageIndex.override(r.bot.imageIndex) == denied,
+	}
+
+	max := r.all.maxSnippet
+	if r.bot.maxSnippet != nil {
+		max = r.bot.maxSnippet
+	}
+
+	if max != nil {
+		switch {
+		case *max == 0:
+			p.NoSnippet = true
+		case *max > 0:
+			p.MaxSnippet = *max
+		}
+	}
+
+	after := r.all.unavailableAfter
+	if !r.bot.unavailableAfter.IsZero() {
+		after = r.bot.unavailableAfter
+	}
+
+	if !after.IsZero() {
+		p.UnavailableAfter = after.Format(time.RFC3339)
+	}
+
+	return p
+}
+
+// Available tells us if the page may still be shown at time t
+func (p Policy) Available(t time.Time) bool {
+	if p.UnavailableAfter == "" {
+		return true
+	}
+
+	after, err := time.Parse(time.RFC3339, p.UnavailableAfter)
+	if err != nil {
+		return true
+	}
+
+	return !t.After(after)
+}
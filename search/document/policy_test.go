@@ -0,0 +1,68 @@
+package document
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPolicyFromMeta(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		html string
+		want Policy
+	}{
+		{"robots", `<meta name="robots" content="noindex, noarchive">`, Policy{Index: false, follow: true, NoArchive: true}},
+		{"our bot", `<meta name="robots" content="noindex"><meta name="JiveSearchBot" content="index, max-snippet:30">`, Policy{Index: true, follow: true, MaxSnippet: 30}},
+		{"other bot", `<meta name="googlebot" content="noindex, nofollow">`, Policy{Index: true, follow: true}},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			d, err := New("http://www.example.com")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			d.SetPolicyFromHeader("jivesearchbot")
+
+			if err := d.SetTokenizer(strings.NewReader("<html><head>" + c.html + "</head><body></body></html>")); err != nil {
+				t.Fatal(err)
+			}
+
+			ch := make(chan string)
+			go func() {
+				for range ch {
+				}
+			}()
+
+			if err := d.SetContent("jivesearchbot", -1, ch, -1, -1, -1, -1); err != nil {
+				t.Fatal(err)
+			}
+			close(ch)
+
+			if d.Policy != c.want {
+				t.Fatalf("got %+v; want: %+v", d.Policy, c.want)
+			}
+		})
+	}
+}
+
+func TestAvailable(t *testing.T) {
+	for _, c := range []struct {
+		name  string
+		after string
+		want  bool
+	}{
+		{"no date", "", true},
+		{"before", "2017-09-02T00:00:00Z", true},
+		{"after", "2017-08-31T00:00:00Z", false},
+		{"invalid", "someday", true},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			now := time.Date(2017, 9, 1, 15, 4, 5, 0, time.UTC)
+			got := Policy{Index: true, UnavailableAfter: c.after}.Available(now)
+			if got != c.want {
+				t.Fatalf("got %v; want: %v", got, c.want)
+			}
+		})
+	}
+}
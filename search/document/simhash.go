@@ -0,0 +1,104 @@
+package document
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+const (
+	minSimHashWords = 50 // too little text to tell a near-duplicate from a coincidence (e.g. pages from the same template)
+	shingleSize     = 3  // words in each of our features
+
+	// MaxSimHashDistance is the most bits two SimHashes can differ by and still be near-duplicates
+	MaxSimHashDistance = 3
+	simHashBands       = MaxSimHashDistance + 1 // so near-duplicates share at least one band
+)
+
+// simHasher computes a SimHash of a page's text as it is tokenized.
+// Unlike a regular hash, similar text produces a similar hash
+// (a small Hamming distance) so we can find printer versions,
+// session-id variants and mirrors of the same page.
+// The features are the overlapping shingles (runs of shingleSize words) of the text.
+// Unlike single words they keep the word order so two pages built from the same
+// template (and so with much the same vocabulary) don't look alike. A single edit
+// only changes shingleSize of the features.
+// http://www.wwwconference.org/www2007/papers/paper215.pdf
+type simHasher struct {
+	v       [64]int
+	words   int
+	shingle [shingleSize]string // the last words we saw
+}
+
+// add a word to the text
+func (s *simHasher) add(word string) {
+	copy(s.shingle[:], s.shingle[1:])
+	s.shingle[shingleSize-1] = strings.ToLower(word)
+	s.words++
+
+	if s.words < shingleSize {
+		return
+	}
+
+	h := fnv.New64a()
+	h.Write([]byte(strings.Join(s.shingle[:], " ")))
+	sum := h.Sum64()
+
+	for i := range s.v {
+		if sum&(1<<uint(i)) != 0 {
+			s.v[i]++
+		} else {
+			s.v[i]--
+		}
+	}
+}
+
+// sum returns the SimHash as a hex string or "" if there wasn't enough text
+func (s *simHasher) sum() string {
+	if s.words < minSimHashWords {
+		return ""
+	}
+
+	var h uint64
+	for i, n := range s.v {
+		if n > 0 {
+			h |= 1 << uint(i)
+		}
+	}
+
+	return fmt.Sprintf("%016x", h)
+}
+
+// SimHashBands splits a SimHash into bands. Two hashes within MaxSimHashDistance
+// bits of each other have at least one band in common so we can look up candidates
+// with an exact match before we check the distance.
+func SimHashBands(h string) []string {
+	if len(h) != 16 {
+		return nil
+	}
+
+	n := len(h) / simHashBands
+	bands := []string{}
+	for i := 0; i < simHashBands; i++ {
+		bands = append(bands, strconv.Itoa(i)+":"+h[i*n:(i+1)*n])
+	}
+
+	return bands
+}
+
+// NearDuplicate tells us if two SimHashes are within MaxSimHashDistance bits of each other
+func NearDuplicate(a, b string) bool {
+	x, err := strconv.ParseUint(a, 16, 64)
+	if err != nil {
+		return false
+	}
+
+	y, err := strconv.ParseUint(b, 16, 64)
+	if err != nil {
+		return false
+	}
+
+	return bits.OnesCount64(x^y) <= MaxSimHashDistance
+}
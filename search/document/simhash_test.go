@@ -0,0 +1,113 @@
+package document
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSimHash(t *testing.T) {
+	text := `The quick brown fox jumps over the lazy dog while the farmer
+		watches from the porch and wonders why the dog never bothers to chase it
+		around the yard on a warm summer afternoon in the countryside. Later that
+		evening the farmer walks down to the river to check on the old mill, which
+		has been grinding grain for the village since his grandfather built it more
+		than a hundred years ago. The water is low this year because the spring rains
+		never came, and the wheel turns slowly, creaking with every rotation as the
+		miller's apprentice sweeps flour from the wooden floor. Back at the house his
+		wife is preparing supper for the children who have spent the day picking apples
+		in the orchard behind the barn, their baskets piled high with the first fruit
+		of the season. After supper the family gathers on the porch to watch the sun
+		set behind the hills while the fox, now hungry, creeps toward the chicken coop.
+		The next morning begins before dawn with the rooster crowing from the fence post
+		and the smell of fresh bread drifting out of the kitchen window. The farmer pulls
+		on his boots, feeds the horses and counts the chickens twice, relieved to find that
+		none are missing despite the feathers scattered near the gate. His eldest daughter
+		hitches the cart for the weekly trip to the market in town, where she will sell
+		eggs, butter and the apples the younger children gathered the day before. The road
+		winds past fields of wheat that ripple like water in the breeze and past the stone
+		church whose bell has rung every Sunday for as long as anyone can remember. At the
+		market the stalls are crowded with neighbors trading news about the harvest, the
+		weather and the new schoolteacher who arrived from the city with a trunk full of
+		books. By noon the cart is empty and the daughter buys thread, salt and a small
+		bag of peppermints for her brothers before starting the long ride home. When she
+		returns the sky has turned gray and the first drops of the rain everyone has been
+		waiting for begin to fall on the dusty yard, sending the chickens running for the
+		shelter of the barn while the farmer stands in the doorway and smiles at the clouds.
+		The storm lasts through the night, drumming on the roof and filling the rain barrels,
+		and by morning the river is running high enough to turn the mill wheel at full speed.`
+
+	simhash := func(s string) string {
+		sh := &simHasher{}
+		for _, w := range strings.Fields(s) {
+			sh.add(w)
+		}
+		return sh.sum()
+	}
+
+	original := simhash(text)
+
+	for _, c := range []struct {
+		name string
+		text string
+		want bool
+	}{
+		{"identical", text, true},
+		{"case", strings.ToUpper(text), true},
+		{"small edit", strings.Replace(text, "countryside", "country", 1), true},
+		{"same words in another order", reverse(text), false},
+		{"different", "Lorem ipsum dolor sit amet consectetur adipiscing elit sed do eiusmod tempor incididunt ut labore et dolore magna aliqua", false},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			if got := NearDuplicate(original, simhash(c.text)); got != c.want {
+				t.Fatalf("got %v; want %v", got, c.want)
+			}
+		})
+	}
+
+	if got := simhash("too little text"); got != "" {
+		t.Fatalf("got %q; want empty simhash", got)
+	}
+}
+
+func reverse(s string) string {
+	words := strings.Fields(s)
+	for i, j := 0, len(words)-1; i < j; i, j = i+1, j-1 {
+		words[i], words[j] = words[j], words[i]
+	}
+	return strings.Join(words, " ")
+}
+
+func TestSimHashBands(t *testing.T) {
+	for _, c := range []struct {
+		simhash string
+		want    []string
+	}{
+		{"", nil},
+		{"0123456789abcdef", []string{"0:0123", "1:4567", "2:89ab", "3:cdef"}},
+	} {
+		t.Run(c.simhash, func(t *testing.T) {
+			if got := SimHashBands(c.simhash); !reflect.DeepEqual(got, c.want) {
+				t.Fatalf("got %v; want %v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestNearDuplicate(t *testing.T) {
+	for _, c := range []struct {
+		a, b string
+		want bool
+	}{
+		{"00000000000000ff", "00000000000000ff", true},
+		{"00000000000000ff", "00000000000000f8", true},  // 3 bits
+		{"00000000000000ff", "00000000000000f0", false}, // 4 bits
+		{"00000000000000ff", "", false},
+	} {
+		t.Run(c.a+" "+c.b, func(t *testing.T) {
+			if got := NearDuplicate(c.a, c.b); got != c.want {
+				t.Fatalf("got %v; want %v", got, c.want)
+			}
+		})
+	}
+}
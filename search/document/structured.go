@@ -0,0 +1,286 @@
+package document
+
+import (
+	"encoding/json"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+// structured is the metadata a page describes itself with. We collect it from
+// schema.org JSON-LD, schema.org microdata and OpenGraph/Twitter card meta tags.
+// https://developers.google.com/search/docs/guides/intro-structured-data
+// http://ogp.me/
+type structured struct {
+	jsonLD    metadata
+	microdata metadata
+	meta      metadata
+	time      string // the first <time datetime="..."> on the page
+
+	authorScope bool // inside an itemprop="author" itemscope (its name is in a nested itemprop)
+	itemProp    string
+	script      bool // inside a <script type="application/ld+json">
+	buf         strings.Builder
+}
+
+type metadata struct {
+	published string
+	modified  string
+	author    string
+	image     string
+	typ       string
+	siteName  string
+}
+
+// merge fills in the blanks of m with n
+func (m *metadata) merge(n metadata) {
+	for _, f := range []struct {
+		dst *string
+		src string
+	}{
+		{&m.published, n.published}, {&m.modified, n.modified}, {&m.author, n.author},
+		{&m.image, n.image}, {&m.typ, n.typ}, {&m.siteName, n.siteName},
+	} {
+		if *f.dst == "" {
+			*f.dst = strings.TrimSpace(f.src)
+		}
+	}
+}
+
+// start handles a start tag
+func (s *structured) start(t html.Token, selfClosing bool) {
+	switch t.Data {
+	case "script":
+		typ, _ := getAttribute(t, "type")
+		s.script = strings.EqualFold(strings.TrimSpace(typ), "application/ld+json") && !selfClosing
+		s.buf.Reset()
+	case "meta":
+		s.setMeta(t)
+	case "time":
+		if dt, ok := getAttribute(t, "datetime"); ok && s.time == "" {
+			s.time = dt
+		}
+	}
+
+	s.setMicrodata(t, selfClosing)
+}
+
+// end handles an end tag
+func (s *structured) end(t html.Token) {
+	if t.Data == "script" && s.script {
+		s.script = false
+		s.jsonLD.merge(parseJSONLD(s.buf.String()))
+	}
+}
+
+// text handles a text token
+func (s *structured) text(txt string) {
+	if s.script {
+		s.buf.WriteString(txt)
+		return
+	}
+
+	if s.itemProp != "" && strings.TrimSpace(txt) != "" {
+		s.setItemProp(s.itemProp, txt)
+		s.itemProp = ""
+	}
+}
+
+// setMeta handles the OpenGraph, Twitter card and a few common meta tags
+func (s *structured) setMeta(t html.Token) {
+	name, ok := getAttribute(t, "property")
+	if !ok {
+		name, _ = getAttribute(t, "name")
+	}
+
+	content, _ := getAttribute(t, "content")
+
+	m := metadata{}
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "article:published_time", "og:published_time", "date", "created", "dc.date", "dcterms.created":
+		m.published = content
+	case "article:modified_time", "og:updated_time", "dcterms.modified":
+		m.modified = content
+	case "author", "article:author", "twitter:creator":
+		if !strings.HasPrefix(content, "http") { // article:author is often a profile url
+			m.author = content
+		}
+	case "og:image", "og:image:url", "og:image:secure_url", "twitter:image", "twitter:image:src":
+		m.image = content
+	case "og:type":
+		m.typ = content
+	case "og:site_name", "application-name":
+		m.siteName = content
+	}
+
+	s.meta.merge(m)
+}
+
+// setMicrodata handles the itemscope, itemtype and itemprop attributes.
+// Only the first itemscope's type is used (usually the outermost).
+// https://html.spec.whatwg.org/multipage/microdata.html
+func (s *structured) setMicrodata(t html.Token, selfClosing bool) {
+	_, scope := getAttribute(t, "itemscope")
+	prop, _ := getAttribute(t, "itemprop")
+	prop = strings.TrimSpace(prop)
+
+	if scope {
+		if typ, ok := getAttribute(t, "itemtype"); ok {
+			s.microdata.merge(metadata{typ: typ[strings.LastIndex(typ, "/")+1:]})
+		}
+		s.authorScope = s.authorScope || prop == "author"
+	}
+
+	if prop == "" || (scope && prop == "author") {
+		return
+	}
+
+	// the value is in an attribute or else is the element's text
+	for _, a := range []string{"content", "datetime", "src", "href"} {
+		if v, ok := getAttribute(t, a); ok {
+			s.setItemProp(prop, v)
+			return
+		}
+	}
+
+	if !selfClosing {
+		s.itemProp = prop
+	}
+}
+
+func (s *structured) setItemProp(prop, val string) {
+	m := metadata{}
+	switch prop {
+	case "datePublished", "dateCreated":
+		m.published = val
+	case "dateModified":
+		m.modified = val
+	case "author":
+		m.author = val
+	case "name":
+		if s.authorScope {
+			m.author = val
+			s.authorScope = false
+		}
+	case "image", "thumbnailUrl":
+		m.image = val
+	}
+
+	s.microdata.merge(m)
+}
+
+// parseJSONLD parses a JSON-LD script. It may hold a single item, an array
+// of items or a @graph of items. We use the first item that has the
+// fields we want (e.g. an Article) and the WebSite for the site's name.
+// https://json-ld.org/
+func parseJSONLD(s string) metadata {
+	m := metadata{}
+
+	var v interface{}
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		return m
+	}
+
+	items := []map[string]interface{}{}
+
+	var collect func(v interface{})
+	collect = func(v interface{}) {
+		switch t := v.(type) {
+		case []interface{}:
+			for _, i := range t {
+				collect(i)
+			}
+		case map[string]interface{}:
+			if g, ok := t["@graph"]; ok {
+				collect(g)
+				return
+			}
+			items = append(items, t)
+		}
+	}
+
+	collect(v)
+
+	for _, item := range items {
+		typ := jsonLDString(item["@type"], "")
+		if typ == "WebSite" {
+			m.merge(metadata{siteName: jsonLDString(item["name"], "name")})
+			continue
+		}
+
+		m.merge(metadata{
+			published: jsonLDString(item["datePublished"], ""),
+			modified:  jsonLDString(item["dateModified"], ""),
+			author:    jsonLDString(item["author"], "name"),
+			image:     jsonLDString(item["image"], "url"),
+			typ:       typ,
+			siteName:  jsonLDString(item["publisher"], "name"),
+		})
+	}
+
+	return m
+}
+
+// jsonLDString returns a value as a string. A value can be a string, an object
+// (e.g. a Person) from which we take key, or an array of them (we take the first).
+func jsonLDString(v interface{}, key string) string {
+	switch t := v.(type) {
+	case string:
+		return t
+	case []interface{}:
+		if len(t) > 0 {
+			return jsonLDString(t[0], key)
+		}
+	case map[string]interface{}:
+		if key != "" {
+			return jsonLDString(t[key], "")
+		}
+	}
+	return ""
+}
+
+// set fills in the doc's Content. JSON-LD is preferred over microdata and
+// microdata over meta tags since they tend to be the most specific.
+func (s *structured) set(d *Document, truncate int) {
+	m := metadata{}
+	m.merge(s.jsonLD)
+	m.merge(s.microdata)
+	m.merge(s.meta)
+	m.merge(metadata{published: s.time})
+
+	d.Date = parseDate(m.published)
+	d.Modified = parseDate(m.modified)
+	d.Author = d.extractText(m.author, truncate)
+	d.Type = d.extractText(m.typ, truncate)
+	d.SiteName = d.extractText(m.siteName, truncate)
+
+	if u, err := url.Parse(strings.TrimSpace(m.image)); err == nil && m.image != "" && d.URL != nil {
+		u = d.URL.ResolveReference(u)
+		if u.Scheme == "http" || u.Scheme == "https" {
+			d.Image = u.String()
+		}
+	}
+}
+
+// parseDate normalizes the many date formats we find to RFC 3339.
+// A date we can't parse is dropped rather than have the doc rejected by our backend.
+func parseDate(s string) string {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return ""
+	}
+
+	for _, f := range []string{
+		time.RFC3339Nano, time.RFC3339, "2006-01-02T15:04:05Z0700", "2006-01-02T15:04:05",
+		"2006-01-02T15:04Z07:00", "2006-01-02T15:04", "2006-01-02 15:04:05", "2006-01-02",
+		time.RFC1123, time.RFC1123Z, "January 2, 2006", "Jan 2, 2006",
+	} {
+		if t, err := time.Parse(f, s); err == nil {
+			return t.UTC().Format(time.RFC3339)
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,108 @@
+package document
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestStructured(t *testing.T) {
+	type want struct {
+		date, modified, author, image, typ, siteName string
+	}
+
+	for _, c := range []struct {
+		name string
+		html string
+		want
+	}{
+		{
+			name: "json-ld",
+			html: `<html><head>
+				<script type="application/ld+json">
+				{
+					"@context": "http://schema.org",
+					"@graph": [
+						{"@type": "WebSite", "name": "Example News"},
+						{
+							"@type": "NewsArticle",
+							"datePublished": "2017-01-27T14:16:23+01:00",
+							"dateModified": "2017-01-28",
+							"author": [{"@type": "Person", "name": "Jane Doe"}],
+							"image": {"@type": "ImageObject", "url": "/images/lead.jpg"}
+						}
+					]
+				}
+				</script>
+				<meta property="og:type" content="article">
+				</head></html>`,
+			want: want{
+				"2017-01-27T13:16:23Z", "2017-01-28T00:00:00Z", "Jane Doe",
+				"http://www.example.com/images/lead.jpg", "NewsArticle", "Example News",
+			},
+		},
+		{
+			name: "microdata",
+			html: `<html><body>
+				<div itemscope itemtype="http://schema.org/Recipe">
+					<h1 itemprop="name">Grandma's Pie</h1>
+					<span itemprop="author" itemscope itemtype="http://schema.org/Person">
+						By <span itemprop="name">John Smith</span>
+					</span>
+					<meta itemprop="datePublished" content="2009-05-08">
+					<img itemprop="image" src="https://cdn.example.com/pie.jpg">
+				</div>
+				</body></html>`,
+			want: want{
+				"2009-05-08T00:00:00Z", "", "John Smith", "https://cdn.example.com/pie.jpg", "Recipe", "",
+			},
+		},
+		{
+			name: "opengraph",
+			html: `<html><head>
+				<meta property="og:type" content="article">
+				<meta property="og:site_name" content="Example Blog">
+				<meta property="og:image" content="http://www.example.com/og.png">
+				<meta property="article:published_time" content="2017-09-01T15:04:05Z">
+				<meta property="article:author" content="https://www.facebook.com/someone">
+				<meta name="twitter:creator" content="@someone">
+				</head></html>`,
+			want: want{
+				"2017-09-01T15:04:05Z", "", "@someone", "http://www.example.com/og.png", "article", "Example Blog",
+			},
+		},
+		{
+			name: "time",
+			html: `<html><body><time datetime="2017-01-27T14:16:23+00:00">Jan 27, 2017</time></body></html>`,
+			want: want{date: "2017-01-27T14:16:23Z"},
+		},
+		{
+			name: "invalid",
+			html: `<html><head>
+				<script type="application/ld+json">{not json</script>
+				<meta name="date" content="sometime last week">
+				<meta property="og:image" content="javascript:alert(1)">
+				</head></html>`,
+			want: want{},
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			d, err := New("http://www.example.com/article")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := d.SetTokenizer(strings.NewReader(c.html)); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := d.SetContent("", 0, make(chan string), 100, 25, 250, 1000); err != nil {
+				t.Fatal(err)
+			}
+
+			got := want{d.Date, d.Modified, d.Author, d.Image, d.Type, d.SiteName}
+			if got != c.want {
+				t.Fatalf("got %+v; want %+v", got, c.want)
+			}
+		})
+	}
+}
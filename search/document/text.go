@@ -0,0 +1,212 @@
+package document
+
+import (
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+const maxHeadings = 20 // per level
+
+// boilerplate are elements that are never part of a page's main content
+var boilerplate = map[atom.Atom]bool{
+	atom.Head: true, atom.Nav: true, atom.Footer: true, atom.Aside: true,
+	atom.Form: true, atom.Script: true, atom.Style: true, atom.Noscript: true,
+	atom.Template: true, atom.Svg: true, atom.Iframe: true, atom.Select: true,
+	atom.Button: true, atom.Menu: true,
+}
+
+// blocks start a new run of text
+var blocks = map[atom.Atom]bool{
+	atom.Address: true, atom.Article: true, atom.Aside: true, atom.Blockquote: true,
+	atom.Br: true, atom.Dd: true, atom.Div: true, atom.Dl: true, atom.Dt: true,
+	atom.Fieldset: true, atom.Figcaption: true, atom.Figure: true, atom.Footer: true,
+	atom.Form: true, atom.H1: true, atom.H2: true, atom.H3: true, atom.H4: true,
+	atom.H5: true, atom.H6: true, atom.Header: true, atom.Hr: true, atom.Li: true,
+	atom.Main: true, atom.Nav: true, atom.Ol: true, atom.P: true, atom.Pre: true,
+	atom.Section: true, atom.Table: true, atom.Td: true, atom.Th: true,
+	atom.Tr: true, atom.Ul: true,
+}
+
+// void elements have no end tag so are never opened
+var void = map[atom.Atom]bool{
+	atom.Area: true, atom.Base: true, atom.Br: true, atom.Col: true, atom.Embed: true,
+	atom.Hr: true, atom.Img: true, atom.Input: true, atom.Link: true, atom.Meta: true,
+	atom.Param: true, atom.Source: true, atom.Track: true, atom.Wbr: true,
+}
+
+// the class or id of a navigation bar, share buttons, etc...unless they also look like content
+var (
+	boilerplateClass = regexp.MustCompile(`(?i)(^|[\s_-])(nav|navbar|menu|footer|sidebar|breadcrumbs?|comments?|share|social|related|banner|advert|ads?|cookies?|popup|promo|subscribe|masthead)($|[\s_-])`)
+	contentClass     = regexp.MustCompile(`(?i)(article|content|main|post|entry|story|body)`)
+)
+
+// extractor is a readability-style extractor of the main content of a page.
+// As the page is tokenized it drops boilerplate (nav, footer, scripts, etc),
+// breaks the text into blocks and throws out blocks that are mostly links.
+// If the page marks its main content with <article> or <main> we keep only that.
+// Headings (h1-h3) are kept separately so they can be weighted.
+// https://github.com/mozilla/readability
+type extractor struct {
+	open     []element
+	block    textBlock
+	blocks   []textBlock
+	headings map[atom.Atom][]string
+}
+
+type element struct {
+	a           atom.Atom
+	name        string
+	boilerplate bool
+	main        bool // <article> or <main>
+}
+
+type textBlock struct {
+	words     []string
+	linkWords int
+	heading   atom.Atom
+	main      bool
+}
+
+func newExtractor() *extractor {
+	return &extractor{
+		headings: map[atom.Atom][]string{},
+	}
+}
+
+// current returns the innermost open element
+func (e *extractor) current() element {
+	if len(e.open) == 0 {
+		return element{}
+	}
+	return e.open[len(e.open)-1]
+}
+
+func (e *extractor) start(t html.Token, selfClosing bool) {
+	if blocks[t.DataAtom] {
+		e.flush()
+	}
+
+	if selfClosing || void[t.DataAtom] {
+		return
+	}
+
+	parent := e.current()
+	el := element{
+		a:           t.DataAtom,
+		name:        t.Data,
+		boilerplate: parent.boilerplate || boilerplate[t.DataAtom],
+		main:        parent.main || t.DataAtom == atom.Article || t.DataAtom == atom.Main,
+	}
+
+	// a <header> is usually the site's header unless it is part of an article
+	if t.DataAtom == atom.Header && !el.main {
+		el.boilerplate = true
+	}
+
+	if !el.boilerplate {
+		class, _ := getAttribute(t, "class")
+		id, _ := getAttribute(t, "id")
+		for _, s := range []string{class, id} {
+			if boilerplateClass.MatchString(s) && !contentClass.MatchString(s) {
+				el.boilerplate = true
+			}
+		}
+	}
+
+	e.open = append(e.open, el)
+}
+
+func (e *extractor) end(t html.Token) {
+	if blocks[t.DataAtom] {
+		e.flush()
+	}
+
+	// close everything up to the matching element...html is rarely well-formed
+	for i := len(e.open) - 1; i >= 0; i-- {
+		if e.open[i].name == t.Data {
+			e.open = e.open[:i]
+			return
+		}
+	}
+}
+
+func (e *extractor) text(s string) {
+	cur := e.current()
+	if cur.boilerplate {
+		return
+	}
+
+	words := strings.Fields(s)
+	if len(words) == 0 {
+		return
+	}
+
+	if len(e.block.words) == 0 {
+		e.block.main = cur.main
+		for _, el := range e.open {
+			switch el.a {
+			case atom.H1, atom.H2, atom.H3:
+				e.block.heading = el.a
+			}
+		}
+	}
+
+	for _, el := range e.open {
+		if el.a == atom.A {
+			e.block.linkWords += len(words)
+			break
+		}
+	}
+
+	e.block.words = append(e.block.words, words...)
+}
+
+// flush ends the current block of text
+func (e *extractor) flush() {
+	b := e.block
+	e.block = textBlock{}
+
+	if len(b.words) == 0 {
+		return
+	}
+
+	if b.heading != 0 {
+		if len(e.headings[b.heading]) < maxHeadings {
+			e.headings[b.heading] = append(e.headings[b.heading], strings.Join(b.words, " "))
+		}
+		return
+	}
+
+	// navigation, tag clouds, "related" lists, etc
+	if b.linkWords*2 > len(b.words) {
+		return
+	}
+
+	e.blocks = append(e.blocks, b)
+}
+
+// body returns the text of the main content
+func (e *extractor) body() []string {
+	e.flush()
+
+	main := false
+	for _, b := range e.blocks {
+		if b.main {
+			main = true
+			break
+		}
+	}
+
+	words := []string{}
+	for _, b := range e.blocks {
+		if main && !b.main {
+			continue
+		}
+		words = append(words, b.words...)
+	}
+
+	return words
+}
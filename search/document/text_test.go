@@ -0,0 +1,59 @@
+package document
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+func TestExtractor(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		html string
+		want []string
+	}{
+		{
+			name: "no article",
+			html: `<div id="menu"><a href="/">Home</a></div>
+				<div><p>Some text</p><p>More text</p></div>`,
+			want: []string{"Some", "text", "More", "text"},
+		},
+		{
+			name: "link heavy",
+			html: `<p><a href="/1">one</a> <a href="/2">two</a> and</p><p>Plain <a href="/3">text</a> here</p>`,
+			want: []string{"Plain", "text", "here"},
+		},
+		{
+			name: "content class",
+			html: `<div class="comments-list">Comments are dropped</div><div class="main-content-sidebar">Kept</div>`,
+			want: []string{"Kept"},
+		},
+		{
+			name: "malformed",
+			html: `<nav><p>Unclosed nav</div></nav><p>Text</p><aside>Dropped</aside>`,
+			want: []string{"Text"},
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			ex := newExtractor()
+			z := html.NewTokenizer(strings.NewReader(c.html))
+
+			for tt := z.Next(); tt != html.ErrorToken; tt = z.Next() {
+				switch tt {
+				case html.TextToken:
+					ex.text(string(z.Text()))
+				case html.StartTagToken, html.SelfClosingTagToken:
+					ex.start(z.Token(), tt == html.SelfClosingTagToken)
+				case html.EndTagToken:
+					ex.end(z.Token())
+				}
+			}
+
+			if got := ex.body(); !reflect.DeepEqual(got, c.want) {
+				t.Fatalf("got %q; want %q", got, c.want)
+			}
+		})
+	}
+}
@@ -3,8 +3,8 @@ package search
 import (
 	"context"
 	"encoding/json"
-	"fmt"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/jivesearch/jivesearch/search/document"
 	"github.com/jivesearch/jivesearch/search/vote"
@@ -15,32 +15,50 @@ import (
 // ElasticSearch embeds our main Elasticsearch instance
 type ElasticSearch struct {
 	*document.ElasticSearch
+	Rankers []Ranker
+	Votes   *VoteRank // nil to ignore votes
 }
 
 // Fetch returns search results for a search query
 // https://www.elastic.co/guide/en/elasticsearch/guide/current/one-lang-docs.html
 // https://www.elastic.co/guide/en/elasticsearch/guide/current/_single_query_string.html#know-your-data
-// The idea here is to first filter out docs that do not want to be indexed.
+// The idea here is to first filter out docs that do not want to be indexed (or are past their unavailable_after date)
+// and those that don't match the query's operators (site:, filetype:, etc) or the filter (e.g. past week).
 // We then search multiple fields for the search query, giving more weight to certain fields.
 // We also are searching the standard analyzer and the language-specific analyzer.
-// We weight the domain > path, path > title, title > description.
+// We weight the domain > path, path > title, title > headings, headings > description, description > body.
+// The text score is then multiplied by our Rankers (e.g. LinkRank) and the votes for the query.
 // We also give extra weight for bigram matches (need trigram????):
 // https://www.elastic.co/guide/en/elasticsearch/guide/current/shingles.html
 // Note: "It is not useful to mix not_analyzed fields with analyzed fields in multi_match queries."
 // TODO: A better domain name method...we could use regex ('.*hendrix'), prefix query, etc.
-func (e *ElasticSearch) Fetch(q string, lang language.Tag, region language.Region, number int, offset int, votes []vote.Result) (*Results, error) {
+func (e *ElasticSearch) Fetch(q string, filter Filter, lang language.Tag, region language.Region, number int, offset int, votes []vote.Result) (*Results, error) {
 	res := &Results{}
 
+	p := ParseQuery(q)
+	if p.Language != language.Und {
+		lang = p.Language
+	}
+
+	var text elastic.Query = elastic.NewMatchAllQuery() // e.g. "site:example.com" alone
+	if q = p.text(); q != "" {
+		text = elastic.NewMultiMatchQuery(
+			q,
+			"domain^3", "path^2",
+			"title^1.5", "title.lang^1.5",
+			"h1^1.3", "h1.lang^1.3",
+			"h2^1.2", "h2.lang^1.2",
+			"h3^1.1", "h3.lang^1.1",
+			"description", "description.lang",
+			"anchors^1.4", "anchors.lang^1.4",
+			"body^0.5", "body.lang^0.5",
+		).Type("cross_fields").MinimumShouldMatch("-25%")
+	}
+
 	qu := elastic.NewBoolQuery().
 		Filter(elastic.NewTermQuery("index", true)).
-		Must(
-			elastic.NewMultiMatchQuery(
-				q,
-				"domain^3", "path^2",
-				"title^1.5", "title.lang^1.5",
-				"description", "description.lang",
-			).Type("cross_fields").MinimumShouldMatch("-25%"),
-		).
+		MustNot(elastic.NewRangeQuery("unavailable_after").Lte("now")).
+		Must(text).
 		Should(
 			elastic.NewMultiMatchQuery(
 				q,
@@ -49,6 +67,8 @@ func (e *ElasticSearch) Fetch(q string, lang language.Tag, region language.Regio
 			).Type("cross_fields"),
 		)
 
+	qu = filter.filter(p.filter(qu))
+
 	// Boost results for regional queries (except for .me, .tv, etc. that are used for other purposes sometimes)
 	// https://support.google.com/webmasters/answer/182192#1
 	if t, err := region.TLD(); err == nil {
@@ -58,6 +78,24 @@ func (e *ElasticSearch) Fetch(q string, lang language.Tag, region language.Regio
 		}
 	}
 
+	fns := []elastic.ScoreFunction{}
+	for _, r := range e.Rankers {
+		fns = append(fns, r.Function())
+	}
+
+	if e.Votes != nil && len(votes) > 0 {
+		fns = append(fns, e.Votes.Function(votes))
+	}
+
+	var query elastic.Query = qu
+	if len(fns) > 0 {
+		fs := elastic.NewFunctionScoreQuery().Query(qu).ScoreMode("multiply").BoostMode("multiply")
+		for _, fn := range fns {
+			fs = fs.AddScoreFunc(fn)
+		}
+		query = fs
+	}
+
 	a, err := e.Analyzer(lang)
 	if err != nil {
 		return res, err
@@ -65,35 +103,26 @@ func (e *ElasticSearch) Fetch(q string, lang language.Tag, region language.Regio
 
 	idx := e.IndexName(a)
 
-	o := e.Client.Search().Index(idx).Type(e.Type).Query(qu).From(offset).Size(number)
-
-	// sort by votes
-	if len(votes) > 0 {
-		var script string
-
-		for i, v := range votes {
-			e := "if"
-			if i > 0 {
-				e = "else if"
-			}
-
-			script += fmt.Sprintf(`%v (doc['id'].value.equals('%v')) return %d;`, e, v.URL, v.Votes)
-		}
-
-		script += fmt.Sprintf("else return 0;")
-
-		sort := elastic.NewScriptSort(
-			elastic.NewScript(script).Lang("painless"), "number",
-		).Order(false).Type("number")
-		o = o.SortBy(sort)
-	}
+	// near-duplicates (printer versions, mirrors, etc) are collapsed to their best scoring doc
+	// the body text is searched but we don't need it in our results
+	o := e.Client.Search().Index(idx).Type(e.Type).Query(query).
+		FetchSourceContext(elastic.NewFetchSourceContext(true).Exclude("body")).
+		Highlight(highlight(lang)).
+		Collapse(elastic.NewCollapseBuilder("cluster")).
+		Aggregation("clusters", elastic.NewCardinalityAggregation().Field("cluster")).
+		From(offset).Size(number)
 
 	out, err := o.Do(context.TODO())
 	if err != nil {
 		return res, err
 	}
 
+	// the total hits count every near-duplicate so we count the clusters instead.
+	// The count is exact up to a few thousand clusters and approximate beyond that.
 	res.Count = out.TotalHits()
+	if c, ok := out.Aggregations.Cardinality("clusters"); ok && c.Value != nil {
+		res.Count = int64(*c.Value)
+	}
 
 	for _, u := range out.Hits.Hits {
 		doc := &document.Document{}
@@ -102,19 +131,100 @@ func (e *ElasticSearch) Fetch(q string, lang language.Tag, region language.Regio
 			return res, err
 		}
 
-		// Rather than have the highlighting done here in elasticsearch
-		// we should have a method on doc to highlight so we get consistent
-		// highlighting regardless of the backend used???
-		// For now, we have moved highlighting to a javascript function
-		// if des, ok := u.Highlight["description"]; ok {
-		//	for _, v := range des {
-		//		doc.Description = v
-		//	}
-		//}
-
 		doc.ID = u.Id
+		doc.Snippet = snippet(u.Highlight)
+		setSnippetPolicy(doc)
 		res.Documents = append(res.Documents, doc)
 	}
 
 	return res, err
 }
+
+// snippetFields are the fields we build a snippet from in order of preference.
+// The body has the most text to find the query terms in.
+var snippetFields = []string{"body.lang", "body", "description.lang", "description"}
+
+// highlight builds query-dependent snippets. The unified highlighter breaks the
+// text into sentences (and long sentences at word boundaries) using the rules of the language.
+// The text is html-escaped and the query terms are wrapped in <em> tags.
+func highlight(lang language.Tag) *elastic.Highlight {
+	fields := []*elastic.HighlighterField{}
+	for _, f := range snippetFields {
+		fields = append(fields, elastic.NewHighlighterField(f))
+	}
+
+	return elastic.NewHighlight().
+		Fields(fields...).
+		HighlighterType("unified").
+		BoundaryScannerType("sentence").
+		BoundaryScannerLocale(lang.String()).
+		Encoder("html").
+		PreTags("<em>").
+		PostTags("</em>").
+		FragmentSize(160).
+		NumOfFragments(2)
+}
+
+// snippet joins the fragments of the first field that matched the query
+func snippet(hl elastic.SearchHitHighlight) string {
+	for _, f := range snippetFields {
+		if frags := hl[f]; len(frags) > 0 {
+			return strings.Join(frags, " ... ")
+		}
+	}
+	return ""
+}
+
+// setSnippetPolicy enforces a doc's nosnippet and max-snippet directives
+// on its snippet and on its description (which we show if there is no snippet)
+func setSnippetPolicy(doc *document.Document) {
+	switch {
+	case doc.NoSnippet:
+		doc.Snippet, doc.Description = "", ""
+	case doc.MaxSnippet > 0:
+		doc.Snippet = truncateSnippet(doc.Snippet, doc.MaxSnippet)
+		if r := []rune(doc.Description); len(r) > doc.MaxSnippet {
+			doc.Description = string(r[:doc.MaxSnippet])
+		}
+	}
+}
+
+// truncateSnippet truncates an html-escaped snippet to n characters of text.
+// Tags don't count and an entity counts as one character. An <em> left open is closed.
+func truncateSnippet(s string, n int) string {
+	var b strings.Builder
+	var open bool
+
+	for i := 0; i < len(s) && n > 0; {
+		switch s[i] {
+		case '<':
+			j := strings.IndexByte(s[i:], '>')
+			if j < 0 {
+				return b.String()
+			}
+			tag := s[i : i+j+1]
+			open = tag == "<em>"
+			b.WriteString(tag)
+			i += j + 1
+			continue
+		case '&':
+			if j := strings.IndexByte(s[i:], ';'); j > -1 {
+				b.WriteString(s[i : i+j+1])
+				i += j + 1
+				n--
+				continue
+			}
+		}
+
+		_, size := utf8.DecodeRuneInString(s[i:])
+		b.WriteString(s[i : i+size])
+		i += size
+		n--
+	}
+
+	if open {
+		b.WriteString("</em>")
+	}
+
+	return b.String()
+}
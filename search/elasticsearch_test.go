@@ -1,9 +1,12 @@
 package search
 
 import (
+	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/jivesearch/jivesearch/search/document"
@@ -19,15 +22,17 @@ func TestFetch(t *testing.T) {
 	}
 
 	for _, c := range []struct {
-		name   string
-		query  string
-		lang   language.Tag
-		region language.Region
-		number int
-		page   int
-		votes  []vote.Result
-		status int
-		resp   string
+		name     string
+		query    string
+		filter   Filter
+		lang     language.Tag
+		region   language.Region
+		number   int
+		page     int
+		votes    []vote.Result
+		status   int
+		resp     string
+		snippets []string
 		want
 	}{
 		{
@@ -47,7 +52,7 @@ func TestFetch(t *testing.T) {
 			    "failed": 0
 			  },
 			  "hits": {
-			    "total": 2,
+			    "total": 3,
 			    "max_score": 6.6914043,
 			    "hits": [
 					{
@@ -58,7 +63,14 @@ func TestFetch(t *testing.T) {
 						"_source": {
 						"title": "Is Bob Dylan Literature? - WSJ",
 						"description": "The Nobel committee says ‘Yes’ to Bob Dylan."
-					}
+					},
+						"highlight": {
+							"description": ["The Nobel committee says ‘Yes’ to <em>Bob</em> <em>Dylan</em>."],
+							"body.lang": [
+								"<em>Bob</em> <em>Dylan</em> won the Nobel Prize in Literature.",
+								"Is <em>Dylan</em> &quot;literature&quot;?"
+							]
+						}
 			      },
 					{
 						"_index": "search-english",
@@ -71,8 +83,15 @@ func TestFetch(t *testing.T) {
 						}
 					}
 			    ]
+			  },
+			  "aggregations": {
+			    "clusters": {"value": 2}
 			  }
 			}`,
+			snippets: []string{
+				`<em>Bob</em> <em>Dylan</em> won the Nobel Prize in Literature. ... Is <em>Dylan</em> &quot;literature&quot;?`,
+				"",
+			},
 			want: want{
 				&Results{
 					Count: 2,
@@ -171,7 +190,9 @@ func TestFetch(t *testing.T) {
 			}))
 			defer ts.Close()
 
+			var body []byte
 			handler = func(w http.ResponseWriter, r *http.Request) {
+				body, _ = ioutil.ReadAll(r.Body)
 				w.Write([]byte(c.resp))
 			}
 
@@ -180,11 +201,37 @@ func TestFetch(t *testing.T) {
 				t.Fatal(err)
 			}
 
-			got, err := e.Fetch(c.query, c.lang, c.region, c.number, c.page, c.votes)
+			got, err := e.Fetch(c.query, c.filter, c.lang, c.region, c.number, c.page, c.votes)
 			if err != c.want.err {
 				t.Fatalf("got err %q; want %q", err, c.want.err)
 			}
 
+			// snippets are built in the language of the index
+			if !strings.Contains(string(body), fmt.Sprintf(`"boundary_scanner_locale":"%v"`, c.lang)) {
+				t.Fatalf("got request %s; want snippets in %v", body, c.lang)
+			}
+
+			// near-duplicates are collapsed
+			if !strings.Contains(string(body), `"collapse":{"field":"cluster"}`) {
+				t.Fatalf("got request %s; want it collapsed on cluster", body)
+			}
+
+			// and counted by cluster
+			if !strings.Contains(string(body), `"clusters":{"cardinality":{"field":"cluster"}}`) {
+				t.Fatalf("got request %s; want the clusters counted", body)
+			}
+
+			// pages past their unavailable_after date are left out
+			if !strings.Contains(string(body), `"range":{"unavailable_after":{"from":null,"include_lower":true,"include_upper":true,"to":"now"}}`) {
+				t.Fatalf("got request %s; want unavailable pages filtered out", body)
+			}
+
+			for i, s := range c.snippets {
+				if got.Documents[i].Snippet != s {
+					t.Fatalf("got snippet %q; want %q", got.Documents[i].Snippet, s)
+				}
+			}
+
 			got.Documents = []*document.Document{}
 			c.want.Results.Documents = []*document.Document{}
 
@@ -195,6 +242,57 @@ func TestFetch(t *testing.T) {
 	}
 }
 
+func TestSetSnippetPolicy(t *testing.T) {
+	for _, c := range []struct {
+		name        string
+		policy      document.Policy
+		snippet     string
+		description string
+	}{
+		{"default", document.Policy{}, "the <em>quick</em> brown fox", "a quick brown fox"},
+		{"nosnippet", document.Policy{NoSnippet: true}, "", ""},
+		{"max-snippet", document.Policy{MaxSnippet: 7}, "the <em>qui</em>", "a quick"},
+		{"max-snippet long", document.Policy{MaxSnippet: 100}, "the <em>quick</em> brown fox", "a quick brown fox"},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			doc := &document.Document{
+				Snippet: "the <em>quick</em> brown fox",
+				Content: document.Content{Description: "a quick brown fox", Policy: c.policy},
+			}
+
+			setSnippetPolicy(doc)
+
+			if doc.Snippet != c.snippet {
+				t.Fatalf("got snippet %q; want %q", doc.Snippet, c.snippet)
+			}
+			if doc.Description != c.description {
+				t.Fatalf("got description %q; want %q", doc.Description, c.description)
+			}
+		})
+	}
+}
+
+func TestTruncateSnippet(t *testing.T) {
+	for _, c := range []struct {
+		name    string
+		snippet string
+		n       int
+		want    string
+	}{
+		{"short", "hello <em>world</em>", 20, "hello <em>world</em>"},
+		{"in a tag", "hello <em>world</em>", 8, "hello <em>wo</em>"},
+		{"after a tag", "<em>hello</em> world", 7, "<em>hello</em> w"},
+		{"entity", "fish &amp; chips", 6, "fish &amp;"},
+		{"unicode", "héllo wörld", 4, "héll"},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			if got := truncateSnippet(c.snippet, c.n); got != c.want {
+				t.Fatalf("got %q; want %q", got, c.want)
+			}
+		})
+	}
+}
+
 func MockService(url string) (*ElasticSearch, error) {
 	client, err := elastic.NewSimpleClient(elastic.SetURL(url))
 	if err != nil {
@@ -0,0 +1,91 @@
+package search
+
+import (
+	"time"
+
+	"github.com/olivere/elastic"
+)
+
+// Filter narrows down the results of a search
+type Filter struct {
+	Time Time
+	From time.Time // of a CustomTime range. Either can be zero for an open-ended range.
+	To   time.Time
+}
+
+// Time is how recent the results must be
+type Time string
+
+// The time ranges we can filter by
+const (
+	AnyTime    Time = ""
+	PastDay    Time = "day"
+	PastWeek   Time = "week"
+	PastMonth  Time = "month"
+	PastYear   Time = "year"
+	CustomTime Time = "custom"
+)
+
+// ParseTime returns the Time of a string or AnyTime if we don't know it
+func ParseTime(s string) Time {
+	switch t := Time(s); t {
+	case PastDay, PastWeek, PastMonth, PastYear, CustomTime:
+		return t
+	}
+	return AnyTime
+}
+
+// dateFormat is the format of a custom range. The crawled date is basic_date
+// and the publish date strict_date_optional_time so we tell elasticsearch what we send.
+const dateFormat = "2006-01-02"
+
+// dates returns the range in elasticsearch's date math
+func (f Filter) dates() (from, to string) {
+	switch f.Time {
+	case PastDay:
+		return "now-1d", "now"
+	case PastWeek:
+		return "now-1w", "now"
+	case PastMonth:
+		return "now-1M", "now"
+	case PastYear:
+		return "now-1y", "now"
+	case CustomTime:
+		if !f.From.IsZero() {
+			from = f.From.Format(dateFormat) + "||/d"
+		}
+		if !f.To.IsZero() {
+			to = f.To.Format(dateFormat) + "||/d" // rounds up to the end of the day
+		}
+	}
+	return from, to
+}
+
+// filter adds the time range to a bool query. A document's date is its
+// publish date or, as most pages don't have one, the date we crawled it.
+func (f Filter) filter(qu *elastic.BoolQuery) *elastic.BoolQuery {
+	from, to := f.dates()
+	if from == "" && to == "" {
+		return qu
+	}
+
+	rng := func(field string) *elastic.RangeQuery {
+		r := elastic.NewRangeQuery(field).Format("yyyy-MM-dd")
+		if from != "" {
+			r = r.Gte(from)
+		}
+		if to != "" {
+			r = r.Lte(to)
+		}
+		return r
+	}
+
+	return qu.Filter(
+		elastic.NewBoolQuery().MinimumNumberShouldMatch(1).Should(
+			rng("date"),
+			elastic.NewBoolQuery().
+				MustNot(elastic.NewExistsQuery("date")).
+				Filter(rng("crawled")),
+		),
+	)
+}
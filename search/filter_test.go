@@ -0,0 +1,88 @@
+package search
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/olivere/elastic"
+)
+
+func TestParseTime(t *testing.T) {
+	for _, c := range []struct {
+		s    string
+		want Time
+	}{
+		{"", AnyTime},
+		{"day", PastDay},
+		{"week", PastWeek},
+		{"month", PastMonth},
+		{"year", PastYear},
+		{"custom", CustomTime},
+		{"decade", AnyTime},
+	} {
+		t.Run(c.s, func(t *testing.T) {
+			if got := ParseTime(c.s); got != c.want {
+				t.Fatalf("got %q; want %q", got, c.want)
+			}
+		})
+	}
+}
+
+func TestFilter(t *testing.T) {
+	for _, c := range []struct {
+		name   string
+		filter Filter
+		want   string
+	}{
+		{
+			"any time", Filter{},
+			`{"bool":{}}`,
+		},
+		{
+			"past week", Filter{Time: PastWeek},
+			`{"bool":{"filter":{"bool":{"minimum_should_match":"1","should":[` +
+				`{"range":{"date":{"format":"yyyy-MM-dd","from":"now-1w","include_lower":true,"include_upper":true,"to":"now"}}},` +
+				`{"bool":{"filter":{"range":{"crawled":{"format":"yyyy-MM-dd","from":"now-1w","include_lower":true,"include_upper":true,"to":"now"}}},"must_not":{"exists":{"field":"date"}}}}]}}}}`,
+		},
+		{
+			"custom", Filter{
+				Time: CustomTime,
+				From: time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC),
+				To:   time.Date(2017, 6, 30, 0, 0, 0, 0, time.UTC),
+			},
+			`{"bool":{"filter":{"bool":{"minimum_should_match":"1","should":[` +
+				`{"range":{"date":{"format":"yyyy-MM-dd","from":"2017-01-01||/d","include_lower":true,"include_upper":true,"to":"2017-06-30||/d"}}},` +
+				`{"bool":{"filter":{"range":{"crawled":{"format":"yyyy-MM-dd","from":"2017-01-01||/d","include_lower":true,"include_upper":true,"to":"2017-06-30||/d"}}},"must_not":{"exists":{"field":"date"}}}}]}}}}`,
+		},
+		{
+			"custom since", Filter{
+				Time: CustomTime,
+				From: time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC),
+			},
+			`{"bool":{"filter":{"bool":{"minimum_should_match":"1","should":[` +
+				`{"range":{"date":{"format":"yyyy-MM-dd","from":"2017-01-01||/d","include_lower":true,"include_upper":true,"to":null}}},` +
+				`{"bool":{"filter":{"range":{"crawled":{"format":"yyyy-MM-dd","from":"2017-01-01||/d","include_lower":true,"include_upper":true,"to":null}}},"must_not":{"exists":{"field":"date"}}}}]}}}}`,
+		},
+		{
+			"custom without dates", Filter{Time: CustomTime},
+			`{"bool":{}}`,
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			src, err := c.filter.filter(elastic.NewBoolQuery()).Source()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := json.Marshal(src)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(got) != c.want {
+				t.Fatalf("got %s; want %s", got, c.want)
+			}
+		})
+	}
+}
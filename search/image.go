@@ -0,0 +1,58 @@
+package search
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/jivesearch/jivesearch/search/document"
+	"github.com/olivere/elastic"
+	"golang.org/x/text/language"
+)
+
+// FetchImages returns image search results for a search query.
+// An image is described by its alt text > its title > the text around it > the title of its page.
+// TODO: boost images on pages of the user's region like Fetch does.
+func (e *ElasticSearch) FetchImages(q string, lang language.Tag, region language.Region, number int, offset int) (*Results, error) {
+	res := &Results{}
+
+	qu := elastic.NewBoolQuery().
+		Must(
+			elastic.NewMultiMatchQuery(
+				q,
+				"alt^2", "alt.lang^2",
+				"title^1.5", "title.lang^1.5",
+				"text", "text.lang",
+				"page_title^0.5", "page_title.lang^0.5",
+			).Type("cross_fields").MinimumShouldMatch("-25%"),
+		)
+
+	a, err := e.Analyzer(lang)
+	if err != nil {
+		return res, err
+	}
+
+	out, err := e.Client.Search().
+		Index(e.ImageIndexName(a)).
+		Type(document.ImageType).
+		Query(qu).
+		From(offset).Size(number).
+		Do(context.TODO())
+
+	if err != nil {
+		return res, err
+	}
+
+	res.Count = out.TotalHits()
+
+	for _, h := range out.Hits.Hits {
+		img := &document.Image{}
+		if err := json.Unmarshal(*h.Source, img); err != nil {
+			return res, err
+		}
+
+		img.ID = h.Id
+		res.Images = append(res.Images, img)
+	}
+
+	return res, nil
+}
@@ -0,0 +1,76 @@
+package search
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jivesearch/jivesearch/search/document"
+	"golang.org/x/text/language"
+)
+
+func TestFetchImages(t *testing.T) {
+	var path string
+	var body []byte
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		body, _ = ioutil.ReadAll(r.Body)
+		w.Write([]byte(`{
+			"took": 3,
+			"hits": {
+				"total": 1,
+				"hits": [
+					{
+						"_index": "search-images-french",
+						"_type": "image",
+						"_id": "https://www.example.com/chat.jpg",
+						"_source": {
+							"alt": "Un chat",
+							"page": "https://www.example.com/chats",
+							"page_title": "Les chats"
+						}
+					}
+				]
+			}
+		}`))
+	}))
+	defer ts.Close()
+
+	e, err := MockService(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := e.FetchImages("chat", language.French, language.MustParseRegion("FR"), 25, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if path != "/search-images-french/image/_search" {
+		t.Fatalf("got path %q; want the french image index", path)
+	}
+
+	if !strings.Contains(string(body), `"alt.lang^2"`) {
+		t.Fatalf("got request %s; want a search of the alt text", body)
+	}
+
+	want := &Results{
+		Count: 1,
+		Images: []*document.Image{
+			{
+				ID:        "https://www.example.com/chat.jpg",
+				Alt:       "Un chat",
+				Page:      "https://www.example.com/chats",
+				PageTitle: "Les chats",
+			},
+		},
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v; want %+v", got, want)
+	}
+}
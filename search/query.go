@@ -0,0 +1,288 @@
+package search
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/olivere/elastic"
+	"golang.org/x/net/publicsuffix"
+	"golang.org/x/text/language"
+)
+
+// Query is a search query parsed into its words and operators:
+//
+//	site:example.com -site:example.com filetype:pdf intitle:word inurl:word lang:fr
+//	"a quoted phrase" -excluded -"excluded phrase" word OR word
+//
+// An operator's value can be quoted too, e.g. intitle:"a phrase".
+// More than one site: or filetype: matches any of them (e.g. site:a.com site:b.com).
+type Query struct {
+	Text     string     // the words that aren't part of an operator
+	Phrases  []string   // "quoted phrases"
+	Or       [][]string // words (or phrases) of which one must match, e.g. "jimi OR jimmy"
+	Exclude  []string   // -words and -"phrases"
+	Site     []string
+	NotSite  []string
+	FileType []string
+	InTitle  []string
+	InURL    []string
+	Language language.Tag
+}
+
+// fileTypes are the MIME types of the documents we index by their extension
+var fileTypes = map[string][]string{
+	"htm":      {"text/html"},
+	"html":     {"text/html"},
+	"pdf":      {"application/pdf"},
+	"txt":      {"text/plain"},
+	"text":     {"text/plain"},
+	"md":       {"text/markdown"},
+	"markdown": {"text/markdown"},
+	"xml":      {"text/xml"},
+}
+
+// textFields are searched for phrases and exclusions. The domain and path aren't text.
+var textFields = []string{
+	"title", "title.lang",
+	"h1", "h1.lang",
+	"h2", "h2.lang",
+	"h3", "h3.lang",
+	"description", "description.lang",
+	"anchors", "anchors.lang",
+	"body", "body.lang",
+}
+
+// token is a word or a quoted phrase of a query
+type token struct {
+	operator string // "site", "intitle", etc.
+	value    string
+	exclude  bool // -word
+	phrase   bool // "quoted"
+}
+
+// ParseQuery parses the operators of a search query.
+// Anything that isn't a known operator (e.g. "http://example.com" or "10:30") is left as is.
+func ParseQuery(q string) *Query {
+	p := &Query{}
+	var text []string
+
+	tokens := tokenize(q)
+
+	for i := 0; i < len(tokens); i++ {
+		t := tokens[i]
+
+		// an OR group: word OR word OR "a phrase"
+		if !t.exclude && t.operator == "" && i+2 < len(tokens) && isOr(tokens[i+1]) {
+			group := []string{t.value}
+			for i+2 < len(tokens) && isOr(tokens[i+1]) && tokens[i+2].operator == "" && !tokens[i+2].exclude {
+				group = append(group, tokens[i+2].value)
+				i += 2
+			}
+			if len(group) > 1 {
+				p.Or = append(p.Or, group)
+				continue
+			}
+		}
+
+		switch t.operator {
+		case "site":
+			if t.exclude {
+				p.NotSite = append(p.NotSite, t.value)
+			} else {
+				p.Site = append(p.Site, t.value)
+			}
+		case "filetype", "ext":
+			p.FileType = append(p.FileType, strings.ToLower(strings.TrimPrefix(t.value, ".")))
+		case "intitle":
+			p.InTitle = append(p.InTitle, t.value)
+		case "inurl":
+			p.InURL = append(p.InURL, t.value)
+		case "lang":
+			if l, err := language.Parse(t.value); err == nil {
+				p.Language = l
+			}
+		default:
+			switch {
+			case t.exclude:
+				p.Exclude = append(p.Exclude, t.value)
+			case t.phrase:
+				p.Phrases = append(p.Phrases, t.value)
+			default:
+				text = append(text, t.value)
+			}
+		}
+	}
+
+	p.Text = strings.Join(text, " ")
+	return p
+}
+
+func isOr(t token) bool {
+	return !t.phrase && !t.exclude && t.operator == "" && (t.value == "OR" || t.value == "|")
+}
+
+// tokenize splits a query into words and quoted phrases along with their operators.
+// A quote that is never closed runs to the end of the query.
+func tokenize(q string) []token {
+	tokens := []token{}
+	r := []rune(q)
+
+	for i := 0; i < len(r); {
+		if isSpace(r[i]) {
+			i++
+			continue
+		}
+
+		t := token{}
+		start := i
+
+		if r[i] == '-' && i+1 < len(r) && !isSpace(r[i+1]) {
+			t.exclude = true
+			i++
+		}
+
+		// the operator (if any)
+		for j := i; j < len(r) && !isSpace(r[j]) && r[j] != '"'; j++ {
+			if r[j] == ':' {
+				if _, ok := operators[strings.ToLower(string(r[i:j]))]; ok && j+1 < len(r) && !isSpace(r[j+1]) {
+					t.operator = strings.ToLower(string(r[i:j]))
+					i = j + 1
+				}
+				break
+			}
+		}
+
+		if r[i] == '"' {
+			j := i + 1
+			for j < len(r) && r[j] != '"' {
+				j++
+			}
+			t.value, t.phrase = strings.TrimSpace(string(r[i+1:j])), true
+			i = j + 1
+		} else {
+			j := i
+			for j < len(r) && !isSpace(r[j]) {
+				j++
+			}
+			t.value = string(r[i:j])
+			i = j
+		}
+
+		if t.value == "" {
+			continue
+		}
+
+		if t.exclude && t.operator != "" && t.operator != "site" { // only site: can be excluded
+			t.operator, t.exclude, t.value = "", false, string(r[start:i])
+		}
+
+		tokens = append(tokens, t)
+	}
+
+	return tokens
+}
+
+var operators = map[string]struct{}{
+	"site": {}, "filetype": {}, "ext": {}, "intitle": {}, "inurl": {}, "lang": {},
+}
+
+func isSpace(r rune) bool {
+	return r == ' ' || r == '\t' || r == '\n' || r == '\r' || r == '　'
+}
+
+// text is the words and phrases of the query that we rank documents by.
+// The words of an OR group are ranked by their own query.
+func (p *Query) text() string {
+	return strings.TrimSpace(strings.Join(append([]string{p.Text}, p.Phrases...), " "))
+}
+
+// filter adds the phrases, OR groups, exclusions and operators of a query to a bool query
+func (p *Query) filter(qu *elastic.BoolQuery) *elastic.BoolQuery {
+	for _, ph := range p.Phrases {
+		qu = qu.Must(elastic.NewMultiMatchQuery(ph, textFields...).Type("phrase"))
+	}
+
+	for _, g := range p.Or {
+		or := elastic.NewBoolQuery().MinimumNumberShouldMatch(1)
+		for _, w := range g {
+			or = or.Should(elastic.NewMultiMatchQuery(w, textFields...).Type("phrase"))
+		}
+		qu = qu.Must(or)
+	}
+
+	for _, ex := range p.Exclude {
+		qu = qu.MustNot(elastic.NewMultiMatchQuery(ex, textFields...).Type("phrase"))
+	}
+
+	if len(p.Site) > 0 {
+		site := elastic.NewBoolQuery().MinimumNumberShouldMatch(1)
+		for _, s := range p.Site {
+			site = site.Should(siteQuery(s))
+		}
+		qu = qu.Filter(site)
+	}
+
+	for _, s := range p.NotSite {
+		qu = qu.MustNot(siteQuery(s))
+	}
+
+	if len(p.FileType) > 0 {
+		mimes := []interface{}{}
+		for _, ft := range p.FileType {
+			m, ok := fileTypes[ft]
+			if !ok { // a type we don't index so nothing will match
+				m = []string{ft}
+			}
+			for _, mime := range m {
+				mimes = append(mimes, mime)
+			}
+		}
+		qu = qu.Filter(elastic.NewTermsQuery("mime", mimes...))
+	}
+
+	for _, t := range p.InTitle {
+		qu = qu.Filter(elastic.NewMultiMatchQuery(t, "title", "title.lang").Type("phrase"))
+	}
+
+	// path_parts is a single token ("path to something") so we look for the value anywhere in it
+	pathParts := strings.NewReplacer("/", " ", "-", " ")
+	for _, u := range p.InURL {
+		u = strings.NewReplacer("*", `\*`, "?", `\?`).Replace(u)
+		qu = qu.Filter(
+			elastic.NewBoolQuery().MinimumNumberShouldMatch(1).Should(
+				elastic.NewWildcardQuery("host", "*"+strings.ToLower(u)+"*"),
+				elastic.NewWildcardQuery("path_parts", "*"+strings.Join(strings.Fields(pathParts.Replace(u)), " ")+"*"),
+			),
+		)
+	}
+
+	return qu
+}
+
+// siteQuery matches the documents of a site. A site is a tld (site:gov),
+// a domain and its subdomains (site:example.com) or a host and its subdomains (site:blog.example.com).
+func siteQuery(s string) elastic.Query {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if u, err := url.Parse(s); err == nil && u.Host != "" { // site:https://example.com/path
+		s = u.Hostname()
+	}
+	s = strings.Trim(strings.Split(s, "/")[0], ".")
+
+	if !strings.Contains(s, ".") {
+		return elastic.NewTermQuery("tld", s)
+	}
+
+	qu := elastic.NewBoolQuery()
+
+	// domain is analyzed into "example", "example.com", "example.com.br", etc so we also check the host
+	if d, err := publicsuffix.EffectiveTLDPlusOne(s); err == nil {
+		qu = qu.Filter(elastic.NewTermQuery("domain", d))
+	}
+
+	return qu.Filter(
+		elastic.NewBoolQuery().MinimumNumberShouldMatch(1).Should(
+			elastic.NewTermQuery("host", s),
+			elastic.NewWildcardQuery("host", "*."+s),
+		),
+	)
+}
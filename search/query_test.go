@@ -0,0 +1,140 @@
+package search
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/olivere/elastic"
+	"golang.org/x/text/language"
+)
+
+func TestParseQuery(t *testing.T) {
+	for _, c := range []struct {
+		query string
+		want  *Query
+	}{
+		{
+			"bob dylan",
+			&Query{Text: "bob dylan"},
+		},
+		{
+			`bob dylan site:example.com -site:blog.example.com filetype:PDF`,
+			&Query{
+				Text:     "bob dylan",
+				Site:     []string{"example.com"},
+				NotSite:  []string{"blog.example.com"},
+				FileType: []string{"pdf"},
+			},
+		},
+		{
+			`"like a rolling stone" lyrics -cover -"live version"`,
+			&Query{
+				Text:    "lyrics",
+				Phrases: []string{"like a rolling stone"},
+				Exclude: []string{"cover", `live version`},
+			},
+		},
+		{
+			`intitle:"rolling stone" inurl:lyrics lang:fr`,
+			&Query{
+				InTitle:  []string{"rolling stone"},
+				InURL:    []string{"lyrics"},
+				Language: language.French,
+			},
+		},
+		{
+			`jimi OR jimmy OR "james marshall" hendrix`,
+			&Query{
+				Text: "hendrix",
+				Or:   [][]string{{"jimi", "jimmy", "james marshall"}},
+			},
+		},
+		{
+			"OR guitar or", // a dangling OR is just a word
+			&Query{Text: "OR guitar or"},
+		},
+		{
+			"http://example.com 10:30 -intitle:guitar site: foo:bar",
+			&Query{Text: "http://example.com 10:30 -intitle:guitar site: foo:bar"},
+		},
+		{
+			`"an unclosed phrase`,
+			&Query{Phrases: []string{"an unclosed phrase"}},
+		},
+		{
+			"lang:notalanguage x-ray",
+			&Query{Text: "x-ray"},
+		},
+	} {
+		t.Run(c.query, func(t *testing.T) {
+			got := ParseQuery(c.query)
+
+			if !reflect.DeepEqual(got, c.want) {
+				t.Fatalf("got %+v; want %+v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestQueryFilter(t *testing.T) {
+	for _, c := range []struct {
+		query string
+		want  []string
+	}{
+		{
+			"site:gov",
+			[]string{`"filter":{"bool":{"minimum_should_match":"1","should":{"term":{"tld":"gov"}}}}`},
+		},
+		{
+			"site:https://Blog.Example.co.uk/path",
+			[]string{
+				`{"term":{"domain":"example.co.uk"}}`,
+				`{"term":{"host":"blog.example.co.uk"}}`,
+				`{"wildcard":{"host":{"wildcard":"*.blog.example.co.uk"}}}`,
+			},
+		},
+		{
+			"-site:example.com",
+			[]string{`"must_not":{"bool":{"filter":[{"term":{"domain":"example.com"}}`},
+		},
+		{
+			"filetype:pdf filetype:md",
+			[]string{`{"terms":{"mime":["application/pdf","text/markdown"]}}`},
+		},
+		{
+			`intitle:"rolling stone"`,
+			[]string{`{"multi_match":{"fields":["title","title.lang"],"query":"rolling stone","tie_breaker":0,"type":"phrase"}}`},
+		},
+		{
+			"inurl:Bob-Dylan*",
+			[]string{
+				`{"wildcard":{"host":{"wildcard":"*bob-dylan\\**"}}}`,
+				`{"wildcard":{"path_parts":{"wildcard":"*Bob Dylan\\**"}}}`,
+			},
+		},
+		{
+			"-cover",
+			[]string{`"must_not":{"multi_match":{"fields":["title","title.lang","h1","h1.lang","h2","h2.lang","h3","h3.lang","description","description.lang","anchors","anchors.lang","body","body.lang"],"query":"cover","tie_breaker":0,"type":"phrase"}}`},
+		},
+	} {
+		t.Run(c.query, func(t *testing.T) {
+			src, err := ParseQuery(c.query).filter(elastic.NewBoolQuery()).Source()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			b, err := json.Marshal(src)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, w := range c.want {
+				if !strings.Contains(string(b), w) {
+					t.Fatalf("got %s; want %s", b, w)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package rank
+
+import (
+	"strings"
+
+	"github.com/jivesearch/jivesearch/search/document"
+)
+
+// Anchors collects the anchor text of the links to each page as we read our link graph.
+// Any one domain gets to describe a page no more than perDomain times (and only once with
+// the same words) so a site can't stuff a page with its own anchors. The same anchor text
+// from many domains tells us the most about a page so it is kept each time.
+type Anchors struct {
+	perDomain int
+	max       int
+	anchors   map[string][]string
+	counts    map[string]int      // by page & domain
+	seen      map[string]struct{} // by page, domain & anchor
+}
+
+// NewAnchors returns an empty Anchors that keeps up to max anchors for a page
+func NewAnchors(perDomain, max int) *Anchors {
+	return &Anchors{
+		perDomain: perDomain,
+		max:       max,
+		anchors:   map[string][]string{},
+		counts:    map[string]int{},
+		seen:      map[string]struct{}{},
+	}
+}
+
+// Add adds the links from a page of a domain
+func (a *Anchors) Add(domain string, links []document.Link) {
+	for _, l := range links {
+		if l.Anchor == "" || len(a.anchors[l.Target]) >= a.max {
+			continue
+		}
+
+		d := l.Target + " " + domain
+		if a.counts[d] >= a.perDomain {
+			continue
+		}
+
+		key := d + " " + strings.ToLower(l.Anchor)
+		if _, ok := a.seen[key]; ok {
+			continue
+		}
+
+		a.seen[key] = struct{}{}
+		a.counts[d]++
+		a.anchors[l.Target] = append(a.anchors[l.Target], l.Anchor)
+	}
+}
+
+// Get returns what the pages that link to a page call it
+func (a *Anchors) Get(page string) []string {
+	if a == nil {
+		return nil
+	}
+	return a.anchors[page]
+}
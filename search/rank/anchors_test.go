@@ -0,0 +1,83 @@
+package rank
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/jivesearch/jivesearch/search/document"
+)
+
+func TestAnchors(t *testing.T) {
+	target := "https://www.example.com/"
+
+	type source struct {
+		domain string
+		links  []document.Link
+	}
+
+	for _, c := range []struct {
+		name      string
+		sources   []source
+		perDomain int
+		max       int
+		want      []string
+	}{
+		{
+			name: "basic",
+			sources: []source{
+				{"example.com", []document.Link{{Target: target, Anchor: "Home"}, {Target: "https://www.example.com/about", Anchor: "About"}}},
+				{"another.com", []document.Link{{Target: target, Anchor: "example"}, {Target: target}}},
+			},
+			perDomain: 5,
+			max:       100,
+			want:      []string{"Home", "example"},
+		},
+		{
+			name: "the same anchor from many domains",
+			sources: []source{
+				{"a.com", []document.Link{{Target: target, Anchor: "great widgets"}}},
+				{"b.com", []document.Link{{Target: target, Anchor: "Great Widgets"}}},
+			},
+			perDomain: 5,
+			max:       100,
+			want:      []string{"great widgets", "Great Widgets"},
+		},
+		{
+			name: "spam",
+			sources: []source{
+				{"spam.com", []document.Link{{Target: target, Anchor: "cheap"}, {Target: target, Anchor: "CHEAP"}, {Target: target, Anchor: "pills"}}},
+				{"spam.com", []document.Link{{Target: target, Anchor: "casino"}}},
+				{"another.com", []document.Link{{Target: target, Anchor: "example"}}},
+			},
+			perDomain: 2,
+			max:       100,
+			want:      []string{"cheap", "pills", "example"},
+		},
+		{
+			name: "max",
+			sources: []source{
+				{"a.com", []document.Link{{Target: target, Anchor: "one"}, {Target: target, Anchor: "two"}}},
+				{"b.com", []document.Link{{Target: target, Anchor: "three"}}},
+			},
+			perDomain: 5,
+			max:       2,
+			want:      []string{"one", "two"},
+		},
+		{
+			name:      "no links",
+			perDomain: 5,
+			max:       100,
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			a := NewAnchors(c.perDomain, c.max)
+			for _, src := range c.sources {
+				a.Add(src.domain, src.links)
+			}
+
+			if got := a.Get(target); !reflect.DeepEqual(got, c.want) {
+				t.Fatalf("got %q; want %q", got, c.want)
+			}
+		})
+	}
+}
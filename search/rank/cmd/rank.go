@@ -0,0 +1,85 @@
+// Command rank computes the PageRank of our documents from the link graph the crawler builds
+// and indexes each document with the anchor text of the links to it.
+// It is a batch job...run it every so often (e.g. daily) as the crawl grows.
+package main
+
+import (
+	"context"
+	"os"
+	"strings"
+
+	"github.com/jivesearch/jivesearch/config"
+	"github.com/jivesearch/jivesearch/log"
+	"github.com/jivesearch/jivesearch/search/document"
+	"github.com/jivesearch/jivesearch/search/rank"
+	"github.com/olivere/elastic"
+	"github.com/spf13/viper"
+)
+
+var (
+	host             bool // rank hosts instead of pages
+	damping          float64
+	iterations       int
+	maxAnchors       int // anchor texts to index with a document
+	maxDomainAnchors int // anchor texts from any one domain
+)
+
+func setup(v *viper.Viper) {
+	v.SetEnvPrefix("jivesearch")
+	v.AutomaticEnv()
+	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	config.SetDefaults(v)
+
+	if v.GetBool("debug") {
+		log.Debug.SetOutput(os.Stdout)
+	}
+
+	host = v.GetString("rank.level") == "host"
+	damping = v.GetFloat64("rank.damping")
+	iterations = v.GetInt("rank.iterations")
+	maxAnchors = v.GetInt("rank.anchors.max")
+	maxDomainAnchors = v.GetInt("rank.anchors.domain")
+}
+
+func main() {
+	v := viper.New()
+	setup(v)
+
+	client, err := elastic.NewClient(elastic.SetURL(v.GetString("elasticsearch.url")), elastic.SetSniff(false))
+	if err != nil {
+		panic(err)
+	}
+
+	bulk, err := client.BulkProcessor().Do(context.Background())
+	if err != nil {
+		panic(err)
+	}
+
+	defer bulk.Close()
+
+	r := &rank.ElasticSearch{
+		ElasticSearch: &document.ElasticSearch{
+			Client: client,
+			Index:  v.GetString("elasticsearch.search.index"),
+			Type:   v.GetString("elasticsearch.search.type"),
+		},
+		Bulk: bulk,
+	}
+
+	if err := r.Setup(); err != nil {
+		panic(err)
+	}
+
+	a := rank.NewAnchors(maxDomainAnchors, maxAnchors)
+
+	g, err := r.Graph(host, a)
+	if err != nil {
+		panic(err)
+	}
+
+	log.Info.Printf("ranking %d nodes\n", g.Len())
+
+	if err := r.Write(g.PageRank(damping, iterations, 1e-6), a, host); err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestSetup(t *testing.T) {
+	v := viper.New()
+	setup(v)
+
+	if host {
+		t.Fatalf("expected pages to be ranked by default")
+	}
+
+	if damping != 0.85 {
+		t.Fatalf("got damping %v; want 0.85", damping)
+	}
+
+	if iterations == 0 {
+		t.Fatalf("expected non zero iterations. got %v", iterations)
+	}
+
+	if maxAnchors != 100 || maxDomainAnchors != 5 {
+		t.Fatalf("got %v anchors and %v per domain; want 100 and 5", maxAnchors, maxDomainAnchors)
+	}
+}
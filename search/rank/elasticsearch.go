@@ -0,0 +1,151 @@
+package rank
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/url"
+	"strings"
+
+	"github.com/jivesearch/jivesearch/search/document"
+	"github.com/olivere/elastic"
+)
+
+// ElasticSearch reads our link graph and writes the ranks back onto our documents
+type ElasticSearch struct {
+	*document.ElasticSearch
+	Bulk *elastic.BulkProcessor
+}
+
+// scrollSize is how many pages (or documents) we read at a time
+const scrollSize = 1000
+
+// Setup adds the rank to the mapping of the document indices we created before we had it
+func (e *ElasticSearch) Setup() error {
+	names, err := e.Client.IndexNames()
+	if err != nil {
+		return err
+	}
+
+	for _, idx := range e.documentIndices(names) {
+		_, err := e.Client.PutMapping().Index(idx).Type(e.Type).
+			BodyString(`{"properties": {"rank": {"type": "float"}}}`).
+			Do(context.TODO())
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// documentIndices are our language-specific document indices, e.g. "search-english"
+func (e *ElasticSearch) documentIndices(names []string) []string {
+	indices := []string{}
+	for _, idx := range names {
+		if !strings.HasPrefix(idx, e.Index+"-") || idx == e.LinkIndexName() || strings.HasPrefix(idx, e.ImageIndexName("")) {
+			continue
+		}
+		indices = append(indices, idx)
+	}
+	return indices
+}
+
+// Graph reads our link graph. For a host graph the links between
+// the pages of two hosts count as a single link between the hosts.
+// The anchor text of the links is collected as we go (nil to ignore it).
+func (e *ElasticSearch) Graph(host bool, anchors *Anchors) (*Graph, error) {
+	g := NewGraph()
+
+	svc := e.Client.Scroll(e.LinkIndexName()).Type(document.LinkType).Size(scrollSize)
+	defer svc.Clear(context.TODO())
+
+	for {
+		res, err := svc.Do(context.TODO())
+		if err == io.EOF {
+			return g, nil
+		}
+		if err != nil {
+			return g, err
+		}
+
+		for _, h := range res.Hits.Hits {
+			ol := &document.Outlinks{}
+			if err := json.Unmarshal(*h.Source, ol); err != nil {
+				return g, err
+			}
+
+			if anchors != nil {
+				anchors.Add(ol.Domain, ol.Links)
+			}
+
+			from := h.Id
+			if host {
+				from = ol.Host
+			}
+
+			for _, l := range ol.Links {
+				to := l.Target
+				if host {
+					u, err := url.Parse(l.Target)
+					if err != nil {
+						continue
+					}
+					to = u.Host
+				}
+				g.Add(from, to)
+			}
+		}
+	}
+}
+
+// Write writes the ranks and anchors onto our documents. A document is ranked by its url
+// or by its host for a host graph. Documents that aren't in the graph are left as is.
+// A document we don't index doesn't get anchors.
+func (e *ElasticSearch) Write(ranks map[string]float64, anchors *Anchors, host bool) error {
+	svc := e.Client.Scroll(e.Index + "-*").Type(e.Type).Size(scrollSize).
+		FetchSourceContext(elastic.NewFetchSourceContext(true).Include("host", "index"))
+	defer svc.Clear(context.TODO())
+
+	for {
+		res, err := svc.Do(context.TODO())
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		for _, h := range res.Hits.Hits {
+			doc := &document.Document{}
+			if err := json.Unmarshal(*h.Source, doc); err != nil {
+				return err
+			}
+
+			node := h.Id
+			if host {
+				node = doc.Host
+			}
+
+			d := map[string]interface{}{}
+			if r, ok := ranks[node]; ok {
+				d["rank"] = r
+			}
+			if a := anchors.Get(h.Id); len(a) > 0 && doc.Index {
+				d["anchors"] = a
+			}
+
+			if len(d) == 0 {
+				continue
+			}
+
+			e.Bulk.Add(
+				elastic.NewBulkUpdateRequest().
+					Index(h.Index).
+					Type(e.Type).
+					Id(h.Id).
+					Doc(d),
+			)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package rank
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jivesearch/jivesearch/search/document"
+	"github.com/olivere/elastic"
+)
+
+// scroller serves the first page of a scroll and then no more hits
+func scroller(t *testing.T, first string, bulk *[]byte) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/_bulk":
+			*bulk, _ = ioutil.ReadAll(r.Body)
+			w.Write([]byte(`{"took": 1, "errors": false, "items": [{"update": {"status": 200}}]}`))
+		case r.URL.Path == "/_search/scroll" && r.Method == "DELETE":
+			w.Write([]byte(`{"succeeded": true, "num_freed": 1}`))
+		case r.URL.Path == "/_search/scroll":
+			w.Write([]byte(`{"_scroll_id": "abc", "hits": {"total": 2, "hits": []}}`))
+		case strings.HasSuffix(r.URL.Path, "/_search"):
+			w.Write([]byte(first))
+		default:
+			t.Fatalf("unexpected request %v %v", r.Method, r.URL)
+		}
+	}))
+}
+
+func TestGraph(t *testing.T) {
+	links := `{
+		"_scroll_id": "abc",
+		"hits": {
+			"total": 2,
+			"hits": [
+				{
+					"_index": "search-links", "_type": "link", "_id": "https://a.example.com/",
+					"_source": {"host": "a.example.com", "domain": "example.com", "links": [
+						{"target": "https://a.example.com/about", "anchor": "About"},
+						{"target": "https://b.example.org/", "anchor": "B"}
+					]}
+				},
+				{
+					"_index": "search-links", "_type": "link", "_id": "https://b.example.org/",
+					"_source": {"host": "b.example.org", "domain": "example.org", "links": [
+						{"target": "https://a.example.com/"}
+					]}
+				}
+			]
+		}
+	}`
+
+	for _, c := range []struct {
+		name string
+		host bool
+		want map[string]float64
+	}{
+		{
+			"pages", false,
+			map[string]float64{"https://a.example.com/": 1.1809, "https://a.example.com/about": 0.9096, "https://b.example.org/": 0.9096},
+		},
+		{
+			"hosts", true,
+			map[string]float64{"a.example.com": 1, "b.example.org": 1},
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			var bulk []byte
+			ts := scroller(t, links, &bulk)
+			defer ts.Close()
+
+			e, err := MockService(ts.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			a := NewAnchors(5, 100)
+			g, err := e.Graph(c.host, a)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// anchors are by page even for a host graph
+			if got := a.Get("https://b.example.org/"); !reflect.DeepEqual(got, []string{"B"}) {
+				t.Fatalf("got anchors %q; want B", got)
+			}
+
+			got := g.PageRank(0.85, 100, 1e-9)
+			if len(got) != len(c.want) {
+				t.Fatalf("got %+v; want %+v", got, c.want)
+			}
+
+			for node, r := range c.want {
+				if d := got[node] - r; d > 0.0001 || d < -0.0001 {
+					t.Fatalf("got %v for %q; want %v", got[node], node, r)
+				}
+			}
+		})
+	}
+}
+
+func TestWrite(t *testing.T) {
+	docs := `{
+		"_scroll_id": "abc",
+		"hits": {
+			"total": 2,
+			"hits": [
+				{"_index": "search-english", "_type": "document", "_id": "https://a.example.com/", "_source": {"host": "a.example.com", "index": true}},
+				{"_index": "search-french", "_type": "document", "_id": "https://c.example.net/", "_source": {"host": "c.example.net"}}
+			]
+		}
+	}`
+
+	anchors := NewAnchors(5, 100)
+	anchors.Add("example.org", []document.Link{
+		{Target: "https://a.example.com/", Anchor: "A"},
+		{Target: "https://c.example.net/", Anchor: "C"}, // we don't index it
+	})
+
+	for _, c := range []struct {
+		name    string
+		host    bool
+		ranks   map[string]float64
+		anchors *Anchors
+		want    string
+	}{
+		{
+			"pages", false,
+			map[string]float64{"https://a.example.com/": 1.5, "https://b.example.org/": 0.5}, nil,
+			`{"update":{"_index":"search-english","_type":"document","_id":"https://a.example.com/"}}` + "\n" + `{"doc":{"rank":1.5}}` + "\n",
+		},
+		{
+			"hosts", true,
+			map[string]float64{"c.example.net": 2}, nil,
+			`{"update":{"_index":"search-french","_type":"document","_id":"https://c.example.net/"}}` + "\n" + `{"doc":{"rank":2}}` + "\n",
+		},
+		{
+			"anchors", false,
+			map[string]float64{"https://a.example.com/": 1.5}, anchors,
+			`{"update":{"_index":"search-english","_type":"document","_id":"https://a.example.com/"}}` + "\n" + `{"doc":{"anchors":["A"],"rank":1.5}}` + "\n",
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			var bulk []byte
+			ts := scroller(t, docs, &bulk)
+			defer ts.Close()
+
+			e, err := MockService(ts.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := e.Write(c.ranks, c.anchors, c.host); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := e.Bulk.Flush(); err != nil {
+				t.Fatal(err)
+			}
+
+			if string(bulk) != c.want {
+				t.Fatalf("got %s; want %s", bulk, c.want)
+			}
+		})
+	}
+}
+
+func TestDocumentIndices(t *testing.T) {
+	e := &ElasticSearch{
+		ElasticSearch: &document.ElasticSearch{Index: "search", Type: "document"},
+	}
+
+	names := []string{"search-english", "search-links", "search-images-english", "search-french", "queries", "robots"}
+	want := []string{"search-english", "search-french"}
+
+	if got := e.documentIndices(names); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v; want %+v", got, want)
+	}
+}
+
+func MockService(url string) (*ElasticSearch, error) {
+	client, err := elastic.NewSimpleClient(elastic.SetURL(url))
+	if err != nil {
+		return nil, err
+	}
+
+	bulk, err := client.BulkProcessor().Do(context.TODO())
+	if err != nil {
+		return nil, err
+	}
+
+	return &ElasticSearch{
+		ElasticSearch: &document.ElasticSearch{
+			Client: client, Index: "search", Type: "document",
+		},
+		Bulk: bulk,
+	}, nil
+}
@@ -0,0 +1,108 @@
+// Package rank computes the authority of our documents from the link graph the crawler builds
+package rank
+
+import "math"
+
+// Graph is a directed graph of the links between pages (or hosts)
+type Graph struct {
+	ids   map[string]int
+	nodes []string
+	out   [][]int
+	edges map[[2]int]struct{}
+}
+
+// NewGraph returns an empty Graph
+func NewGraph() *Graph {
+	return &Graph{
+		ids:   map[string]int{},
+		edges: map[[2]int]struct{}{},
+	}
+}
+
+func (g *Graph) id(node string) int {
+	if id, ok := g.ids[node]; ok {
+		return id
+	}
+
+	id := len(g.nodes)
+	g.ids[node] = id
+	g.nodes = append(g.nodes, node)
+	g.out = append(g.out, nil)
+	return id
+}
+
+// Add adds a link. Many links from one node to another count as one and a node can't vote for itself.
+func (g *Graph) Add(from, to string) {
+	f, t := g.id(from), g.id(to)
+	if f == t {
+		return
+	}
+
+	if _, ok := g.edges[[2]int{f, t}]; ok {
+		return
+	}
+
+	g.edges[[2]int{f, t}] = struct{}{}
+	g.out[f] = append(g.out[f], t)
+}
+
+// Len is the number of nodes in the graph
+func (g *Graph) Len() int {
+	return len(g.nodes)
+}
+
+// PageRank computes the PageRank of each node. The rank of a node without
+// links (a dangling node) is spread across all nodes. We stop after
+// the given number of iterations or once the ranks change by less than tolerance.
+// The ranks are scaled so the average is 1...the raw ranks get tiny as the graph grows.
+// http://ilpubs.stanford.edu:8090/422/1/1999-66.pdf
+func (g *Graph) PageRank(damping float64, iterations int, tolerance float64) map[string]float64 {
+	n := float64(len(g.nodes))
+	ranks := map[string]float64{}
+	if n == 0 {
+		return ranks
+	}
+
+	pr := make([]float64, len(g.nodes))
+	for i := range pr {
+		pr[i] = 1 / n
+	}
+
+	for i := 0; i < iterations; i++ {
+		var dangling float64
+		for j, out := range g.out {
+			if len(out) == 0 {
+				dangling += pr[j]
+			}
+		}
+
+		next := make([]float64, len(pr))
+		base := (1-damping)/n + damping*dangling/n
+		for j := range next {
+			next[j] = base
+		}
+
+		for j, out := range g.out {
+			share := damping * pr[j] / float64(len(out))
+			for _, t := range out {
+				next[t] += share
+			}
+		}
+
+		var diff float64
+		for j := range pr {
+			diff += math.Abs(next[j] - pr[j])
+		}
+
+		pr = next
+		if diff < tolerance {
+			break
+		}
+	}
+
+	for j, node := range g.nodes {
+		ranks[node] = pr[j] * n
+	}
+
+	return ranks
+}
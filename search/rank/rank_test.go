@@ -0,0 +1,57 @@
+package rank
+
+import (
+	"math"
+	"testing"
+)
+
+func TestPageRank(t *testing.T) {
+	for _, c := range []struct {
+		name  string
+		links [][2]string
+		want  map[string]float64
+	}{
+		{
+			"empty", nil, map[string]float64{},
+		},
+		{
+			"cycle",
+			[][2]string{{"a", "b"}, {"b", "c"}, {"c", "a"}},
+			map[string]float64{"a": 1, "b": 1, "c": 1},
+		},
+		{
+			"basic",
+			[][2]string{{"a", "b"}, {"a", "c"}, {"b", "c"}, {"c", "a"}, {"d", "c"}},
+			map[string]float64{"a": 1.4901, "b": 0.7833, "c": 1.5766, "d": 0.15},
+		},
+		{
+			"duplicates and self-links don't count",
+			[][2]string{{"a", "b"}, {"a", "b"}, {"a", "c"}, {"b", "c"}, {"c", "a"}, {"c", "c"}, {"d", "c"}},
+			map[string]float64{"a": 1.4901, "b": 0.7833, "c": 1.5766, "d": 0.15},
+		},
+		{
+			"dangling",
+			[][2]string{{"a", "b"}, {"c", "b"}},
+			map[string]float64{"a": 0.6383, "b": 1.7234, "c": 0.6383},
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			g := NewGraph()
+			for _, l := range c.links {
+				g.Add(l[0], l[1])
+			}
+
+			got := g.PageRank(0.85, 100, 1e-9)
+
+			if len(got) != len(c.want) || g.Len() != len(c.want) {
+				t.Fatalf("got %+v; want %+v", got, c.want)
+			}
+
+			for node, r := range c.want {
+				if math.Abs(got[node]-r) > 0.0001 {
+					t.Fatalf("got %v for %q; want %v", got[node], node, r)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package search
+
+import (
+	"github.com/jivesearch/jivesearch/search/vote"
+	"github.com/olivere/elastic"
+)
+
+// Ranker adds a signal other than the text (e.g. the authority of a page)
+// to the score of a document. The score is multiplied by each Ranker's function.
+type Ranker interface {
+	Function() elastic.ScoreFunction
+}
+
+// LinkRank ranks a document by its PageRank (see the rank package).
+// The ranks average 1 so log(2 + rank) keeps a page that nobody
+// links to in the running and a page everybody links to from running away with it.
+type LinkRank struct {
+	Factor float64
+}
+
+// Function returns the score function of the rank
+func (l *LinkRank) Function() elastic.ScoreFunction {
+	return elastic.NewFieldValueFactorFunction().
+		Field("rank").
+		Factor(l.Factor).
+		Modifier("log2p").
+		Missing(0)
+}
+
+// VoteRank ranks a document by our users' votes for the query. Older votes count
+// for less (see vote.PostgreSQL.HalfLife). The votes for the other pages of a
+// domain count toward its pages too, though DomainWeight should be the lesser.
+// Votes aren't a Ranker as they differ from one query to the next.
+type VoteRank struct {
+	Weight       float64
+	DomainWeight float64
+}
+
+// voteScript multiplies the score by 1 + weight * log(1 + |votes|) or divides it by as much for
+// a page that was voted down. A document's domain is found by trimming its host's labels one by one.
+const voteScript = `double v = 0;
+String id = doc['id'].value;
+if (params.urls.containsKey(id)) { v += params.urls[id]; }
+String h = doc['host'].value;
+while (h != null) {
+	if (params.domains.containsKey(h)) { v += params.domain_weight * params.domains[h]; break; }
+	int i = h.indexOf('.');
+	h = i < 0 ? null : h.substring(i + 1);
+}
+double m = 1 + params.weight * Math.log(1 + Math.abs(v));
+return v < 0 ? 1 / m : m;`
+
+// Function returns the score function of the votes. The votes are passed as
+// params so the script is the same (and cached by elasticsearch) for every query.
+func (r *VoteRank) Function(votes []vote.Result) elastic.ScoreFunction {
+	urls, domains := map[string]float64{}, map[string]float64{}
+	for _, v := range votes {
+		urls[v.URL] += v.Score
+		if v.Domain != "" {
+			domains[v.Domain] += v.Score
+		}
+	}
+
+	return elastic.NewScriptFunction(
+		elastic.NewScript(voteScript).Lang("painless").Params(map[string]interface{}{
+			"urls":          urls,
+			"domains":       domains,
+			"weight":        r.Weight,
+			"domain_weight": r.DomainWeight,
+		}),
+	)
+}
@@ -0,0 +1,133 @@
+package search
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jivesearch/jivesearch/search/vote"
+	"golang.org/x/text/language"
+)
+
+func TestLinkRank(t *testing.T) {
+	src, err := (&LinkRank{Factor: 1.5}).Function().Source()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := json.Marshal(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `{"factor":1.5,"field":"rank","missing":0,"modifier":"log2p"}`
+	if string(got) != want {
+		t.Fatalf("got %s; want %s", got, want)
+	}
+}
+
+func TestFetchRankers(t *testing.T) {
+	for _, c := range []struct {
+		name    string
+		rankers []Ranker
+		want    bool
+	}{
+		{"none", nil, false},
+		{"link rank", []Ranker{&LinkRank{Factor: 1}}, true},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			var body []byte
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ = ioutil.ReadAll(r.Body)
+				w.Write([]byte(`{"took": 1, "hits": {"total": 0, "hits": []}}`))
+			}))
+			defer ts.Close()
+
+			e, err := MockService(ts.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			e.Rankers = c.rankers
+
+			if _, err := e.Fetch("bob dylan", Filter{}, language.English, language.MustParseRegion("US"), 10, 0, nil); err != nil {
+				t.Fatal(err)
+			}
+
+			want := `"function_score":{"boost_mode":"multiply","field_value_factor":{"factor":1,"field":"rank","missing":0,"modifier":"log2p"},"query":{"bool":`
+			if got := strings.Contains(string(body), want); got != c.want {
+				t.Fatalf("got request %s; want function_score %v", body, c.want)
+			}
+		})
+	}
+}
+
+func TestVoteRank(t *testing.T) {
+	votes := []vote.Result{
+		{URL: "https://www.example.com/", Domain: "example.com", Votes: 3, Score: 2.5},
+		{URL: "https://blog.example.com/bob-dylan", Domain: "example.com", Votes: -1, Score: -0.5},
+		{URL: "http://www.dylan.com/", Domain: "dylan.com", Votes: 1, Score: 1},
+	}
+
+	src, err := (&VoteRank{Weight: 2, DomainWeight: 0.25}).Function(votes).Source()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := json.Marshal(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `{"script":{"lang":"painless","params":{"domain_weight":0.25,"domains":{"dylan.com":1,"example.com":2},` +
+		`"urls":{"http://www.dylan.com/":1,"https://blog.example.com/bob-dylan":-0.5,"https://www.example.com/":2.5},"weight":2},`
+	if !strings.HasPrefix(string(got), want) || !strings.Contains(string(got), `"source":"double v = 0;`) {
+		t.Fatalf("got %s; want %s...", got, want)
+	}
+}
+
+func TestFetchVotes(t *testing.T) {
+	votes := []vote.Result{{URL: "https://www.example.com/", Domain: "example.com", Votes: 3, Score: 2.5}}
+
+	for _, c := range []struct {
+		name   string
+		voteRk *VoteRank
+		votes  []vote.Result
+		want   bool
+	}{
+		{"no votes", &VoteRank{Weight: 1, DomainWeight: 0.2}, nil, false},
+		{"votes ignored", nil, votes, false},
+		{"votes", &VoteRank{Weight: 1, DomainWeight: 0.2}, votes, true},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			var body []byte
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ = ioutil.ReadAll(r.Body)
+				w.Write([]byte(`{"took": 1, "hits": {"total": 0, "hits": []}}`))
+			}))
+			defer ts.Close()
+
+			e, err := MockService(ts.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			e.Votes = c.voteRk
+
+			if _, err := e.Fetch("bob dylan", Filter{}, language.English, language.MustParseRegion("US"), 10, 0, c.votes); err != nil {
+				t.Fatal(err)
+			}
+
+			// votes are part of the score rather than a sort
+			if strings.Contains(string(body), `"sort"`) {
+				t.Fatalf("got request %s; want no sort", body)
+			}
+
+			want := `"urls":{"https://www.example.com/":2.5}`
+			if got := strings.Contains(string(body), want); got != c.want {
+				t.Fatalf("got request %s; want votes %v", body, c.want)
+			}
+		})
+	}
+}
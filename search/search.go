@@ -12,7 +12,12 @@ import (
 
 // Fetcher outlines the methods used to retrieve the core search results
 type Fetcher interface {
-	Fetch(q string, lang language.Tag, region language.Region, number int, page int, votes []vote.Result) (*Results, error)
+	Fetch(q string, filter Filter, lang language.Tag, region language.Region, number int, page int, votes []vote.Result) (*Results, error)
+}
+
+// ImageFetcher outlines the methods used to retrieve image search results
+type ImageFetcher interface {
+	FetchImages(q string, lang language.Tag, region language.Region, number int, offset int) (*Results, error)
 }
 
 // Results are the core search results from a query
@@ -24,6 +29,7 @@ type Results struct {
 	Last       string               `json:"last"`
 	Pagination []string             `json:"-"`
 	Documents  []*document.Document `json:"links"`
+	Images     []*document.Image    `json:"images,omitempty"`
 }
 
 // AddPagination adds pagination to the search results
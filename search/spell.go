@@ -0,0 +1,79 @@
+package search
+
+import (
+	"context"
+	"strings"
+
+	"github.com/olivere/elastic"
+	"golang.org/x/text/language"
+)
+
+// Speller outlines the methods used to correct the spelling of a query ("Did you mean?")
+type Speller interface {
+	Spell(q string, lang language.Tag, size int) ([]Correction, error)
+}
+
+// Correction is a query with its misspelled words corrected
+type Correction struct {
+	Text  string  `json:"text"`
+	Score float64 `json:"score"` // how likely the correction is...higher is better
+}
+
+const spellSuggest = "spell"
+
+// spellFields are the fields we take the corrections from. They use the standard
+// analyzer so a correction has the words as they were written rather than their stems.
+var spellFields = []string{"body", "title"}
+
+// spellCollate only keeps the corrections that match a document
+const spellCollate = `{"match": {"body": {"query": "{{suggestion}}", "operator": "and"}}}`
+
+// Spell corrects the misspelled words of a query with the words of our documents in its language.
+// The phrase suggester weighs each combination of corrected words by how often the words occur in
+// our documents, so "jimi hendrix" beats "jimi hendricks" for "jimi hendrixx".
+// The query itself is one of the options if its words are likely as they are.
+// A query with operators (site:, "quoted phrases", etc) is left as is.
+// https://www.elastic.co/guide/en/elasticsearch/reference/6.2/search-suggesters-phrase.html
+func (e *ElasticSearch) Spell(q string, lang language.Tag, size int) ([]Correction, error) {
+	corrections := []Correction{}
+
+	q = strings.Join(strings.Fields(q), " ")
+	if q == "" || ParseQuery(q).Text != q {
+		return corrections, nil
+	}
+
+	a, err := e.Analyzer(lang)
+	if err != nil {
+		return corrections, err
+	}
+
+	s := elastic.NewPhraseSuggester(spellSuggest).
+		Text(q).
+		Field(spellFields[0]).
+		Size(size).
+		MaxErrors(2).
+		CollateQuery(spellCollate).
+		CollatePrune(false)
+
+	for _, f := range spellFields {
+		s = s.CandidateGenerator(
+			elastic.NewDirectCandidateGenerator(f).SuggestMode("always").MinWordLength(3),
+		)
+	}
+
+	out, err := e.Client.Search().Index(e.IndexName(a)).
+		Suggester(s).
+		Size(0).
+		Do(context.TODO())
+	if err != nil {
+		return corrections, err
+	}
+
+	for _, sug := range out.Suggest[spellSuggest] {
+		for _, opt := range sug.Options {
+			corrections = append(corrections, Correction{Text: opt.Text, Score: opt.Score})
+		}
+	}
+
+	return corrections, nil
+}
@@ -0,0 +1,108 @@
+package search
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/text/language"
+)
+
+func TestSpell(t *testing.T) {
+	for _, c := range []struct {
+		name  string
+		query string
+		lang  language.Tag
+		resp  string
+		path  string // of the request...empty if we don't search
+		want  []Correction
+	}{
+		{
+			name:  "misspelled",
+			query: "jimi  hendrixx",
+			lang:  language.English,
+			resp: `{
+				"took": 3,
+				"timed_out": false,
+				"_shards": {"total": 5, "successful": 5, "skipped": 0, "failed": 0},
+				"hits": {"total": 12, "max_score": 0, "hits": []},
+				"suggest": {
+					"spell": [
+						{
+							"text": "jimi hendrixx",
+							"offset": 0,
+							"length": 13,
+							"options": [
+								{"text": "jimi hendrix", "score": 0.0812},
+								{"text": "jimi hendricks", "score": 0.0031},
+								{"text": "jimi hendrixx", "score": 0.0002}
+							]
+						}
+					]
+				}
+			}`,
+			path: "/search-english/_search",
+			want: []Correction{
+				{Text: "jimi hendrix", Score: 0.0812},
+				{Text: "jimi hendricks", Score: 0.0031},
+				{Text: "jimi hendrixx", Score: 0.0002},
+			},
+		},
+		{
+			name:  "operators",
+			query: "jimi hendrixx site:example.com",
+			lang:  language.English,
+			want:  []Correction{},
+		},
+		{
+			name:  "phrase",
+			query: `"jimi hendrixx"`,
+			lang:  language.English,
+			want:  []Correction{},
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			var path string
+			var body []byte
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				path = r.URL.Path
+				body, _ = ioutil.ReadAll(r.Body)
+				w.Write([]byte(c.resp))
+			}))
+			defer ts.Close()
+
+			e, err := MockService(ts.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := e.Spell(c.query, c.lang, 3)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if path != c.path {
+				t.Fatalf("got path %q; want %q", path, c.path)
+			}
+
+			if c.path != "" {
+				for _, s := range []string{
+					`"text":"jimi hendrixx"`,
+					`"direct_generator":[{"field":"body","min_word_length":3,"suggest_mode":"always"},{"field":"title","min_word_length":3,"suggest_mode":"always"}]`,
+					`"collate":{"prune":false,"query":"{\"match\": {\"body\": {\"query\": \"{{suggestion}}\", \"operator\": \"and\"}}}"}`,
+				} {
+					if !strings.Contains(string(body), s) {
+						t.Fatalf("got request %s; want %s", body, s)
+					}
+				}
+			}
+
+			if !reflect.DeepEqual(got, c.want) {
+				t.Fatalf("got %+v; want %+v", got, c.want)
+			}
+		})
+	}
+}
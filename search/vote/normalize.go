@@ -0,0 +1,83 @@
+package vote
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/language"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Stemmers reduce the words of a query in a language to their stem so that
+// "jimi hendrix songs" and "jimi hendrix song" share their votes.
+// We only stem languages we have a Stemmer for.
+var Stemmers = map[language.Base]func(word string) string{
+	mustBase(language.English): stemEnglish,
+}
+
+func mustBase(t language.Tag) language.Base {
+	b, _ := t.Base()
+	return b
+}
+
+// Normalize returns the form of a query that we match votes by so that "jimi hendrix",
+// "Jimi  Hendrix" and "jimi hendrix?" are the same query: the unicode
+// compatibility form (NFKC), lowercased, without punctuation and single-spaced.
+// The words are stemmed with the Stemmer of the query's language if stem is true.
+func Normalize(q string, lang language.Tag, stem bool) string {
+	q = strings.ToLower(norm.NFKC.String(q))
+
+	// an apostrophe is part of a word ("don't" is "dont") and other punctuation separates words ("ac/dc" is "ac dc")
+	words := strings.FieldsFunc(q, func(r rune) bool {
+		return unicode.IsSpace(r) || unicode.IsSymbol(r) || unicode.IsPunct(r) && !isApostrophe(r)
+	})
+
+	var stemmer func(string) string
+	if stem {
+		b, _ := lang.Base()
+		stemmer = Stemmers[b]
+	}
+
+	normalized := []string{}
+	for _, w := range words {
+		w = strings.Map(func(r rune) rune {
+			if isApostrophe(r) {
+				return -1
+			}
+			return r
+		}, w)
+
+		if w == "" {
+			continue
+		}
+
+		if stemmer != nil {
+			w = stemmer(w)
+		}
+
+		normalized = append(normalized, w)
+	}
+
+	return strings.Join(normalized, " ")
+}
+
+func isApostrophe(r rune) bool {
+	return r == '\'' || r == '’'
+}
+
+// stemEnglish is the "S" stemmer, which only conflates singular and plural nouns.
+// It is a lot less aggressive than Porter's, which suits a short query.
+// Harman, D. (1991). How effective is suffixing? JASIS 42(1), 7-15.
+func stemEnglish(w string) string {
+	switch {
+	case len(w) < 4:
+		return w
+	case strings.HasSuffix(w, "ies") && !strings.HasSuffix(w, "eies") && !strings.HasSuffix(w, "aies"):
+		return w[:len(w)-3] + "y"
+	case strings.HasSuffix(w, "es") && !strings.HasSuffix(w, "aes") && !strings.HasSuffix(w, "ees") && !strings.HasSuffix(w, "oes"):
+		return w[:len(w)-1]
+	case strings.HasSuffix(w, "s") && !strings.HasSuffix(w, "us") && !strings.HasSuffix(w, "ss"):
+		return w[:len(w)-1]
+	}
+	return w
+}
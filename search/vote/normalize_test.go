@@ -0,0 +1,57 @@
+package vote
+
+import (
+	"testing"
+
+	"golang.org/x/text/language"
+)
+
+func TestNormalize(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		q    string
+		lang language.Tag
+		stem bool
+		want string
+	}{
+		{"basic", "jimi hendrix", language.English, false, "jimi hendrix"},
+		{"case & whitespace", "  Jimi \t Hendrix ", language.English, false, "jimi hendrix"},
+		{"punctuation", "jimi hendrix?", language.English, false, "jimi hendrix"},
+		{"separated by punctuation", "ac/dc & guns-n-roses", language.English, false, "ac dc guns n roses"},
+		{"apostrophe", "don’t stop believin'", language.English, false, "dont stop believin"},
+		{"compatibility form", "ｊｉｍｉ ﬁre", language.English, false, "jimi fire"},
+		{"accents kept", "Beyoncé", language.French, false, "beyoncé"},
+		{"stemmed", "jimi hendrix songs", language.English, true, "jimi hendrix song"},
+		{"stemmed ies", "jimi hendrix biographies", language.AmericanEnglish, true, "jimi hendrix biography"},
+		{"not stemmed", "jimi hendrix songs", language.English, false, "jimi hendrix songs"},
+		{"no stemmer", "canciones de jimi hendrix", language.Spanish, true, "canciones de jimi hendrix"},
+		{"only punctuation", "?!", language.English, false, ""},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			if got := Normalize(c.q, c.lang, c.stem); got != c.want {
+				t.Fatalf("got %q; want %q", got, c.want)
+			}
+		})
+	}
+}
+
+func TestStemEnglish(t *testing.T) {
+	for _, c := range []struct {
+		word string
+		want string
+	}{
+		{"songs", "song"},
+		{"queries", "query"},
+		{"shoes", "shoe"},
+		{"glass", "glass"},
+		{"virus", "virus"},
+		{"bus", "bus"},
+		{"guitar", "guitar"},
+	} {
+		t.Run(c.word, func(t *testing.T) {
+			if got := stemEnglish(c.word); got != c.want {
+				t.Fatalf("got %q; want %q", got, c.want)
+			}
+		})
+	}
+}
@@ -4,25 +4,31 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"time"
+
+	"golang.org/x/text/language"
 )
 
 // PostgreSQL contains our client and database info
 type PostgreSQL struct {
 	*sql.DB
-	Table string
+	Table    string
+	HalfLife time.Duration // a vote counts half as much after this long...0 for no decay
+	Limits   []Limit       // how often an identity can vote
+	Stem     bool          // match votes by the stems of the query's words (see Normalize)
 }
 
-// ErrScoreFnExists indicates an issue setting up our default score() function
-var ErrScoreFnExists = errors.New(`pq: function "score" already exists with same argument types`)
+// ErrScoreFnExists indicates an issue setting up our default normalized_score() function
+var ErrScoreFnExists = errors.New(`pq: function "normalized_score" already exists with same argument types`)
 
 // Get retrieves the urls & vote tallies for a given query
-// score($1,$2,$3) is a PostgreSQL stored procedure to return
-// votes for the matching query.
-func (p *PostgreSQL) Get(query string, limit int) ([]Result, error) {
+// normalized_score($1,$2) is a PostgreSQL stored procedure to return
+// votes for the matching (normalized) query.
+func (p *PostgreSQL) Get(query string, lang language.Tag, limit int) ([]Result, error) {
 	votes := []Result{}
 
 	// Pagination here won't work...???
-	rows, err := p.DB.Query("SELECT * FROM score($1) LIMIT $2", query, limit)
+	rows, err := p.DB.Query("SELECT * FROM normalized_score($1, $2) LIMIT $3", Normalize(query, lang, p.Stem), p.HalfLife.Hours()/24, limit)
 	if err != nil {
 		return votes, err
 	}
@@ -30,7 +36,7 @@ func (p *PostgreSQL) Get(query string, limit int) ([]Result, error) {
 	defer rows.Close()
 	for rows.Next() {
 		res := Result{}
-		if err := rows.Scan(&res.URL, &res.Votes); err != nil {
+		if err := rows.Scan(&res.URL, &res.Domain, &res.Votes, &res.Score); err != nil {
 			return votes, err
 		}
 		votes = append(votes, res)
@@ -43,17 +49,138 @@ func (p *PostgreSQL) Get(query string, limit int) ([]Result, error) {
 	return votes, err
 }
 
+// DomainVotes returns the vote total across all queries for a domain
+func (p *PostgreSQL) DomainVotes(domain string) (int, error) {
+	var votes int
+
+	err := p.DB.QueryRow(fmt.Sprintf(
+		`SELECT COALESCE(SUM(vote), 0) FROM %s WHERE domain=$1`, p.Table), domain,
+	).Scan(&votes)
+
+	return votes, err
+}
+
 // Insert saves a vote to PostgreSQL
 // using %s here for table name s/b safe from sql injection???
+// A voter gets one ballot per (normalized) query & url...voting again changes their vote.
+// A vote must have an identity (see Identity). The identity rotates daily so a limit
+// of votes per day is per calendar day.
 func (p *PostgreSQL) Insert(v *Vote) error {
+	v.Normalized = Normalize(v.Query, v.Language, p.Stem)
+
+	if err := v.setBallot(); err != nil {
+		return err
+	}
+
+	for _, l := range p.Limits {
+		var cnt int
+		if err := p.DB.QueryRow(fmt.Sprintf(
+			`SELECT count(*) FROM %s WHERE identity=$1 AND created > $2`, p.Table), v.Identity, time.Now().Add(-l.Per),
+		).Scan(&cnt); err != nil {
+			return err
+		}
+
+		if cnt >= l.Votes {
+			return ErrRateLimited
+		}
+	}
+
 	_, err := p.DB.Exec(fmt.Sprintf(
-		`INSERT INTO %s (query, url, domain, vote, date)
-		VALUES ($1, $2, $3, $4, $5)`, p.Table), v.Query, v.URL, v.Domain, v.Vote, v.Date,
+		`INSERT INTO %s (query, url, domain, vote, date, identity, ballot, normalized)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (ballot) DO UPDATE SET vote=EXCLUDED.vote`, p.Table),
+		v.Query, v.URL, v.Domain, v.Vote, v.Date, v.Identity, v.Ballot, v.Normalized,
 	)
 
 	return err
 }
 
+// Bursts returns the urls that got at least min votes for a query since a time.
+// A url that many identities vote for at once is likely somebody gaming our results.
+func (p *PostgreSQL) Bursts(since time.Time, min int) ([]Burst, error) {
+	bursts := []Burst{}
+
+	rows, err := p.DB.Query(fmt.Sprintf(
+		`SELECT query, url, count(*) AS votes, min(created), max(created)
+		FROM %s
+		WHERE created > $1
+		GROUP BY query, url
+		HAVING count(*) >= $2
+		ORDER BY votes DESC`, p.Table), since, min,
+	)
+	if err != nil {
+		return bursts, err
+	}
+
+	defer rows.Close()
+	for rows.Next() {
+		b := Burst{}
+		if err := rows.Scan(&b.Query, &b.URL, &b.Votes, &b.From, &b.To); err != nil {
+			return bursts, err
+		}
+		bursts = append(bursts, b)
+	}
+
+	return bursts, rows.Err()
+}
+
+// Purge deletes the votes of a burst and returns how many there were
+func (p *PostgreSQL) Purge(b Burst) (int64, error) {
+	res, err := p.DB.Exec(fmt.Sprintf(
+		`DELETE FROM %s WHERE query=$1 AND url=$2 AND created BETWEEN $3 AND $4`, p.Table),
+		b.Query, b.URL, b.From, b.To,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+// normalizeBatch is how many votes we normalize at a time
+const normalizeBatch = 1000
+
+// normalize sets the normalized query of the votes from before we had one. We don't know
+// the language of those queries so they are stemmed as English, our default language,
+// else "songs" would never match the stemmed "song" we look them up by.
+func (p *PostgreSQL) normalize() error {
+	for {
+		rows, err := p.DB.Query(fmt.Sprintf(
+			`SELECT DISTINCT query FROM %s WHERE normalized IS NULL LIMIT $1`, p.Table), normalizeBatch,
+		)
+		if err != nil {
+			return err
+		}
+
+		queries := []string{}
+		for rows.Next() {
+			var q string
+			if err := rows.Scan(&q); err != nil {
+				rows.Close()
+				return err
+			}
+			queries = append(queries, q)
+		}
+
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, q := range queries {
+			if _, err := p.DB.Exec(fmt.Sprintf(
+				`UPDATE %s SET normalized=$1 WHERE query=$2 AND normalized IS NULL`, p.Table), Normalize(q, language.English, p.Stem), q,
+			); err != nil {
+				return err
+			}
+		}
+
+		if len(queries) < normalizeBatch {
+			return nil
+		}
+	}
+}
+
 // Setup creates our table
 func (p *PostgreSQL) Setup() error {
 	_, err := p.DB.Exec(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
@@ -62,7 +189,11 @@ func (p *PostgreSQL) Setup() error {
 			url text,
 			domain text,
 			vote smallint,
-			date date
+			date date,
+			identity text,
+			ballot text,
+			created timestamptz DEFAULT now(),
+			normalized text
 		);`, p.Table),
 	)
 
@@ -70,26 +201,75 @@ func (p *PostgreSQL) Setup() error {
 		return err
 	}
 
+	// tables from before we identified voters
+	if _, err = p.DB.Exec(fmt.Sprintf(`ALTER TABLE %s
+		ADD COLUMN IF NOT EXISTS identity text,
+		ADD COLUMN IF NOT EXISTS ballot text,
+		ADD COLUMN IF NOT EXISTS created timestamptz DEFAULT now(),
+		ADD COLUMN IF NOT EXISTS normalized text;`, p.Table)); err != nil {
+		return err
+	}
+
+	if err := p.normalize(); err != nil {
+		return err
+	}
+
 	if _, err = p.DB.Exec(fmt.Sprintf("CREATE INDEX IF NOT EXISTS query_idx ON %s (query);", p.Table)); err != nil {
 		return err
 	}
 
-	// Create a default score() function.
+	if _, err = p.DB.Exec(fmt.Sprintf("CREATE INDEX IF NOT EXISTS normalized_idx ON %s (normalized);", p.Table)); err != nil {
+		return err
+	}
+
+	// Tables from before we normalized queries have one vote per identity per exact query so
+	// "Cats" and "cats" may both have a vote...we keep the latest. Their identity_idx is replaced.
+	if _, err = p.DB.Exec(fmt.Sprintf(`DELETE FROM %[1]s a USING %[1]s b
+		WHERE a.identity = b.identity AND a.normalized = b.normalized AND a.url = b.url AND a.id < b.id;`, p.Table)); err != nil {
+		return err
+	}
+
+	if _, err = p.DB.Exec("DROP INDEX IF EXISTS identity_idx;"); err != nil {
+		return err
+	}
+
+	// one ballot per voter per query & url no matter the day (a vote from before we had ballots is NULL so never conflicts).
+	// The identity rotates daily so it is only good for rate limiting.
+	if _, err = p.DB.Exec("DROP INDEX IF EXISTS identity_normalized_idx;"); err != nil {
+		return err
+	}
+
+	if _, err = p.DB.Exec(fmt.Sprintf("CREATE UNIQUE INDEX IF NOT EXISTS ballot_idx ON %s (ballot);", p.Table)); err != nil {
+		return err
+	}
+
+	if _, err = p.DB.Exec(fmt.Sprintf("CREATE INDEX IF NOT EXISTS identity_created_idx ON %s (identity, created);", p.Table)); err != nil {
+		return err
+	}
+
+	if _, err = p.DB.Exec(fmt.Sprintf("CREATE INDEX IF NOT EXISTS created_idx ON %s (created);", p.Table)); err != nil {
+		return err
+	}
+
+	// Create a default normalized_score() function.
 	// Do not replace an existing fn if it already exists.
 	// This function is meant to be overidden.
-	scoreFn := `
-		CREATE FUNCTION score(q text)
-		RETURNS TABLE(url text, votes bigint)
+	// A vote's weight halves every half_life days (0 for no decay).
+	// It isn't score() as we'd keep an existing score() that matches the query rather than the normalized query.
+	scoreFn := fmt.Sprintf(`
+		CREATE FUNCTION normalized_score(q text, half_life double precision)
+		RETURNS TABLE(url text, domain text, votes bigint, score double precision)
 		AS 
 		$$
-			SELECT  url, sum(vote) AS votes
-			FROM votes
-			WHERE lower(query)=lower(q) 
-			GROUP BY url
-			ORDER BY votes DESC  
+			SELECT  url, domain, sum(vote) AS votes,
+				sum(vote * CASE WHEN half_life > 0 THEN power(0.5, (current_date - date) / half_life) ELSE 1 END) AS score
+			FROM %s
+			WHERE normalized=q
+			GROUP BY url, domain
+			ORDER BY score DESC  
 		$$ 
 		LANGUAGE sql;
-	`
+	`, p.Table)
 
 	_, err = p.DB.Exec(scoreFn)
 
@@ -1,58 +1,74 @@
 package vote
 
 import (
+	"encoding/hex"
 	"reflect"
 	"testing"
+	"time"
 
+	"golang.org/x/text/language"
 	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
 )
 
 func TestGet(t *testing.T) {
 	type u struct {
-		url   string
-		votes int
+		url    string
+		domain string
+		votes  int
+		score  float64
 	}
 
 	for _, c := range []struct {
-		name string
-		q    string
-		l    int
-		o    int
-		urls []u
-		want []Result
+		name     string
+		q        string
+		lang     language.Tag
+		norm     string
+		l        int
+		halfLife time.Duration
+		urls     []u
+		want     []Result
 	}{
 		{
 			name: "basic",
 			q:    "a search term",
+			lang: language.English,
+			norm: "a search term",
 			l:    1,
-			o:    1,
 			urls: []u{
-				{"https://www.example.com/a-path-to-nowhere", 2},
+				{"https://www.example.com/a-path-to-nowhere", "example.com", 2, 2},
 			},
 			want: []Result{
 				Result{
-					URL:   "https://www.example.com/a-path-to-nowhere",
-					Votes: 2,
+					URL:    "https://www.example.com/a-path-to-nowhere",
+					Domain: "example.com",
+					Votes:  2,
+					Score:  2,
 				},
 			},
 		},
 		{
-			name: "multiple urls",
-			q:    "another search term",
-			l:    1,
-			o:    1,
+			name:     "multiple urls",
+			q:        "Another  search-term?",
+			lang:     language.English,
+			norm:     "another search term",
+			l:        1,
+			halfLife: 180 * 24 * time.Hour,
 			urls: []u{
-				{"http://example.com/?a=query", 150},
-				{"https://www.example.com/a-path-to-somewhere", -419},
+				{"http://example.com/?a=query", "example.com", 150, 122.5},
+				{"https://www.example.com/a-path-to-somewhere", "example.com", -419, -210.25},
 			},
 			want: []Result{
 				Result{
-					URL:   "http://example.com/?a=query",
-					Votes: 150,
+					URL:    "http://example.com/?a=query",
+					Domain: "example.com",
+					Votes:  150,
+					Score:  122.5,
 				},
 				Result{
-					URL:   "https://www.example.com/a-path-to-somewhere",
-					Votes: -419,
+					URL:    "https://www.example.com/a-path-to-somewhere",
+					Domain: "example.com",
+					Votes:  -419,
+					Score:  -210.25,
 				},
 			},
 		},
@@ -64,23 +80,22 @@ func TestGet(t *testing.T) {
 			}
 			defer db.Close()
 
-			urls := []string{}
-			rows := sqlmock.NewRows([]string{"url", "vote"})
+			rows := sqlmock.NewRows([]string{"url", "domain", "votes", "score"})
 			for _, u := range c.urls {
-				urls = append(urls, u.url)
-				rows = rows.AddRow(u.url, u.votes)
+				rows = rows.AddRow(u.url, u.domain, u.votes, u.score)
 			}
 
-			mock.ExpectQuery("SELECT").
-				WithArgs(c.q, c.l).
+			mock.ExpectQuery(`SELECT \* FROM normalized_score\(\$1, \$2\)`).
+				WithArgs(c.norm, c.halfLife.Hours()/24, c.l).
 				WillReturnRows(rows)
 
 			p := &PostgreSQL{
-				DB:    db,
-				Table: "votes",
+				DB:       db,
+				Table:    "votes",
+				HalfLife: c.halfLife,
 			}
 
-			got, err := p.Get(c.q, c.l)
+			got, err := p.Get(c.q, c.lang, c.l)
 			if err != nil {
 				t.Fatal(err)
 			}
@@ -96,32 +111,126 @@ func TestGet(t *testing.T) {
 	}
 }
 
+func TestDomainVotes(t *testing.T) {
+	for _, c := range []struct {
+		name   string
+		domain string
+		want   int
+	}{
+		{"upvoted", "example.com", 14},
+		{"downvoted", "cat.com", -3},
+		{"no votes", "nothing.org", 0},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+
+			mock.ExpectQuery("SELECT COALESCE").
+				WithArgs(c.domain).
+				WillReturnRows(sqlmock.NewRows([]string{"votes"}).AddRow(c.want))
+
+			p := &PostgreSQL{
+				DB:    db,
+				Table: "votes",
+			}
+
+			got, err := p.DomainVotes(c.domain)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Fatal(err)
+			}
+
+			if got != c.want {
+				t.Fatalf("got %d; want: %d", got, c.want)
+			}
+		})
+	}
+}
+
 func TestInsert(t *testing.T) {
 	for _, c := range []struct {
-		name string
-		q    string
-		url  string
-		vote int
+		name     string
+		q        string
+		url      string
+		vote     int
+		identity string
+		limits   []Limit
+		counts   []int // the votes the identity made within each limit
+		stem     bool
+		norm     string
+		err      error
 	}{
 		{
-			name: "upvote",
-			q:    "a search term",
-			url:  "https://www.example.com/a-path-to-nowhere",
-			vote: 1,
+			name:     "upvote",
+			q:        "a search term",
+			url:      "https://www.example.com/a-path-to-nowhere",
+			vote:     1,
+			identity: "abc123",
+			norm:     "a search term",
+		},
+		{
+			name:     "downvote",
+			q:        "Cats!",
+			url:      "https://www.cat.com/?something=nothing",
+			vote:     -1,
+			identity: "abc123",
+			norm:     "cats",
+		},
+		{
+			name:     "stemmed",
+			q:        "Cats!",
+			url:      "https://www.cat.com/?something=nothing",
+			vote:     -1,
+			identity: "abc123",
+			stem:     true,
+			norm:     "cat",
 		},
 		{
-			name: "downvote",
-			q:    "cats",
-			url:  "https://www.cat.com/?something=nothing",
-			vote: -1,
+			name:     "within limits",
+			q:        "cats",
+			url:      "https://www.cat.com/",
+			vote:     1,
+			identity: "abc123",
+			limits:   []Limit{{Votes: 10, Per: time.Hour}, {Votes: 50, Per: 24 * time.Hour}},
+			counts:   []int{9, 20},
+			norm:     "cats",
+		},
+		{
+			name:     "rate limited",
+			q:        "cats",
+			url:      "https://www.cat.com/",
+			vote:     1,
+			identity: "abc123",
+			limits:   []Limit{{Votes: 10, Per: time.Hour}, {Votes: 50, Per: 24 * time.Hour}},
+			counts:   []int{3, 50},
+			err:      ErrRateLimited,
+		},
+		{
+			name:   "no identity",
+			q:      "cats",
+			url:    "https://www.cat.com/",
+			vote:   1,
+			limits: []Limit{{Votes: 10, Per: time.Hour}},
+			err:    ErrNoIdentity,
 		},
 	} {
 		t.Run(c.name, func(t *testing.T) {
 			v := &Vote{
-				Query: c.q,
-				URL:   c.url,
-				Vote:  c.vote,
-				Date:  now(),
+				Query:    c.q,
+				URL:      c.url,
+				Vote:     c.vote,
+				Date:     now(),
+				Identity: c.identity,
+				Language: language.English,
+			}
+			if c.identity != "" {
+				v.voter = []byte("voter")
 			}
 
 			db, mock, err := sqlmock.New()
@@ -130,17 +239,27 @@ func TestInsert(t *testing.T) {
 			}
 			defer db.Close()
 
-			mock.ExpectExec("INSERT INTO votes").
-				WithArgs(v.Query, v.URL, v.Domain, v.Vote, v.Date).
-				WillReturnResult(sqlmock.NewResult(1, 1))
+			for _, cnt := range c.counts {
+				mock.ExpectQuery("SELECT count").
+					WithArgs(v.Identity, sqlmock.AnyArg()).
+					WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(cnt))
+			}
+
+			if c.err == nil {
+				mock.ExpectExec("INSERT INTO votes .* ON CONFLICT").
+					WithArgs(v.Query, v.URL, v.Domain, v.Vote, v.Date, v.Identity, hex.EncodeToString(hash(v.voter, c.norm, c.url)), c.norm).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+			}
 
 			p := &PostgreSQL{
-				DB:    db,
-				Table: "votes",
+				DB:     db,
+				Table:  "votes",
+				Limits: c.limits,
+				Stem:   c.stem,
 			}
 
-			if err := p.Insert(v); err != nil {
-				t.Fatal(err)
+			if err := p.Insert(v); err != c.err {
+				t.Fatalf("got err %v; want %v", err, c.err)
 			}
 
 			if err := mock.ExpectationsWereMet(); err != nil {
@@ -150,6 +269,79 @@ func TestInsert(t *testing.T) {
 	}
 }
 
+func TestBursts(t *testing.T) {
+	since := time.Date(2017, 9, 1, 0, 0, 0, 0, time.UTC)
+	from, to := since.Add(time.Hour), since.Add(time.Hour+10*time.Minute)
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT query, url, count").
+		WithArgs(since, 100).
+		WillReturnRows(
+			sqlmock.NewRows([]string{"query", "url", "votes", "min", "max"}).
+				AddRow("cheap pills", "https://spam.example.com/", 250, from, to),
+		)
+
+	p := &PostgreSQL{
+		DB:    db,
+		Table: "votes",
+	}
+
+	got, err := p.Bursts(since, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Burst{
+		{Query: "cheap pills", URL: "https://spam.example.com/", Votes: 250, From: from, To: to},
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v; want: %+v", got, want)
+	}
+}
+
+func TestPurge(t *testing.T) {
+	from := time.Date(2017, 9, 1, 1, 0, 0, 0, time.UTC)
+	b := Burst{Query: "cheap pills", URL: "https://spam.example.com/", Votes: 250, From: from, To: from.Add(10 * time.Minute)}
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	mock.ExpectExec("DELETE FROM votes").
+		WithArgs(b.Query, b.URL, b.From, b.To).
+		WillReturnResult(sqlmock.NewResult(0, 250))
+
+	p := &PostgreSQL{
+		DB:    db,
+		Table: "votes",
+	}
+
+	got, err := p.Purge(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got != 250 {
+		t.Fatalf("got %d; want 250", got)
+	}
+}
+
 func TestSetup(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	if err != nil {
@@ -160,15 +352,54 @@ func TestSetup(t *testing.T) {
 	p := &PostgreSQL{
 		DB:    db,
 		Table: "votes",
+		Stem:  true,
 	}
 
 	mock.ExpectExec("CREATE TABLE IF NOT EXISTS").
 		WillReturnResult(sqlmock.NewResult(0, 0))
 
-	mock.ExpectExec("CREATE INDEX IF NOT EXISTS").
+	mock.ExpectExec("ALTER TABLE votes").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	// the votes from before we normalized queries
+	mock.ExpectQuery("SELECT DISTINCT query FROM votes WHERE normalized IS NULL").
+		WithArgs(normalizeBatch).
+		WillReturnRows(sqlmock.NewRows([]string{"query"}).AddRow("Jimi  Hendrix?").AddRow("cats"))
+
+	mock.ExpectExec("UPDATE votes SET normalized").
+		WithArgs("jimi hendrix", "Jimi  Hendrix?").
+		WillReturnResult(sqlmock.NewResult(0, 3))
+
+	mock.ExpectExec("UPDATE votes SET normalized").
+		WithArgs("cat", "cats").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	mock.ExpectExec("CREATE INDEX IF NOT EXISTS query_idx").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	mock.ExpectExec("CREATE INDEX IF NOT EXISTS normalized_idx").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	// the duplicate votes of an identity once their queries are normalized
+	mock.ExpectExec("DELETE FROM votes a USING votes b").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+
+	mock.ExpectExec("DROP INDEX IF EXISTS identity_idx").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	mock.ExpectExec("DROP INDEX IF EXISTS identity_normalized_idx").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	mock.ExpectExec(`CREATE UNIQUE INDEX IF NOT EXISTS ballot_idx ON votes \(ballot\)`).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	mock.ExpectExec(`CREATE INDEX IF NOT EXISTS identity_created_idx ON votes \(identity, created\)`).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	mock.ExpectExec("CREATE INDEX IF NOT EXISTS created_idx").
 		WillReturnResult(sqlmock.NewResult(1, 1))
 
-	mock.ExpectExec("CREATE FUNCTION").
+	mock.ExpectExec(`CREATE FUNCTION normalized_score\(q text, half_life double precision\)`).
 		WillReturnResult(sqlmock.NewResult(1, 1))
 
 	err = p.Setup()
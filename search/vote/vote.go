@@ -2,10 +2,15 @@
 package vote
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
+	"strings"
 	"time"
 
 	"github.com/jivesearch/jivesearch/search/document"
+	"golang.org/x/text/language"
 )
 
 // Vote represents a user's vote for a url
@@ -15,19 +20,47 @@ type Vote struct {
 	Domain string `db:"domain" json:"-"`
 	Vote   int    `db:"vote" json:"vote"`
 	Date   string `db:"date" json:"date"`
+	// Identity tells one voter from another on the day they vote without storing who they are (see Identity)
+	Identity string `db:"identity" json:"-"`
+	// Ballot is the voter's one vote for the (normalized) query & url no matter the day (see Insert)
+	Ballot string `db:"ballot" json:"-"`
+	voter  []byte // a hash of the voter that doesn't rotate...never stored
+	// Language of the query...we match votes by the normalized query (see Normalize)
+	Language   language.Tag `db:"-" json:"-"`
+	Normalized string       `db:"normalized" json:"-"`
 }
 
 // Result represents the vote total for a url
 type Result struct {
-	URL   string `db:"url" json:"url"`
-	Votes int    `db:"votes" json:"votes"`
+	URL    string  `db:"url" json:"url"`
+	Domain string  `db:"domain" json:"-"`
+	Votes  int     `db:"votes" json:"votes"`
+	Score  float64 `db:"score" json:"-"` // the votes with older votes counting for less
+}
+
+// Burst is a suspicious number of votes for a url for a query in a short time
+type Burst struct {
+	Query string    `db:"query" json:"query"`
+	URL   string    `db:"url" json:"url"`
+	Votes int       `db:"votes" json:"votes"`
+	From  time.Time `db:"from" json:"from"` // the first and last of the votes
+	To    time.Time `db:"to" json:"to"`
+}
+
+// Limit is the max number of votes an identity can make in a period of time
+type Limit struct {
+	Votes int
+	Per   time.Duration
 }
 
 // Voter outlines the methods to store & retrieve votes
 type Voter interface {
 	Setup() error
-	Get(query string, limit int) ([]Result, error)
+	Get(query string, lang language.Tag, limit int) ([]Result, error)
+	DomainVotes(domain string) (int, error)
 	Insert(v *Vote) error
+	Bursts(since time.Time, min int) ([]Burst, error)
+	Purge(b Burst) (int64, error)
 }
 
 // Option is a function option
@@ -41,6 +74,10 @@ var (
 	ErrInvalidURL = errors.New("invalid url")
 	// ErrInvalidVote indicates an invalid vote was passed
 	ErrInvalidVote = errors.New("invalid vote; must be -1 or 1")
+	// ErrRateLimited indicates a voter has voted too often
+	ErrRateLimited = errors.New("too many votes; try again later")
+	// ErrNoIdentity indicates a vote without a voter (see Identity)
+	ErrNoIdentity = errors.New("vote has no identity")
 )
 
 // New creates a new Vote, setting the date to today
@@ -89,6 +126,14 @@ func URL(lnk string) Option {
 	}
 }
 
+// Language sets the language of the query
+func Language(lang language.Tag) Option {
+	return func(v *Vote) (*Vote, error) {
+		v.Language = lang
+		return v, nil
+	}
+}
+
 // Value sets the vote value
 func Value(vote int) Option {
 	return func(v *Vote) (*Vote, error) {
@@ -100,3 +145,34 @@ func Value(vote int) Option {
 		return v, nil
 	}
 }
+
+// Identity sets the identity of the voter to a hash of their IP address and user agent.
+// The hash is keyed by a secret key so the IP address can't be found by hashing every IP address
+// and by the date so the same voter can't be followed from one day to the next. We rate limit
+// the identity (which rotates at midnight). The voter's ballot for a query & url doesn't rotate
+// so they can't vote for the same url again the next day (see setBallot).
+// Set the date (e.g. New does) before the Identity.
+func Identity(ip, userAgent string, key []byte) Option {
+	return func(v *Vote) (*Vote, error) {
+		v.voter = hash(key, ip, userAgent)
+		v.Identity = hex.EncodeToString(hash(key, v.Date, ip, userAgent))
+		return v, nil
+	}
+}
+
+// setBallot sets the ballot of the voter for the normalized query & url. It is keyed by the
+// voter's hash (which we don't store) so one ballot doesn't tell us who else cast it.
+func (v *Vote) setBallot() error {
+	if v.Identity == "" || len(v.voter) == 0 {
+		return ErrNoIdentity
+	}
+
+	v.Ballot = hex.EncodeToString(hash(v.voter, v.Normalized, v.URL))
+	return nil
+}
+
+func hash(key []byte, s ...string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(strings.Join(s, "\x00")))
+	return mac.Sum(nil)
+}
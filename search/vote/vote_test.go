@@ -127,3 +127,82 @@ func TestNew(t *testing.T) {
 		})
 	}
 }
+
+// A voter can't vote for a url for a query again the next day but their votes can't be tied together
+func TestBallot(t *testing.T) {
+	key := []byte("secret")
+	ballot := func(date, ip, q, u string) *Vote {
+		v := &Vote{Date: date, Normalized: q, URL: u}
+		if _, err := Identity(ip, "Mozilla/5.0", key)(v); err != nil {
+			t.Fatal(err)
+		}
+		if err := v.setBallot(); err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+
+	v := ballot("20170901", "203.0.113.5", "cats", "https://www.cat.com/")
+
+	for _, c := range []struct {
+		name string
+		v    *Vote
+		same bool
+	}{
+		{"the next day", ballot("20170902", "203.0.113.5", "cats", "https://www.cat.com/"), true},
+		{"a week later", ballot("20170908", "203.0.113.5", "cats", "https://www.cat.com/"), true},
+		{"another url", ballot("20170901", "203.0.113.5", "cats", "https://www.kitten.com/"), false},
+		{"another query", ballot("20170901", "203.0.113.5", "kittens", "https://www.cat.com/"), false},
+		{"another voter", ballot("20170901", "203.0.113.6", "cats", "https://www.cat.com/"), false},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			if got := c.v.Ballot == v.Ballot; got != c.same {
+				t.Fatalf("got same ballot %v; want %v", got, c.same)
+			}
+			if c.v.Date != v.Date && c.v.Identity == v.Identity {
+				t.Fatal("expected the identity to rotate daily")
+			}
+		})
+	}
+
+	if err := (&Vote{Normalized: "cats", URL: "https://www.cat.com/"}).setBallot(); err != ErrNoIdentity {
+		t.Fatalf("got err %v; want %v", err, ErrNoIdentity)
+	}
+}
+
+func TestIdentity(t *testing.T) {
+	key := []byte("secret")
+	identity := func(date, ip, ua string, key []byte) string {
+		v, err := (&Vote{Date: date}), error(nil)
+		if v, err = Identity(ip, ua, key)(v); err != nil {
+			t.Fatal(err)
+		}
+		return v.Identity
+	}
+
+	id := identity("20170901", "203.0.113.5", "Mozilla/5.0", key)
+	if len(id) != 64 {
+		t.Fatalf("got %q; want a sha256 hex digest", id)
+	}
+
+	for _, c := range []struct {
+		name string
+		date string
+		ip   string
+		ua   string
+		key  []byte
+		same bool
+	}{
+		{"same voter", "20170901", "203.0.113.5", "Mozilla/5.0", key, true},
+		{"next day", "20170902", "203.0.113.5", "Mozilla/5.0", key, false},
+		{"another ip", "20170901", "203.0.113.6", "Mozilla/5.0", key, false},
+		{"another user agent", "20170901", "203.0.113.5", "curl/7.54.0", key, false},
+		{"another key", "20170901", "203.0.113.5", "Mozilla/5.0", []byte("other"), false},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			if got := identity(c.date, c.ip, c.ua, c.key) == id; got != c.same {
+				t.Fatalf("got same identity %v; want %v", got, c.same)
+			}
+		})
+	}
+}
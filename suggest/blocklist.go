@@ -0,0 +1,176 @@
+package suggest
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/olivere/elastic"
+)
+
+const (
+	blockedType  = "blocked"
+	maxBlocklist = 10000
+	maxWords     = 10 // of a query we look up in the blocklist...longer ones are cut short by our mapping anyways
+)
+
+// blockedQuery is a query we never suggest
+type blockedQuery struct {
+	Query string `json:"query"`
+}
+
+// blocklistIndex is the index of the queries we never suggest
+func (e *ElasticSearch) blocklistIndex() string {
+	return e.Index + "-blocked"
+}
+
+// setupBlocklist creates our blocklist if we don't have one
+func (e *ElasticSearch) setupBlocklist() error {
+	exists, err := e.Client.IndexExists(e.blocklistIndex()).Do(context.TODO())
+	if err != nil || exists {
+		return err
+	}
+
+	_, err = e.Client.CreateIndex(e.blocklistIndex()).
+		BodyString(`{"mappings": {"` + blockedType + `": {"properties": {"query": {"type": "keyword"}}}}}`).
+		Do(context.TODO())
+	return err
+}
+
+// normalize is how we block a query: lowercased and single-spaced
+func normalize(q string) string {
+	return strings.Join(strings.Fields(strings.ToLower(q)), " ")
+}
+
+// phrases are the words of a query and each run of them, e.g.
+// "call john smith" is "call", "call john", "call john smith", "john", "john smith" and "smith"
+func phrases(q string) []string {
+	words := strings.Fields(normalize(q))
+	if len(words) > maxWords {
+		words = words[:maxWords]
+	}
+
+	p := []string{}
+	for i := range words {
+		for j := i + 1; j <= len(words); j++ {
+			p = append(p, strings.Join(words[i:j], " "))
+		}
+	}
+	return p
+}
+
+// blocked is true if a blocked query is a part of the query (so blocking
+// "john smith" blocks "john smith phone" too)
+func (e *ElasticSearch) blocked(q string) (bool, error) {
+	svc := e.Client.Mget()
+	for _, p := range phrases(q) {
+		svc = svc.Add(elastic.NewMultiGetItem().Index(e.blocklistIndex()).Type(blockedType).Id(p))
+	}
+
+	res, err := svc.Do(context.TODO())
+	if err != nil {
+		return false, err
+	}
+
+	for _, doc := range res.Docs {
+		if doc.Found {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// Block adds a query to our blocklist and removes the suggestions it is a part of.
+// It returns how many suggestions were removed.
+func (e *ElasticSearch) Block(q string) (int64, error) {
+	q = normalize(q)
+	if q == "" {
+		return 0, nil
+	}
+
+	_, err := e.Client.Index().
+		Index(e.blocklistIndex()).
+		Type(blockedType).
+		Id(q).
+		BodyJson(&blockedQuery{Query: q}).
+		Do(context.TODO())
+	if err != nil {
+		return 0, err
+	}
+
+	return e.Remove(q)
+}
+
+// Unblock removes a query from our blocklist. Its suggestions come back as they are searched again.
+func (e *ElasticSearch) Unblock(q string) error {
+	_, err := e.Client.Delete().
+		Index(e.blocklistIndex()).
+		Type(blockedType).
+		Id(normalize(q)).
+		Do(context.TODO())
+	if elastic.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// Blocklist returns the queries we block in alphabetical order
+func (e *ElasticSearch) Blocklist() ([]string, error) {
+	list := []string{}
+
+	res, err := e.Client.Search().
+		Index(e.blocklistIndex()).
+		Type(blockedType).
+		Sort("query", true).
+		Size(maxBlocklist).
+		Do(context.TODO())
+	if err != nil {
+		return list, err
+	}
+
+	for _, h := range res.Hits.Hits {
+		b := &blockedQuery{}
+		if err := json.Unmarshal(*h.Source, b); err != nil {
+			return list, err
+		}
+		list = append(list, b.Query)
+	}
+
+	return list, nil
+}
+
+// Remove removes the suggestions (and withheld queries) a query is a part of in every language & region.
+// It returns how many were removed. They come back if they are searched again...Block them to prevent that.
+func (e *ElasticSearch) Remove(q string) (int64, error) {
+	if q = normalize(q); q == "" {
+		return 0, nil
+	}
+
+	res, err := e.Client.DeleteByQuery(e.Index).
+		Type(e.Type).
+		Query(elastic.NewMatchPhraseQuery("query", q)).
+		ProceedOnVersionConflict().
+		Do(context.TODO())
+	if err != nil {
+		return 0, err
+	}
+
+	return res.Deleted, nil
+}
+
+// Expire forgets the queries we withheld that were first searched before a time and who searched them.
+// A query that few people search in a long time is more likely to be personal than popular.
+func (e *ElasticSearch) Expire(before time.Time) (int64, error) {
+	res, err := e.Client.DeleteByQuery(e.Index).
+		Type(e.Type).
+		Query(elastic.NewRangeQuery("pending").Lt(before.UTC().Format(time.RFC3339))).
+		ProceedOnVersionConflict().
+		Do(context.TODO())
+	if err != nil {
+		return 0, err
+	}
+
+	return res.Deleted, nil
+}
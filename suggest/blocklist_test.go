@@ -0,0 +1,173 @@
+package suggest
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPhrases(t *testing.T) {
+	for _, c := range []struct {
+		q    string
+		want []string
+	}{
+		{"Call  John Smith", []string{"call", "call john", "call john smith", "john", "john smith", "smith"}},
+		{"jimi", []string{"jimi"}},
+		{" ", []string{}},
+		{"a b c d e f g h i j k", nil},
+	} {
+		t.Run(c.q, func(t *testing.T) {
+			got := phrases(c.q)
+			if c.want == nil { // too many words
+				if len(got) != maxWords*(maxWords+1)/2 {
+					t.Fatalf("got %d phrases; want %d", len(got), maxWords*(maxWords+1)/2)
+				}
+				return
+			}
+
+			if !reflect.DeepEqual(got, c.want) {
+				t.Fatalf("got %q; want %q", got, c.want)
+			}
+		})
+	}
+}
+
+func TestModerator(t *testing.T) {
+	type want struct {
+		requests []string
+		body     string // part of the last request
+		n        int64
+		list     []string
+	}
+
+	for _, c := range []struct {
+		name   string
+		status int
+		resp   string
+		fn     func(e *ElasticSearch) (int64, []string, error)
+		want
+	}{
+		{
+			name: "block",
+			resp: `{"took": 3, "total": 2, "deleted": 2}`,
+			fn: func(e *ElasticSearch) (int64, []string, error) {
+				n, err := e.Block(" John  Smith ")
+				return n, nil, err
+			},
+			want: want{
+				requests: []string{"PUT /queries-blocked/blocked/john smith", "POST /queries/query/_delete_by_query"},
+				body:     `{"query":{"match_phrase":{"query":{"query":"john smith"}}}}`,
+				n:        2,
+			},
+		},
+		{
+			name:   "unblock",
+			status: http.StatusNotFound,
+			resp:   `{"found": false}`,
+			fn: func(e *ElasticSearch) (int64, []string, error) {
+				return 0, nil, e.Unblock("John Smith")
+			},
+			want: want{
+				requests: []string{"DELETE /queries-blocked/blocked/john smith"},
+			},
+		},
+		{
+			name: "blocklist",
+			resp: `{
+				"took": 1,
+				"hits": {
+					"total": 2,
+					"hits": [
+						{"_index": "queries-blocked", "_type": "blocked", "_id": "jane doe", "_source": {"query": "jane doe"}},
+						{"_index": "queries-blocked", "_type": "blocked", "_id": "john smith", "_source": {"query": "john smith"}}
+					]
+				}
+			}`,
+			fn: func(e *ElasticSearch) (int64, []string, error) {
+				list, err := e.Blocklist()
+				return 0, list, err
+			},
+			want: want{
+				requests: []string{"POST /queries-blocked/blocked/_search"},
+				body:     `"sort":[{"query":{"order":"asc"}}]`,
+				list:     []string{"jane doe", "john smith"},
+			},
+		},
+		{
+			name: "remove",
+			resp: `{"took": 3, "total": 1, "deleted": 1}`,
+			fn: func(e *ElasticSearch) (int64, []string, error) {
+				n, err := e.Remove("JIMI hendrix")
+				return n, nil, err
+			},
+			want: want{
+				requests: []string{"POST /queries/query/_delete_by_query"},
+				body:     `{"query":{"match_phrase":{"query":{"query":"jimi hendrix"}}}}`,
+				n:        1,
+			},
+		},
+		{
+			name: "expire",
+			resp: `{"took": 3, "total": 7, "deleted": 7}`,
+			fn: func(e *ElasticSearch) (int64, []string, error) {
+				n, err := e.Expire(time.Date(2018, 3, 1, 0, 0, 0, 0, time.UTC))
+				return n, nil, err
+			},
+			want: want{
+				requests: []string{"POST /queries/query/_delete_by_query"},
+				body:     `"to":"2018-03-01T00:00:00Z"`,
+				n:        7,
+			},
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			requests := []string{}
+			var body []byte
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests = append(requests, r.Method+" "+r.URL.Path)
+				body, _ = ioutil.ReadAll(r.Body)
+
+				if strings.HasPrefix(r.URL.Path, "/queries-blocked/blocked/") && r.Method == "PUT" {
+					w.Write([]byte(`{"_index": "queries-blocked", "_type": "blocked", "_id": "john smith", "result": "created"}`))
+					return
+				}
+
+				if c.status != 0 {
+					w.WriteHeader(c.status)
+				}
+				w.Write([]byte(c.resp))
+			}))
+			defer ts.Close()
+
+			e, err := MockService(ts.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			n, list, err := c.fn(e)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(requests, c.want.requests) {
+				t.Fatalf("got requests %v; want %v", requests, c.want.requests)
+			}
+
+			if !strings.Contains(string(body), c.want.body) {
+				t.Fatalf("got %s; want %s", body, c.want.body)
+			}
+
+			if n != c.want.n {
+				t.Fatalf("got %d; want %d", n, c.want.n)
+			}
+
+			if !reflect.DeepEqual(list, c.want.list) {
+				t.Fatalf("got %v; want %v", list, c.want.list)
+			}
+		})
+	}
+}
@@ -0,0 +1,151 @@
+// Command suggest serves the api that keeps queries out of our autocomplete.
+// It only listens on localhost...don't expose it.
+//
+//	GET  /blocklist           the queries we block
+//	POST /block?q=john+smith  blocks a query and removes the suggestions it is a part of
+//	POST /unblock?q=john+smith
+//	POST /remove?q=john+smith removes the suggestions a query is a part of
+//
+// It is also our batch job: every so often (see suggest.rebuild) it rebuilds the weights of
+// our suggestions from their recent searches and forgets the queries we withheld for too long.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jivesearch/jivesearch/config"
+	"github.com/jivesearch/jivesearch/log"
+	"github.com/jivesearch/jivesearch/suggest"
+	"github.com/olivere/elastic"
+	"github.com/spf13/viper"
+)
+
+type admin struct {
+	suggest.Moderator
+}
+
+func (a *admin) handler() http.Handler {
+	r := http.NewServeMux()
+	r.HandleFunc("/blocklist", a.blocklistHandler)
+	r.HandleFunc("/block", a.moderateHandler(a.Block))
+	r.HandleFunc("/unblock", a.moderateHandler(func(q string) (int64, error) {
+		return 0, a.Unblock(q)
+	}))
+	r.HandleFunc("/remove", a.moderateHandler(a.Remove))
+	return r
+}
+
+func (a *admin) blocklistHandler(w http.ResponseWriter, r *http.Request) {
+	list, err := a.Blocklist()
+	if err != nil {
+		log.Info.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	write(w, map[string][]string{"blocklist": list})
+}
+
+// moderateHandler blocks, unblocks or removes the "q" param and responds with how many suggestions were removed
+func (a *admin) moderateHandler(fn func(q string) (int64, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		q := strings.TrimSpace(r.FormValue("q"))
+		if q == "" {
+			http.Error(w, "missing query", http.StatusBadRequest)
+			return
+		}
+
+		n, err := fn(q)
+		if err != nil {
+			log.Info.Println(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		log.Info.Printf("%v %q: %d suggestions removed\n", r.URL.Path, q, n)
+		write(w, map[string]int64{"removed": n})
+	}
+}
+
+func write(w http.ResponseWriter, v interface{}) {
+	j, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(j)
+}
+
+// maintain decays the weights of our suggestions and forgets
+// the queries we withheld for longer than expire
+func maintain(m suggest.Maintainer, expire time.Duration) {
+	now := time.Now()
+
+	n, err := m.Decay(now)
+	if err != nil {
+		log.Info.Println(err)
+	}
+	log.Info.Printf("decayed %d suggestions\n", n)
+
+	n, err = m.Expire(now.Add(-expire))
+	if err != nil {
+		log.Info.Println(err)
+	}
+	log.Info.Printf("forgot %d withheld queries\n", n)
+}
+
+func setup(v *viper.Viper) {
+	v.SetEnvPrefix("jivesearch")
+	v.AutomaticEnv()
+	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	config.SetDefaults(v)
+
+	if v.GetBool("debug") {
+		log.Debug.SetOutput(os.Stdout)
+	}
+}
+
+func main() {
+	v := viper.New()
+	setup(v)
+
+	client, err := elastic.NewClient(elastic.SetURL(v.GetString("elasticsearch.url")), elastic.SetSniff(false))
+	if err != nil {
+		panic(err)
+	}
+
+	s := &suggest.ElasticSearch{
+		Client:   client,
+		Index:    v.GetString("elasticsearch.query.index"),
+		Type:     v.GetString("elasticsearch.query.type"),
+		HalfLife: v.GetDuration("suggest.halflife"),
+	}
+
+	if err := s.Setup(); err != nil {
+		panic(err)
+	}
+
+	go func() {
+		for {
+			maintain(s, v.GetDuration("suggest.expire"))
+			time.Sleep(v.GetDuration("suggest.rebuild"))
+		}
+	}()
+
+	a := &admin{s}
+	addr := fmt.Sprintf("127.0.0.1:%d", v.GetInt("suggest.admin.port"))
+	log.Info.Printf("Listening at http://%v", addr)
+	log.Info.Fatal(http.ListenAndServe(addr, a.handler()))
+}
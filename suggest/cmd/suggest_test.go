@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func TestHandler(t *testing.T) {
+	for _, c := range []struct {
+		name   string
+		method string
+		path   string
+		status int
+		body   string
+	}{
+		{"blocklist", "GET", "/blocklist", http.StatusOK, `{"blocklist":["jane doe","john smith"]}`},
+		{"block", "POST", "/block?q=john+smith", http.StatusOK, `{"removed":3}`},
+		{"unblock", "POST", "/unblock?q=john+smith", http.StatusOK, `{"removed":0}`},
+		{"remove", "POST", "/remove?q=jimi+hendrix", http.StatusOK, `{"removed":1}`},
+		{"not a post", "GET", "/block?q=john+smith", http.StatusMethodNotAllowed, "Method Not Allowed\n"},
+		{"no query", "POST", "/remove?q=+", http.StatusBadRequest, "missing query\n"},
+		{"error", "POST", "/remove?q=error", http.StatusInternalServerError, "oops\n"},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			a := &admin{&mockModerator{}}
+
+			rec := httptest.NewRecorder()
+			a.handler().ServeHTTP(rec, httptest.NewRequest(c.method, c.path, nil))
+
+			if rec.Code != c.status {
+				t.Fatalf("got %v; want %v", rec.Code, c.status)
+			}
+
+			if got := rec.Body.String(); got != c.body {
+				t.Fatalf("got %q; want %q", got, c.body)
+			}
+		})
+	}
+}
+
+func TestMaintain(t *testing.T) {
+	m := &mockMaintainer{}
+	maintain(m, 30*24*time.Hour)
+
+	if m.decayed.IsZero() {
+		t.Fatal("expected our suggestions to be decayed")
+	}
+
+	if got := m.decayed.Sub(m.expired); got != 30*24*time.Hour {
+		t.Fatalf("got queries withheld for %v expired; want 720h", got)
+	}
+}
+
+func TestSetup(t *testing.T) {
+	v := viper.New()
+	setup(v)
+
+	if got := v.GetDuration("suggest.expire"); got != 30*24*time.Hour {
+		t.Fatalf("got %v; want 720h", got)
+	}
+
+	if got := v.GetDuration("suggest.rebuild"); got != 24*time.Hour {
+		t.Fatalf("got %v; want 24h", got)
+	}
+
+	if got := v.GetInt("suggest.admin.port"); got == 0 {
+		t.Fatalf("expected a port for our api")
+	}
+}
+
+type mockModerator struct{}
+
+func (m *mockModerator) Block(q string) (int64, error) {
+	return 3, nil
+}
+
+func (m *mockModerator) Unblock(q string) error {
+	return nil
+}
+
+func (m *mockModerator) Blocklist() ([]string, error) {
+	return []string{"jane doe", "john smith"}, nil
+}
+
+func (m *mockModerator) Remove(q string) (int64, error) {
+	if strings.Contains(q, "error") {
+		return 0, errors.New("oops")
+	}
+	return 1, nil
+}
+
+type mockMaintainer struct {
+	decayed, expired time.Time
+}
+
+func (m *mockMaintainer) Decay(now time.Time) (int64, error) {
+	m.decayed = now
+	return 42, nil
+}
+
+func (m *mockMaintainer) Expire(before time.Time) (int64, error) {
+	m.expired = before
+	return 7, nil
+}
@@ -0,0 +1,70 @@
+package suggest
+
+import (
+	"context"
+	"time"
+
+	"github.com/olivere/elastic"
+)
+
+// retainHalfLives is how many half-lives we keep the searches of a day for.
+// After 10 a search counts for less than 0.1% of what it did.
+const retainHalfLives = 10
+
+// decayScript rebuilds the weight of a suggestion from its searches of each day.
+// A suggestion from before we counted them by day starts over with its weight as today's searches.
+const decayScript = `
+	if (ctx._source.completion_suggest == null) {
+		ctx.op = 'noop';
+		return;
+	}
+
+	if (ctx._source.days == null) {
+		ctx._source.days = new HashMap();
+		ctx._source.days[String.valueOf(params.today)] = ctx._source.completion_suggest.weight;
+	}
+
+	double weight = 0;
+	List forget = new ArrayList();
+	for (def d : ctx._source.days.entrySet()) {
+		long age = params.today - Integer.parseInt(d.getKey());
+		if (age > params.retain) {
+			forget.add(d.getKey());
+			continue;
+		}
+		weight += d.getValue() * Math.pow(0.5, age / params.half_life);
+	}
+
+	for (def d : forget) {
+		ctx._source.days.remove(d);
+	}
+
+	ctx._source.completion_suggest.weight = (int)Math.round(weight);`
+
+// Decay rebuilds the weight of our suggestions from their searches of each day. A search counts for
+// half as much after each HalfLife so a query that is trending now overtakes one that was popular long ago.
+// Run it every day or so. It returns how many suggestions were updated.
+func (e *ElasticSearch) Decay(now time.Time) (int64, error) {
+	halfLife := e.HalfLife.Hours() / 24
+	if halfLife <= 0 {
+		return 0, nil
+	}
+
+	params := map[string]interface{}{
+		"today":     day(now),
+		"half_life": halfLife,
+		"retain":    int(retainHalfLives * halfLife),
+	}
+
+	// withheld queries aren't weighted yet
+	res, err := e.Client.UpdateByQuery(e.Index).Type(e.Type).
+		Query(elastic.NewBoolQuery().MustNot(elastic.NewExistsQuery("pending"))).
+		Script(elastic.NewScript(decayScript).Params(params)).
+		ProceedOnVersionConflict().
+		Do(context.TODO())
+	if err != nil {
+		return 0, err
+	}
+
+	return res.Updated, nil
+}
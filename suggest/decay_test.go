@@ -0,0 +1,90 @@
+package suggest
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDay(t *testing.T) {
+	for _, c := range []struct {
+		t    time.Time
+		want int64
+	}{
+		{time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC), 0},
+		{time.Date(2018, 3, 1, 0, 0, 0, 0, time.UTC), 17591},
+		{time.Date(2018, 3, 1, 23, 59, 59, 0, time.UTC), 17591},
+	} {
+		t.Run(c.t.String(), func(t *testing.T) {
+			if got := day(c.t); got != c.want {
+				t.Fatalf("got %d; want %d", got, c.want)
+			}
+		})
+	}
+}
+
+func TestDecay(t *testing.T) {
+	for _, c := range []struct {
+		name     string
+		halfLife time.Duration
+		requests int
+		body     []string
+		want     int64
+	}{
+		{
+			name:     "two weeks",
+			halfLife: 14 * 24 * time.Hour,
+			requests: 1,
+			body: []string{
+				`"query":{"bool":{"must_not":{"exists":{"field":"pending"}}}}`,
+				`"params":{"half_life":14,"retain":140,"today":17591}`,
+			},
+			want: 42,
+		},
+		{
+			name: "no decay",
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			requests := 0
+			var body []byte
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				if want := "/queries/query/_update_by_query"; r.URL.Path != want {
+					t.Fatalf("got path %q; want %q", r.URL.Path, want)
+				}
+				body, _ = ioutil.ReadAll(r.Body)
+				w.Write([]byte(`{"took": 12, "total": 42, "updated": 42, "noops": 0}`))
+			}))
+			defer ts.Close()
+
+			e, err := MockService(ts.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			e.HalfLife = c.halfLife
+
+			got, err := e.Decay(time.Date(2018, 3, 1, 12, 0, 0, 0, time.UTC))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if requests != c.requests {
+				t.Fatalf("got %d requests; want %d", requests, c.requests)
+			}
+
+			for _, want := range c.body {
+				if !strings.Contains(string(body), want) {
+					t.Fatalf("got %s; want %s", body, want)
+				}
+			}
+
+			if got != c.want {
+				t.Fatalf("got %d; want %d", got, c.want)
+			}
+		})
+	}
+}
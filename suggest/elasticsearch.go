@@ -4,35 +4,134 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/olivere/elastic"
+	"golang.org/x/text/language"
 )
 
-const completionSuggest = "completion_suggest"
+const (
+	completionSuggest  = "completion_suggest"
+	globalSuggest      = "completion_suggest_global"
+	fuzzySuggest       = "fuzzy_suggest"
+	fuzzyGlobalSuggest = "fuzzy_suggest_global"
+	localeContext      = "locale"
+)
 
 // ElasticSearch holds the index name and the connection
 type ElasticSearch struct {
 	Client *elastic.Client
 	Index  string
 	Type   string
+	Fuzzy
+	Anonymity int           // the different users that have to search a query before we suggest it
+	HalfLife  time.Duration // after which a search counts for half as much (see Decay)
+}
+
+// Fuzzy are the settings of our typo-tolerant suggestions
+type Fuzzy struct {
+	Fuzziness int // max edits (0 for no fuzzy suggestions)..."jimu" is 1 edit from "jimi"
+	Prefix    int // the characters at the start of a query that have to match exactly
+}
+
+// suggester is one of the completion suggesters we ask for. Exact suggestions come
+// before fuzzy ones and those of the user's locale before those of everybody else.
+type suggester struct {
+	name   string
+	locale bool
+	fuzzy  bool
+}
+
+var suggesters = []suggester{
+	{completionSuggest, true, false},
+	{globalSuggest, false, false},
+	{fuzzySuggest, true, true},
+	{fuzzyGlobalSuggest, false, true},
+}
+
+// locales are the contexts of a query searched in a language & region, e.g. "fr" and "fr-FR".
+// The region is optional (e.g. "fr" for a French speaker we can't place).
+func locales(lang language.Tag, region language.Region) []string {
+	b, _ := lang.Base()
+	l := []string{b.String()}
+	if region.IsCountry() {
+		l = append(l, b.String()+"-"+region.String())
+	}
+	return l
+}
+
+// id is the id of a query in a language & region...each has its own popularity
+func id(term string, lang language.Tag, region language.Region) string {
+	l := locales(lang, region)
+	return l[len(l)-1] + ":" + term
+}
+
+// localeQuery matches the queries searched in a language, boosting those from the same region
+type localeQuery []string
+
+// Source returns the context query. The regional locale (if any) goes first.
+func (l localeQuery) Source() (interface{}, error) {
+	contexts := []interface{}{}
+	for i := len(l) - 1; i >= 0; i-- {
+		c := map[string]interface{}{"context": l[i]}
+		if i > 0 {
+			c["boost"] = 2
+		}
+		contexts = append(contexts, c)
+	}
+	return map[string]interface{}{localeContext: contexts}, nil
 }
 
-// Completion handles autocomplete queries
-func (e *ElasticSearch) Completion(term string, size int) (Results, error) {
-	// Another option is the NewFuzzyCompletionSuggester and
-	// set the "Fuzziness" but we'll start with this for now.
+// Completion handles autocomplete queries. We suggest what others searched in the
+// same language (and region) and, when there aren't enough of those, what everybody searched.
+// Queries that start with the term come before those that are a typo or two away from it.
+func (e *ElasticSearch) Completion(term string, lang language.Tag, region language.Region, size int) (Results, error) {
 	res := Results{}
 
-	s := elastic.NewCompletionSuggester(completionSuggest).
-		Text(term).
-		Field(completionSuggest).
-		Size(size)
+	svc := e.Client.
+		Search().
+		Index(e.Index).
+		Query(elastic.NewMatchAllQuery())
 
 	// there's gotta be a nicer way to inspect raw query than this
 	// eg we Marshal src everytime whether we need to or not
-	src, err := s.Source(true)
-	if err != nil {
-		return res, err
+	src := map[string]interface{}{}
+
+	for _, sg := range suggesters {
+		if sg.fuzzy && e.Fuzziness < 1 {
+			continue
+		}
+
+		s := elastic.NewCompletionSuggester(sg.name).
+			Text(term).
+			Field(completionSuggest).
+			SkipDuplicates(true).
+			Size(size)
+
+		if sg.locale {
+			s = s.ContextQuery(localeQuery(locales(lang, region)))
+		}
+
+		// the fuzzy suggesters only kick in once they've got more than the exact prefix to work with
+		if sg.fuzzy {
+			s = s.FuzzyOptions(
+				elastic.NewFuzzyCompletionSuggesterOptions().
+					EditDistance(e.Fuzziness).
+					PrefixLength(e.Prefix).
+					MinLength(e.Prefix + 1).
+					UnicodeAware(true),
+			)
+		}
+
+		ss, err := s.Source(false)
+		if err != nil {
+			return res, err
+		}
+		src[sg.name] = ss
+
+		svc = svc.Suggester(s)
 	}
 
 	d, err := json.Marshal(src)
@@ -42,19 +141,22 @@ func (e *ElasticSearch) Completion(term string, size int) (Results, error) {
 
 	res.RawQuery = string(d)
 
-	result, err := e.Client.
-		Search().
-		Index(e.Index).
-		Query(elastic.NewMatchAllQuery()).
-		Suggester(s).
-		Do(context.TODO())
+	result, err := svc.Do(context.TODO())
+	if err != nil {
+		return res, err
+	}
 
-	if err == nil {
-		if item, ok := result.Suggest[completionSuggest]; ok {
-			for _, sug := range item {
-				for _, opt := range sug.Options {
-					res.Suggestions = append(res.Suggestions, opt.Text)
+	seen := map[string]struct{}{}
+	for _, sg := range suggesters {
+		for _, sug := range result.Suggest[sg.name] {
+			for _, opt := range sug.Options {
+				if _, ok := seen[opt.Text]; ok || len(res.Suggestions) >= size {
+					continue
 				}
+				seen[opt.Text] = struct{}{}
+
+				m, fuzzy := match(term, opt.Text)
+				res.Suggestions = append(res.Suggestions, Suggestion{Text: opt.Text, Match: m, Fuzzy: fuzzy})
 			}
 		}
 	}
@@ -62,69 +164,317 @@ func (e *ElasticSearch) Completion(term string, size int) (Results, error) {
 	return res, err
 }
 
-// Exists checks if a term is already in our index
-func (e *ElasticSearch) Exists(term string) (bool, error) {
-	return e.Client.Exists().
-		Index(e.Index).
-		Type(e.Type).
-		Id(term).
-		Do(context.TODO())
-}
+// Popularity is how many times each query was searched in a language, both with and
+// without a region. The "Did you mean?" corrections that were searched the most are the likeliest.
+func (e *ElasticSearch) Popularity(queries []string, lang language.Tag, region language.Region) (map[string]int, error) {
+	pop := map[string]int{}
+	if len(queries) == 0 {
+		return pop, nil
+	}
 
-// Insert adds a new term to our index
-func (e *ElasticSearch) Insert(term string) error {
-	q := struct {
-		Completion *elastic.SuggestField `json:"completion_suggest"`
-	}{
-		elastic.NewSuggestField().Input(term).Weight(0),
+	svc := e.Client.Mget()
+	for _, q := range queries {
+		for _, l := range locales(lang, region) {
+			svc = svc.Add(elastic.NewMultiGetItem().Index(e.Index).Type(e.Type).Id(l + ":" + q))
+		}
 	}
 
-	_, err := e.Client.Index().
-		Index(e.Index).
-		Type(e.Type).
-		Id(term).
-		BodyJson(&q).
-		Do(context.TODO())
+	res, err := svc.Do(context.TODO())
+	if err != nil {
+		return pop, err
+	}
 
-	return err
+	for _, doc := range res.Docs {
+		if !doc.Found || doc.Source == nil {
+			continue
+		}
+
+		q := struct {
+			Completion *completion `json:"completion_suggest"`
+		}{}
+		if err := json.Unmarshal(*doc.Source, &q); err != nil {
+			return pop, err
+		}
+
+		if q.Completion == nil { // withheld
+			continue
+		}
+
+		pop[q.Completion.Input] += q.Completion.Weight
+	}
+
+	return pop, nil
+}
+
+// completion is a query in a language & region
+type completion struct {
+	Input    string              `json:"input"`
+	Weight   int                 `json:"weight"`
+	Contexts map[string][]string `json:"contexts"`
 }
 
-// Increment increments a term in our index
-func (e *ElasticSearch) Increment(term string) error {
-	_, err := e.Client.
+// pending is a query we withhold until enough different users searched it
+type pending struct {
+	Query    string         `json:"query"`
+	Users    []string       `json:"users"`
+	Searches int            `json:"searches"`
+	Pending  string         `json:"pending"` // when it was first searched
+	Days     map[string]int `json:"days"`
+}
+
+// now is when a query is searched
+var now = time.Now
+
+// day is the number of days since the unix epoch. The searches of a query are counted by day.
+func day(t time.Time) int64 {
+	return t.Unix() / (24 * 60 * 60)
+}
+
+// addScript counts a search. A withheld query becomes a suggestion (weighted by its searches so far)
+// once it was searched by k users and we forget who they were. The searches of each day
+// are kept so Decay can rebuild the weight...until then a search adds 1 to it.
+const addScript = `
+	if (ctx._source.days == null) {
+		ctx._source.days = new HashMap();
+	}
+	ctx._source.days[params.day] = (ctx._source.days[params.day] ?: 0) + 1;
+
+	if (ctx._source.completion_suggest != null) {
+		ctx._source.completion_suggest.weight += 1;
+		return;
+	}
+
+	ctx._source.searches += 1;
+	if (!ctx._source.users.contains(params.user)) {
+		ctx._source.users.add(params.user);
+	}
+
+	if (ctx._source.users.size() >= params.k) {
+		ctx._source.completion_suggest = ['input': params.input, 'weight': ctx._source.searches, 'contexts': params.contexts];
+		ctx._source.remove('users');
+		ctx._source.remove('pending');
+	}`
+
+// Add counts a search of a term by a user (see User) for our autocomplete. We only suggest what lots
+// of people search so a term is withheld until Anonymity different users searched it. A term that
+// looks like personal information (see Private) or that is blocked isn't counted at all.
+func (e *ElasticSearch) Add(term string, lang language.Tag, region language.Region, user string) error {
+	term = strings.Join(strings.Fields(term), " ")
+	if term == "" || Private(term) {
+		return nil
+	}
+
+	blocked, err := e.blocked(term)
+	if err != nil || blocked {
+		return err
+	}
+
+	params := map[string]interface{}{
+		"user":     user,
+		"k":        e.Anonymity,
+		"input":    term,
+		"contexts": map[string][]string{localeContext: locales(lang, region)},
+		"day":      strconv.FormatInt(day(now()), 10),
+	}
+
+	_, err = e.Client.
 		Update().
 		Index(e.Index).
 		Type(e.Type).
-		Id(term).
-		Script(elastic.NewScriptInline("ctx._source.completion_suggest.weight += 1")).
+		Id(id(term, lang, region)).
+		Script(elastic.NewScript(addScript).Params(params)).
+		ScriptedUpsert(true).
+		Upsert(&pending{Query: term, Users: []string{}, Pending: now().UTC().Format(time.RFC3339), Days: map[string]int{}}).
+		RetryOnConflict(3).
 		Do(context.TODO())
 
 	return err
 }
 
+// properties of our queries. The query (as text) is how we remove a suggestion and
+// the users of a withheld query are only kept to count them.
+func (e *ElasticSearch) properties() string {
+	return fmt.Sprintf(`
+		"properties": {
+			"%v": {
+				"type": "completion",
+				"analyzer": "simple",
+				"search_analyzer" : "simple",
+				"preserve_separators": true,
+				"preserve_position_increments": true,
+				"max_input_length": 50,
+				"contexts": [
+					{
+						"name": "%v",
+						"type": "category"
+					}
+				]
+			},
+			"query": {"type": "text"},
+			"users": {"type": "keyword", "index": false},
+			"searches": {"type": "integer", "index": false},
+			"pending": {"type": "date"},
+			"days": {"type": "object", "enabled": false}
+		}`, completionSuggest, localeContext)
+}
+
+// mapping of our queries. An index from before we had locales can't be given them (see migrate).
 func (e *ElasticSearch) mapping() string {
 	return fmt.Sprintf(`{
 		"mappings": {
-			"query": {
+			"%v": {
 				"dynamic": "strict",
-				"properties": {
-					"%v": {
-						"type": "completion",
-						"analyzer": "simple",
-						"search_analyzer" : "simple",
-						"preserve_separators": true,
-						"preserve_position_increments": true,
-						"max_input_length": 50
-					}					
-				}
+				%v
 			}
 		}
-	}`, completionSuggest)
+	}`, e.Type, e.properties())
 }
 
-// Setup creates a completion index
+// Setup creates our completion index and blocklist. An index from before we had locales is
+// migrated (see migrate) and the suggestions of an index from before we withheld queries get
+// their query so they can be removed.
 func (e *ElasticSearch) Setup() error {
-	_, err := e.Client.CreateIndex(e.Index).Body(e.mapping()).Do(context.TODO())
+	exists, err := e.IndexExists()
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case !exists:
+		// a migration that stopped after it deleted our old index
+		migrated, err := e.Client.IndexExists(e.migrated()).Do(context.TODO())
+		if err != nil {
+			return err
+		}
+
+		if migrated {
+			if _, err := e.Client.Alias().Add(e.migrated(), e.Index).Do(context.TODO()); err != nil {
+				return err
+			}
+			break
+		}
+
+		if _, err := e.Client.CreateIndex(e.Index).Body(e.mapping()).Do(context.TODO()); err != nil {
+			return err
+		}
+	default:
+		localized, err := e.localized()
+		if err != nil {
+			return err
+		}
+
+		if !localized {
+			if err := e.migrate(); err != nil {
+				return err
+			}
+			break
+		}
+
+		if _, err := e.Client.PutMapping().Index(e.Index).Type(e.Type).BodyString("{" + e.properties() + "}").Do(context.TODO()); err != nil {
+			return err
+		}
+
+		_, err = e.Client.UpdateByQuery(e.Index).Type(e.Type).
+			Query(elastic.NewBoolQuery().MustNot(elastic.NewExistsQuery("query"))).
+			Script(elastic.NewScript("ctx._source.query = ctx._source.completion_suggest.input")).
+			ProceedOnVersionConflict().
+			Do(context.TODO())
+		if err != nil {
+			return err
+		}
+	}
+
+	return e.setupBlocklist()
+}
+
+// migrated is the index we move our queries to from an index that doesn't have their locales
+func (e *ElasticSearch) migrated() string {
+	return e.Index + "-v2"
+}
+
+// localized returns true if our index has the locales of our queries
+func (e *ElasticSearch) localized() (bool, error) {
+	res, err := e.Client.GetMapping().Index(e.Index).Type(e.Type).Do(context.TODO())
+	if err != nil {
+		return false, err
+	}
+
+	for _, idx := range res { // keyed by the index, not by its alias
+		b, err := json.Marshal(idx)
+		if err != nil {
+			return false, err
+		}
+
+		m := struct {
+			Mappings map[string]struct {
+				Properties map[string]struct {
+					Contexts []interface{} `json:"contexts"`
+				} `json:"properties"`
+			} `json:"mappings"`
+		}{}
+
+		if err := json.Unmarshal(b, &m); err != nil {
+			return false, err
+		}
+
+		if len(m.Mappings[e.Type].Properties[completionSuggest].Contexts) == 0 {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// undLocale is the locale of the queries from before we had locales. No user
+// searches in it so they are only suggested to everybody (see suggesters).
+const undLocale = "und"
+
+// migrateScript moves a query from before we had locales to undLocale
+const migrateScript = `
+	ctx._id = params.prefix + ctx._id;
+	ctx._source.completion_suggest.contexts = params.contexts;
+	if (ctx._source.query == null) {
+		ctx._source.query = ctx._source.completion_suggest.input;
+	}`
+
+// migrate copies the queries of an index from before we had locales to a new index
+// that has them. Our old index is then deleted and its name becomes an alias of the new one.
+func (e *ElasticSearch) migrate() error {
+	exists, err := e.Client.IndexExists(e.migrated()).Do(context.TODO())
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		if _, err := e.Client.CreateIndex(e.migrated()).Body(e.mapping()).Do(context.TODO()); err != nil {
+			return err
+		}
+	}
+
+	params := map[string]interface{}{
+		"prefix":   undLocale + ":",
+		"contexts": map[string][]string{localeContext: {undLocale}},
+	}
+
+	res, err := e.Client.Reindex().
+		SourceIndex(e.Index).
+		DestinationIndex(e.migrated()).
+		Script(elastic.NewScript(migrateScript).Params(params)).
+		Refresh("true").
+		Do(context.TODO())
+	if err != nil {
+		return err
+	}
+
+	if len(res.Failures) > 0 {
+		return fmt.Errorf("unable to migrate %d of the queries in %v to %v", len(res.Failures), e.Index, e.migrated())
+	}
+
+	if _, err := e.Client.DeleteIndex(e.Index).Do(context.TODO()); err != nil {
+		return err
+	}
+
+	_, err = e.Client.Alias().Add(e.migrated(), e.Index).Do(context.TODO())
 	return err
 }
 
@@ -1,17 +1,25 @@
 package suggest
 
 import (
+	"encoding/json"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/olivere/elastic"
+	"golang.org/x/text/language"
 )
 
 func TestCompletion(t *testing.T) {
 	for _, c := range []struct {
 		term   string
+		lang   language.Tag
+		region language.Region
+		fuzzy  Fuzzy
 		size   int
 		status int
 		resp   string
@@ -19,6 +27,8 @@ func TestCompletion(t *testing.T) {
 	}{
 		{
 			term:   "b",
+			lang:   language.English,
+			region: language.MustParseRegion("US"),
 			size:   10,
 			status: http.StatusOK,
 			resp: `{
@@ -126,12 +136,13 @@ func TestCompletion(t *testing.T) {
 				}
 			}`,
 			want: Results{
-				RawQuery:    `{"completion_suggest":{"text":"b","completion":{"field":"completion_suggest","size":10}}}`,
-				Suggestions: []string{"brad", "bros", "bob", "blondie", "brad pitt", "buster"},
+				RawQuery:    `{"completion_suggest":{"text":"b","completion":{"contexts":{"locale":[{"boost":2,"context":"en-US"},{"context":"en"}]},"field":"completion_suggest","size":10,"skip_duplicates":true}},"completion_suggest_global":{"text":"b","completion":{"field":"completion_suggest","size":10,"skip_duplicates":true}}}`,
+				Suggestions: []Suggestion{{Text: "brad", Match: 1}, {Text: "bros", Match: 1}, {Text: "bob", Match: 1}, {Text: "blondie", Match: 1}, {Text: "brad pitt", Match: 1}, {Text: "buster", Match: 1}},
 			},
 		},
 		{
 			term:   "ji",
+			lang:   language.English,
 			size:   7,
 			status: http.StatusOK,
 			resp: `{
@@ -213,8 +224,182 @@ func TestCompletion(t *testing.T) {
 				}
 			}`,
 			want: Results{
-				RawQuery:    `{"completion_suggest":{"text":"ji","completion":{"field":"completion_suggest","size":7}}}`,
-				Suggestions: []string{"jiffy", "jill", "jim", "jimi"},
+				RawQuery:    `{"completion_suggest":{"text":"ji","completion":{"contexts":{"locale":[{"context":"en"}]},"field":"completion_suggest","size":7,"skip_duplicates":true}},"completion_suggest_global":{"text":"ji","completion":{"field":"completion_suggest","size":7,"skip_duplicates":true}}}`,
+				Suggestions: []Suggestion{{Text: "jiffy", Match: 2}, {Text: "jill", Match: 2}, {Text: "jim", Match: 2}, {Text: "jimi", Match: 2}},
+			},
+		},
+		{
+			term:   "ch",
+			lang:   language.French,
+			region: language.MustParseRegion("FR"),
+			size:   3,
+			status: http.StatusOK,
+			resp: `{
+				"took": 0,
+				"timed_out": false,
+				"hits": {
+					"total": 0,
+					"max_score": 0,
+					"hits": []
+				},
+				"suggest": {
+					"completion_suggest": [
+						{
+							"text": "ch",
+							"offset": 0,
+							"length": 2,
+							"options": [
+								{
+									"text": "chanson",
+									"_index": "test-queries",
+									"_type": "query",
+									"_id": "fr-FR:chanson",
+									"_score": 2
+								}
+							]
+						}
+					],
+					"completion_suggest_global": [
+						{
+							"text": "ch",
+							"offset": 0,
+							"length": 2,
+							"options": [
+								{
+									"text": "cheap flights",
+									"_index": "test-queries",
+									"_type": "query",
+									"_id": "en-US:cheap flights",
+									"_score": 50
+								},
+								{
+									"text": "chanson",
+									"_index": "test-queries",
+									"_type": "query",
+									"_id": "fr-FR:chanson",
+									"_score": 2
+								},
+								{
+									"text": "chrome",
+									"_index": "test-queries",
+									"_type": "query",
+									"_id": "en:chrome",
+									"_score": 1
+								},
+								{
+									"text": "china",
+									"_index": "test-queries",
+									"_type": "query",
+									"_id": "en:china",
+									"_score": 1
+								}
+							]
+						}
+					]
+				}
+			}`,
+			want: Results{
+				RawQuery:    `{"completion_suggest":{"text":"ch","completion":{"contexts":{"locale":[{"boost":2,"context":"fr-FR"},{"context":"fr"}]},"field":"completion_suggest","size":3,"skip_duplicates":true}},"completion_suggest_global":{"text":"ch","completion":{"field":"completion_suggest","size":3,"skip_duplicates":true}}}`,
+				Suggestions: []Suggestion{{Text: "chanson", Match: 2}, {Text: "cheap flights", Match: 2}, {Text: "chrome", Match: 2}},
+			},
+		},
+		{
+			term:   "jimu",
+			lang:   language.English,
+			fuzzy:  Fuzzy{Fuzziness: 1, Prefix: 2},
+			size:   3,
+			status: http.StatusOK,
+			resp: `{
+				"took": 0,
+				"timed_out": false,
+				"hits": {
+					"total": 0,
+					"max_score": 0,
+					"hits": []
+				},
+				"suggest": {
+					"completion_suggest": [
+						{
+							"text": "jimu",
+							"offset": 0,
+							"length": 4,
+							"options": []
+						}
+					],
+					"completion_suggest_global": [
+						{
+							"text": "jimu",
+							"offset": 0,
+							"length": 4,
+							"options": [
+								{
+									"text": "jimuzu",
+									"_index": "test-queries",
+									"_type": "query",
+									"_id": "ja:jimuzu",
+									"_score": 1
+								}
+							]
+						}
+					],
+					"fuzzy_suggest": [
+						{
+							"text": "jimu",
+							"offset": 0,
+							"length": 4,
+							"options": [
+								{
+									"text": "jimi hendrix",
+									"_index": "test-queries",
+									"_type": "query",
+									"_id": "en:jimi hendrix",
+									"_score": 30
+								},
+								{
+									"text": "jimuzu",
+									"_index": "test-queries",
+									"_type": "query",
+									"_id": "ja:jimuzu",
+									"_score": 4
+								}
+							]
+						}
+					],
+					"fuzzy_suggest_global": [
+						{
+							"text": "jimu",
+							"offset": 0,
+							"length": 4,
+							"options": [
+								{
+									"text": "jimmy page",
+									"_index": "test-queries",
+									"_type": "query",
+									"_id": "en:jimmy page",
+									"_score": 20
+								},
+								{
+									"text": "jim morrison",
+									"_index": "test-queries",
+									"_type": "query",
+									"_id": "en:jim morrison",
+									"_score": 10
+								}
+							]
+						}
+					]
+				}
+			}`,
+			want: Results{
+				RawQuery: `{"completion_suggest":{"text":"jimu","completion":{"contexts":{"locale":[{"context":"en"}]},"field":"completion_suggest","size":3,"skip_duplicates":true}},` +
+					`"completion_suggest_global":{"text":"jimu","completion":{"field":"completion_suggest","size":3,"skip_duplicates":true}},` +
+					`"fuzzy_suggest":{"text":"jimu","completion":{"contexts":{"locale":[{"context":"en"}]},"field":"completion_suggest","fuzzy":{"fuzziness":1,"min_length":3,"prefix_length":2,"unicode_aware":true},"size":3,"skip_duplicates":true}},` +
+					`"fuzzy_suggest_global":{"text":"jimu","completion":{"field":"completion_suggest","fuzzy":{"fuzziness":1,"min_length":3,"prefix_length":2,"unicode_aware":true},"size":3,"skip_duplicates":true}}}`,
+				Suggestions: []Suggestion{
+					{Text: "jimuzu", Match: 4},
+					{Text: "jimi hendrix", Match: 4, Fuzzy: true},
+					{Text: "jimmy page", Match: 4, Fuzzy: true},
+				},
 			},
 		},
 	} {
@@ -235,7 +420,9 @@ func TestCompletion(t *testing.T) {
 				t.Fatal(err)
 			}
 
-			got, err := e.Completion(c.term, c.size)
+			e.Fuzzy = c.fuzzy
+
+			got, err := e.Completion(c.term, c.lang, c.region, c.size)
 			if err != nil {
 				t.Fatal(err)
 			}
@@ -247,136 +434,243 @@ func TestCompletion(t *testing.T) {
 	}
 }
 
-func TestExists(t *testing.T) {
+func TestAdd(t *testing.T) {
 	for _, c := range []struct {
-		term   string
-		status int
-		resp   string
-		want   bool
+		name     string
+		term     string
+		blocked  bool
+		requests []string // the paths we request
+		body     []string // parts of the update
 	}{
 		{
-			term:   "a search term",
-			status: http.StatusNotFound,
-			resp:   `{"exists": false}`,
-			want:   false,
+			name:     "searched",
+			term:     " jimi  hendrix ",
+			requests: []string{"/_mget", "/queries/query/fr-FR:jimi hendrix/_update"},
+			body: []string{
+				`"contexts":{"locale":["fr","fr-FR"]}`,
+				`"input":"jimi hendrix"`,
+				`"k":3`,
+				`"user":"abc123"`,
+				`"day":"`,
+				`"scripted_upsert":true`,
+				`"upsert":{"query":"jimi hendrix","users":[],"searches":0,"pending":"`,
+				`"days":{}}`,
+			},
+		},
+		{
+			name:     "blocked",
+			term:     "john smith phone",
+			blocked:  true,
+			requests: []string{"/_mget"},
 		},
 		{
-			term:   "another search term",
-			status: http.StatusOK,
-			resp:   `{"exists": true}`,
-			want:   true,
+			name: "private",
+			term: "john.smith@example.com",
+		},
+		{
+			name: "empty",
+			term: "  ",
 		},
 	} {
-		t.Run(c.term, func(t *testing.T) {
-			handler := http.NotFound
+		t.Run(c.name, func(t *testing.T) {
+			requests := []string{}
+			var body []byte
 			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-				w.WriteHeader(c.status)
-				handler(w, r)
-			}))
+				requests = append(requests, r.URL.Path)
+				b, _ := ioutil.ReadAll(r.Body)
+
+				if r.URL.Path == "/_mget" {
+					found := "false"
+					if c.blocked {
+						found = "true"
+					}
+					w.Write([]byte(`{"docs": [{"_index": "queries-blocked", "_type": "blocked", "_id": "john smith", "found": ` + found + `}]}`))
+					return
+				}
 
+				body = b
+				w.Write([]byte(`{"_index": "queries", "_type": "query", "_id": "fr-FR:jimi hendrix", "result": "updated"}`))
+			}))
 			defer ts.Close()
 
-			handler = func(w http.ResponseWriter, r *http.Request) {
-				w.Write([]byte(c.resp))
-			}
-
 			e, err := MockService(ts.URL)
 			if err != nil {
 				t.Fatal(err)
 			}
+			e.Anonymity = 3
 
-			got, err := e.Exists(c.term)
-			if err != nil {
+			if err := e.Add(c.term, language.French, language.MustParseRegion("FR"), "abc123"); err != nil {
 				t.Fatal(err)
 			}
 
-			if got != c.want {
-				t.Fatalf("got %v; want %v", got, c.want)
+			if len(requests) != len(c.requests) {
+				t.Fatalf("got requests %v; want %v", requests, c.requests)
+			}
+			for i := range requests {
+				if requests[i] != c.requests[i] {
+					t.Fatalf("got requests %v; want %v", requests, c.requests)
+				}
+			}
+
+			for _, want := range c.body {
+				if !strings.Contains(string(body), want) {
+					t.Fatalf("got %s; want %s", body, want)
+				}
 			}
 		})
 	}
 }
 
-func TestInsert(t *testing.T) {
+func TestAddWithheld(t *testing.T) {
+	type search struct {
+		ip   string
+		days int // after the first search
+	}
+
 	for _, c := range []struct {
-		term   string
-		status int
-		resp   string
+		name      string
+		searches  []search
+		suggested bool
 	}{
 		{
-			term:   "a search term",
-			status: http.StatusOK,
-			resp:   `{"acknowledged": true}`,
+			name:     "one user",
+			searches: []search{{"127.0.0.1", 0}, {"127.0.0.1", 1}, {"127.0.0.1", 2}, {"127.0.0.1", 5}, {"127.0.0.1", 30}},
 		},
 		{
-			term:   "another search term",
-			status: http.StatusOK,
-			resp:   `{"acknowledged": true}`,
+			name:     "two users",
+			searches: []search{{"127.0.0.1", 0}, {"127.0.0.2", 1}, {"127.0.0.1", 2}, {"127.0.0.2", 3}},
+		},
+		{
+			name:      "enough users",
+			searches:  []search{{"127.0.0.1", 0}, {"127.0.0.2", 1}, {"127.0.0.3", 2}},
+			suggested: true,
 		},
 	} {
-		t.Run(c.term, func(t *testing.T) {
-			handler := http.NotFound
+		t.Run(c.name, func(t *testing.T) {
+			// the users of the query as addScript keeps them
+			users := map[string]bool{}
+			days := map[string]bool{}
+			suggested := false
+
 			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-				w.WriteHeader(c.status)
-				handler(w, r)
-			}))
+				if r.URL.Path == "/_mget" {
+					w.Write([]byte(`{"docs": [{"_index": "queries-blocked", "_type": "blocked", "_id": "jimi hendrix", "found": false}]}`))
+					return
+				}
 
-			defer ts.Close()
+				var body struct {
+					Script struct {
+						Params struct {
+							User string `json:"user"`
+							K    int    `json:"k"`
+							Day  string `json:"day"`
+						} `json:"params"`
+					} `json:"script"`
+				}
+				if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+					t.Error(err)
+					return
+				}
 
-			handler = func(w http.ResponseWriter, r *http.Request) {
-				w.Write([]byte(c.resp))
-			}
+				p := body.Script.Params
+				days[p.Day] = true
+				if !suggested {
+					users[p.User] = true
+					if len(users) >= p.K {
+						suggested, users = true, nil
+					}
+				}
+
+				w.Write([]byte(`{"_index": "queries", "_type": "query", "_id": "en-US:jimi hendrix", "result": "updated"}`))
+			}))
+			defer ts.Close()
 
 			e, err := MockService(ts.URL)
 			if err != nil {
 				t.Fatal(err)
 			}
+			e.Anonymity = 3
 
-			if err := e.Insert(c.term); err != nil {
-				t.Fatal(err)
+			first := time.Date(2018, 3, 1, 9, 0, 0, 0, time.UTC)
+			defer func() { now = time.Now }()
+
+			for _, s := range c.searches {
+				now = func() time.Time { return first.AddDate(0, 0, s.days) }
+				user := User(s.ip, "Mozilla/5.0", "jimi hendrix", []byte("secret"))
+				if err := e.Add("jimi hendrix", language.English, language.MustParseRegion("US"), user); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if len(days) < 2 {
+				t.Fatalf("got searches on %d days; want several", len(days))
+			}
+
+			if suggested != c.suggested {
+				t.Fatalf("got suggested %v; want %v (users %v)", suggested, c.suggested, users)
+			}
+
+			if suggested && users != nil {
+				t.Fatalf("got users %v; want them forgotten once suggested", users)
 			}
 		})
 	}
 }
 
-func TestIncrement(t *testing.T) {
+func TestPopularity(t *testing.T) {
 	for _, c := range []struct {
-		term   string
-		status int
-		resp   string
+		name    string
+		queries []string
+		resp    string
+		want    map[string]int
 	}{
 		{
-			term:   "a search term",
-			status: http.StatusOK,
-			resp:   `{"acknowledged": true}`,
+			name:    "regional and not",
+			queries: []string{"jimi hendrix", "jimi hendricks"},
+			resp: `{
+				"docs": [
+					{"_index": "queries", "_type": "query", "_id": "fr:jimi hendrix", "found": true,
+						"_source": {"completion_suggest": {"input": "jimi hendrix", "weight": 3}}},
+					{"_index": "queries", "_type": "query", "_id": "fr-FR:jimi hendrix", "found": true,
+						"_source": {"completion_suggest": {"input": "jimi hendrix", "weight": 12}}},
+					{"_index": "queries", "_type": "query", "_id": "fr:jimi hendricks", "found": false},
+					{"_index": "queries", "_type": "query", "_id": "fr-FR:jimi hendricks", "found": true,
+						"_source": {"completion_suggest": {"input": "jimi hendricks", "weight": 1}}}
+				]
+			}`,
+			want: map[string]int{"jimi hendrix": 15, "jimi hendricks": 1},
 		},
 		{
-			term:   "another search term",
-			status: http.StatusOK,
-			resp:   `{"acknowledged": true}`,
+			name: "none",
+			want: map[string]int{},
 		},
 	} {
-		t.Run(c.term, func(t *testing.T) {
-			handler := http.NotFound
+		t.Run(c.name, func(t *testing.T) {
 			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-				w.WriteHeader(c.status)
-				handler(w, r)
+				if r.URL.Path != "/_mget" {
+					t.Fatalf("got path %q; want %q", r.URL.Path, "/_mget")
+				}
+				b, _ := ioutil.ReadAll(r.Body)
+				if want := `{"_id":"fr-FR:jimi hendrix","_index":"queries","_type":"query"}`; !strings.Contains(string(b), want) {
+					t.Fatalf("got %s; want %s", b, want)
+				}
+				w.Write([]byte(c.resp))
 			}))
-
 			defer ts.Close()
 
-			handler = func(w http.ResponseWriter, r *http.Request) {
-				w.Write([]byte(c.resp))
-			}
-
 			e, err := MockService(ts.URL)
 			if err != nil {
 				t.Fatal(err)
 			}
 
-			if err := e.Increment(c.term); err != nil {
+			got, err := e.Popularity(c.queries, language.French, language.MustParseRegion("FR"))
+			if err != nil {
 				t.Fatal(err)
 			}
+
+			if !reflect.DeepEqual(got, c.want) {
+				t.Fatalf("got %v; want %v", got, c.want)
+			}
 		})
 	}
 }
@@ -423,27 +717,79 @@ func TestIndexExists(t *testing.T) {
 }
 
 func TestSetup(t *testing.T) {
+	localized := `{"queries": {"mappings": {"query": {"properties": {"completion_suggest": {"type": "completion", "contexts": [{"name": "locale", "type": "category"}]}}}}}}`
+	old := `{"queries": {"mappings": {"query": {"properties": {"completion_suggest": {"type": "completion"}}}}}}`
+
 	for _, c := range []struct {
-		name   string
-		status int
-		resp   string
+		name     string
+		indices  map[string]bool // the indices that exist
+		mapping  string
+		requests []string
+		body     []string // of the reindex
 	}{
 		{
-			"ok", http.StatusOK, `{"acknowledged": true}`,
+			name:    "new",
+			indices: map[string]bool{},
+			requests: []string{
+				"HEAD /queries", "HEAD /queries-v2", "PUT /queries",
+				"HEAD /queries-blocked", "PUT /queries-blocked",
+			},
+		},
+		{
+			name:    "existing",
+			indices: map[string]bool{"queries": true, "queries-blocked": true},
+			mapping: localized,
+			requests: []string{
+				"HEAD /queries", "GET /queries/_mapping/query",
+				"PUT /queries/_mapping/query", "POST /queries/query/_update_by_query",
+				"HEAD /queries-blocked",
+			},
+		},
+		{
+			name:    "from before locales",
+			indices: map[string]bool{"queries": true, "queries-blocked": true},
+			mapping: old,
+			requests: []string{
+				"HEAD /queries", "GET /queries/_mapping/query",
+				"HEAD /queries-v2", "PUT /queries-v2", "POST /_reindex", "DELETE /queries", "POST /_aliases",
+				"HEAD /queries-blocked",
+			},
+			body: []string{
+				`"source":{"index":"queries"}`, `"dest":{"index":"queries-v2"}`,
+				`"params":{"contexts":{"locale":["und"]},"prefix":"und:"}`,
+			},
+		},
+		{
+			name:    "interrupted migration",
+			indices: map[string]bool{"queries-v2": true, "queries-blocked": true},
+			requests: []string{
+				"HEAD /queries", "HEAD /queries-v2", "POST /_aliases",
+				"HEAD /queries-blocked",
+			},
 		},
 	} {
 		t.Run(c.name, func(t *testing.T) {
-			handler := http.NotFound
+			requests := []string{}
+			var body []byte
 			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-				handler(w, r)
+				requests = append(requests, r.Method+" "+r.URL.Path)
+
+				switch {
+				case r.Method == "HEAD" && !c.indices[strings.TrimPrefix(r.URL.Path, "/")]:
+					w.WriteHeader(http.StatusNotFound)
+				case strings.HasSuffix(r.URL.Path, "/_mapping/query") && r.Method == "GET":
+					w.Write([]byte(c.mapping))
+				case strings.HasSuffix(r.URL.Path, "_update_by_query"):
+					w.Write([]byte(`{"took": 1, "total": 2, "updated": 2}`))
+				case r.URL.Path == "/_reindex":
+					body, _ = ioutil.ReadAll(r.Body)
+					w.Write([]byte(`{"took": 1, "total": 2, "created": 2, "failures": []}`))
+				default:
+					w.Write([]byte(`{"acknowledged": true}`))
+				}
 			}))
 			defer ts.Close()
 
-			handler = func(w http.ResponseWriter, r *http.Request) {
-				w.WriteHeader(c.status)
-				w.Write([]byte(c.resp))
-			}
-
 			e, err := MockService(ts.URL)
 			if err != nil {
 				t.Fatal(err)
@@ -452,6 +798,16 @@ func TestSetup(t *testing.T) {
 			if err := e.Setup(); err != nil {
 				t.Fatal(err)
 			}
+
+			if !reflect.DeepEqual(requests, c.requests) {
+				t.Fatalf("got requests %v; want %v", requests, c.requests)
+			}
+
+			for _, want := range c.body {
+				if !strings.Contains(string(body), want) {
+					t.Fatalf("got %s; want %s", body, want)
+				}
+			}
 		})
 	}
 }
@@ -463,3 +819,23 @@ func MockService(url string) (*ElasticSearch, error) {
 	}
 	return &ElasticSearch{Client: client, Index: "queries", Type: "query"}, nil
 }
+
+func TestLocales(t *testing.T) {
+	for _, c := range []struct {
+		name   string
+		lang   language.Tag
+		region language.Region
+		want   []string
+	}{
+		{"language & region", language.French, language.MustParseRegion("CA"), []string{"fr", "fr-CA"}},
+		{"regional language", language.BritishEnglish, language.MustParseRegion("GB"), []string{"en", "en-GB"}},
+		{"no region", language.German, language.Region{}, []string{"de"}},
+		{"not a country", language.Spanish, language.MustParseRegion("419"), []string{"es"}},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			if got := locales(c.lang, c.region); !reflect.DeepEqual(got, c.want) {
+				t.Fatalf("got %v; want %v", got, c.want)
+			}
+		})
+	}
+}
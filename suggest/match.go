@@ -0,0 +1,93 @@
+package suggest
+
+import (
+	"strings"
+	"unicode"
+)
+
+// match returns how many characters at the start of a suggestion match the term and whether
+// they match it exactly. A fuzzy suggestion matches as many characters as makes it closest
+// to the term, e.g. "jimu" matches "jimi" of "jimi hendrix". The simple analyzer
+// we index our queries with ignores case and anything that isn't a letter so we do too.
+func match(term, suggestion string) (int, bool) {
+	t, s := []rune(strings.ToLower(term)), []rune(strings.ToLower(suggestion))
+
+	// an exact match (ignoring non-letters)
+	i, j := 0, 0
+	for i < len(t) && j < len(s) {
+		if !unicode.IsLetter(t[i]) {
+			i++
+			continue
+		}
+
+		if !unicode.IsLetter(s[j]) {
+			j++
+			continue
+		}
+
+		if t[i] != s[j] {
+			break
+		}
+
+		i++
+		j++
+	}
+
+	for i < len(t) && !unicode.IsLetter(t[i]) {
+		i++
+	}
+
+	if i == len(t) {
+		return j, false
+	}
+
+	// the fuzzy match...ties go to the prefix closest in length to the term
+	best, dist := 0, len(t)+1
+	for k := 0; k <= len(s) && k <= len(t)+maxEdits; k++ {
+		d := distance(t, s[:k])
+		if d < dist || d == dist && abs(k-len(t)) < abs(best-len(t)) {
+			best, dist = k, d
+		}
+	}
+
+	return best, true
+}
+
+// maxEdits is the most edits elasticsearch allows for a fuzzy query
+const maxEdits = 2
+
+// distance is the Levenshtein distance between a & b
+func distance(a, b []rune) int {
+	prev, cur := make([]int, len(b)+1), make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+
+			cur[j] = prev[j-1] + cost // substitution
+			if prev[j]+1 < cur[j] {   // deletion
+				cur[j] = prev[j] + 1
+			}
+			if cur[j-1]+1 < cur[j] { // insertion
+				cur[j] = cur[j-1] + 1
+			}
+		}
+		prev, cur = cur, prev
+	}
+
+	return prev[len(b)]
+}
+
+func abs(i int) int {
+	if i < 0 {
+		return -i
+	}
+	return i
+}
@@ -0,0 +1,50 @@
+package suggest
+
+import "testing"
+
+func TestMatch(t *testing.T) {
+	for _, c := range []struct {
+		term       string
+		suggestion string
+		match      int
+		fuzzy      bool
+	}{
+		{"jimi", "jimi hendrix", 4, false},
+		{"Jimi H", "jimi hendrix", 6, false},
+		{"jimi ", "jimi hendrix", 4, false},
+		{"rem", "r.e.m.", 5, false},
+		{"chans", "chanson", 5, false},
+		{"jimu", "jimi hendrix", 4, true},
+		{"jmi hen", "jimi hendrix", 8, true},
+		{"hendirx", "hendrix", 7, true},
+		{"ji", "jill", 2, false},
+		{"ĉu", "ĉu vi", 2, false},
+	} {
+		t.Run(c.term, func(t *testing.T) {
+			m, fuzzy := match(c.term, c.suggestion)
+			if m != c.match || fuzzy != c.fuzzy {
+				t.Fatalf("got %d, %v; want %d, %v", m, fuzzy, c.match, c.fuzzy)
+			}
+		})
+	}
+}
+
+func TestDistance(t *testing.T) {
+	for _, c := range []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"jimi", "jimi", 0},
+		{"jimu", "jimi", 1},
+		{"jmi", "jimi", 1},
+		{"kitten", "sitting", 3},
+		{"", "abc", 3},
+	} {
+		t.Run(c.a+"/"+c.b, func(t *testing.T) {
+			if got := distance([]rune(c.a), []rune(c.b)); got != c.want {
+				t.Fatalf("got %d; want %d", got, c.want)
+			}
+		})
+	}
+}
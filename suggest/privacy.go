@@ -0,0 +1,50 @@
+package suggest
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"regexp"
+	"strings"
+)
+
+// User identifies who searched a query so we can count the different people that searched it.
+// It is a hash of their IP address and user agent keyed by a secret key so the IP address
+// can't be found by hashing every IP address. The (normalized) query is hashed too so the
+// queries a user searched can't be tied to each other by their hash. It is the same every day
+// so one user searching a query day after day is still one user...we forget who searched a
+// query once it is suggested.
+func User(ip, userAgent, q string, key []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(strings.Join([]string{ip, userAgent, normalize(q)}, "\x00")))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// minPrivateDigits is how many digits in a row (give or take spaces, dashes, etc.) make a
+// number personal: phone numbers, credit cards, social security numbers, etc.
+// "2018 world cup" and "90210" are fine.
+const minPrivateDigits = 7
+
+var (
+	emailRe  = regexp.MustCompile(`[^\s@]+@[^\s@]+\.[^\s@]+`)
+	ipRe     = regexp.MustCompile(`\b\d{1,3}(\.\d{1,3}){3}\b`)
+	digitsRe = regexp.MustCompile(`\d[\d\s\-.()/]*\d`)
+	digitRe  = regexp.MustCompile(`\d`)
+)
+
+// Private is true for a query that looks like it has personal information in it
+// (an email address, phone number, IP address, etc.). We never suggest those.
+// It errs on the side of privacy...a query that is left out is only a missed suggestion.
+func Private(q string) bool {
+	if emailRe.MatchString(q) || ipRe.MatchString(q) {
+		return true
+	}
+
+	for _, n := range digitsRe.FindAllString(q, -1) {
+		if len(digitRe.FindAllString(n, -1)) >= minPrivateDigits {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,63 @@
+package suggest
+
+import (
+	"testing"
+)
+
+func TestUser(t *testing.T) {
+	key := []byte("secret")
+
+	a := User("127.0.0.1", "Mozilla/5.0", "jimi hendrix", key)
+	if len(a) != 64 {
+		t.Fatalf("got %q; want a hex sha256", a)
+	}
+
+	if b := User("127.0.0.1", "Mozilla/5.0", " Jimi  Hendrix", key); a != b {
+		t.Fatalf("got %q and %q; want the same user", a, b)
+	}
+
+	for _, c := range []struct {
+		name, ip, ua, q string
+		key             []byte
+	}{
+		{"ip", "127.0.0.2", "Mozilla/5.0", "jimi hendrix", key},
+		{"user agent", "127.0.0.1", "curl/7.58.0", "jimi hendrix", key},
+		{"query", "127.0.0.1", "Mozilla/5.0", "jimi hendrix songs", key},
+		{"key", "127.0.0.1", "Mozilla/5.0", "jimi hendrix", []byte("another secret")},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			if b := User(c.ip, c.ua, c.q, c.key); a == b {
+				t.Fatalf("got the same user %q; want another", b)
+			}
+		})
+	}
+}
+
+func TestPrivate(t *testing.T) {
+	for _, c := range []struct {
+		q    string
+		want bool
+	}{
+		{"jimi hendrix", false},
+		{"2018 world cup", false},
+		{"weather 90210", false},
+		{"windows 10 update", false},
+		{"iphone 8 vs 8 plus", false},
+		{"1,000,000 usd to eur", false},
+		{"john.smith@example.com", true},
+		{"email john.smith@example.com password", true},
+		{"555-123-4567", true},
+		{"call (555) 123 4567", true},
+		{"+44 20 7946 0958", true},
+		{"4111 1111 1111 1111", true},
+		{"078-05-1120", true},
+		{"who is 192.168.1.20", true},
+		{"8.8.8.8", true},
+	} {
+		t.Run(c.q, func(t *testing.T) {
+			if got := Private(c.q); got != c.want {
+				t.Fatalf("got %v; want %v", got, c.want)
+			}
+		})
+	}
+}
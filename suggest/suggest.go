@@ -1,19 +1,48 @@
 // Package suggest handles AutoComplete and Phrase Suggester (Did you mean?) queries
 package suggest
 
-// Suggester outlines methods to fetch & store Autocomplete & PhraseSuggester results
+import (
+	"time"
+
+	"golang.org/x/text/language"
+)
+
+// Suggester outlines methods to fetch & store Autocomplete & PhraseSuggester results.
+// A query's popularity is counted by the language and region it was searched in.
+// A query is only suggested once enough different users searched it.
 type Suggester interface {
 	IndexExists() (bool, error)
 	Setup() error
-	Exists(q string) (bool, error)
-	Insert(q string) error
-	Increment(q string) error
-	Completion(q string, size int) (Results, error)
-	//phrase(q string) Results //  TODO: "Did you mean?"
+	Add(q string, lang language.Tag, region language.Region, user string) error
+	Completion(q string, lang language.Tag, region language.Region, size int) (Results, error)
+	Popularity(queries []string, lang language.Tag, region language.Region) (map[string]int, error)
+}
+
+// Moderator outlines methods to keep queries out of our suggestions.
+// A blocked query (e.g. a person's name) is removed and its words are never suggested again.
+type Moderator interface {
+	Block(q string) (int64, error)
+	Unblock(q string) error
+	Blocklist() ([]string, error)
+	Remove(q string) (int64, error)
+}
+
+// Maintainer outlines the batch jobs that keep our suggestions fresh
+type Maintainer interface {
+	Expire(before time.Time) (int64, error)
+	Decay(now time.Time) (int64, error)
 }
 
 // Results are the results of an autocomplete query
 type Results struct { // remember top-level arrays = no-no in javascript/json
-	RawQuery    string   `json:"-"`
-	Suggestions []string `json:"suggestions"`
+	RawQuery    string       `json:"-"`
+	Suggestions []Suggestion `json:"suggestions"`
+}
+
+// Suggestion is a query we suggest. The first Match characters of the Text are
+// what was typed (or near enough for a Fuzzy suggestion) so the rest can be bolded.
+type Suggestion struct {
+	Text  string `json:"text"`
+	Match int    `json:"match"`
+	Fuzzy bool   `json:"fuzzy,omitempty"`
 }